@@ -1,19 +1,33 @@
 // The span-amsl-discovery tool will create a discovery (now defunkt) like API
 // response from available AMSL endpoints, refs #14456, #14415.
+//
+// API responses can be saved with -save-dir and used later with -from-dir,
+// for reproducible builds without access to AMSL. Two databases can be
+// compared with -diff, to see attachment changes per ISIL.
+//
+//	$ span-amsl-discovery -live https://example.technology -save-dir amsl-2021-05-01 -db amsl.db
+//	$ span-amsl-discovery -from-dir amsl-2021-05-01 -live https://example.technology -db amsl.db
+//	$ span-amsl-discovery -diff old.db new.db
 package main
 
 import (
 	"bufio"
+	"bytes"
 	"database/sql"
 	"flag"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/segmentio/encoding/json"
 
+	"github.com/miku/span"
+	"github.com/miku/span/atomic"
+	"github.com/miku/span/tagging"
 	"github.com/miku/span/xio"
 	"github.com/sethgrid/pester"
 	log "github.com/sirupsen/logrus"
@@ -22,10 +36,13 @@ import (
 )
 
 var (
-	live       = flag.String("live", "https://example.technology", "AMSL live base url")
+	live       = flag.String("live", "https://example.technology", "AMSL live base url, also used for file links")
 	allowEmpty = flag.Bool("allow-empty", false, "allow empty responses from api")
 	flatten    = flag.Bool("f", false, "flatten output into a TSV")
-	dbFile     = flag.String("db", "", "write data into an sqlite3 database")
+	dbFile     = flag.String("db", "", "write data into a new sqlite3 database")
+	fromDir    = flag.String("from-dir", "", "read saved API responses (e.g. metadata_usage.json) from directory instead of AMSL")
+	saveDir    = flag.String("save-dir", "", "save API responses into directory, for use with -from-dir")
+	diff       = flag.Bool("diff", false, "report attachment changes per ISIL between two databases given as arguments")
 )
 
 // Discovery API response (now defunkt).
@@ -145,10 +162,94 @@ func fetchLocation(location string, r io.ReaderFrom) (int64, error) {
 	return r.ReadFrom(resp.Body)
 }
 
-// fetchFrom constructs a link and fetches the response into given io.ReaderFrom.
+// fetchFrom constructs a link and fetches the response into given
+// io.ReaderFrom. With -save-dir, the response is saved as well.
 func fetchFrom(base, kind string, r io.ReaderFrom) (int64, error) {
 	loc := fmt.Sprintf("%s/outboundservices/list?do=%s", base, kind)
-	return fetchLocation(loc, r)
+	if *saveDir == "" {
+		return fetchLocation(loc, r)
+	}
+	var buf bytes.Buffer
+	if _, err := fetchLocation(loc, &buf); err != nil {
+		return 0, err
+	}
+	if err := atomic.WriteFile(savedFilename(*saveDir, kind), buf.Bytes(), 0644); err != nil {
+		return 0, err
+	}
+	return r.ReadFrom(&buf)
+}
+
+// savedFilename returns the filename of a saved API response.
+func savedFilename(dir, kind string) string {
+	return filepath.Join(dir, kind+".json")
+}
+
+// readSaved reads a saved API response into given io.ReaderFrom.
+func readSaved(dir, kind string, r io.ReaderFrom) (int64, error) {
+	filename := savedFilename(dir, kind)
+	log.Printf("reading %s", filename)
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	n, err := r.ReadFrom(f)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", filename, err)
+	}
+	return n, nil
+}
+
+// openDatabase opens an existing sqlite3 database.
+func openDatabase(filename string) (*sql.DB, error) {
+	if _, err := os.Stat(filename); err != nil {
+		return nil, err
+	}
+	return sql.Open("sqlite3", filename)
+}
+
+// runDiff writes attachment changes between two databases as TSV: ISIL,
+// change, source id, technical collection id, collection and differences.
+func runDiff(w io.Writer, oldFile, newFile string) error {
+	a, err := openDatabase(oldFile)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	b, err := openDatabase(newFile)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+	changes, err := tagging.Diff(a, b)
+	if err != nil {
+		return err
+	}
+	var isils []string
+	for k := range changes {
+		isils = append(isils, k)
+	}
+	sort.Strings(isils)
+	for _, isil := range isils {
+		counts := make(map[string]int)
+		for _, c := range changes[isil] {
+			counts[c.Kind]++
+			fields := []string{
+				isil,
+				c.Kind,
+				c.Attachment.SourceID,
+				c.Attachment.TechnicalCollectionID,
+				c.Attachment.MegaCollection,
+				strings.Join(c.Differences, "; "),
+			}
+			if _, err := io.WriteString(w, strings.Join(slugifyTabs(fields), "\t")+"\n"); err != nil {
+				return err
+			}
+		}
+		log.Printf("%s: %d added, %d removed, %d changed",
+			isil, counts["added"], counts["removed"], counts["changed"])
+	}
+	return nil
 }
 
 // slugifyTabs removes tabs from all fields.
@@ -197,6 +298,33 @@ The generated TSV (via -f) fields are:
 	}
 	flag.Parse()
 
+	if *diff {
+		if flag.NArg() != 2 {
+			log.Fatal("usage: span-amsl-discovery -diff old.db new.db")
+		}
+		bw := bufio.NewWriter(os.Stdout)
+		if err := runDiff(bw, flag.Arg(0), flag.Arg(1)); err != nil {
+			log.Fatal(err)
+		}
+		if err := bw.Flush(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *fromDir != "" && *saveDir != "" {
+		log.Fatal("-from-dir and -save-dir are exclusive")
+	}
+	if *saveDir != "" {
+		if err := os.MkdirAll(*saveDir, 0755); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *dbFile != "" {
+		if _, err := os.Stat(*dbFile); err == nil {
+			log.Fatalf("database %s already exists", *dbFile)
+		}
+	}
+
 	var (
 		mur MetadataUsageResponse
 		hcr HoldingsFileConcatResponse
@@ -218,7 +346,13 @@ The generated TSV (via -f) fields are:
 	}
 
 	for _, ff := range fetchlist {
-		if _, err := fetchFrom(ff.base, ff.kind, ff.r); err != nil {
+		var err error
+		if *fromDir != "" {
+			_, err = readSaved(*fromDir, ff.kind, ff.r)
+		} else {
+			_, err = fetchFrom(ff.base, ff.kind, ff.r)
+		}
+		if err != nil {
 			log.Fatal(err)
 		}
 	}
@@ -377,44 +511,26 @@ The generated TSV (via -f) fields are:
 			}
 		}
 	case *dbFile != "":
-		createTable := `
-			create table amsl (
-				shard text not null,
-				isil text not null,
-				sid text not null,
-				tcid text not null,
-				mc text not null,
-				hfuri text,
-				hflabel text,
-				hflink text,
-				hfeval text,
-				cfuri text,
-				cflabel text,
-				cflink text,
-				cfelink text,
-				pisil text,
-				docuri text,
-				doclabel text
-			);
-
-			create index amsl_isil on amsl(isil);
-			create index amsl_isil_sid on amsl(isil, sid);
-			create index amsl_isil_sid_mc on amsl(isil, sid, mc);
-			create index amsl_mc on amsl(mc);
-			create index amsl_sid on amsl(sid);
-			create index amsl_sid_mc on amsl(sid, mc);
-			create index amsl_sid_tcid on amsl(sid, tcid);
-			create index amsl_tcid on amsl(tcid);
-		`
 		db, err := sql.Open("sqlite3", *dbFile)
 		if err != nil {
 			log.Fatal(err)
 		}
 		defer db.Close()
-		_, err = db.Exec(createTable)
-		if err != nil {
-			log.Printf("%q: %s\n", err, createTable)
-			return
+		if err := tagging.Migrate(db); err != nil {
+			log.Fatal(err)
+		}
+		origin := *live
+		if *fromDir != "" {
+			origin = *fromDir
+		}
+		for k, v := range map[string]string{
+			"created": time.Now().Format(time.RFC3339),
+			"origin":  origin,
+			"version": span.AppVersion,
+		} {
+			if err := tagging.SetMeta(db, k, v); err != nil {
+				log.Fatal(err)
+			}
 		}
 		tx, err := db.Begin()
 		if err != nil {
@@ -455,7 +571,9 @@ The generated TSV (via -f) fields are:
 				log.Fatal(err)
 			}
 		}
-		tx.Commit()
+		if err := tx.Commit(); err != nil {
+			log.Fatal(err)
+		}
 		log.Printf("@%d in %s", len(updates), time.Since(started))
 	default:
 		if err := json.NewEncoder(bw).Encode(updates); err != nil {
@@ -1,15 +1,30 @@
-// span-check runs quality checks on input data
+// span-check runs quality checks on input data.
+//
+// By default, the finc stage one and two checks run on all records. A quality
+// profile file selects tests and thresholds per source and fails, if a source
+// exceeds its error ratio:
+//
+//	$ span-check -profile profiles.yaml < file.is
+//
+// Use -list to see available tests and -example for an example profile file.
+//
+// With -fix, mechanically fixable issues, like non-canonical ISSN or HTML
+// entities in author names, are repaired and all records are written out:
+//
+//	$ span-check -fix < file.is > fixed.is
 package main
 
 import (
 	"bufio"
-	"github.com/segmentio/encoding/json"
 	"flag"
 	"fmt"
 	"os"
 	"runtime"
-	"sync/atomic"
+	"sort"
+	"strings"
+	"sync"
 
+	"github.com/segmentio/encoding/json"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/miku/span"
@@ -18,12 +33,24 @@ import (
 	"github.com/miku/span/quality"
 )
 
+// sourceStats counts records and records with at least one issue.
+type sourceStats struct {
+	Total  int64
+	Failed int64
+}
+
 func main() {
 
 	verbose := flag.Bool("verbose", false, "be verbose")
 	showVersion := flag.Bool("v", false, "prints current program version")
 	size := flag.Int("b", 20000, "batch size")
 	numWorkers := flag.Int("w", runtime.NumCPU(), "number of workers")
+	profileFile := flag.String("profile", "", "path to YAML file with quality profiles per source")
+	listTests := flag.Bool("list", false, "list tests usable in profiles")
+	showExample := flag.Bool("example", false, "show example profile file")
+	fix := flag.Bool("fix", false, "repair fixable issues and write records, x.fixes lists changed fields with old and new values")
+	fixNames := flag.String("fixes", "", "comma separated list of fixes to apply with -fix, default all")
+	listFixes := flag.Bool("list-fixes", false, "list available fixes")
 
 	flag.Parse()
 
@@ -31,31 +58,109 @@ func main() {
 		fmt.Println(span.AppVersion)
 		os.Exit(0)
 	}
+	if *listTests {
+		for _, name := range quality.TestNames() {
+			fmt.Println(name)
+		}
+		os.Exit(0)
+	}
+	if *showExample {
+		fmt.Println(quality.ExampleProfiles)
+		os.Exit(0)
+	}
+	if *listFixes {
+		for _, f := range quality.FixSuite {
+			fmt.Println(f.Name)
+		}
+		os.Exit(0)
+	}
+	var names []string
+	if *fixNames != "" {
+		names = strings.Split(*fixNames, ",")
+	}
+	fixes, err := quality.SelectFixes(names...)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	errStats := make(map[string]*int64)
+	profiles := quality.DefaultProfiles()
+	if *profileFile != "" {
+		f, err := os.Open(*profileFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		profiles, err = quality.ReadProfiles(f)
+		if err != nil {
+			log.Fatalf("%s: %v", *profileFile, err)
+		}
+		f.Close()
+	}
+
+	var (
+		mu       sync.Mutex
+		errStats = make(map[string]int64)
+		fixStats = make(map[string]int64)
+		sources  = make(map[string]*sourceStats)
+		// unchecked counts records per source without a profile.
+		unchecked = make(map[string]int64)
+	)
 
 	p := parallel.NewProcessor(bufio.NewReader(os.Stdin), os.Stdout, func(_ int64, b []byte) ([]byte, error) {
 		var is finc.IntermediateSchema
 		if err := json.Unmarshal(b, &is); err != nil {
 			return b, err
 		}
-		for _, t := range quality.TestSuiteFinc {
+		var applied []string
+		if *fix {
+			applied = quality.FixRecord(&is, fixes)
+		}
+		var issues []quality.Issue
+		profile, ok := profiles.ForSource(is.SourceID)
+		if !ok {
+			profile = &quality.Profile{}
+		}
+		for _, t := range profile.Testers() {
 			if err := t.TestRecord(is); err != nil {
 				issue, ok := err.(quality.Issue)
 				if !ok {
 					log.Fatalf("unexpected error type: %T", err)
 				}
-				key := issue.Err.Error()
-				if errStats[key] == nil {
-					var x int64
-					errStats[key] = &x
-				}
-				atomic.AddInt64(errStats[key], 1)
+				issues = append(issues, issue)
 				if *verbose {
-					return json.Marshal(issue)
+					break
 				}
 			}
 		}
+		mu.Lock()
+		if !ok {
+			unchecked[is.SourceID]++
+		}
+		if ok {
+			if sources[is.SourceID] == nil {
+				sources[is.SourceID] = &sourceStats{}
+			}
+			sources[is.SourceID].Total++
+			if len(issues) > 0 {
+				sources[is.SourceID].Failed++
+			}
+		}
+		for _, issue := range issues {
+			errStats[issue.Err.Error()]++
+		}
+		for _, name := range applied {
+			fixStats[name]++
+		}
+		mu.Unlock()
+		if *fix {
+			b, err := json.Marshal(is)
+			if err != nil {
+				return nil, err
+			}
+			return append(b, '\n'), nil
+		}
+		if *verbose && len(issues) > 0 {
+			return json.Marshal(issues[0])
+		}
 		return nil, nil
 	})
 
@@ -70,7 +175,45 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	if !*verbose {
+	switch {
+	case *fix:
+		// Records go to stdout, so we only log issues remaining after fixes.
+		fb, err := json.Marshal(fixStats)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("fixes: %s", fb)
+		log.Printf("remaining issues: %s", b)
+	case !*verbose:
 		fmt.Println(string(b))
 	}
+
+	var usids []string
+	for sid := range unchecked {
+		usids = append(usids, sid)
+	}
+	sort.Strings(usids)
+	for _, sid := range usids {
+		log.Printf("source %s: %d records not checked, no profile applies (map the source or add a %q profile)",
+			sid, unchecked[sid], quality.DefaultProfileName)
+	}
+
+	var sids []string
+	for sid := range sources {
+		sids = append(sids, sid)
+	}
+	sort.Strings(sids)
+	var exceeded []string
+	for _, sid := range sids {
+		profile, _ := profiles.ForSource(sid)
+		st := sources[sid]
+		if profile.Exceeded(st.Failed, st.Total) {
+			log.Printf("source %s: %d/%d records failed, exceeds max error ratio %0.4f of profile %s",
+				sid, st.Failed, st.Total, *profile.MaxErrorRatio, profile.Name)
+			exceeded = append(exceeded, sid)
+		}
+	}
+	if len(exceeded) > 0 {
+		log.Fatalf("%d source(s) exceeded error ratio: %v", len(exceeded), exceeded)
+	}
 }
@@ -19,24 +19,43 @@
 //
 //   $ span-compare -e -t
 //   ...
+//
+// Save a fingerprint of an index (counts per ISIL, SID and collection, format
+// and facet_avail distributions) and compare it later, e.g. to another
+// fingerprint or a live server:
+//
+//   $ span-compare -b 10.1.1.15:8085/solr/biblio -save 2021-04.json
+//   $ span-compare -a 2021-04.json -b 10.1.1.7:8085/solr/biblio
+//   $ span-compare -a 2021-03.json -b 2021-04.json -f
+//
+// Add a row per collection, render markdown and fail, if any count dropped by
+// more than 10%:
+//
+//   $ span-compare -e -c -format markdown -max-drop 10
+//
+// Output formats are tsv (default), textile, markdown, csv and json.
 package main
 
 import (
 	"bytes"
+	"encoding/csv"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"math"
 	"os"
 	"path"
-	"sort"
+	"strconv"
 	"strings"
 	"text/template"
 
+	"github.com/ilyakaznacheev/cleanenv"
 	"github.com/miku/clam"
+	"github.com/miku/span/configutil"
+	"github.com/miku/span/reviewutil"
 	"github.com/miku/span/solrutil"
 	"github.com/miku/span/xio"
+	"github.com/segmentio/encoding/json"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -180,14 +199,20 @@ var defaultConfigPath = path.Join(xio.UserHomeDir(), ".config/span/span.json")
 
 var (
 	amslLiveServer   = flag.String("amsl", "", "url to live amsl api for ad-hoc source names, e.g. https://example.technology")
-	liveServer       = flag.String("a", "http://localhost:8983/solr/biblio", "live server location")
-	nonliveServer    = flag.String("b", "http://localhost:8983/solr/biblio", "non-live server location")
+	liveServer       = flag.String("a", "http://localhost:8983/solr/biblio", "live server location or fingerprint file")
+	nonliveServer    = flag.String("b", "http://localhost:8983/solr/biblio", "non-live server location or fingerprint file")
 	whatIsLive       = flag.Bool("e", false, "use whatislive.url to determine live and non live servers")
 	liveLinkTemplate = flag.String("tl", "https://katalog.ub.uni-leipzig.de/Search/Results?lookfor=source_id:{{ .SourceID }}",
 		"live link template for source (for focus institution)")
 	spanConfigFile   = flag.String("span-config", defaultConfigPath, "for whatislive.url")
-	textile          = flag.Bool("t", false, "emit textile")
-	focusInstitution = flag.String("emph", "DE-15", "emphasize institution in textile output")
+	textile          = flag.Bool("t", false, "emit textile, same as -format textile")
+	format           = flag.String("format", "tsv", "output format: tsv, textile, markdown, csv, json")
+	focusInstitution = flag.String("emph", "DE-15", "emphasize institution in textile and markdown output")
+	saveFile         = flag.String("save", "", "save fingerprint of the non-live (-b) index to file and exit")
+	compareFacets    = flag.Bool("f", false, "compare format and facet_avail distributions, too")
+	showCollections  = flag.Bool("c", false, "add a row for each collection (mega_collection) below each source")
+	ticket           = flag.String("ticket", "", "post comparison to ticket, requires notify settings (default: redmine.baseurl and redmine.apitoken) in span-config")
+	maxDrop          = flag.Float64("max-drop", 0, "exit with an error, if a count drops by more than this percentage (0 disables)")
 )
 
 // ResultWriter for report generator.
@@ -292,7 +317,96 @@ func (w *TextileWriter) WriteFields(fields ...interface{}) {
 		}
 		s = append(s, v)
 	}
-	_, w.err = fmt.Fprintf(w.w, fmt.Sprintf("| %s |\n", strings.Join(s, " | ")))
+	_, w.err = fmt.Fprintf(w.w, "| %s |\n", strings.Join(s, " | "))
+}
+
+// MarkdownWriter writes markdown tables, e.g. for GitLab or GitHub issues.
+type MarkdownWriter struct {
+	TextileWriter
+}
+
+// WriteHeader write a header and fixes the number of columns.
+func (w *MarkdownWriter) WriteHeader(header ...string) {
+	if w.columns > 0 || w.err != nil {
+		return
+	}
+	w.columns = len(header)
+	var sep []string
+	for range header {
+		sep = append(sep, "---")
+	}
+	_, w.err = fmt.Fprintf(w.w, "| %s |\n| %s |\n", strings.Join(header, " | "), strings.Join(sep, " | "))
+}
+
+// CSVWriter writes comma separated values, with a header.
+type CSVWriter struct {
+	w   *csv.Writer
+	err error
+}
+
+// WriteHeader writes the header.
+func (w *CSVWriter) WriteHeader(header ...string) {
+	if w.err == nil {
+		w.err = w.w.Write(header)
+	}
+}
+
+// WriteFields writes fields.
+func (w *CSVWriter) WriteFields(fields ...interface{}) {
+	if w.err != nil {
+		return
+	}
+	var s []string
+	for _, f := range fields {
+		s = append(s, fmt.Sprintf("%v", f))
+	}
+	if w.err = w.w.Write(s); w.err == nil {
+		w.w.Flush()
+		w.err = w.w.Error()
+	}
+}
+
+// Err returns any error that happened.
+func (w *CSVWriter) Err() error {
+	return w.err
+}
+
+// Style decorates table cells for a given output format.
+type Style struct {
+	Emphasize func(s string) string
+	Link      func(text, link string) string
+}
+
+var (
+	plain = func(s string) string { return s }
+	bold  = func(s string) string { return fmt.Sprintf("*%s*", s) }
+	// styles by output format; tsv keeps emphasis, as it always did.
+	styles = map[string]Style{
+		"tsv": {Emphasize: bold, Link: func(text, _ string) string { return text }},
+		"csv": {Emphasize: plain, Link: func(text, _ string) string { return text }},
+		"textile": {
+			Emphasize: bold,
+			Link:      func(text, link string) string { return fmt.Sprintf(`"%s":%s`, text, link) },
+		},
+		"markdown": {
+			Emphasize: func(s string) string { return fmt.Sprintf("**%s**", s) },
+			Link:      func(text, link string) string { return fmt.Sprintf("[%s](%s)", text, link) },
+		},
+	}
+)
+
+// newResultWriter returns a writer for a tabular output format.
+func newResultWriter(w io.Writer, format string) ResultWriter {
+	switch format {
+	case "textile":
+		return &TextileWriter{w: w}
+	case "markdown":
+		return &MarkdownWriter{TextileWriter{w: w}}
+	case "csv":
+		return &CSVWriter{w: csv.NewWriter(w)}
+	default:
+		return &TabWriter{w: w}
+	}
 }
 
 // prependHTTP prepends http, if necessary.
@@ -303,8 +417,8 @@ func prependHTTP(s string) string {
 	return s
 }
 
-// renderSourceLink renders a textile link to a source, with the given link text.
-func renderSourceLink(tmpl string, data interface{}, text string) (string, error) {
+// renderSourceLink renders a link to a source.
+func renderSourceLink(tmpl string, data interface{}) (string, error) {
 	t, err := template.New("t").Parse(tmpl)
 	if err != nil {
 		return "", err
@@ -313,12 +427,142 @@ func renderSourceLink(tmpl string, data interface{}, text string) (string, error
 	if err := t.Execute(&buf, data); err != nil {
 		return "", err
 	}
-	return fmt.Sprintf(`"%s":%s`, text, buf.String()), nil
+	return buf.String(), nil
+}
+
+// fingerprint reads a fingerprint file or computes the fingerprint of a
+// server.
+func fingerprint(s string) (*solrutil.Fingerprint, error) {
+	if fi, err := os.Stat(s); err == nil && !fi.IsDir() {
+		fp, err := solrutil.ReadFingerprint(s)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("using fingerprint of %s from %s", fp.Server, fp.Date.Format("2006-01-02 15:04"))
+		return fp, nil
+	}
+	index := solrutil.Index{Server: prependHTTP(s)}
+	return index.Fingerprint()
+}
+
+// renderPct renders a percentage, emphasizing larger changes.
+func renderPct(style Style, pct float64) string {
+	v := fmt.Sprintf("%0.2f", pct)
+	if pct > 5.0 || pct < -5.0 {
+		return style.Emphasize(v)
+	}
+	return v
+}
+
+// writeComparison renders a comparison in a tabular format.
+func writeComparison(w io.Writer, format string, c *solrutil.Comparison, collections bool) error {
+	var (
+		style  = styles[format]
+		rw     = newResultWriter(w, format)
+		header = []string{"ISIL", "Source", "Name", "Live", "Nonlive", "Diff", "Pct", "Comment"}
+	)
+	if collections {
+		header = []string{"ISIL", "Source", "Collection", "Name", "Live", "Nonlive", "Diff", "Pct", "Comment"}
+	}
+	rw.WriteHeader(header...)
+	for _, row := range c.Rows {
+		if !collections && row.Collection != "" {
+			continue
+		}
+		var (
+			institution = row.Institution
+			liveField   = fmt.Sprintf("%d", row.Live)
+			comment     string
+		)
+		// Emphasize focussed institution.
+		if format != "tsv" && institution == *focusInstitution {
+			institution = style.Emphasize(institution)
+		}
+		if row.Link != "" {
+			liveField = style.Link(liveField, row.Link)
+		}
+		if row.Exceeded {
+			comment = style.Emphasize(fmt.Sprintf("dropped more than %v%%", c.MaxDrop))
+		}
+		if collections {
+			rw.WriteFields(institution, row.SourceID, row.Collection, row.Name, liveField, row.Nonlive,
+				row.Diff, renderPct(style, row.Pct), comment)
+		} else {
+			rw.WriteFields(institution, row.SourceID, row.Name, liveField, row.Nonlive,
+				row.Diff, renderPct(style, row.Pct), comment)
+		}
+		if rw.Err() != nil {
+			return rw.Err()
+		}
+	}
+	if len(c.Facets) == 0 {
+		return nil
+	}
+	// A separate table, as the textile writer fixes the number of columns.
+	if format == "textile" || format == "markdown" {
+		if _, err := io.WriteString(w, "\n"); err != nil {
+			return err
+		}
+	}
+	rw = newResultWriter(w, format)
+	rw.WriteHeader("Field", "Value", "Live", "Nonlive", "Diff", "Pct")
+	for _, f := range c.Facets {
+		rw.WriteFields(f.Field, f.Value, f.Live, f.Nonlive, f.Diff, renderPct(style, f.Pct))
+		if rw.Err() != nil {
+			return rw.Err()
+		}
+	}
+	return nil
+}
+
+// notify posts a comparison to a ticket, with the backends configured in the
+// span config.
+func notify(ticket string, c *solrutil.Comparison) error {
+	if _, err := strconv.Atoi(ticket); err != nil {
+		log.Printf("ignoring ticket update for non-numeric ticket id: %s", ticket)
+		return nil
+	}
+	// Fallback configuration.
+	if _, err := os.Stat(*spanConfigFile); os.IsNotExist(err) {
+		*spanConfigFile = "/etc/span/span.json"
+	}
+	var conf configutil.Config
+	if err := cleanenv.ReadConfig(*spanConfigFile, &conf); err != nil {
+		return err
+	}
+	notifiers, err := reviewutil.Notifiers(&conf)
+	if err != nil {
+		return err
+	}
+	render := func(markup string) (string, error) {
+		var (
+			buf    bytes.Buffer
+			format = markup
+		)
+		if markup == reviewutil.Text {
+			format = "tsv"
+		}
+		fmt.Fprintf(&buf, "live: %s (%s)\n", c.Live, c.LiveDate.Format("2006-01-02 15:04"))
+		fmt.Fprintf(&buf, "nonlive: %s (%s)\n\n", c.Nonlive, c.NonliveDate.Format("2006-01-02 15:04"))
+		if err := writeComparison(&buf, format, c, *showCollections); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+	subject := fmt.Sprintf("index comparison %s vs %s", c.Live, c.Nonlive)
+	return reviewutil.Broadcast(notifiers, ticket, subject, render)
 }
 
 func main() {
 	flag.Parse()
 
+	if *textile {
+		*format = "textile"
+	}
+	if _, ok := styles[*format]; !ok && *format != "json" {
+		log.Fatalf("unknown format: %s", *format)
+	}
+
 	if *amslLiveServer != "" {
 		log.Printf("fetching source names via AMSL: %s", *amslLiveServer)
 		names, err := fetchSourceNames(*amslLiveServer)
@@ -351,118 +595,71 @@ func main() {
 		log.Printf("live=%s, nonlive=%s", *liveServer, *nonliveServer)
 	}
 
-	live := solrutil.Index{Server: prependHTTP(*liveServer)}
-	nonlive := solrutil.Index{Server: prependHTTP(*nonliveServer)}
+	if *saveFile != "" {
+		fp, err := fingerprint(*nonliveServer)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := fp.WriteFile(*saveFile); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("saved fingerprint of %s to %s", fp.Server, *saveFile)
+		os.Exit(0)
+	}
 
-	sids, err := nonlive.SourceIdentifiers()
+	live, err := fingerprint(*liveServer)
 	if err != nil {
 		log.Fatal(err)
 	}
-	sort.Strings(sids)
-
-	institutions, err := nonlive.Institutions()
+	nonlive, err := fingerprint(*nonliveServer)
 	if err != nil {
 		log.Fatal(err)
 	}
-	sort.Strings(institutions)
 
-	var rw ResultWriter
-	switch {
-	case *textile:
-		rw = &TextileWriter{w: os.Stdout}
-	default:
-		rw = &TabWriter{w: os.Stdout}
+	c := solrutil.Compare(live, nonlive, solrutil.CompareOptions{
+		Collections: *showCollections,
+		Facets:      *compareFacets,
+		MaxDrop:     *maxDrop,
+	})
+	for i, row := range c.Rows {
+		name, ok := SourceNames[row.SourceID]
+		if !ok {
+			name = "XXX: missing source name"
+		}
+		c.Rows[i].Name = name
+		// XXX: Put all live link templates into a configaration file (or scrape from wiki).
+		data := struct {
+			SourceID    string
+			Institution string
+		}{
+			row.SourceID,
+			row.Institution,
+		}
+		if c.Rows[i].Link, err = renderSourceLink(*liveLinkTemplate, data); err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	rw.WriteHeader("ISIL", "Source", "Name", "Live", "Nonlive", "Diff", "Pct", "Comment")
-
-	// TODO(miku): Parallelize queries.
-	for _, institution := range institutions {
-		if strings.TrimSpace(institution) == "" {
-			continue
-		}
-		// TODO(miku): This should not be in the index in the first place
-		if strings.TrimSpace(institution) == `" "` {
-			continue
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(c)
+	default:
+		err = writeComparison(os.Stdout, *format, c, *showCollections)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *ticket != "" {
+		if err := notify(*ticket, c); err != nil {
+			log.Fatal(err)
 		}
-		for _, sid := range sids {
-			query := fmt.Sprintf(`source_id:"%s" AND institution:"%s"`, sid, institution)
-			numLive, err := live.NumFound(query)
-			if err != nil {
-				log.Fatal(err)
-			}
-			numNonlive, err := nonlive.NumFound(query)
-			if err != nil {
-				log.Fatal(err)
-			}
-			// TODO(miku): Might catch too much, e.g. DOAJ, refs #14417.
-			if numLive == 0 && numNonlive == 0 {
-				continue
-			}
-			name, ok := SourceNames[sid]
-			if !ok {
-				name = "XXX: missing source name"
-			}
-
-			// Emphasize focussed institution.
-			var renderInstitution = institution
-			if *textile && institution == *focusInstitution {
-				renderInstitution = fmt.Sprintf("*%s*", institution)
-			}
-
-			// Fields with links.
-			var liveField = fmt.Sprintf("%d", numLive)
-			var nonliveField = fmt.Sprintf("%d", numNonlive)
-
-			// Percentage change, refs #12756.
-			var pctChange float64
-			switch {
-			case numLive == 0 && numNonlive > 0:
-				pctChange = 100
-			default:
-				pctChange = (float64(numNonlive-numLive) / (float64(numLive))) * 100
-			}
-
-			// Remove -0.00 from rendering.
-			if pctChange == 0 {
-				pctChange = math.Copysign(pctChange, 1)
-			}
-			var pctChangeField string
-
-			switch {
-			case pctChange > 5.0 || pctChange < -5.0:
-				pctChangeField = fmt.Sprintf("*%0.2f*", pctChange)
-			default:
-				pctChangeField = fmt.Sprintf("%0.2f", pctChange)
-			}
-
-			if *textile {
-				data := struct {
-					SourceID    string
-					Institution string
-				}{
-					sid,
-					institution,
-				}
-				// XXX: Put all live link templates into a configaration file (or scrape from wiki).
-				if institution == *focusInstitution {
-					liveField, err = renderSourceLink(*liveLinkTemplate, data, fmt.Sprintf("%d", numLive))
-					if err != nil {
-						log.Fatal(err)
-					}
-				} else {
-					liveField, err = renderSourceLink(*liveLinkTemplate, data, fmt.Sprintf("%d", numLive))
-					if err != nil {
-						log.Fatal(err)
-					}
-				}
-				nonliveField = fmt.Sprintf("%d", numNonlive)
-			}
-
-			rw.WriteFields(renderInstitution, sid, name, liveField, nonliveField, numNonlive-numLive, pctChangeField, "")
-			if rw.Err() != nil {
-				log.Fatal(rw.Err())
-			}
+	}
+	if exceeded := c.Exceeded(); len(exceeded) > 0 {
+		for _, row := range exceeded {
+			log.Printf("%s %s %s dropped by %0.2f%%", row.Institution, row.SourceID, row.Collection, -row.Pct)
 		}
+		log.Fatalf("%d count(s) dropped more than %v%%", len(exceeded), *maxDrop)
 	}
 }
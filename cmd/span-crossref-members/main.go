@@ -1,7 +1,16 @@
 // span-crossref-members fetches crossref members api. It will merely paginate
-// through the api responses and will output one response per line.  Create
-// mapping from DOI to name: span-crossref-members | jq -rc '.message.items[].prefix[] |
-// {(.value|tostring): .name}' | jq -s add > assets/crossref/members.json
+// through the api responses and will output one response per line.
+//
+// A compact member catalogue (ID, prefixes, primary and alternate names) can
+// be built from a harvested dump, or from a member table (id, prefix, count,
+// name), and queried offline by member ID, DOI prefix or DOI. The builtin
+// catalogue is built from a table, with primary names from the members API
+// (names.ndj), see make catalogue:
+//
+//	$ span-crossref-members > members.ndj
+//	$ span-crossref-members -build members.ndj > catalogue.ndj
+//	$ span-crossref-members -build -table -names assets/crossref/names.ndj docs/crossref-members-2023-09-07.tsv | gzip -9n > assets/crossref/catalogue.ndj.gz
+//	$ span-crossref-members -lookup 10.1016
 package main
 
 import (
@@ -18,6 +27,8 @@ import (
 	"time"
 
 	"github.com/miku/span"
+	"github.com/miku/span/crossrefutil/members"
+	"github.com/miku/span/formats/crossref"
 )
 
 // MembersResponse from api.crossref.org/member, generated by JSONGen, use offset to paginate.
@@ -93,8 +104,68 @@ var (
 	silent     = flag.Bool("q", false, "suppress logging output")
 	version    = flag.Bool("version", false, "output version")
 	retryCount = flag.Int("retry", 3, "retry count on HTTP 500 and similar errors")
+	build      = flag.Bool("build", false, "build a compact catalogue from a harvested dump (file or stdin)")
+	table      = flag.Bool("table", false, "with -build, read a tab separated table (id, prefix, count, name) instead of a dump")
+	namesFile  = flag.String("names", "", "with -table, take primary names from this file (assets/crossref/names.ndj)")
+	lookup     = flag.String("lookup", "", "lookup member by ID, DOI prefix or DOI in catalogue")
+	catalogue  = flag.String("catalogue", "", "catalogue file to use for lookup, defaults to builtin catalogue")
 )
 
+// buildCatalogue reads a dump or table and writes a compact catalogue.
+func buildCatalogue(w io.Writer, r io.Reader) error {
+	var (
+		c   *members.Catalogue
+		err error
+	)
+	if *table {
+		var names []members.Names
+		if *namesFile != "" {
+			f, err := os.Open(*namesFile)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if names, err = members.ReadNames(f); err != nil {
+				return fmt.Errorf("%s: %v", *namesFile, err)
+			}
+		}
+		c, err = members.ReadTable(r, names)
+	} else {
+		c, err = members.ReadDump(r)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("catalogue with %d members", c.Len())
+	_, err = c.WriteTo(w)
+	return err
+}
+
+// lookupMember finds a member and writes it as JSON.
+func lookupMember(w io.Writer, s string) error {
+	var (
+		c   *members.Catalogue
+		err error
+	)
+	if *catalogue != "" {
+		f, err := os.Open(*catalogue)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if c, err = members.Read(f); err != nil {
+			return err
+		}
+	} else if c, err = crossref.Members(); err != nil {
+		return err
+	}
+	m, ok := c.Lookup(s)
+	if !ok {
+		return fmt.Errorf("no member found for %s", s)
+	}
+	return json.NewEncoder(w).Encode(m)
+}
+
 func main() {
 	flag.Parse()
 
@@ -106,6 +177,28 @@ func main() {
 		os.Exit(0)
 	}
 
+	switch {
+	case *build:
+		var r io.Reader = os.Stdin
+		if flag.NArg() > 0 {
+			f, err := os.Open(flag.Arg(0))
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer f.Close()
+			r = f
+		}
+		if err := buildCatalogue(os.Stdout, r); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case *lookup != "":
+		if err := lookupMember(os.Stdout, *lookup); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	var buf bytes.Buffer
 
 	for {
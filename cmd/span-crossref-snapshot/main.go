@@ -1,8 +1,15 @@
-// Given as single file with crossref works API messages,
-// create a potentially smaller file, which contains only the most recent
-// version of each document.
+// Given one or more files with crossref works API messages, create a
+// potentially smaller file, which contains only the most recent version of
+// each document.
+//
+// Multiple input files (e.g. as cached by span-crossref-sync) are processed
+// in order as a single stream of lines; each file may be plain, gzip or zstd
+// compressed. Inputs can be given as arguments or in a path list file (-f),
+// one path or glob per line.
 //
 // Works in a three stage, two pass fashion: (1) extract, (2) identify, (3) extract.
+// All stages run in Go by default; with -external, stage 2 and 3 use sort,
+// filterline (or an awk fallback) and an external compress program instead.
 // Performance data point (30M compressed records, 11m33.871s):
 //
 // 2017/07/24 18:26:10 stage 1: 8m13.799431646s
@@ -10,6 +17,19 @@
 // 2017/07/24 18:29:30 stage 3: 2m34.23537293s
 //
 // $ span-crossref-snapshot -z crossref.ndj.gz -o out.ndj.gz
+// $ span-crossref-snapshot -z -o out.ndj.zst '/data/finc/crossref/feed-1-index-2022-*.json.zst'
+// $ span-crossref-snapshot -z -o out.ndj.zst -f paths.txt
+//
+// With -index, a DOI index (DOI, indexed date, byte offset into the
+// uncompressed snapshot) is written alongside the snapshot. An existing
+// snapshot and its index can then be updated incrementally with a number of
+// new files; only DOIs with a more recent version are replaced. New and
+// updated records can be written to a separate delta file.
+//
+//	$ span-crossref-snapshot -z -o out.ndj.zst -index out.idx feed-*.json.zst
+//	$ span-crossref-snapshot -update out.ndj.zst -update-index out.idx \
+//	    -z -o next.ndj.zst -index next.idx -delta delta.ndj -delta-list delta.tsv \
+//	    feed-1-index-2023-05-02-2023-05-02.json.zst
 package main
 
 import (
@@ -21,6 +41,7 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime/pprof"
 	"strings"
 
@@ -29,13 +50,16 @@ import (
 	"github.com/klauspost/compress/zstd"
 	gzip "github.com/klauspost/pgzip"
 	"github.com/miku/clam"
+	"github.com/miku/span/atomic"
+	"github.com/miku/span/crossrefutil"
 	"github.com/miku/span/formats/crossref"
 	"github.com/miku/span/parallel"
 	"github.com/miku/span/xio"
 	log "github.com/sirupsen/logrus"
 )
 
-// fallback awk script is used, if the filterline executable is not found
+// fallback awk script is used with -external, if the filterline executable is
+// not found
 var fallback = `
 #!/bin/bash
 LIST="$1" LC_ALL=C awk '
@@ -55,12 +79,19 @@ LIST="$1" LC_ALL=C awk '
 var (
 	excludeFile     = flag.String("x", "", "a list of DOI to further ignore")
 	outputFile      = flag.String("o", "", "output file")
-	compressed      = flag.Bool("z", false, "input file is compressed (see: -compress-program)")
+	compressed      = flag.Bool("z", false, "compress output (see: -compress-program); compressed input is detected automatically, -z no longer marks compressed input")
 	batchsize       = flag.Int("b", 40000, "batch size")
-	compressProgram = flag.String("compress-program", "zstd", "compress program")
+	compressProgram = flag.String("compress-program", "zstd", "compression, zstd or gzip (with -external: compress program)")
 	cpuProfile      = flag.String("cpuprofile", "", "write cpuprofile to file")
 	verbose         = flag.Bool("verbose", false, "be verbose")
-	pathFile        = flag.String("f", "", "path to a file naming all inputs files to be considered, one file per line")
+	pathFile        = flag.String("f", "", "path to a file naming all inputs files to be considered, one file or glob per line")
+	indexFile       = flag.String("index", "", "write DOI index (DOI, indexed date, offset) of the output snapshot to file")
+	updateSnapshot  = flag.String("update", "", "existing snapshot to update incrementally with the given input files")
+	updateIndex     = flag.String("update-index", "", "DOI index of the snapshot to update, required with -update")
+	deltaFile       = flag.String("delta", "", "write new and updated records to file, only with -update")
+	deltaListFile   = flag.String("delta-list", "", "write new and updated DOI to file, only with -update")
+	external        = flag.Bool("external", false, "use external sort, filterline (or awk) and compress program, instead of builtin stages")
+	partitions      = flag.Int("partitions", 64, "number of partitions to split DOI into in stage 2, more partitions use less memory")
 )
 
 // writeFields writes a variable number of values separated by sep to a given
@@ -85,6 +116,282 @@ func writeFields(w io.Writer, sep string, values ...interface{}) (int, error) {
 	return io.WriteString(w, s)
 }
 
+// expandPaths resolves globs in the given list of paths, keeping order. A
+// path without glob metacharacters must exist, a glob must match at least one
+// file.
+func expandPaths(paths []string) (result []string, err error) {
+	for _, p := range paths {
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no such file: %s", p)
+		}
+		result = append(result, matches...)
+	}
+	return result, nil
+}
+
+// readPathList returns the paths listed in a file, skipping comments. The
+// last path does not need a trailing newline.
+func readPathList(filename string) (paths []string, err error) {
+	lines, err := xio.ReadLines(filename)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		paths = append(paths, line)
+	}
+	return paths, nil
+}
+
+// compressWriter wraps a writer with the configured compression.
+func compressWriter(w io.Writer) (io.WriteCloser, error) {
+	switch *compressProgram {
+	case "zstd":
+		return zstd.NewWriter(w)
+	case "gzip", "pigz":
+		return gzip.NewWriter(w), nil
+	default:
+		return nil, fmt.Errorf("only gzip and zstd supported currently")
+	}
+}
+
+// writeIndexFile writes the DOI index for a snapshot file.
+func writeIndexFile(indexFilename, snapshotFilename string) error {
+	r, err := xio.OpenDecompress(snapshotFilename)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	f, err := atomic.New(indexFilename, 0644)
+	if err != nil {
+		return err
+	}
+	if err := crossrefutil.WriteIndex(f, r); err != nil {
+		f.Abort()
+		return err
+	}
+	return f.Close()
+}
+
+// runUpdate updates an existing snapshot with records from the given files.
+func runUpdate(files []string, excludes map[string]struct{}) (*crossrefutil.UpdateStats, error) {
+	snapshot, err := xio.OpenDecompress(*updateSnapshot)
+	if err != nil {
+		return nil, err
+	}
+	defer snapshot.Close()
+	index, err := os.Open(*updateIndex)
+	if err != nil {
+		return nil, err
+	}
+	defer index.Close()
+	var (
+		outputs []*atomic.File
+		u       = &crossrefutil.SnapshotUpdate{
+			Snapshot: snapshot,
+			Index:    index,
+			Files:    files,
+			Excludes: excludes,
+		}
+	)
+	// create opens an output file, which is only moved into place, if the
+	// whole update succeeds.
+	create := func(filename string) (*atomic.File, error) {
+		f, err := atomic.New(filename, 0644)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, f)
+		return f, nil
+	}
+	abort := func() {
+		for _, f := range outputs {
+			f.Abort()
+		}
+	}
+	output, err := create(*outputFile)
+	if err != nil {
+		return nil, err
+	}
+	u.Output = output
+	var cw io.WriteCloser
+	if *compressed {
+		if cw, err = compressWriter(output); err != nil {
+			abort()
+			return nil, err
+		}
+		u.Output = cw
+	}
+	for _, v := range []struct {
+		filename string
+		w        *io.Writer
+	}{
+		{*indexFile, &u.IndexOutput},
+		{*deltaFile, &u.Delta},
+		{*deltaListFile, &u.DeltaList},
+	} {
+		if v.filename == "" {
+			continue
+		}
+		f, err := create(v.filename)
+		if err != nil {
+			abort()
+			return nil, err
+		}
+		*v.w = f
+	}
+	stats, err := u.Run()
+	if err != nil {
+		abort()
+		return nil, err
+	}
+	if cw != nil {
+		if err := cw.Close(); err != nil {
+			abort()
+			return nil, err
+		}
+	}
+	for _, f := range outputs {
+		if err := f.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
+
+// selectLatest writes the sorted line numbers of the most recent version of
+// each DOI into a temporary file and returns its name.
+func selectLatest(extractFile string) (string, error) {
+	f, err := os.Open(extractFile)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	output, err := ioutil.TempFile("", "span-crossref-snapshot-lines-")
+	if err != nil {
+		return "", err
+	}
+	defer output.Close()
+	if err := crossrefutil.SelectLatest(output, f, "", *partitions); err != nil {
+		os.Remove(output.Name())
+		return "", err
+	}
+	return output.Name(), nil
+}
+
+// externalSelectLatest uses sort to identify relevant records. Sort by DOI
+// (3), then date reversed (2); then unique by DOI (3). Should keep the entry
+// of the last update (filename, document date, DOI).
+func externalSelectLatest(extractFile string) (string, error) {
+	fastsort := `LC_ALL=C sort -S20%`
+	cmd := `{{ f }} -k3,3 -rk2,2 {{ input }} | {{ f }} -k3,3 -u | cut -f1 | {{ f }} -n > {{ output }}`
+	return clam.RunOutput(cmd, clam.Map{"f": fastsort, "input": extractFile})
+}
+
+// filterLines extracts the lines given in a file of sorted line numbers from
+// the input files and returns the name of a temporary file containing the
+// result, compressed, if requested.
+func filterLines(linenos string, files []string) (string, error) {
+	l, err := os.Open(linenos)
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	output, err := ioutil.TempFile("", "span-crossref-snapshot-output-")
+	if err != nil {
+		return "", err
+	}
+	defer output.Close()
+	var (
+		w  io.Writer = output
+		r            = &xio.ConcatReader{Filenames: files}
+		cw io.WriteCloser
+	)
+	defer r.Close()
+	if *compressed {
+		if cw, err = compressWriter(output); err != nil {
+			return "", err
+		}
+		w = cw
+	}
+	n, err := crossrefutil.FilterLines(w, r, l)
+	if err != nil {
+		os.Remove(output.Name())
+		return "", err
+	}
+	if cw != nil {
+		if err := cw.Close(); err != nil {
+			os.Remove(output.Name())
+			return "", err
+		}
+	}
+	log.WithFields(log.Fields{
+		"prefix": "stage 3",
+		"lines":  n,
+	}).Debug("extracted records")
+	return output.Name(), nil
+}
+
+// externalFilterLines uses filterline, or an awk fallback, and an external
+// compression program to extract relevant lines.
+func externalFilterLines(linenos string, files []string) (string, error) {
+	comp := fmt.Sprintf(`%s -c`, *compressProgram)
+	filterline := `filterline`
+	if _, err := exec.LookPath("filterline"); err != nil {
+		if _, err := exec.LookPath("awk"); err != nil {
+			return "", fmt.Errorf("filterline (git.io/v7qak) or awk is required")
+		}
+		tf, err := ioutil.TempFile("", "span-crossref-snapshot-filterline-")
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(tf.Name())
+		if _, err := io.WriteString(tf, fallback); err != nil {
+			return "", err
+		}
+		if err := tf.Close(); err != nil {
+			return "", err
+		}
+		if err := os.Chmod(tf.Name(), 0755); err != nil {
+			return "", err
+		}
+		filterline = tf.Name()
+	}
+	result, err := ioutil.TempFile("", "span-crossref-snapshot-output-")
+	if err != nil {
+		return "", err
+	}
+	if err := result.Close(); err != nil {
+		return "", err
+	}
+	script := fmt.Sprintf(`%s %s /dev/stdin > %s`, filterline, linenos, result.Name())
+	if *compressed {
+		switch *compressProgram {
+		case "zstd":
+			script = fmt.Sprintf(`%s %s /dev/stdin | %s -T0 > %s`, filterline, linenos, comp, result.Name())
+		default:
+			script = fmt.Sprintf(`%s %s /dev/stdin | %s > %s`, filterline, linenos, comp, result.Name())
+		}
+	}
+	log.Debug(script)
+	r := &xio.ConcatReader{Filenames: files}
+	defer r.Close()
+	c := exec.Command(clam.DefaultShell, "-c", script)
+	c.Stdin = r
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		os.Remove(result.Name())
+		return "", err
+	}
+	return result.Name(), nil
+}
+
 func main() {
 	flag.Parse()
 	if *verbose {
@@ -101,66 +408,27 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 	var (
-		r, reader io.Reader
-		f         *os.File
-		err       error
-		excludes  = make(map[string]struct{})
+		paths    = flag.Args()
+		files    []string
+		err      error
+		excludes = make(map[string]struct{})
 	)
-	switch {
-	case *pathFile != "":
-		log.Fatal("not yet implemented")
-		// b, err := ioutil.ReadFile(*pathFile)
-		// if err != nil {
-		// 	log.Fatal(err)
-		// }
-		// s := string(b)
-		// var readers []io.Reader
-		// for _, line := range strings.Split(s, "\n") {
-		// 	line = strings.TrimSpace(line)
-		// 	if len(line) == 0 || strings.HasPrefix(line, "#") {
-		// 		continue
-		// 	}
-		// 	f, err := os.Open(line)
-		// 	if err != nil {
-		// 		log.Fatal(err)
-		// 	}
-		// 	defer f.Close()
-		// 	readers = append(readers, f)
-		// }
-		// log.Printf("path-list: will read from %d files", len(readers))
-		// r = io.MultiReader(readers...)
-	case flag.NArg() == 0:
-		log.Fatal("input file required")
-	case flag.NArg() == 1:
-		f, err = os.Open(flag.Arg(0))
+	if *pathFile != "" {
+		listed, err := readPathList(*pathFile)
 		if err != nil {
 			log.Fatal(err)
 		}
-		defer f.Close()
-		r = f
-	default:
-		log.Fatal("no input specified")
+		paths = append(listed, paths...)
 	}
-	switch {
-	case *compressed && (*compressProgram == "gzip" || *compressProgram == "pigz"):
-		g, err := gzip.NewReader(r)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer g.Close()
-		reader = g
-	case *compressed && *compressProgram == "zstd":
-		g, err := zstd.NewReader(r)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer g.Close()
-		reader = g
-	case *compressed:
-		log.Fatal("only gzip and zstd supported currently")
-	default:
-		reader = r
+	if len(paths) == 0 {
+		log.Fatal("input file required")
 	}
+	if files, err = expandPaths(paths); err != nil {
+		log.Fatal(err)
+	}
+	log.WithFields(log.Fields{
+		"files": len(files),
+	}).Info("reading from input files")
 	if *outputFile == "" {
 		log.Fatal("output filename required")
 	}
@@ -175,6 +443,27 @@ func main() {
 		}
 		log.Debugf("excludes: %d", len(excludes))
 	}
+	if *updateSnapshot != "" {
+		if *updateIndex == "" {
+			log.Fatal("index of snapshot to update required (-update-index)")
+		}
+		log.WithFields(log.Fields{
+			"snapshot": *updateSnapshot,
+			"index":    *updateIndex,
+		}).Info("updating snapshot")
+		stats, err := runUpdate(files, excludes)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.WithFields(log.Fields{
+			"kept":      stats.Kept,
+			"updated":   stats.Updated,
+			"new":       stats.New,
+			"stale":     stats.Stale,
+			"unchanged": stats.Unchanged,
+		}).Info("updated snapshot")
+		return
+	}
 	// Stage 1: Extract minimum amount of information from the raw data, write to tempfile.
 	log.WithFields(log.Fields{
 		"prefix":       "stage 1",
@@ -185,6 +474,9 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer os.Remove(tf.Name())
+	reader := &xio.ConcatReader{Filenames: files}
+	defer reader.Close()
 	var (
 		br = bufio.NewReader(reader)
 		bw = bufio.NewWriter(tf)
@@ -228,77 +520,45 @@ func main() {
 	if err := tf.Close(); err != nil {
 		log.Fatal(err)
 	}
-	// Stage 2: Identify relevant records. Sort by DOI (3), then date reversed (2);
-	// then unique by DOI (3). Should keep the entry of the last update (filename,
-	// document date, DOI).
-	fastsort := `LC_ALL=C sort -S20%`
-	cmd := `{{ f }} -k3,3 -rk2,2 {{ input }} | {{ f }} -k3,3 -u | cut -f1 | {{ f }} -n > {{ output }}`
+	// Stage 2: Identify relevant records, i.e. the line numbers of the most
+	// recent version of each DOI.
 	log.WithFields(log.Fields{
-		"prefix":    "stage 2",
-		"batchsize": *batchsize,
+		"prefix":   "stage 2",
+		"external": *external,
 	}).Info("identifying relevant records")
-	output, err := clam.RunOutput(cmd, clam.Map{"f": fastsort, "input": tf.Name()})
+	stage2, stage3 := selectLatest, filterLines
+	if *external {
+		stage2, stage3 = externalSelectLatest, externalFilterLines
+	}
+	linenos, err := stage2(tf.Name())
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer os.Remove(output)
-	// External tools and fallbacks for stage 3. comp, decomp, filterline.
-	comp, decomp := fmt.Sprintf(`%s -c`, *compressProgram), fmt.Sprintf(`%s -d -c`, *compressProgram)
-	filterline := `filterline`
-	if _, err := exec.LookPath("filterline"); err != nil {
-		if _, err := exec.LookPath("awk"); err != nil {
-			log.Fatal("filterline (git.io/v7qak) or awk is required")
-		}
-		tf, err := ioutil.TempFile("", "span-crossref-snapshot-filterline-")
-		if err != nil {
-			log.Fatal(err)
-		}
-		if _, err := io.WriteString(tf, fallback); err != nil {
-			log.Fatal(err)
-		}
-		if err := tf.Close(); err != nil {
-			log.Fatal(err)
-		}
-		if err := os.Chmod(tf.Name(), 0755); err != nil {
-			log.Fatal(err)
-		}
-		defer os.Remove(tf.Name())
-		filterline = tf.Name()
-	}
-	// Stage 3: Extract relevant records. Compressed input will be recompressed again.
+	defer os.Remove(linenos)
+	// Stage 3: Extract relevant records. All input files are again read as
+	// a single stream, so line numbers match those of stage 1. Output will be
+	// compressed, if requested.
 	log.WithFields(log.Fields{
-		"prefix":     "stage 3",
-		"comp":       comp,
-		"decomp":     decomp,
-		"filterline": filterline,
+		"prefix":   "stage 3",
+		"external": *external,
 	}).Info("extract relevant records")
-	cmd = `{{ filterline }} {{ L }} {{ F }} > {{ output }}`
-	if *compressed {
-		switch *compressProgram {
-		case "zstd":
-			cmd = `{{ filterline }} {{ L }} <({{ decomp }} -T0 {{ F }}) | {{ comp }} -T0 > {{ output }}`
-		default:
-			cmd = `{{ filterline }} {{ L }} <({{ decomp }} {{ F }}) | {{ comp }} > {{ output }}`
-		}
-	}
-	// TODO: need to have a filesystem entry that would allow to have a "union"
-	// like view over multiple files;
-	// https://unix.stackexchange.com/q/748123/376.
-	output, err = clam.RunOutput(cmd, clam.Map{
-		"L":          output,
-		"F":          f.Name(),
-		"filterline": filterline,
-		"decomp":     decomp,
-		"comp":       comp,
-	})
+	result, err := stage3(linenos, files)
 	if err != nil {
 		log.Fatal(err)
 	}
-	if err := os.Rename(output, *outputFile); err != nil {
-		if err := CopyFile(*outputFile, output, 0644); err != nil {
+	if err := os.Rename(result, *outputFile); err != nil {
+		if err := CopyFile(*outputFile, result, 0644); err != nil {
+			log.Fatal(err)
+		}
+		os.Remove(result)
+	}
+	if *indexFile != "" {
+		log.WithFields(log.Fields{
+			"index": *indexFile,
+		}).Info("writing index")
+		if err := writeIndexFile(*indexFile, *outputFile); err != nil {
 			log.Fatal(err)
 		}
-		os.Remove(output)
 	}
 }
 
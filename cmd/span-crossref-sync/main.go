@@ -19,6 +19,18 @@
 // cron job. Processes that need this data can manually find files or create a
 // snapshot.
 //
+// Next to each cached file, a manifest (JSON) is written, containing the
+// window, filter, number of items written, total results reported by the API,
+// checksum and compression program. With -verify, all files for the given
+// date range are decompressed and recounted; mismatches, missing files and
+// gaps in date coverage are reported and the program exits non-zero. Files
+// cached before manifests existed and read completely get a manifest with
+// -backfill; without it, the manifest is only logged. Backfilled manifests
+// have no total results, so completeness is not checked for these files.
+//
+//	$ span-crossref-sync -verify -p zstd -P feed-1- -s 2022-01-01 -c /data/finc/crossref/
+//	$ span-crossref-sync -verify -backfill -p zstd -P feed-1- -s 2022-01-01 -c /data/finc/crossref/
+//
 // Data point: https://github.com/miku/filterline#data-point-crossref-snapshot
 package main
 
@@ -40,6 +52,7 @@ import (
 
 	"github.com/adrg/xdg"
 	"github.com/miku/span/atomic"
+	"github.com/miku/span/crossrefutil"
 	"github.com/miku/span/dateutil"
 	"github.com/miku/span/xflag"
 	"github.com/sethgrid/pester"
@@ -66,6 +79,9 @@ var (
 	compressProgram = flag.String("p", "gzip", "compress program: gzip or zstd")
 	prefix          = flag.String("P", "default-", "a tag to distinguish between different runs, filename prefix")
 	quiet           = flag.Bool("q", false, "do not emit any output, do not write to a file, just sync")
+	verify          = flag.Bool("verify", false, "verify cached files against their manifests, exit non-zero on problems")
+	backfill        = flag.Bool("backfill", false, "with -verify, write manifests for cached files without one, total results will be unknown")
+	verifyTolerance = flag.Float64("verify-tolerance", 0.0, "fraction of total results that may be missing in a file, e.g. 0.01")
 
 	syncStart xflag.Date = xflag.Date{Time: dateutil.MustParse("2021-01-01")}
 	syncEnd   xflag.Date = xflag.Date{Time: time.Now().Add(-24 * time.Hour)}
@@ -115,7 +131,9 @@ type WorksResponse struct {
 // filters should always be of the form YYYY-MM-DD, YYYY-MM or YYYY. The date
 // filters are inclusive
 // (https://api.crossref.org/swagger-ui/index.html#/operations/Works/get_works).
-func (s *Sync) writeWindow(w io.Writer, f, u time.Time) error {
+// Returns the number of lines written and the total number of results
+// reported by the API.
+func (s *Sync) writeWindow(w io.Writer, f, u time.Time) (written, total int64, err error) {
 	filter := fmt.Sprintf("from-%s-date:%s,until-%s-date:%s",
 		s.ApiFilter, f.Format("2006-01-02"), s.ApiFilter, u.Format("2006-01-02"))
 	vs := url.Values{}
@@ -137,16 +155,16 @@ OUTER:
 		}
 		req, err := http.NewRequest("GET", link, nil)
 		if err != nil {
-			return err
+			return written, total, err
 		}
 		req.Header.Add("User-Agent", s.UserAgent)
 		resp, err := s.Client.Do(req)
 		if err != nil {
-			return err
+			return written, total, err
 		}
 		defer resp.Body.Close()
 		if resp.StatusCode >= 400 {
-			return fmt.Errorf("HTTP %d", resp.StatusCode)
+			return written, total, fmt.Errorf("HTTP %d", resp.StatusCode)
 		}
 		var wr WorksResponse
 		if err := json.NewDecoder(resp.Body).Decode(&wr); err != nil {
@@ -158,13 +176,14 @@ OUTER:
 			} else {
 				// total: 10493829, seen: 3120000 (29.73%)
 				// 2021/12/14 18:15:08 decode: unexpected EOF
-				return fmt.Errorf("decode: %v", err)
+				return written, total, fmt.Errorf("decode: %v", err)
 			}
 		}
 		if wr.Status != "ok" {
-			return fmt.Errorf("crossref api failed: %s", wr.Status)
+			return written, total, fmt.Errorf("crossref api failed: %s", wr.Status)
 		}
 		seen = seen + int64(len(wr.Message.Items))
+		total = wr.Message.TotalResults
 		if s.Verbose {
 			var pct float64
 			if wr.Message.TotalResults == 0 {
@@ -179,25 +198,27 @@ OUTER:
 		case "t", "tabs":
 			if _, err := fmt.Fprintf(w, "%s\t%d\t%d\n",
 				f.Format("2006-01-02"), seen, wr.Message.TotalResults); err != nil {
-				return err
+				return written, total, err
 			}
+			written++
 			break OUTER
 		case "s", "sync":
 			for _, item := range wr.Message.Items {
 				item = append(item, bNewline...)
 				if _, err := w.Write(item); err != nil {
-					return err
+					return written, total, err
 				}
+				written++
 			}
 			if seen >= wr.Message.TotalResults {
 				if s.Verbose {
 					log.Printf("done, seen: %d, total: %d", seen, wr.Message.TotalResults)
 				}
-				return nil
+				return written, total, nil
 			}
 			cursor := wr.Message.NextCursor
 			if cursor == "" {
-				return nil
+				return written, total, nil
 			}
 			vs = url.Values{}
 			vs.Add("cursor", cursor)
@@ -205,7 +226,7 @@ OUTER:
 				vs.Add("mailto", s.ApiEmail)
 			}
 		default:
-			return fmt.Errorf("use tabs (t) or sync (s) mode")
+			return written, total, fmt.Errorf("use tabs (t) or sync (s) mode")
 		}
 		// status: ok, total: 55818, seen: 47818 (85.67%)
 		// We had repeated requests, with seemingly a new cursor, but no new
@@ -216,12 +237,12 @@ OUTER:
 				log.Printf("assuming ok to skip - seen: %d, total: %d", seen, wr.Message.TotalResults)
 				break
 			} else {
-				return fmt.Errorf("no more messages, consider restart; total: %d, seen: %d", wr.Message.TotalResults, seen)
+				return written, total, fmt.Errorf("no more messages, consider restart; total: %d, seen: %d", wr.Message.TotalResults, seen)
 			}
 		}
 		i = 0
 	}
-	return nil
+	return written, total, nil
 }
 
 func cleanup() error {
@@ -236,6 +257,100 @@ func cleanup() error {
 	})
 }
 
+// cacheFilename returns the path to the cached file for a given interval.
+func cacheFilename(iv dateutil.Interval) string {
+	var ext string
+	switch {
+	case *compressProgram == "zstd":
+		ext = "zst"
+	default:
+		ext = "gz"
+	}
+	return path.Join(*cacheDir, fmt.Sprintf("%s%s-%s-%s.json.%s",
+		*prefix,
+		*apiFilter,
+		iv.Start.Format("2006-01-02"),
+		iv.End.Format("2006-01-02"),
+		ext))
+}
+
+// verifyCache checks all cached files for the given intervals and writes a
+// report line per file. Returns the number of problems found. A missing file
+// for an interval counts as a gap in date coverage.
+func verifyCache(w io.Writer, ivs []dateutil.Interval) (int, error) {
+	var (
+		manifests []*crossrefutil.Manifest
+		reported  []dateutil.Interval
+		problems  int
+	)
+	for _, iv := range ivs {
+		var (
+			iv        = iv
+			cachePath = cacheFilename(iv)
+			verifier  = &crossrefutil.Verifier{
+				Tolerance:     *verifyTolerance,
+				WriteBackfill: *backfill,
+				Backfill: func(filename string) *crossrefutil.Manifest {
+					return &crossrefutil.Manifest{
+						Start:           iv.Start,
+						End:             iv.End,
+						Filter:          *apiFilter,
+						Mode:            *mode,
+						CompressProgram: *compressProgram,
+					}
+				},
+			}
+		)
+		if *verbose {
+			log.Printf("verifying: %v", cachePath)
+		}
+		v, err := verifier.VerifyFile(cachePath)
+		if err != nil {
+			return problems, err
+		}
+		switch {
+		case v.Backfilled && *backfill:
+			log.Printf("backfilled manifest for %s, total results unknown", cachePath)
+		case v.Backfilled:
+			b, err := json.Marshal(v.Manifest)
+			if err != nil {
+				return problems, err
+			}
+			log.Printf("manifest for %s, not written (see: -backfill): %s", cachePath, b)
+		}
+		if v.Manifest == nil {
+			// Reported per file, not again as gap.
+			reported = append(reported, iv)
+		}
+		if m := v.Manifest; m != nil {
+			manifests = append(manifests, m)
+			if !m.Start.Equal(iv.Start) || !m.End.Equal(iv.End) {
+				v.Problems = append(v.Problems, fmt.Sprintf("window mismatch: got %s, want %s",
+					dateutil.Interval{Start: m.Start, End: m.End}, iv))
+			}
+		}
+		if v.OK() {
+			if _, err := fmt.Fprintf(w, "OK\t%s\t%d\n", cachePath, v.Lines); err != nil {
+				return problems, err
+			}
+			continue
+		}
+		for _, p := range v.Problems {
+			if _, err := fmt.Fprintf(w, "FAIL\t%s\t%d\t%s\n", cachePath, v.Lines, p); err != nil {
+				return problems, err
+			}
+			problems++
+		}
+	}
+	for _, gap := range crossrefutil.Gaps(manifests, reported...) {
+		if _, err := fmt.Fprintf(w, "GAP\t%s\n", gap); err != nil {
+			return problems, err
+		}
+		problems++
+	}
+	return problems, nil
+}
+
 func main() {
 	flag.Var(&syncStart, "s", "start date for harvest")
 	flag.Var(&syncEnd, "e", "end date for harvest")
@@ -288,6 +403,14 @@ func main() {
 		for _, iv := range ivs {
 			fmt.Println(iv)
 		}
+	case *verify:
+		problems, err := verifyCache(w, ivs)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if problems > 0 {
+			log.Fatalf("verification failed with %d problem(s)", problems)
+		}
 	default:
 		c := make(chan os.Signal, 1)
 		signal.Notify(c, os.Interrupt)
@@ -299,19 +422,7 @@ func main() {
 			os.Exit(1) // TODO: a better way?
 		}()
 		for _, iv := range ivs {
-			var ext string
-			switch {
-			case *compressProgram == "zstd":
-				ext = "zst"
-			default:
-				ext = "gz"
-			}
-			cachePath := path.Join(*cacheDir, fmt.Sprintf("%s%s-%s-%s.json.%s",
-				*prefix,
-				*apiFilter,
-				iv.Start.Format("2006-01-02"),
-				iv.End.Format("2006-01-02"),
-				ext))
+			cachePath := cacheFilename(iv)
 			if *verbose {
 				log.Printf("cache path: %v", cachePath)
 			}
@@ -320,7 +431,8 @@ func main() {
 				if err != nil {
 					log.Fatal(err)
 				}
-				if err = sync.writeWindow(cacheFile, iv.Start, iv.End); err != nil {
+				written, total, err := sync.writeWindow(cacheFile, iv.Start, iv.End)
+				if err != nil {
 					log.Fatal(err)
 				}
 				if err := cacheFile.Close(); err != nil {
@@ -330,6 +442,28 @@ func main() {
 				if err != nil {
 					log.Fatal(err)
 				}
+				checksum, err := crossrefutil.Checksum(compressed)
+				if err != nil {
+					log.Fatal(err)
+				}
+				manifest := &crossrefutil.Manifest{
+					Filename:        path.Base(cachePath),
+					Start:           iv.Start,
+					End:             iv.End,
+					Filter:          *apiFilter,
+					Mode:            *mode,
+					ItemsWritten:    written,
+					TotalResults:    total,
+					SHA256:          checksum,
+					CompressProgram: *compressProgram,
+					Created:         time.Now(),
+				}
+				// The manifest is written first, so a file is never left
+				// without one; a manifest without file is replaced on the
+				// next run.
+				if err := manifest.WriteFile(crossrefutil.ManifestPath(cachePath)); err != nil {
+					log.Fatal(err)
+				}
 				if err := atomic.Move(compressed, cachePath); err != nil {
 					log.Fatal(err)
 				}
@@ -2,6 +2,17 @@
 // information relevant to attachments.  Docs:
 // https://s3.amazonaws.com/foliodocs/api/mod-finc-config/p/fincConfigMetadataCollections.html
 //
+// With -db, collections, sources and filters are turned into an sqlite
+// database with the same schema as span-amsl-discovery -db, usable with
+// span-tagger; -tsv writes the same rows as tab separated values.
+//
+//	$ span-folio -u user:pass -db folio.db
+//	$ span-tagger -db folio.db -folio https://okapi.erm.staging.folio.finc.info -u user:pass < file.is
+//
+// Holding file links point to finc-config files, which require authentication,
+// so span-tagger needs the FOLIO endpoint used by span-folio (-folio) and
+// credentials (-u); without -folio, span-tagger fails on these links.
+//
 // Get metadata collections per ISIL, each "fincConfigMetadataCollections",
 // "FilterToCollections", "Filter".
 //
@@ -73,6 +84,8 @@
 package main
 
 import (
+	"bufio"
+	"database/sql"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -80,11 +93,16 @@ import (
 	"os"
 	"strings"
 	"text/tabwriter"
+	"time"
 
+	"github.com/miku/span"
 	"github.com/miku/span/folio"
 	"github.com/miku/span/strutil"
+	"github.com/miku/span/tagging"
 	"github.com/miku/span/xflag"
 	"github.com/sethgrid/pester"
+
+	_ "github.com/mattn/go-sqlite3"
 )
 
 // TODO: Add config file location, also: unify config file handling.
@@ -93,12 +111,39 @@ import (
 var (
 	muFolio   = flag.String("folio", "https://okapi.erm.staging.folio.finc.info", "folio endpoint")
 	tenant    = flag.String("tenant", "de_15", "folio tenant")
-	limit     = flag.Int("limit", 100000, "limit for lists")
+	limit     = flag.Int("limit", folio.DefaultPageSize, "page size for lists, all pages are fetched")
 	cqlQuery  = flag.String("cql", `(selectedBy=("*"))`, `cql query, e.g. (selectedBy=("DE-15")`)
 	rawOutput = flag.Bool("r", false, "raw output")
+	dbFile    = flag.String("db", "", "write attachment rows to sqlite3 database file (amsl schema)")
+	tsvOutput = flag.Bool("tsv", false, "write attachment rows as TSV")
 	userPass  xflag.UserPassword
 )
 
+// writeDatabase writes config rows into a new database file.
+func writeDatabase(filename string, rows []tagging.ConfigRow) error {
+	if _, err := os.Stat(filename); err == nil {
+		return fmt.Errorf("%s: file exists", filename)
+	}
+	db, err := sql.Open("sqlite3", filename)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := tagging.Migrate(db); err != nil {
+		return err
+	}
+	for k, v := range map[string]string{
+		"created": time.Now().Format(time.RFC3339),
+		"origin":  *muFolio,
+		"version": span.AppVersion,
+	} {
+		if err := tagging.SetMeta(db, k, v); err != nil {
+			return err
+		}
+	}
+	return tagging.WriteRows(db, rows)
+}
+
 func main() {
 	flag.Var(&userPass, "u", "user:password for api")
 	flag.Parse()
@@ -114,17 +159,44 @@ func main() {
 		log.Fatal(err)
 	}
 	log.Println("[ok] auth")
-	opts := folio.MetadataCollectionsOpts{
-		CQL:   *cqlQuery,
-		Limit: *limit,
+	if *dbFile != "" || *tsvOutput {
+		config, err := tagging.FetchFolioConfig(&api, *cqlQuery, *limit)
+		if err != nil {
+			log.Fatal(err)
+		}
+		rows := config.Rows()
+		log.Printf("[ok] %d rows", len(rows))
+		if *dbFile != "" {
+			if err := writeDatabase(*dbFile, rows); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		bw := bufio.NewWriter(os.Stdout)
+		defer bw.Flush()
+		for _, r := range rows {
+			fields := []string{
+				r.ShardLabel, r.ISIL, r.SourceID, r.TechnicalCollectionID,
+				r.MegaCollection, r.HoldingsFileURI, r.HoldingsFileLabel,
+				r.LinkToHoldingsFile, r.EvaluateHoldingsFileForLibrary,
+				r.ContentFileURI, r.ContentFileLabel, r.LinkToContentFile,
+				r.ExternalLinkToContentFile, r.ProductISIL, r.DokumentURI,
+				r.DokumentLabel,
+			}
+			for i, f := range fields {
+				fields[i] = strings.ReplaceAll(f, "\t", " ")
+			}
+			fmt.Fprintln(bw, strings.Join(fields, "\t"))
+		}
+		return
 	}
-	resp, err := api.MetadataCollections(opts)
+	collections, err := api.AllMetadataCollections(*cqlQuery, *limit)
 	if err != nil {
 		log.Fatal(err)
 	}
 	switch {
 	case *rawOutput:
-		for _, v := range resp.FincConfigMetadataCollections {
+		for _, v := range collections {
 			b, err := json.Marshal(v)
 			if err != nil {
 				log.Fatal(err)
@@ -134,7 +206,7 @@ func main() {
 	default:
 		w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
 		defer w.Flush()
-		for _, entry := range resp.FincConfigMetadataCollections {
+		for _, entry := range collections {
 			fmt.Fprintf(w, "%s\t%s\t%s\n",
 				strutil.Truncate(entry.Label, 40),
 				strutil.Truncate(strings.Join(entry.SolrMegaCollections, ", "), 40),
@@ -21,6 +21,8 @@ var (
 	holdingsFile = flag.String("f", "", "path to holdings file in KBART format (not all CSV files will work)")
 	issnList     = flag.String("l", "", "path to ISSN list (1234-789X), one per line, empty lines ignored (overrides -f)")
 	server       = flag.String("server", "", "server url to check agains")
+	timeout      = flag.Duration("timeout", 10*time.Minute, "timeout for a single SOLR request")
+	retries      = flag.Int("retries", 3, "number of retries for failed SOLR requests")
 )
 
 func main() {
@@ -68,7 +70,7 @@ func main() {
 		log.Fatal("holdings file or issn list required")
 	}
 
-	ilist, err := indexSerialNumbers(*server)
+	ilist, err := indexSerialNumbers(*server, solrutil.NewClient(*timeout, *retries))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -109,8 +111,8 @@ func normalizeSerialNumbers(s []string) (result []string) {
 }
 
 // indexSerialNumbers returns a unique list of ISSN from a SOLR index.
-func indexSerialNumbers(server string) ([]string, error) {
-	index := solrutil.Index{Server: server, FacetLimit: 1000000}
+func indexSerialNumbers(server string, client *solrutil.Client) ([]string, error) {
+	index := solrutil.Index{Server: server, FacetLimit: 1000000, Client: client}
 	return index.FacetKeys("*:*", "issn")
 }
 
@@ -1,74 +1,44 @@
 // span-oa-filter will set x.oa to true, if the given KBART file validates a record.
+//
+// Additional evidence can come from an Unpaywall snapshot (-unpaywall), a DOAJ
+// journal CSV (-doaj) and Creative Commons license URLs in x.license
+// (-license). The evidence is recorded in x.oa_reason, the license type, if
+// known, in x.oa_license.
+//
+//	$ span-oa-filter -f oa.kbart -unpaywall unpaywall.jsonl.gz -doaj doaj.csv -license < file.is
 package main
 
 import (
+	"archive/zip"
 	"bufio"
-	"github.com/segmentio/encoding/json"
 	"flag"
 	"fmt"
 	"os"
-	"strings"
+
+	"github.com/segmentio/encoding/json"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/miku/span"
-	"github.com/miku/span/filter"
 	"github.com/miku/span/formats/finc"
+	"github.com/miku/span/openaccess"
 	"github.com/miku/span/parallel"
 	"github.com/miku/span/xflag"
+	"github.com/miku/span/xio"
 )
 
-// FreeContentItem is a single item from the API response (2017-12-01).
-type FreeContentItem struct {
-	FreeContent    string `json:"freeContent"`
-	MegaCollection string `json:"mega_collection"`
-	Shard          string `json:"shard"`
-	Sid            string `json:"sid"`
-}
-
-// FreeContentLookup maps a string of the form "Sid:MegaCollection" to a bool,
-// indicating free access (true) and uncertainty or closed access (false).
-type FreeContentLookup map[string]bool
-
-// createFreeContentLookup creates a map for fast lookups in loops. Filename
-// contains AMSL API response (2017-12-01).
-// XXX: This can take up significant memory (e.g. 40% of 16G).
-func createFreeContentLookup(filename string) (FreeContentLookup, error) {
-	lookup := make(FreeContentLookup)
-
+// openHoldings reads a KBART file, which may be zipped.
+func openHoldings(filename string) (*openaccess.Holdings, error) {
+	if zr, err := zip.OpenReader(filename); err == nil {
+		zr.Close()
+		return openaccess.ReadHoldings(&xio.ZipContentReader{Filename: filename})
+	}
 	f, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
-
-	var items []FreeContentItem
-	if err := json.NewDecoder(f).Decode(&items); err != nil {
-		return nil, err
-	}
-
-	for _, item := range items {
-		key := fmt.Sprintf("%s:%s", item.Sid, item.MegaCollection)
-		switch strings.TrimSpace(strings.ToLower(item.FreeContent)) {
-		case "ja", "yes", "ok", "1", "T", "true":
-			lookup[key] = true
-		case "nicht festgelegt":
-			lookup[key] = false
-		default:
-			lookup[key] = false
-		}
-	}
-	return lookup, nil
-}
-
-// kbartToFilterConfig creates map that can be serialized into a valid filterconfig JSON.
-func kbartToFilterConfig(filename string, verbose bool) (interface{}, error) {
-	return map[string]map[string]interface{}{
-		"holdings": map[string]interface{}{
-			"file":    filename,
-			"verbose": verbose,
-		},
-	}, nil
+	return openaccess.ReadHoldings(f)
 }
 
 var (
@@ -79,8 +49,39 @@ var (
 	verbose          = flag.Bool("verbose", false, "extended output")
 	debug            = flag.Bool("debug", false, "debug output")
 	batchMemoryLimit = flag.Int64("m", 209715200, "memory limit per batch")
+	unpaywallFile    = flag.String("unpaywall", "", "path to an Unpaywall snapshot (NDJSON, may be compressed), open access DOI are kept in memory, about 8 bytes each")
+	doajFile         = flag.String("doaj", "", "path to a DOAJ journal CSV file")
+	useLicense       = flag.Bool("license", false, "use Creative Commons license URLs in x.license as evidence")
 )
 
+// loadDetector prepares lookups for additional evidence.
+func loadDetector() (*openaccess.Detector, error) {
+	d := &openaccess.Detector{UseLicense: *useLicense}
+	if *unpaywallFile != "" {
+		f, err := xio.OpenDecompress(*unpaywallFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if d.Unpaywall, err = openaccess.ReadUnpaywall(f); err != nil {
+			return nil, fmt.Errorf("%s: %v", *unpaywallFile, err)
+		}
+		log.Printf("loaded %d open access DOI from unpaywall", d.Unpaywall.Len())
+	}
+	if *doajFile != "" {
+		f, err := xio.OpenDecompress(*doajFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if d.DOAJ, err = openaccess.ReadDOAJ(f); err != nil {
+			return nil, fmt.Errorf("%s: %v", *doajFile, err)
+		}
+		log.Printf("loaded %d ISSN from DOAJ", len(d.DOAJ))
+	}
+	return d, nil
+}
+
 func main() {
 	var (
 		excludeSourceIdentifiersFlags    xflag.Array
@@ -98,30 +99,35 @@ func main() {
 		os.Exit(0)
 	}
 
-	// Prepare filterconfig.
-	fmap, err := kbartToFilterConfig(*kbartFile, *verbose)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	config, err := json.Marshal(fmap)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// Create a holdings filter, fail here, if files are broken.
-	filter := filter.HoldingsFilter{}
-	if err := filter.UnmarshalJSON(config); err != nil {
-		log.Fatal(err)
+	// Load holdings, fail here, if files are broken.
+	var (
+		holdings *openaccess.Holdings
+		lookup   *openaccess.FreeContent
+		err      error
+	)
+	if *kbartFile != "" {
+		if holdings, err = openHoldings(*kbartFile); err != nil {
+			log.Fatal(err)
+		}
+		holdings.Verbose = *verbose
+		log.Printf("loaded holdings with %d ISSN", holdings.Len())
 	}
-
-	lookup := make(FreeContentLookup)
 	if *freeContentFile != "" {
-		lookup, err = createFreeContentLookup(*freeContentFile)
+		f, err := os.Open(*freeContentFile)
 		if err != nil {
 			log.Fatal(err)
 		}
-		log.Printf("loaded free content map with %d entries", len(lookup))
+		lookup, err = openaccess.ReadFreeContent(bufio.NewReader(f))
+		if err != nil {
+			log.Fatal(err)
+		}
+		f.Close()
+		log.Printf("loaded free content map with %d entries", lookup.Len())
+	}
+
+	detector, err := loadDetector()
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	excludeSids := make(map[string]bool)
@@ -145,28 +151,48 @@ func main() {
 
 		if _, ok := openAccessSids[is.SourceID]; ok {
 			is.OpenAccess = true
+			is.OpenAccessReason = openaccess.ReasonSourceID
 		} else {
 			// Bail out on excluded SIDs, refs #12738.
 			if _, ok := excludeSids[is.SourceID]; !ok {
 
 				// Set OA by KBART: various list (e.g. KBART in AMSL, OA GOLD list, maybe more in this format).
-				if filter.Apply(is) {
+				if holdings != nil && holdings.Covers(&is) {
 					is.OpenAccess = true
+					is.OpenAccessReason = openaccess.ReasonKBART
 				}
 
 				// Additionally, compare free content API results.
 				for _, c := range is.MegaCollections {
-					key := fmt.Sprintf("%s:%s", is.SourceID, c)
-					if v, ok := lookup[key]; ok {
+					if lookup == nil {
+						break
+					}
+					if v, ok := lookup.Lookup(is.SourceID, c); ok {
 						is.OpenAccess = v
 						if v {
+							is.OpenAccessReason = openaccess.ReasonFreeContent
 							break // In case of multiple collections, we keep the max.
 						}
 					}
 				}
+
+				// Article or journal level evidence, if collections did not tell.
+				if !is.OpenAccess {
+					if e, ok := detector.Detect(&is); ok {
+						is.OpenAccess = true
+						is.OpenAccessReason = e.Reason
+						is.OpenAccessLicense = e.License
+					}
+				}
 			}
 		}
 
+		if !is.OpenAccess {
+			is.OpenAccessReason, is.OpenAccessLicense = "", ""
+		} else if is.OpenAccessLicense == "" {
+			is.OpenAccessLicense = openaccess.License(&is)
+		}
+
 		bb, err := json.Marshal(is)
 		if err != nil {
 			return bb, err
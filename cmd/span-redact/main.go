@@ -1,11 +1,18 @@
 // Redact intermediate schema, that is set fulltext field to the empty string.
 // This can be done with `jq` and `del` as well, but span-redact is a bit
 // faster, as it can work in parallel.
+//
+// Other fields can be removed from all records or per source, and only
+// selected fields can be kept; this works on intermediate schema and Solr
+// documents alike. Without options, x.fulltext is removed.
+//
+//	$ span-redact -drop abstract,x.fulltext < file.is
+//	$ span-redact -rule 48:abstract -drop fullrecord < solr.ndj
+//	$ span-redact -keep finc.id,finc.source_id,authors.rft.au < file.is
 package main
 
 import (
 	"bufio"
-	"github.com/segmentio/encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -15,14 +22,21 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/miku/span"
-	"github.com/miku/span/formats/finc"
 	"github.com/miku/span/parallel"
+	"github.com/miku/span/redactutil"
+	"github.com/miku/span/xflag"
 )
 
 func main() {
 	showVersion := flag.Bool("v", false, "prints current program version")
 	size := flag.Int("b", 20000, "batch size")
 	numWorkers := flag.Int("w", runtime.NumCPU(), "number of workers")
+	keep := flag.String("keep", "", "keep only these fields, comma separated, e.g. finc.id,authors.rft.au")
+	quiet := flag.Bool("q", false, "do not report changed records per field")
+
+	var dropFlags, ruleFlags xflag.Array
+	flag.Var(&dropFlags, "drop", "fields to remove from all records, comma separated (repeatable)")
+	flag.Var(&ruleFlags, "rule", "fields to remove from records of a source, e.g. 48:abstract,x.fulltext (repeatable)")
 
 	flag.Parse()
 
@@ -31,6 +45,22 @@ func main() {
 		os.Exit(0)
 	}
 
+	redactor := &redactutil.Redactor{Keep: redactutil.SplitFields(*keep)}
+	for _, v := range dropFlags {
+		redactor.Rules = append(redactor.Rules, redactutil.Rule{Fields: redactutil.SplitFields(v)})
+	}
+	for _, v := range ruleFlags {
+		rule, err := redactutil.ParseRule(v)
+		if err != nil {
+			log.Fatal(err)
+		}
+		redactor.Rules = append(redactor.Rules, rule)
+	}
+	if len(redactor.Rules) == 0 && len(redactor.Keep) == 0 {
+		// Redact full text.
+		redactor.Rules = []redactutil.Rule{{Fields: []string{"x.fulltext"}}}
+	}
+
 	var reader io.Reader = os.Stdin
 
 	if flag.NArg() > 0 {
@@ -50,20 +80,11 @@ func main() {
 	defer w.Flush()
 
 	p := parallel.NewProcessor(bufio.NewReader(reader), w, func(_ int64, b []byte) ([]byte, error) {
-		is := finc.IntermediateSchema{}
-
-		if err := json.Unmarshal(b, &is); err != nil {
+		bb, err := redactor.Redact(b)
+		if err != nil {
 			log.Printf("failed to unmarshal: %s", string(b))
 			return b, err
 		}
-
-		// Redact full text.
-		is.Fulltext = ""
-
-		bb, err := json.Marshal(is)
-		if err != nil {
-			return bb, err
-		}
 		bb = append(bb, '\n')
 		return bb, nil
 	})
@@ -74,4 +95,11 @@ func main() {
 	if err := p.Run(); err != nil {
 		log.Fatal(err)
 	}
+	if !*quiet {
+		n, counts := redactor.Counts()
+		log.Printf("%d records", n)
+		for _, c := range counts {
+			log.Printf("%s: %d records changed", c.Field, c.Count)
+		}
+	}
 }
@@ -64,6 +64,8 @@ var (
 	verbose     = flag.Bool("verbose", false, "be verbose")
 	numWorker   = flag.Int("w", 32, "number of workers for parallel reports")
 	batchSize   = flag.Int("bs", 1, "number of values passed to workers")
+	timeout     = flag.Duration("timeout", 60*time.Second, "timeout for a single SOLR request")
+	retries     = flag.Int("retries", 3, "number of retries for failed SOLR requests")
 
 	reportTypes = []string{"basic", "json", "fast", "faster"}
 )
@@ -183,7 +185,10 @@ func main() {
 		os.Exit(0)
 	}
 
-	index := solrutil.Index{Server: solrutil.PrependHTTP(*server)}
+	index := solrutil.Index{
+		Server: solrutil.PrependHTTP(*server),
+		Client: solrutil.NewClient(*timeout, *retries),
+	}
 	var err error
 
 	if *sid == "" {
@@ -2,12 +2,16 @@
 // queries, refs #12756.
 //
 // There is a yaml file for configuring queries. It is possible to send results
-// directly to a Redmine ticket. This program can be used standalone, or via
-// span-webhookd.
+// directly to a ticket (Redmine, GitLab issue), a webhook or via mail, as
+// configured with "notify" in the span config. This program can be used
+// standalone, or via span-webhookd.
 //
 // Additional rules: Ansigelung, sigeltest.
 //
-// * [ ] Allow for margins, 97% correct.
+// Most rules accept an optional tolerance as last element, e.g. "~3" allows 3%
+// of records to violate a rule.
+//
+// Exit code is 3, if any case failed, and 1 on errors.
 package main
 
 import (
@@ -20,11 +24,14 @@ import (
 	"strconv"
 	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/segmentio/encoding/json"
 
 	"github.com/fatih/color"
+	"github.com/ilyakaznacheev/cleanenv"
 	"github.com/miku/span"
+	"github.com/miku/span/configutil"
 	"github.com/miku/span/reviewutil"
 	"github.com/miku/span/solrutil"
 	"github.com/miku/span/xio"
@@ -153,6 +160,37 @@ min-ratio:
 # appears at least a fixed number of times.
 min-count:
     - ["source_id:89", "facet_avail", "Free", 50]
+
+# Tolerance in percent, used for all cases without an explicit tolerance. Most
+# cases accept an optional tolerance as last element, e.g. "~3". For
+# allowed-keys, all-records and facet-regex the tolerance is the ratio of
+# records allowed to violate the rule, for min-ratio, min-count, max-count and
+# field-present-ratio it relaxes the given bound.
+tolerance: 0
+
+# MaxCount: Query, Facet-Field, Value, Max Count. Checks, if the given value
+# appears at most a fixed number of times.
+# max-count:
+#     - ["source_id:89", "facet_avail", "Free", 10000000]
+
+# FieldPresentRatio: Query, Field, Ratio (Percent). Checks, if at least the
+# given percentage of documents has any value in a field.
+# field-present-ratio:
+#     - ["source_id:49", "issn", 90, "~2"]
+
+# FacetRegex: Query, Facet-Field, Pattern. Checks, if all values of a facet
+# match a regular expression (matching the whole value).
+# facet-regex:
+#     - ["source_id:49", "publishDate", "[12][0-9]{3}"]
+
+# The live solr server for compare-to-live cases. If "auto" or empty, the
+# current live solr server will be figured out automatically.
+# live: "auto"
+
+# CompareToLive: Query. Checks, if the number of results did not drop by more
+# than the tolerance compared to the live server.
+# compare-to-live:
+#     - ["source_id:48", "~5"]
 `
 
 const (
@@ -162,23 +200,34 @@ const (
 
 var (
 	server         = flag.String("server", "", "location of SOLR server, overrides review.yaml")
+	live           = flag.String("live", "", "location of live SOLR server for compare-to-live cases, overrides review.yaml")
 	textile        = flag.Bool("t", false, "emit a textile table to stdout")
 	ascii          = flag.Bool("a", false, "emit ascii table to stdout")
 	reviewFile     = flag.String("c", "", "path to review.yaml file containing test cases, e.g. https://git.io/fh5Zh")
 	spanConfigFile = flag.String("span-config", path.Join(xio.UserHomeDir(), ".config/span/span.json"), "gitlab, redmine tokens, whatislive location")
-	ticket         = flag.String("ticket", "", "post result to redmine, overrides review.yaml, requires redmine.baseurl and redmine.apitoken configured in span-config")
+	ticket         = flag.String("ticket", "", "post result to ticket, overrides review.yaml, requires notify settings (default: redmine.baseurl and redmine.apitoken) in span-config")
 	noCollapse     = flag.Bool("C", false, "do not collapse details")
+	format         = flag.String("format", "", "output format: ascii, textile, json, junit")
+	historyDir     = flag.String("history-dir", defaultHistoryDir(), "directory to keep review results in")
+	noHistory      = flag.Bool("no-history", false, "do not save review results to history")
+	timeout        = flag.Duration("timeout", 60*time.Second, "timeout for a single SOLR request")
+	retries        = flag.Int("retries", 3, "number of retries for failed SOLR requests")
+	showHistory    = flag.Bool("history", false, "show cases that changed between two reviews of a server (see: -from, -to), then exit")
+	listHistory    = flag.Bool("history-list", false, "list recorded reviews of a server, then exit")
+	historyFrom    = flag.String("from", "-2", "with -history, earlier review, by index (negative counts from most recent) or timestamp")
+	historyTo      = flag.String("to", "-1", "with -history, later review, by index (negative counts from most recent) or timestamp")
 )
 
-// Result represents a single result row. XXX: Maybe add more fields, e.g.
-// number of results in this test case.
-type Result struct {
-	SourceIdentifier string
-	Link             string
-	SolrField        string
-	FixedResult      bool
-	Passed           bool
-	Comment          string
+// Result is a single result row.
+type Result = reviewutil.Result
+
+// defaultHistoryDir returns the default location of the review history.
+func defaultHistoryDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return path.Join(xio.UserHomeDir(), ".cache/span/review")
+	}
+	return path.Join(dir, "span/review")
 }
 
 // TextileResultWriter converts Results to Textile markup.
@@ -228,14 +277,82 @@ func (w *TextileResultWriter) WriteResults(rs []Result) (written int, err error)
 	return written, nil
 }
 
-// Given a query string, parse out the source identifier, panics currently, if
-// query is not of the form source_id:23. TODO(miku): make this more flexible.
-func MustParseSourceIdentifier(s string) string {
+// MarkdownResultWriter converts Results to a markdown table.
+type MarkdownResultWriter struct {
+	w io.Writer
+}
+
+// WriteResults writes a table of results.
+func (w *MarkdownResultWriter) WriteResults(rs []Result) (written int, err error) {
+	n, err := io.WriteString(w.w, "| Source ID, Field | Fixed | Passed | Comment |\n| --- | --- | --- | --- |\n")
+	if err != nil {
+		return 0, err
+	}
+	written += n
+	for _, r := range rs {
+		f, p := Check, Check
+		if !r.FixedResult {
+			f = Cross
+		}
+		if !r.Passed {
+			p = Cross
+		}
+		n, err := fmt.Fprintf(w.w, "| [%s %s](%s) | %v | %v | %v |\n",
+			r.SourceIdentifier, r.SolrField, r.Link, f, p, r.Comment)
+		if err != nil {
+			return 0, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// renderMessage renders review results for a ticket, in a given markup.
+func renderMessage(markup, server, hostname string, results []Result) (string, error) {
+	var buf bytes.Buffer
+	switch markup {
+	case reviewutil.Textile:
+		fmt.Fprintf(&buf, "* tested SOLR at %s\n", server)
+		fmt.Fprintf(&buf, "* ran span-review %s on %s with review %s\n\n", span.AppVersion, hostname, *reviewFile)
+		if _, err := NewTextileTableWriter(&buf).WriteResults(results); err != nil {
+			return "", err
+		}
+		if !*noCollapse {
+			return fmt.Sprintf("{{collapse(Details)\n%s\n}}\n", buf.String()), nil
+		}
+	case reviewutil.Markdown:
+		fmt.Fprintf(&buf, "* tested SOLR at %s\n", server)
+		fmt.Fprintf(&buf, "* ran span-review %s on %s with review %s\n\n", span.AppVersion, hostname, *reviewFile)
+		mw := &MarkdownResultWriter{w: &buf}
+		if _, err := mw.WriteResults(results); err != nil {
+			return "", err
+		}
+	default:
+		fmt.Fprintf(&buf, "tested SOLR at %s\n", server)
+		fmt.Fprintf(&buf, "ran span-review %s on %s with review %s\n\n", span.AppVersion, hostname, *reviewFile)
+		tw := tabwriter.NewWriter(&buf, 0, 0, 4, ' ', 0)
+		for i, r := range results {
+			passed := "ok"
+			if !r.Passed {
+				passed = "X"
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%v\t%s\t\n", i, r.SourceIdentifier, r.SolrField, passed, r.Comment)
+		}
+		if err := tw.Flush(); err != nil {
+			return "", err
+		}
+	}
+	return buf.String(), nil
+}
+
+// ParseSourceIdentifier parses out the source identifier from a query of the
+// form source_id:23 and returns an error for any other query.
+func ParseSourceIdentifier(s string) (string, error) {
 	parts := strings.Split(s, ":")
-	if len(parts) != 2 {
-		panic(fmt.Sprintf("failed to parse source id query: %s", s))
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) != "source_id" {
+		return "", fmt.Errorf("failed to parse source id query: %s", s)
 	}
-	return parts[1]
+	return strings.Trim(strings.TrimSpace(parts[1]), `"`), nil
 }
 
 // ErrorOrMessage returns error or message if error is nil.
@@ -246,9 +363,110 @@ func ErrorOrMessage(err error, message string) string {
 	return message
 }
 
+// normalizeServer turns a configured SOLR location into a base URL.
+func normalizeServer(s string) string {
+	// Be a bit more flexible and handle the default admin interface URL,
+	// e.g. http://example.com/solr/#/biblio, as well.
+	if strings.Contains(s, "/#/") {
+		log.Printf("adjusting SOLR admin URL %s", s)
+		s = strings.Replace(s, "/#", "", -1)
+	}
+	// A URL like http://solr.web:8080/solr/biblio//select?q=*:* would 404 (with 5.5.5).
+	return strings.TrimRight(s, "/")
+}
+
+// relax lowers a minimum by a tolerance given in percent.
+func relax(v, tolerancePct float64) float64 {
+	return v * (1 - tolerancePct/100)
+}
+
+// withTolerance appends a tolerance to a comment, if there is one.
+func withTolerance(s string, tolerancePct float64) string {
+	if tolerancePct == 0 {
+		return s
+	}
+	return fmt.Sprintf("%s (~%0.2f%%)", s, tolerancePct)
+}
+
+// writeHistoryList writes the recorded reviews of a server, with index.
+func writeHistoryList(w io.Writer, history *reviewutil.History, server string) error {
+	reports, err := history.Reports(server)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 4, ' ', 0)
+	for i, r := range reports {
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%d/%d failed\t%s\t\n", i, i-len(reports),
+			r.Date.Format(time.RFC3339), r.Failures(), len(r.Results), r.ReviewFile)
+	}
+	return tw.Flush()
+}
+
+// writeHistory writes cases, that changed between two reviews of a server,
+// selected by -from and -to.
+func writeHistory(w io.Writer, history *reviewutil.History, server string) error {
+	reports, err := history.Reports(server)
+	if err != nil {
+		return err
+	}
+	if len(reports) == 0 {
+		return fmt.Errorf("no reviews of %s in %s", server, history.Filename(server))
+	}
+	before, err := reviewutil.SelectReport(reports, *historyFrom)
+	if err != nil {
+		return fmt.Errorf("-from: %v", err)
+	}
+	after, err := reviewutil.SelectReport(reports, *historyTo)
+	if err != nil {
+		return fmt.Errorf("-to: %v", err)
+	}
+	changes := reviewutil.Changes(before, after)
+	if *format == "json" {
+		type change struct {
+			Status string             `json:"status"`
+			Before *reviewutil.Result `json:"before,omitempty"`
+			After  *reviewutil.Result `json:"after,omitempty"`
+		}
+		doc := struct {
+			Server  string    `json:"server"`
+			Before  time.Time `json:"before"`
+			After   time.Time `json:"after"`
+			Changes []change  `json:"changes"`
+		}{Server: server, Before: before.Date, After: after.Date, Changes: []change{}}
+		for _, c := range changes {
+			doc.Changes = append(doc.Changes, change{Status: c.Status, Before: c.Before, After: c.After})
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(doc)
+	}
+	fmt.Fprintf(w, "%s: %d changes between %s (%d/%d failed) and %s (%d/%d failed)\n\n", server,
+		len(changes), before.Date.Format(time.RFC3339), before.Failures(), len(before.Results),
+		after.Date.Format(time.RFC3339), after.Failures(), len(after.Results))
+	tw := tabwriter.NewWriter(w, 0, 0, 4, ' ', 0)
+	for _, c := range changes {
+		var (
+			r        = c.Result()
+			observed = r.Observed
+		)
+		if c.Before != nil && c.After != nil {
+			observed = fmt.Sprintf("%s -> %s", c.Before.Observed, c.After.Observed)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t\n",
+			c.Status, r.Kind, r.Query, r.SolrField, r.Expected, observed)
+	}
+	return tw.Flush()
+}
+
 func main() {
 	flag.Parse()
 
+	switch *format {
+	case "", "ascii", "textile", "json", "junit":
+	default:
+		log.Fatalf("unknown format: %s", *format)
+	}
+
 	// Fallback configuration, since daemon home is /usr/sbin.
 	if _, err := os.Stat(*spanConfigFile); os.IsNotExist(err) {
 		*spanConfigFile = "/etc/span/span.json"
@@ -290,16 +508,7 @@ func main() {
 			log.Fatal(err)
 		}
 	} else {
-		// Be a bit more flexible and handle the default admin interface URL,
-		// e.g. http://example.com/solr/#/biblio, as well.
-		if strings.Contains(config.SolrServer, "/#/") {
-			log.Printf("adjusting SOLR admin URL %s", config.SolrServer)
-			config.SolrServer = strings.Replace(config.SolrServer, "/#", "", -1)
-		}
-		solrServer = config.SolrServer
-
-		// A URL like http://solr.web:8080/solr/biblio//select?q=*:* would 404 (with 5.5.5).
-		solrServer = strings.TrimRight(solrServer, "/")
+		solrServer = normalizeServer(config.SolrServer)
 	}
 	if *server != "" {
 		solrServer = *server
@@ -308,74 +517,111 @@ func main() {
 	if *ticket != "" {
 		log.Printf("will attempt to update ticket %s", *ticket)
 	}
-	log.Printf("%d/%d/%d/%d", len(config.AllowedKeys), len(config.AllRecords), len(config.MinRatio), len(config.MinCount))
-	index := solrutil.Index{Server: solrutil.PrependHTTP(solrServer)}
+	log.Printf("%d/%d/%d/%d/%d/%d/%d/%d", len(config.AllowedKeys), len(config.AllRecords),
+		len(config.MinRatio), len(config.MinCount), len(config.MaxCount),
+		len(config.FieldPresentRatio), len(config.CompareToLive), len(config.FacetRegex))
+	client := solrutil.NewClient(*timeout, *retries)
+	index := solrutil.Index{Server: solrutil.PrependHTTP(solrServer), Client: client}
+
+	if *showHistory || *listHistory {
+		var (
+			history = &reviewutil.History{Dir: *historyDir}
+			err     error
+		)
+		if *listHistory {
+			err = writeHistoryList(os.Stdout, history, index.Server)
+		} else {
+			err = writeHistory(os.Stdout, history, index.Server)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
 
 	// Collect review results.
 	var results []Result
 
-	// Cases like "access_facet:"Electronic Resources" für alle Records".
-	// Multiple values are alternatives.
-	for _, c := range config.AllowedKeys {
-		if len(c) < 3 {
-			log.Fatalf("invalid test case, too few fields: %s", c)
-		}
-		query, field, values := c[0], c[1], c[2:]
-		if err = index.AllowedKeys(query, field, values...); err != nil {
-			log.Println(err)
+	// addResult records the outcome of a single test case, taking the zero
+	// results policy into account.
+	addResult := func(kind string, check solrutil.Check, tolerance float64, comment string) {
+		if check.Err != nil {
+			log.Println(check.Err)
 		}
-		passed := (err == nil)
+		passed := check.Passed()
 
-		numFound, err := index.NumFound(query)
+		numFound, err := index.NumFound(check.Query)
 		if err != nil {
 			log.Fatal(err)
 		}
 		if numFound == 0 && config.ZeroResultsPolicy == "fail" {
 			passed = false
 		}
-
+		// Queries other than source_id:N are shown as they are.
+		sid, err := ParseSourceIdentifier(check.Query)
+		if err != nil {
+			sid = check.Query
+		}
+		link := index.FacetLink(check.Query, check.Field)
+		if check.Field == "" {
+			link = index.SelectLink(check.Query)
+		}
 		results = append(results, Result{
-			SourceIdentifier: MustParseSourceIdentifier(query),
-			Link:             index.FacetLink(query, field),
-			SolrField:        field,
+			Kind:             kind,
+			SourceIdentifier: sid,
+			Query:            check.Query,
+			SolrField:        check.Field,
+			Expected:         check.Expected,
+			Observed:         check.Observed,
+			Tolerance:        tolerance,
+			Link:             link,
 			FixedResult:      true,
 			Passed:           passed,
-			Comment:          ErrorOrMessage(err, fmt.Sprintf("%s %s %s", query, field, values)),
+			Comment:          ErrorOrMessage(check.Err, withTolerance(comment, tolerance)),
 		})
 	}
 
+	// splitTolerance separates the optional tolerance from a test case.
+	splitTolerance := func(c []string) ([]string, float64) {
+		v, tolerance, err := config.SplitTolerance(c)
+		if err != nil {
+			log.Fatalf("invalid test case %s: %s", c, err)
+		}
+		return v, tolerance
+	}
+
+	// Cases like "access_facet:"Electronic Resources" für alle Records".
+	// Multiple values are alternatives.
+	for _, c := range config.AllowedKeys {
+		c, tolerance := splitTolerance(c)
+		if len(c) < 3 {
+			log.Fatalf("invalid test case, too few fields: %s", c)
+		}
+		query, field, values := c[0], c[1], c[2:]
+		check := index.AllowedKeysTolerance(query, field, tolerance, values...)
+		addResult("allowed-keys", check, tolerance, fmt.Sprintf("%s %s %s", query, field, values))
+	}
+
 	// Cases like "facet_avail:Online UND facet_avail:Free für alle Records".
 	// All records must have one or more facet values.
 	for _, c := range config.AllRecords {
+		c, tolerance := splitTolerance(c)
 		if len(c) < 3 {
 			log.Fatalf("invalid test case, too few fields: %s", c)
 		}
 		query, field, values := c[0], c[1], c[2:]
-		if err = index.EqualSizeTotal(query, field, values...); err != nil {
-			log.Println(err)
-		}
-		passed := (err == nil)
-
-		numFound, err := index.NumFound(query)
-		if err != nil {
-			log.Fatal(err)
-		}
-		if numFound == 0 && config.ZeroResultsPolicy == "fail" {
-			passed = false
+		var check solrutil.Check
+		if tolerance > 0 {
+			check = index.EqualSizeTotalTolerance(query, field, tolerance, values...)
+		} else {
+			check = index.EqualSizeTotal(query, field, values...)
 		}
-
-		results = append(results, Result{
-			SourceIdentifier: MustParseSourceIdentifier(query),
-			Link:             index.FacetLink(query, field),
-			SolrField:        field,
-			FixedResult:      true,
-			Passed:           passed,
-			Comment:          ErrorOrMessage(err, fmt.Sprintf("%s %s %s", query, field, values)),
-		})
+		addResult("all-records", check, tolerance, fmt.Sprintf("%s %s %s", query, field, values))
 	}
 
 	// Cases like "facet_avail:Free für mindestens 0,5% aller Records".
 	for _, c := range config.MinRatio {
+		c, tolerance := splitTolerance(c)
 		if len(c) != 4 {
 			log.Fatalf("invalid test case, expected four fields: %s", c)
 		}
@@ -384,32 +630,14 @@ func main() {
 		if err != nil {
 			log.Fatalf("minRatio is not a float: %s", err)
 		}
-		if err = index.MinRatioPct(query, field, value, minRatioPct); err != nil {
-			log.Println(err)
-		}
-		passed := (err == nil)
-
-		numFound, err := index.NumFound(query)
-		if err != nil {
-			log.Fatal(err)
-		}
-		if numFound == 0 && config.ZeroResultsPolicy == "fail" {
-			passed = false
-		}
-
-		results = append(results, Result{
-			SourceIdentifier: MustParseSourceIdentifier(query),
-			Link:             index.FacetLink(query, field),
-			SolrField:        field,
-			FixedResult:      true,
-			Passed:           passed,
-			Comment: ErrorOrMessage(err, fmt.Sprintf("%s %s %s %0.4f",
-				query, field, value, minRatioPct)),
-		})
+		check := index.MinRatioPct(query, field, value, relax(minRatioPct, tolerance))
+		addResult("min-ratio", check, tolerance, fmt.Sprintf("%s %s %s %0.4f",
+			query, field, value, minRatioPct))
 	}
 
 	// Cases like "facet_avail:Free für mindestens 50 Records".
 	for _, c := range config.MinCount {
+		c, tolerance := splitTolerance(c)
 		if len(c) != 4 {
 			log.Fatalf("invalid test case, expected four fields: %s", c)
 		}
@@ -418,39 +646,130 @@ func main() {
 		if err != nil {
 			log.Fatalf("minCount is not an int: %s", err)
 		}
-		if err = index.MinCount(query, field, value, minCount); err != nil {
-			log.Println(err)
+		check := index.MinCount(query, field, value, int(relax(float64(minCount), tolerance)))
+		addResult("min-count", check, tolerance, fmt.Sprintf("%s %s %s %d",
+			query, field, value, minCount))
+	}
+
+	// Cases like "facet_avail:Free für höchstens 1000 Records".
+	for _, c := range config.MaxCount {
+		c, tolerance := splitTolerance(c)
+		if len(c) != 4 {
+			log.Fatalf("invalid test case, expected four fields: %s", c)
+		}
+		query, field, value := c[0], c[1], c[2]
+		maxCount, err := strconv.Atoi(c[3])
+		if err != nil {
+			log.Fatalf("maxCount is not an int: %s", err)
 		}
-		passed := (err == nil)
+		limit := maxCount + int(solrutil.Margin(int64(maxCount), tolerance))
+		check := index.MaxCount(query, field, value, limit)
+		addResult("max-count", check, tolerance, fmt.Sprintf("%s %s %s %d",
+			query, field, value, maxCount))
+	}
 
-		numFound, err := index.NumFound(query)
+	// Cases like "mindestens 97% aller Records haben eine ISSN".
+	for _, c := range config.FieldPresentRatio {
+		c, tolerance := splitTolerance(c)
+		if len(c) != 3 {
+			log.Fatalf("invalid test case, expected three fields: %s", c)
+		}
+		query, field := c[0], c[1]
+		minRatioPct, err := strconv.ParseFloat(c[2], 64)
 		if err != nil {
-			log.Fatal(err)
+			log.Fatalf("field present ratio is not a float: %s", err)
 		}
-		if numFound == 0 && config.ZeroResultsPolicy == "fail" {
-			passed = false
+		check := index.FieldPresentRatioPct(query, field, relax(minRatioPct, tolerance))
+		addResult("field-present-ratio", check, tolerance, fmt.Sprintf("%s %s %0.4f",
+			query, field, minRatioPct))
+	}
+
+	// Cases like "language passt für alle Records auf ^[A-Z][a-z]+$".
+	for _, c := range config.FacetRegex {
+		c, tolerance := splitTolerance(c)
+		if len(c) != 3 {
+			log.Fatalf("invalid test case, expected three fields: %s", c)
 		}
+		query, field, pattern := c[0], c[1], c[2]
+		check := index.FacetRegex(query, field, pattern, tolerance)
+		addResult("facet-regex", check, tolerance, fmt.Sprintf("%s %s %s",
+			query, field, pattern))
+	}
 
-		results = append(results, Result{
-			SourceIdentifier: MustParseSourceIdentifier(query),
-			Link:             index.FacetLink(query, field),
-			SolrField:        field,
-			FixedResult:      true,
-			Passed:           passed,
-			Comment: ErrorOrMessage(err, fmt.Sprintf("%s %s %s %d",
-				query, field, value, minCount)),
-		})
+	// Cases like "Anzahl für source_id:48 sinkt um höchstens 5% gegenüber live".
+	// The tolerance is the maximum drop allowed.
+	if len(config.CompareToLive) > 0 {
+		var liveServer string
+		switch {
+		case *live != "":
+			liveServer = *live
+		case config.LiveServer == "" || strings.ToLower(config.LiveServer) == "auto":
+			liveServer, err = solrutil.FindLiveSolrServer(*spanConfigFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+		default:
+			liveServer = normalizeServer(config.LiveServer)
+		}
+		log.Printf("using live solr at %s", liveServer)
+		liveIndex := solrutil.Index{Server: solrutil.PrependHTTP(liveServer), Client: client}
+		for _, c := range config.CompareToLive {
+			c, tolerance := splitTolerance(c)
+			if len(c) != 1 {
+				log.Fatalf("invalid test case, expected a single query: %s", c)
+			}
+			query := c[0]
+			check := index.CompareNumFound(liveIndex, query, tolerance)
+			addResult("compare-to-live", check, tolerance, fmt.Sprintf("%s vs %s", query, liveIndex.Server))
+		}
+	}
+
+	// Keep results, so we can see what changed between index builds.
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "an unidentified host"
+	}
+	report := &reviewutil.Report{
+		Server:     index.Server,
+		Date:       time.Now(),
+		Hostname:   hostname,
+		Version:    span.AppVersion,
+		ReviewFile: *reviewFile,
+		Results:    results,
+	}
+	if *historyDir != "" && !*noHistory {
+		history := &reviewutil.History{Dir: *historyDir}
+		if err := history.Save(report); err != nil {
+			log.Printf("failed to save review history: %s", err)
+		} else {
+			log.Printf("saved review to %s", history.Filename(index.Server))
+		}
+	}
+
+	// Exit non-zero, if any case failed, so CI jobs fail, too. Errors exit
+	// with 1, failed cases with a separate code.
+	exitCode := 0
+	if n := report.Failures(); n > 0 {
+		log.Printf("%d of %d cases failed", n, len(results))
+		exitCode = reviewutil.ExitCasesFailed
 	}
 
 	// Serialization options.
 	if *textile {
+		*format = "textile"
+	}
+	if *ascii {
+		*format = "ascii"
+	}
+	switch *format {
+	case "":
+	case "textile":
 		tw := NewTextileTableWriter(os.Stdout)
 		if _, err := tw.WriteResults(results); err != nil {
 			log.Fatal(err)
 		}
-		os.Exit(0)
-	}
-	if *ascii {
+		os.Exit(exitCode)
+	case "ascii":
 		w := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', 0)
 		red, green := color.New(color.FgRed), color.New(color.FgGreen)
 		for i, r := range results {
@@ -462,7 +781,17 @@ func main() {
 				r.SourceIdentifier, r.SolrField, passed, r.Comment)
 		}
 		w.Flush()
-		os.Exit(0)
+		os.Exit(exitCode)
+	case "json":
+		if err := report.WriteJSON(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(exitCode)
+	case "junit":
+		if err := report.WriteJUnit(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(exitCode)
 	}
 
 	// Ticket handling.
@@ -472,51 +801,26 @@ func main() {
 	if config.Ticket != "" {
 		if _, err := strconv.Atoi(config.Ticket); err != nil {
 			log.Printf("ignoring ticket update for non-numeric ticket id: %s", config.Ticket)
-			os.Exit(0)
+			os.Exit(exitCode)
 		}
 		// Fallback configuration, since daemon home is /usr/sbin.
 		if _, err := os.Stat(*spanConfigFile); os.IsNotExist(err) {
 			*spanConfigFile = "/etc/span/span.json"
 		}
-		if _, err := os.Stat(*spanConfigFile); os.IsNotExist(err) {
-			log.Printf("warning: no span config file found, thing might break")
-		}
-		f, err := os.Open(*spanConfigFile)
-		if err != nil {
-			log.Printf("failed to open span config: %s", err)
-		}
-		var conf struct {
-			BaseURL string `json:"redmine.baseurl"`
-			Token   string `json:"redmine.apitoken"`
-		}
-		if err := json.NewDecoder(f).Decode(&conf); err != nil {
-			log.Fatal(err)
+		var conf configutil.Config
+		if err := cleanenv.ReadConfig(*spanConfigFile, &conf); err != nil {
+			log.Fatalf("failed to read span config: %s", err)
 		}
-		// Collect message for ticket.
-		var buf bytes.Buffer
-
-		hostname, err := os.Hostname()
+		notifiers, err := reviewutil.Notifiers(&conf)
 		if err != nil {
-			hostname = "an unidentified host"
-		}
-		fmt.Fprintf(&buf, "* tested SOLR at %s\n", index.Server)
-		fmt.Fprintf(&buf, "* ran span-review %s on %s with review %s\n\n", span.AppVersion, hostname, *reviewFile)
-
-		tw := NewTextileTableWriter(&buf)
-		if _, err := tw.WriteResults(results); err != nil {
 			log.Fatal(err)
 		}
-
-		redmine := &reviewutil.Redmine{BaseURL: conf.BaseURL, Token: conf.Token}
-		var message string
-		switch {
-		case *noCollapse:
-			message = fmt.Sprintf("index review results\n\n%s", buf.String())
-		default:
-			message = fmt.Sprintf("index review results\n\n{{collapse(Details)\n%s\n}}\n", buf.String())
+		render := func(markup string) (string, error) {
+			return renderMessage(markup, index.Server, hostname, results)
 		}
-		if err := redmine.UpdateTicket(config.Ticket, message); err != nil {
+		if err := reviewutil.Broadcast(notifiers, config.Ticket, "index review results", render); err != nil {
 			log.Fatal(err)
 		}
 	}
+	os.Exit(exitCode)
 }
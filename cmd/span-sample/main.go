@@ -0,0 +1,200 @@
+// span-sample extracts a small, reproducible sample from intermediate schema
+// files, e.g. for bug reports and regression fixtures: up to N records per
+// source and collection, optionally only records from a DOI or id list.
+//
+//	$ span-sample -n 10 < file.is > sample.is
+//	$ span-sample -n 0 -doi dois.txt < file.is > sample.is
+//	$ span-sample -n 2 -by source -drop x.fulltext,abstract -fixture issue12345 < file.is
+//
+// The same seed and input yield the same sample, regardless of the number of
+// workers.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/segmentio/encoding/json"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/miku/span"
+	"github.com/miku/span/atomic"
+	"github.com/miku/span/parallel"
+	"github.com/miku/span/redactutil"
+	"github.com/miku/span/statsutil"
+	"github.com/miku/span/xflag"
+	"github.com/miku/span/xio"
+)
+
+var (
+	size        = flag.Int("n", 10, "records per stratum, 0 keeps all matching records")
+	by          = flag.String("by", "collection", "stratify by: source, collection (source and collections), none")
+	seed        = flag.Int64("seed", 1, "seed, the same seed yields the same sample")
+	doiFile     = flag.String("doi", "", "only records with a DOI from this file, one per line")
+	idFile      = flag.String("id", "", "only records with a finc.id or finc.record_id from this file, one per line")
+	keep        = flag.String("keep", "", "keep only these fields in sampled records, comma separated")
+	fixture     = flag.String("fixture", "", "write sample to <fixtures-dir>/<name>.is instead of stdout")
+	fixturesDir = flag.String("fixtures-dir", "fixtures", "fixtures directory, used with -fixture")
+	force       = flag.Bool("f", false, "overwrite an existing fixture")
+	batchSize   = flag.Int("b", 20000, "batch size")
+	numWorkers  = flag.Int("w", runtime.NumCPU(), "number of workers")
+	showVersion = flag.Bool("v", false, "prints current program version")
+)
+
+// record contains the fields required for selection and stratification.
+type record struct {
+	ID              string   `json:"finc.id"`
+	RecordID        string   `json:"finc.record_id"`
+	SourceID        string   `json:"finc.source_id"`
+	MegaCollections []string `json:"finc.mega_collection"`
+	DOI             string   `json:"doi"`
+}
+
+// stratum returns the stratum key of a record.
+func (r *record) stratum() string {
+	switch *by {
+	case "none":
+		return ""
+	case "source":
+		return r.SourceID
+	default:
+		mcs := append([]string(nil), r.MegaCollections...)
+		sort.Strings(mcs)
+		return r.SourceID + "\t" + strings.Join(mcs, "; ")
+	}
+}
+
+// readSet reads a file with one value per line into a set, values are
+// transformed with f.
+func readSet(filename string, f func(string) string) (map[string]bool, error) {
+	lines, err := xio.ReadLines(filename)
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]bool)
+	for _, line := range lines {
+		set[f(line)] = true
+	}
+	return set, nil
+}
+
+func main() {
+	var dropFlags, ruleFlags xflag.Array
+	flag.Var(&dropFlags, "drop", "fields to remove from sampled records, comma separated (repeatable)")
+	flag.Var(&ruleFlags, "rule", "fields to remove from sampled records of a source, e.g. 48:abstract (repeatable)")
+	flag.Parse()
+	if *showVersion {
+		fmt.Println(span.AppVersion)
+		os.Exit(0)
+	}
+	switch *by {
+	case "source", "collection", "none":
+	default:
+		log.Fatalf("unknown stratum: %s", *by)
+	}
+	var (
+		dois, ids map[string]bool
+		err       error
+	)
+	if *doiFile != "" {
+		if dois, err = readSet(*doiFile, strings.ToLower); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("selecting from %d DOI", len(dois))
+	}
+	if *idFile != "" {
+		if ids, err = readSet(*idFile, strings.TrimSpace); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("selecting from %d ids", len(ids))
+	}
+	fixtureFile := filepath.Join(*fixturesDir, *fixture+".is")
+	if _, err := os.Stat(fixtureFile); err == nil && *fixture != "" && !*force {
+		log.Fatalf("%s exists, use -f to overwrite", fixtureFile)
+	}
+	// Redaction, only applied to sampled records.
+	var redactor *redactutil.Redactor
+	if len(dropFlags) > 0 || len(ruleFlags) > 0 || *keep != "" {
+		redactor = &redactutil.Redactor{Keep: redactutil.SplitFields(*keep)}
+		for _, v := range dropFlags {
+			redactor.Rules = append(redactor.Rules, redactutil.Rule{Fields: redactutil.SplitFields(v)})
+		}
+		for _, v := range ruleFlags {
+			rule, err := redactutil.ParseRule(v)
+			if err != nil {
+				log.Fatal(err)
+			}
+			redactor.Rules = append(redactor.Rules, rule)
+		}
+	}
+	var reader io.Reader = os.Stdin
+	if flag.NArg() > 0 {
+		var files []io.Reader
+		for _, filename := range flag.Args() {
+			f, err := xio.OpenDecompress(filename)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer f.Close()
+			files = append(files, f)
+		}
+		reader = io.MultiReader(files...)
+	}
+	sampler := statsutil.NewSampler(*size, *seed)
+	p := parallel.NewProcessor(bufio.NewReader(reader), io.Discard, func(lineno int64, b []byte) ([]byte, error) {
+		var r record
+		if err := json.Unmarshal(b, &r); err != nil {
+			return nil, err
+		}
+		if dois != nil || ids != nil {
+			if !dois[strings.ToLower(r.DOI)] && !ids[r.ID] && !ids[r.RecordID] {
+				return nil, nil
+			}
+		}
+		key := []byte(r.ID)
+		if len(key) == 0 {
+			key = bytes.TrimSpace(b)
+		}
+		sampler.Add(r.stratum(), key, lineno, bytes.TrimSpace(b))
+		return nil, nil
+	})
+	p.NumWorkers = *numWorkers
+	p.BatchSize = *batchSize
+	if err := p.Run(); err != nil {
+		log.Fatal(err)
+	}
+	samples := sampler.Samples()
+	log.Printf("sampled %d of %d records from %d strata", len(samples), sampler.Seen(), sampler.Strata())
+	var buf bytes.Buffer
+	for _, s := range samples {
+		data := s.Data
+		if redactor != nil {
+			if data, err = redactor.Redact(data); err != nil {
+				log.Fatal(err)
+			}
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	if *fixture == "" {
+		if _, err := os.Stdout.Write(buf.Bytes()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := os.MkdirAll(*fixturesDir, 0755); err != nil {
+		log.Fatal(err)
+	}
+	if err := atomic.WriteFile(fixtureFile, buf.Bytes(), 0644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("wrote %s", fixtureFile)
+}
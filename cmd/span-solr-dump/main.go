@@ -0,0 +1,328 @@
+// span-solr-dump writes the documents matching a query from a SOLR index as
+// newline delimited JSON, e.g. to compare the live index with pipeline output
+// or to rebuild an index.
+//
+// Documents are streamed with cursorMark deep paging, so any result set size
+// works. The export can be split into one query per source_id, which run in
+// parallel, into a single output or one file per source. Documents without a
+// source_id are exported by a final query, into no-source-id.ndj with -d.
+//
+//	$ span-solr-dump -server 10.1.1.7:8085/solr/biblio -q 'source_id:48 AND institution:DE-14' > 48.ndj
+//	$ span-solr-dump -server 10.1.1.7:8085/solr/biblio -fl id,title,source_id -shard -d dump -compress zstd
+//
+// If the index was built with span-export -with-fullrecord, the originating
+// intermediate schema records can be recovered from the fullrecord field:
+//
+//	$ span-solr-dump -server 10.1.1.7:8085/solr/biblio -q 'source_id:48' -is > 48.is.ndj
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	gzip "github.com/klauspost/pgzip"
+	"github.com/miku/span"
+	spanatomic "github.com/miku/span/atomic"
+	"github.com/miku/span/solrutil"
+	"github.com/segmentio/encoding/json"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	server          = flag.String("server", "http://localhost:8983/solr/biblio", "SOLR server")
+	query           = flag.String("q", "*:*", "query")
+	fields          = flag.String("fl", "", "comma separated list of fields to export, default all stored fields")
+	shard           = flag.Bool("shard", false, "run one query per source_id, in parallel")
+	numWorkers      = flag.Int("w", 4, "number of parallel queries, with -shard")
+	outputDir       = flag.String("d", "", "with -shard, write one file per source_id into this directory")
+	compressProgram = flag.String("compress", "", "compress output with gzip or zstd")
+	intermediate    = flag.Bool("is", false, "write intermediate schema records from fullrecord field (requires span-export -with-fullrecord)")
+	timeout         = flag.Duration("timeout", 5*time.Minute, "timeout for a single SOLR request")
+	retries         = flag.Int("retries", 3, "number of retries for failed SOLR requests")
+	showVersion     = flag.Bool("v", false, "prints current program version")
+)
+
+// stats counts exported and skipped documents across workers.
+type stats struct {
+	written int64
+	skipped int64 // documents without intermediate schema in fullrecord
+}
+
+// compressWriter wraps a writer with the configured compression.
+func compressWriter(w io.Writer) (io.WriteCloser, error) {
+	switch *compressProgram {
+	case "zstd":
+		return zstd.NewWriter(w)
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	default:
+		return nil, fmt.Errorf("only gzip and zstd supported currently")
+	}
+}
+
+// extension returns the filename extension for the configured compression.
+func extension() string {
+	switch *compressProgram {
+	case "zstd":
+		return ".ndj.zst"
+	case "gzip":
+		return ".ndj.gz"
+	default:
+		return ".ndj"
+	}
+}
+
+// fullrecord returns the intermediate schema record stored in the fullrecord
+// field of a document, or false, if the document carries a blob reference only.
+func fullrecord(doc json.RawMessage) ([]byte, bool, error) {
+	var v struct {
+		Fullrecord string `json:"fullrecord"`
+	}
+	if err := json.Unmarshal(doc, &v); err != nil {
+		return nil, false, err
+	}
+	if v.Fullrecord == "" || strings.HasPrefix(v.Fullrecord, "blob:") {
+		return nil, false, nil
+	}
+	if !json.Valid([]byte(v.Fullrecord)) {
+		return nil, false, fmt.Errorf("fullrecord is not JSON: %s", v.Fullrecord)
+	}
+	return []byte(v.Fullrecord), true, nil
+}
+
+// lockedWriter allows workers to write whole lines to a shared writer.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Write writes p at once.
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+// dump writes all documents matching a query, one per line.
+func dump(w io.Writer, index solrutil.Index, q string, fl []string, st *stats) error {
+	return index.Documents(q, fl, func(doc json.RawMessage) error {
+		if *intermediate {
+			b, ok, err := fullrecord(doc)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				atomic.AddInt64(&st.skipped, 1)
+				return nil
+			}
+			doc = b
+		}
+		if _, err := w.Write(append(doc, '\n')); err != nil {
+			return err
+		}
+		atomic.AddInt64(&st.written, 1)
+		return nil
+	})
+}
+
+// dumpFile writes the documents matching a query into a file, which only
+// appears, if the export succeeded.
+func dumpFile(filename string, index solrutil.Index, q string, fl []string, st *stats) error {
+	f, err := spanatomic.New(filename, 0644)
+	if err != nil {
+		return err
+	}
+	var (
+		bw           = bufio.NewWriter(f)
+		w  io.Writer = bw
+		cw io.WriteCloser
+	)
+	if *compressProgram != "" {
+		if cw, err = compressWriter(bw); err != nil {
+			f.Abort()
+			return err
+		}
+		w = cw
+	}
+	if err := dump(w, index, q, fl, st); err != nil {
+		f.Abort()
+		return err
+	}
+	if cw != nil {
+		if err := cw.Close(); err != nil {
+			f.Abort()
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		f.Abort()
+		return err
+	}
+	return f.Close()
+}
+
+// shardQuery is a part of an export, with its own query.
+type shardQuery struct {
+	name  string // source_id or noSourceID
+	query string
+}
+
+// noSourceID names the part of an export without a source_id.
+const noSourceID = "no-source-id"
+
+// shardQueries returns one query per source_id found for a query and a final
+// query for documents without a source_id, if there are any.
+func shardQueries(index solrutil.Index, q string) ([]shardQuery, error) {
+	sids, err := index.FacetKeysFunc(q, "source_id", func(_ string, v int) bool { return v > 0 })
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(sids)
+	var result []shardQuery
+	for _, sid := range sids {
+		result = append(result, shardQuery{name: sid, query: fmt.Sprintf(`(%s) AND source_id:"%s"`, q, sid)})
+	}
+	rest := fmt.Sprintf(`(%s) AND -source_id:[* TO *]`, q)
+	n, err := index.NumFound(rest)
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 {
+		result = append(result, shardQuery{name: noSourceID, query: rest})
+	}
+	return result, nil
+}
+
+// dumpShards exports the documents matching a query with one query per
+// source_id, in parallel, to w or into one file per source_id in outputDir.
+func dumpShards(w io.Writer, index solrutil.Index, q string, fl []string, st *stats) error {
+	parts, err := shardQueries(index, q)
+	if err != nil {
+		return err
+	}
+	log.Printf("exporting %d shards with %d workers", len(parts), *numWorkers)
+	if *outputDir != "" {
+		if err := os.MkdirAll(*outputDir, 0755); err != nil {
+			return err
+		}
+	}
+	var (
+		queue  = make(chan shardQuery)
+		wg     sync.WaitGroup
+		lw     = &lockedWriter{w: w}
+		mu     sync.Mutex
+		errors []string
+	)
+	for i := 0; i < *numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for part := range queue {
+				var err error
+				switch {
+				case *outputDir != "":
+					filename := filepath.Join(*outputDir, filepath.Base(part.name)+extension())
+					err = dumpFile(filename, index, part.query, fl, st)
+				default:
+					err = dump(lw, index, part.query, fl, st)
+				}
+				if err != nil {
+					mu.Lock()
+					errors = append(errors, fmt.Sprintf("source %s: %v", part.name, err))
+					mu.Unlock()
+					continue
+				}
+				log.Printf("exported source %s", part.name)
+			}
+		}()
+	}
+	for _, part := range parts {
+		queue <- part
+	}
+	close(queue)
+	wg.Wait()
+	if len(errors) > 0 {
+		sort.Strings(errors)
+		return fmt.Errorf("export failed: %s", strings.Join(errors, "; "))
+	}
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	if *showVersion {
+		fmt.Println(span.AppVersion)
+		os.Exit(0)
+	}
+	switch *compressProgram {
+	case "", "gzip", "zstd":
+	default:
+		log.Fatalf("unknown compression: %s", *compressProgram)
+	}
+	if *outputDir != "" && !*shard {
+		log.Fatal("-d requires -shard")
+	}
+	index := solrutil.Index{
+		Server: solrutil.PrependHTTP(*server),
+		Client: solrutil.NewClient(*timeout, *retries),
+	}
+	if *intermediate && *fields != "" {
+		log.Fatal("-is exports the fullrecord field only, cannot be combined with -fl")
+	}
+	var fl []string
+	if *fields != "" {
+		fl = strings.Split(*fields, ",")
+	}
+	if *intermediate {
+		fl = []string{"id", "fullrecord"}
+	}
+	var (
+		st      stats
+		started = time.Now()
+	)
+	// Output, unless we write one file per shard.
+	var (
+		bw           = bufio.NewWriter(os.Stdout)
+		w  io.Writer = bw
+		cw io.WriteCloser
+	)
+	if *compressProgram != "" && *outputDir == "" {
+		var err error
+		if cw, err = compressWriter(bw); err != nil {
+			log.Fatal(err)
+		}
+		w = cw
+	}
+	switch {
+	case !*shard:
+		if err := dump(w, index, *query, fl, &st); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		if err := dumpShards(w, index, *query, fl, &st); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if cw != nil {
+		if err := cw.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("exported %d documents in %s", st.written, time.Since(started))
+	if st.skipped > 0 {
+		log.Printf("skipped %d documents without intermediate schema in fullrecord", st.skipped)
+	}
+}
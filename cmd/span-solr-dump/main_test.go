@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miku/span/solrutil"
+	"github.com/segmentio/encoding/json"
+)
+
+func TestFullrecord(t *testing.T) {
+	var cases = []struct {
+		about string
+		doc   string
+		want  string
+		ok    bool
+		err   bool
+	}{
+		{"intermediate schema", `{"id": "1", "fullrecord": "{\"finc.id\": \"ai-49-1\"}"}`, `{"finc.id": "ai-49-1"}`, true, false},
+		{"blob reference", `{"id": "1", "fullrecord": "blob:ai-49-1"}`, "", false, false},
+		{"empty value", `{"id": "1", "fullrecord": ""}`, "", false, false},
+		{"no fullrecord", `{"id": "1"}`, "", false, false},
+		{"invalid JSON in fullrecord", `{"id": "1", "fullrecord": "<record/>"}`, "", false, true},
+		{"invalid document", `{"id": `, "", false, true},
+	}
+	for _, c := range cases {
+		b, ok, err := fullrecord(json.RawMessage(c.doc))
+		if (err != nil) != c.err {
+			t.Errorf("%s: got err %v, want err %v", c.about, err, c.err)
+		}
+		if ok != c.ok || string(b) != c.want {
+			t.Errorf("%s: got %q, %v, want %q, %v", c.about, b, ok, c.want, c.ok)
+		}
+	}
+}
+
+// testDocs are served by solrServer, one document has no source_id.
+var testDocs = []map[string]string{
+	{"id": "1", "source_id": "48"},
+	{"id": "2", "source_id": "49"},
+	{"id": "3", "source_id": "49"},
+	{"id": "4"},
+}
+
+// solrServer answers facet, count and cursor queries over testDocs.
+func solrServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		var (
+			q    = r.Form.Get("q")
+			docs []map[string]string
+		)
+		for _, doc := range testDocs {
+			sid, ok := doc["source_id"]
+			switch {
+			case strings.Contains(q, `-source_id:[* TO *]`) && ok:
+				continue
+			case strings.Contains(q, `source_id:"`) && !strings.Contains(q, `source_id:"`+sid+`"`):
+				continue
+			}
+			docs = append(docs, doc)
+		}
+		var resp = map[string]interface{}{
+			"response": map[string]interface{}{"numFound": len(docs)},
+		}
+		switch {
+		case r.Form.Get("facet") == "true":
+			counts := make(map[string]int)
+			for _, doc := range docs {
+				if sid, ok := doc["source_id"]; ok {
+					counts[sid]++
+				}
+			}
+			var ff []interface{}
+			for k, v := range counts {
+				ff = append(ff, k, v)
+			}
+			resp["facet_counts"] = map[string]interface{}{
+				"facet_fields": map[string]interface{}{"source_id": ff},
+			}
+		case r.Form.Get("rows") != "0":
+			resp["response"] = map[string]interface{}{"numFound": len(docs), "docs": docs}
+			resp["nextCursorMark"] = r.Form.Get("cursorMark")
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			t.Error(err)
+		}
+	}))
+}
+
+// ids returns the sorted ids of the documents in a newline delimited stream.
+func ids(t *testing.T, b []byte) []string {
+	var result []string
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		if line == "" {
+			continue
+		}
+		var doc struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal([]byte(line), &doc); err != nil {
+			t.Fatalf("invalid line %q: %v", line, err)
+		}
+		result = append(result, doc.ID)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestDumpShards(t *testing.T) {
+	ts := solrServer(t)
+	defer ts.Close()
+	index := solrutil.Index{Server: ts.URL, Client: solrutil.NewClient(5*time.Second, 0)}
+
+	var (
+		buf bytes.Buffer
+		st  stats
+	)
+	if err := dumpShards(&buf, index, "*:*", nil, &st); err != nil {
+		t.Fatalf("dumpShards: got %v, want nil", err)
+	}
+	if got := strings.Join(ids(t, buf.Bytes()), ","); got != "1,2,3,4" {
+		t.Errorf("dumpShards: got %s, want 1,2,3,4", got)
+	}
+	if st.written != 4 {
+		t.Errorf("dumpShards: got %d written, want 4", st.written)
+	}
+
+	dir, err := ioutil.TempDir("", "span-solr-dump-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	*outputDir = dir
+	defer func() { *outputDir = "" }()
+	if err := dumpShards(ioutil.Discard, index, "*:*", nil, &stats{}); err != nil {
+		t.Fatalf("dumpShards: got %v, want nil", err)
+	}
+	var want = map[string]string{
+		"48.ndj":           "1",
+		"49.ndj":           "2,3",
+		"no-source-id.ndj": "4",
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != len(want) {
+		t.Errorf("dumpShards: got %d files, want %d", len(files), len(want))
+	}
+	for name, v := range want {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("dumpShards: %v", err)
+			continue
+		}
+		if got := strings.Join(ids(t, b), ","); got != v {
+			t.Errorf("dumpShards %s: got %s, want %s", name, got, v)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+// span-stats profiles intermediate schema files, overall and per source: fill
+// ratio, estimated cardinality, top values, length and year histograms for
+// each field.
+//
+//	$ span-stats < file.is > stats.json
+//	$ span-stats -format table < file.is
+//
+// Two saved reports can be compared, e.g. to spot regressions between two
+// converter versions:
+//
+//	$ span-stats -diff old.json new.json
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+
+	"github.com/segmentio/encoding/json"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/miku/span"
+	"github.com/miku/span/parallel"
+	"github.com/miku/span/statsutil"
+)
+
+var (
+	topN        = flag.Int("n", 10, "number of top values per field")
+	bySource    = flag.Bool("by-source", true, "keep a profile per source")
+	format      = flag.String("format", "json", "output format: json, table")
+	showEmpty   = flag.Bool("empty", false, "include fields without values in table output")
+	diff        = flag.Bool("diff", false, "compare two JSON reports given as arguments")
+	threshold   = flag.Float64("threshold", 0.01, "minimum fill ratio difference and relative change of records and cardinality to report with -diff")
+	size        = flag.Int("b", 20000, "batch size")
+	numWorkers  = flag.Int("w", runtime.NumCPU(), "number of workers")
+	showVersion = flag.Bool("v", false, "prints current program version")
+)
+
+// ellipsis shortens a string to at most n runes.
+func ellipsis(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
+
+// writeProfile writes a single profile as table.
+func writeProfile(w io.Writer, title string, p *statsutil.Profile) error {
+	fmt.Fprintf(w, "%s, %d records\n\n", title, p.Records)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FIELD\tFILL\tVALUES\tCARD\tLENGTH\tTOP")
+	var years []string
+	for _, name := range p.FieldNames() {
+		f := p.Fields[name]
+		if f.Records == 0 && !*showEmpty {
+			continue
+		}
+		var length statsutil.Bucket
+		for _, b := range f.Lengths {
+			if b.Count > length.Count {
+				length = b
+			}
+		}
+		var top []string
+		for i, v := range f.Top {
+			if i == 3 {
+				break
+			}
+			top = append(top, fmt.Sprintf("%s (%d)", ellipsis(v.Value, 30), v.Count))
+		}
+		var lengthLabel = "-"
+		if length.Count > 0 {
+			lengthLabel = length.String()
+		}
+		fmt.Fprintf(tw, "%s\t%0.4f\t%d\t%d\t%s\t%s\n",
+			name, f.Fill, f.Values, f.Cardinality, lengthLabel, strings.Join(top, "; "))
+		if len(f.Years) > 0 {
+			var ys []string
+			for y := range f.Years {
+				ys = append(ys, y)
+			}
+			sort.Strings(ys)
+			var counts []string
+			for _, y := range ys {
+				counts = append(counts, fmt.Sprintf("%s:%d", y, f.Years[y]))
+			}
+			years = append(years, fmt.Sprintf("%s years: %s", name, strings.Join(counts, " ")))
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(years) > 0 {
+		fmt.Fprintf(w, "\n%s\n", strings.Join(years, "\n"))
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// writeReport writes a report as JSON or table.
+func writeReport(w io.Writer, r *statsutil.Report) error {
+	if *format == "json" {
+		return json.NewEncoder(w).Encode(r)
+	}
+	if err := writeProfile(w, "overall", r.Overall); err != nil {
+		return err
+	}
+	for _, sid := range r.SourceIDs() {
+		if err := writeProfile(w, "source "+sid, r.Sources[sid]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeChanges writes the differences between two reports.
+func writeChanges(w io.Writer, changes []statsutil.Change) error {
+	if *format == "json" {
+		if changes == nil {
+			changes = []statsutil.Change{}
+		}
+		return json.NewEncoder(w).Encode(changes)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tFIELD\tWHAT\tA\tB")
+	for _, c := range changes {
+		source, field := c.Source, c.Field
+		if source == "" {
+			source = "overall"
+		}
+		if field == "" {
+			field = "-"
+		}
+		switch c.What {
+		case "fill":
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%0.4f\t%0.4f\n", source, field, c.What, c.A, c.B)
+		default:
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%0.0f\t%0.0f\n", source, field, c.What, c.A, c.B)
+		}
+	}
+	return tw.Flush()
+}
+
+// readReport reads a JSON report from a file.
+func readReport(filename string) (*statsutil.Report, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := statsutil.ReadReport(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return r, nil
+}
+
+func main() {
+	flag.Parse()
+	if *showVersion {
+		fmt.Println(span.AppVersion)
+		os.Exit(0)
+	}
+	switch *format {
+	case "json", "table":
+	default:
+		log.Fatalf("unknown format: %s", *format)
+	}
+	bw := bufio.NewWriter(os.Stdout)
+	defer bw.Flush()
+	if *diff {
+		if flag.NArg() != 2 {
+			log.Fatal("usage: span-stats -diff a.json b.json")
+		}
+		a, err := readReport(flag.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		b, err := readReport(flag.Arg(1))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := writeChanges(bw, statsutil.Diff(a, b, *threshold)); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	profiler := statsutil.NewProfiler(*topN, *bySource)
+	p := parallel.NewProcessor(bufio.NewReader(os.Stdin), io.Discard, func(_ int64, b []byte) ([]byte, error) {
+		if err := profiler.Add(b); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	})
+	p.NumWorkers = *numWorkers
+	p.BatchSize = *size
+	if err := p.Run(); err != nil {
+		log.Fatal(err)
+	}
+	if err := writeReport(bw, profiler.Report()); err != nil {
+		log.Fatal(err)
+	}
+}
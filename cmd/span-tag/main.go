@@ -9,16 +9,15 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"flag"
 	"fmt"
 	"io"
-	"net/http"
 	"net/url"
 	"os"
 	"runtime"
 	"runtime/pprof"
 	"strings"
+	"time"
 
 	json "github.com/segmentio/encoding/json"
 	log "github.com/sirupsen/logrus"
@@ -48,29 +47,21 @@ var (
 	prefs                = flag.String("prefs", "85 55 89 60 50 105 34 101 53 49 28 48 121", "most preferred source id first, for deduplication")
 	ignoreSameIdentifier = flag.Bool("isi", false, "when doing deduplication, ignore matches in index with the same id")
 	dropDangling         = flag.Bool("D", false, "drop dangling documents that do not have any isil attached")
+	timeout              = flag.Duration("timeout", 30*time.Second, "timeout for a single SOLR request, when deduplicating")
+	retries              = flag.Int("retries", 3, "number of retries for failed SOLR requests, when deduplicating")
 )
 
-// SelectResponse with reduced fields.
-type SelectResponse struct {
-	Response struct {
-		Docs []struct {
-			ID          string   `json:"id"`
-			Institution []string `json:"institution"`
-			SourceID    string   `json:"source_id"`
-		} `json:"docs"`
-		NumFound int64 `json:"numFound"`
-		Start    int64 `json:"start"`
-	} `json:"response"`
-	ResponseHeader struct {
-		Params struct {
-			Q  string `json:"q"`
-			Wt string `json:"wt"`
-		} `json:"params"`
-		QTime  int64
-		Status int64 `json:"status"`
-	} `json:"responseHeader"`
+// dedupDoc contains the fields of an index document needed for
+// deduplication.
+type dedupDoc struct {
+	ID          string   `json:"id"`
+	Institution []string `json:"institution"`
+	SourceID    string   `json:"source_id"`
 }
 
+// index to deduplicate against, if a server is given.
+var index solrutil.Index
+
 // preferencePosition returns the position of a given preference as int.
 // Smaller means preferred. If there is no match, return some higher number
 // (low prio).
@@ -94,31 +85,33 @@ func DroppableLabels(is finc.IntermediateSchema) (labels []string, err error) {
 	}
 	// We could search for the DOI directly, e.g. in url field, but currently
 	// the url field in VuFind is not indexed (https://is.gd/zEBoEx).
-	link := fmt.Sprintf(`%s/select?df=allfields&wt=json&q="%s"`, *server, url.QueryEscape(doi))
+	vs := url.Values{}
+	vs.Set("df", "allfields")
+	vs.Set("fl", "id,institution,source_id")
+	vs.Set("q", fmt.Sprintf(`"%s"`, doi))
 	if *verbose {
-		log.Printf("[%s] fetching: %s", is.ID, link)
+		log.Printf("[%s] fetching: %s/select?%s", is.ID, index.Server, vs.Encode())
 	}
-	resp, err := http.Get(link)
+	resp, err := index.Select(vs)
 	if err != nil {
+		log.Printf("[%s] failed query for %s", is.ID, doi)
 		return labels, err
 	}
-	defer resp.Body.Close()
-	var (
-		sr  SelectResponse
-		buf bytes.Buffer // Keep response for debugging.
-		tee = io.TeeReader(resp.Body, &buf)
-	)
-	if err := json.NewDecoder(tee).Decode(&sr); err != nil {
-		log.Printf("[%s] failed link: %s", is.ID, link)
-		log.Printf("[%s] failed response: %s", is.ID, buf.String())
-		return labels, err
+	var docs []dedupDoc
+	for _, b := range resp.Response.Docs {
+		var doc dedupDoc
+		if err := json.Unmarshal(b, &doc); err != nil {
+			log.Printf("[%s] failed response: %s", is.ID, string(b))
+			return labels, err
+		}
+		docs = append(docs, doc)
 	}
 	// ignored merely counts the number of docs, that had the same id in the index, for logging
 	var ignored int
 	for _, label := range is.Labels {
 		// For each label (ISIL), see, whether any match in SOLR has the same
 		// label (ISIL) as well.
-		for _, doc := range sr.Response.Docs {
+		for _, doc := range docs {
 			if *ignoreSameIdentifier && doc.ID == is.ID {
 				ignored++
 				continue
@@ -160,7 +153,10 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 	if *server != "" {
-		*server = solrutil.PrependHTTP(*server)
+		index = solrutil.Index{
+			Server: solrutil.PrependHTTP(*server),
+			Client: solrutil.NewClient(*timeout, *retries),
+		}
 	}
 	var (
 		// The configuration forest.
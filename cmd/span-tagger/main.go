@@ -13,6 +13,11 @@
 //     $ span-amsl-discovery -db amsl.db -live https://live.server
 //     $ taskcat AIIntermediateSchema | span-tagger -db amsl.db > tagged.ndj
 //
+// For a database written by span-folio, holding files are downloaded from
+// FOLIO, which requires credentials:
+//
+//     $ span-tagger -db folio.db -folio https://okapi.example -u user:pass < file.is
+//
 // TODO:
 //
 // * [ ] cover all attachment modes from https://git.io/JvdmC
@@ -39,8 +44,11 @@ import (
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/miku/span"
+	"github.com/miku/span/folio"
 	"github.com/miku/span/formats/finc"
 	"github.com/miku/span/tagging"
+	"github.com/miku/span/xflag"
+	"github.com/sethgrid/pester"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -50,9 +58,14 @@ var (
 	cpuprofile  = flag.String("cpuprofile", "", "file to cpu profile")
 	showVersion = flag.Bool("v", false, "prints current program version")
 	debug       = flag.Bool("debug", false, "only output id and ISIL")
+	folioURL    = flag.String("folio", "", "folio endpoint, to download holding files referenced by span-folio -db")
+	tenant      = flag.String("tenant", "de_15", "folio tenant")
+	folioCache  = flag.String("folio-cache", folio.DefaultCacheDir, "cache directory for files downloaded from folio")
+	userPass    xflag.UserPassword
 )
 
 func main() {
+	flag.Var(&userPass, "u", "user:password for folio api")
 	flag.Parse()
 	if *showVersion {
 		fmt.Println(span.AppVersion)
@@ -76,6 +89,18 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	if *folioURL != "" {
+		api := &folio.API{
+			Base:     *folioURL,
+			Tenant:   *tenant,
+			Client:   pester.New(),
+			CacheDir: *folioCache,
+		}
+		if err := api.Authenticate(userPass.User, userPass.Password); err != nil {
+			log.Fatal(err)
+		}
+		labeler.UseFolio(api)
+	}
 	var (
 		br      = bufio.NewReader(os.Stdin)
 		i       = 0
@@ -1,48 +1,96 @@
 // span-webhookd can serve as a webhook receiver[1] for gitlab, refs #13499.
+// GitHub and Gitea push webhooks are supported as well.
 //
 // We listen for push hooks to trigger index reviews via span-review.
 //
 // [1] https://docs.gitlab.com/ee/user/project/integrations/webhooks.html#example-webhook-receiver
 //
+// Requests are verified with a secret per provider: GitLab sends the secret
+// token in X-Gitlab-Token, GitHub and Gitea sign the payload with it. Requests
+// from a provider without a secret are rejected, unless -insecure is given.
+// Versions up to 0.1.353 accepted unsigned webhooks; set "gitlab.secret" when
+// upgrading. Without any secret and without -insecure, the server does not
+// start.
+//
 // Configuration (Redmine, Gitlab, Index), by default in
 // ~/.config/span/span.json, fallback at /etc/span/span.json. This config file
 // is used both by span-webhookd and span-review.
 //
-// {
-//    "gitlab.token": "g0d8gf0LKJWg89dsf8gd0gf9-YU",
-//    "whatislive.url": "http://example.com/whatislive",
-//    "redmine.baseurl": "https://projects.example.com",
-//    "redmine.apitoken": "badfb87ab7987daafbd9db",
-//    "port": 8080
-// }
+//	{
+//	   "gitlab.token": "g0d8gf0LKJWg89dsf8gd0gf9-YU",
+//	   "gitlab.secret": "webhook-secret",
+//	   "whatislive.url": "http://example.com/whatislive",
+//	   "redmine.baseurl": "https://projects.example.com",
+//	   "redmine.apitoken": "badfb87ab7987daafbd9db",
+//	   "port": 8080
+//	}
+//
+// Notifications go to Redmine by default. Set "notify" to a comma separated
+// list of redmine, gitlab (issue comments, requires "gitlab.url" and
+// "gitlab.project"), webhook ("notify.webhook.url") and smtp ("smtp.addr",
+// "smtp.from", "smtp.to").
+//
+// Reviews run as jobs from a queue kept on disk (-jobs-dir), so they survive
+// restarts. Jobs, their status and output can be inspected via HTTP:
+//
+//	GET /jobs                 list all jobs, most recent first
+//	GET /jobs/{id}            a single job
+//	GET /jobs/{id}/stdout     captured output of a job (or stderr)
+//
+// Job endpoints expose command lines and output. They require a bearer token
+// ("webhookd.jobs.token" or -jobs-token) and are disabled without one. With
+// -jobs-localhost, they are served without a token to requests from
+// localhost; do not use this behind a reverse proxy on the same machine, as
+// all proxied requests come from localhost.
+//
+// If a review fails, a note is added to the ticket of the review.
 //
 // Some limitations:
 //
-// * By default, the server will listen on all interfaces, only the port number
-//   is configurable.
-// * There is no error reporting except in the logs.
-// * Exit code from spawned span-review is ignored.
+//   - By default, the server will listen on all interfaces, only the port number
+//     is configurable.
+//
+// What to run on a push is decided by trigger rules (-rules), mapping
+// providers, events (push, tag), branches and changed paths to commands with
+// templated arguments. Without rules, span-review runs for each changed
+// docs/review*.yaml file. Example rules file:
+//
+//	rules:
+//	    - name: review
+//	      paths: ["^docs/review.*yaml"]
+//	      command: span-review
+//	      args: ["-c", "{{ .Path }}"]
+//	      ticket: "{{ ticket .Path }}"
+//	    - name: compare
+//	      branches: ["master"]
+//	      paths: ["^docs/compare.yaml"]
+//	      command: span-compare
+//	      args: ["-e", "-t"]
+//	      ticket: "{{ ticket .Path }}"
+//	      post: true
 //
 // TODO:
 //
 // * [ ] proper config handling
-// * [ ] send errors into ticket or e-mail
-// * [ ] more, maybe more flexible rules
-//
 package main
 
 import (
+	"bytes"
+	"crypto/subtle"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
-	"os/exec"
 	"os/signal"
 	"path"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"text/template"
 	"time"
 
 	"github.com/segmentio/encoding/json"
@@ -52,7 +100,9 @@ import (
 	"github.com/miku/span"
 	"github.com/miku/span/configutil"
 	"github.com/miku/span/gitlab"
+	"github.com/miku/span/jobutil"
 	"github.com/miku/span/reviewutil"
+	"github.com/miku/span/webhook"
 	"github.com/miku/span/xio"
 	log "github.com/sirupsen/logrus"
 )
@@ -64,17 +114,29 @@ var (
 	logfile        = flag.String("logfile", "", "log to file")
 	spanConfigFile = flag.String("span-config", path.Join(xio.UserHomeDir(), ".config/span/span.json"), "gitlab, redmine tokens, whatislive location")
 	triggerPath    = flag.String("trigger-path", "trigger", "path trigger, {host}:{port}/{trigger-path}")
+	jobsDir        = flag.String("jobs-dir", path.Join(os.TempDir(), "span-webhookd/jobs"), "directory to keep jobs and their output in")
+	jobsToken      = flag.String("jobs-token", "", "bearer token required for the /jobs endpoints, if empty will use span-config; without a token, the endpoints are disabled")
+	jobsLocalhost  = flag.Bool("jobs-localhost", false, "without a jobs token, serve /jobs to requests from localhost (unsafe behind a local reverse proxy)")
+	insecure       = flag.Bool("insecure", false, "accept unverified webhooks from providers without a configured secret")
+	rulesFile      = flag.String("rules", "", "YAML file with trigger rules, default is to run span-review on changed docs/review*.yaml")
 	banner         = fmt.Sprintf(`[<>] webhookd %s`, span.AppVersion)
 
 	// Parsed configuration options.
 	config configutil.Config
 
-	// IndexReviewQueue takes requests for index reviews, add some buffering, so we
-	// can accept a few requests at a time, although this is improbable.
-	IndexReviewQueue = make(chan IndexReviewRequest, 8)
-	done             = make(chan bool)
+	// queue keeps review jobs, until a worker runs them.
+	queue *jobutil.Queue
+	// rules decide, which actions to run on a push.
+	rules *webhook.Rules
+	// checkoutMu serializes git operations on local clones and the removal
+	// of checkouts no longer needed by any job.
+	checkoutMu sync.Mutex
+	done       = make(chan bool)
 )
 
+// maxReportBytes limits the amount of job output sent into a ticket.
+const maxReportBytes = 4096
+
 // IndexReviewRequest contains information for run an index review.
 type IndexReviewRequest struct {
 	ReviewConfigFile string
@@ -97,32 +159,185 @@ func (irr *IndexReviewRequest) PeekTicketNumber() (string, error) {
 	return reviewConfig.Ticket, nil
 }
 
-// Worker hangs in there, checks for any new review requests on the index
-// review queue and starts review, if requested.
+// Worker runs queued jobs, until the queue is closed.
 func Worker(done chan bool) {
 	log.Println("worker started")
-	for rr := range IndexReviewQueue {
-		log.Printf("worker received review request: %s, running review ...", rr)
+	queue.Work(finishJob)
+	log.Println("worker shutdown")
+	done <- true
+}
 
-		cmd, args := "span-review", []string{"-c", rr.ReviewConfigFile}
-		log.Printf("[cmd] %s %s", cmd, strings.Join(args, " "))
+// finishJob reports a job and removes its checkout, if no other job needs it.
+func finishJob(j *jobutil.Job) {
+	reportJob(j)
+	removeCheckout(j)
+}
 
-		// TODO(miku): use runCommand, but handle stdout, stderr as well.
-		out, err := exec.Command(cmd, args...).CombinedOutput()
-		if err != nil {
-			log.Printf("%s failed: %s, combined output: %s", cmd, err, string(out))
+// removeCheckout removes the per commit checkout of a finished job, unless
+// a queued or running job still reads from it.
+func removeCheckout(j *jobutil.Job) {
+	if j.Dir == "" {
+		return
+	}
+	checkoutMu.Lock()
+	defer checkoutMu.Unlock()
+	jobs, err := queue.List()
+	if err != nil {
+		log.Printf("keeping checkout %s: %s", j.Dir, err)
+		return
+	}
+	for _, other := range jobs {
+		if other.ID == j.ID || other.Dir != j.Dir {
 			continue
 		}
-		// TODO(miku): use PeekTicketNumber to send error to ticket if needed.
-		log.Printf("[output] %s", out)
-		log.Println("review completed")
+		if other.Status == jobutil.StatusQueued || other.Status == jobutil.StatusRunning {
+			return
+		}
 	}
-	log.Println("worker shutdown")
-	done <- true
+	if err := os.RemoveAll(j.Dir); err != nil {
+		log.Printf("failed to remove checkout %s: %s", j.Dir, err)
+		return
+	}
+	log.Printf("removed checkout %s", j.Dir)
+}
+
+// reportJob logs the outcome of a job and adds a note to the associated
+// ticket, if the job failed or its output should be posted. A review with
+// failed cases (reviewutil.ExitCasesFailed) reports its results itself and
+// is not reported as failed.
+func reportJob(j *jobutil.Job) {
+	log.Printf("%s finished with exit code %d after %s", j, j.ExitCode, j.Finished.Sub(j.Started))
+	var (
+		failed = j.Status == jobutil.StatusFailed
+		stream = jobutil.Stdout
+	)
+	if failed && j.ExitCode == reviewutil.ExitCasesFailed {
+		log.Printf("job %s ran, but some cases failed", j.ID)
+		failed = false
+	}
+	if !failed && !j.Post {
+		return
+	}
+	if failed {
+		stream = jobutil.Stderr
+	}
+	output, err := queue.Tail(j.ID, stream, maxReportBytes)
+	if err != nil {
+		log.Printf("failed to read output of job %s: %s", j.ID, err)
+	}
+	if failed {
+		log.Printf("job %s failed: %s, stderr: %s", j.ID, j.Error, output)
+	}
+	if _, err := strconv.Atoi(j.Ticket); err != nil {
+		log.Printf("no valid ticket number for job %s, not reporting", j.ID)
+		return
+	}
+	notifiers, err := reviewutil.Notifiers(&config)
+	if err != nil {
+		log.Printf("notifications not configured, not reporting job %s: %s", j.ID, err)
+		return
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "an unidentified host"
+	}
+	var (
+		command = strings.TrimSpace(j.Name + " " + strings.Join(j.Args, " "))
+		subject = fmt.Sprintf("%s results", j.Name)
+	)
+	if failed {
+		subject = fmt.Sprintf("%s failed", j.Name)
+	}
+	render := func(markup string) (string, error) {
+		var buf bytes.Buffer
+		switch markup {
+		case reviewutil.Textile:
+			fmt.Fprintf(&buf, "* job %s on %s\n* command: %s\n", j.ID, hostname, command)
+			if failed {
+				fmt.Fprintf(&buf, "* exit code %d: %s\n\n{{collapse(stderr)\n<pre>\n%s\n</pre>\n}}\n",
+					j.ExitCode, j.Error, output)
+			} else {
+				fmt.Fprintf(&buf, "\n%s\n", output)
+			}
+		case reviewutil.Markdown:
+			fmt.Fprintf(&buf, "* job %s on %s\n* command: `%s`\n", j.ID, hostname, command)
+			if failed {
+				fmt.Fprintf(&buf, "* exit code %d: %s\n\n```\n%s\n```\n", j.ExitCode, j.Error, output)
+			} else {
+				fmt.Fprintf(&buf, "\n%s\n", output)
+			}
+		default:
+			fmt.Fprintf(&buf, "job %s on %s\ncommand: %s\n", j.ID, hostname, command)
+			if failed {
+				fmt.Fprintf(&buf, "exit code %d: %s\n", j.ExitCode, j.Error)
+			}
+			fmt.Fprintf(&buf, "\n%s\n", output)
+		}
+		return buf.String(), nil
+	}
+	if err := reviewutil.Broadcast(notifiers, j.Ticket, subject, render); err != nil {
+		log.Printf("failed to report job %s to ticket %s: %s", j.ID, j.Ticket, err)
+	}
+}
+
+// peekTicket returns the ticket number of a review file or an empty string.
+func peekTicket(filename string) string {
+	rr := IndexReviewRequest{ReviewConfigFile: filename}
+	ticket, err := rr.PeekTicketNumber()
+	if err != nil {
+		log.Printf("cannot read ticket number from %s: %s", filename, err)
+		return ""
+	}
+	return ticket
+}
+
+// maxPayloadBytes limits the size of a webhook request body.
+const maxPayloadBytes = 25 << 20
+
+// providers returns the supported webhook providers. Gitea sends GitHub
+// headers as well, so it needs to come first.
+func providers() []webhook.Provider {
+	return []webhook.Provider{
+		webhook.Gitea{Secret: config.GiteaSecret, Insecure: *insecure},
+		webhook.GitHub{Secret: config.GitHubSecret, Insecure: *insecure},
+		webhook.GitLab{Secret: config.GitLabSecret, Insecure: *insecure},
+	}
+}
+
+// nonAlnum matches characters not used in clone directory names.
+var nonAlnum = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// cloneRepo returns the local clone for the repository a push event refers
+// to, one per provider and repository. Pushed commits are checked out into
+// separate worktrees, see checkoutDir.
+func cloneRepo(push *webhook.Push) gitlab.Repo {
+	var (
+		name = strings.Trim(nonAlnum.ReplaceAllString(push.Repo, "_"), "_")
+		repo = gitlab.Repo{
+			URL: push.CloneURL,
+			Dir: fmt.Sprintf("%s-%s-%s", config.GitLabCloneDir, push.Provider, name),
+		}
+	)
+	switch push.Provider {
+	case "gitlab":
+		repo.Token = config.GitLabToken
+	case "github":
+		repo.Token = config.GitHubToken
+	case "gitea":
+		repo.Token = config.GiteaToken
+	}
+	return repo
+}
+
+// checkoutDir returns the directory for the pushed commit. Queued jobs read
+// from this directory, so later pushes to the same ref do not change the
+// files of a job.
+func checkoutDir(repo gitlab.Repo, push *webhook.Push) string {
+	return fmt.Sprintf("%s-%s", repo.Dir, nonAlnum.ReplaceAllString(push.After, "_"))
 }
 
-// HookHandler can act as webhook receiver. The hook we use at the moment is
-// the Push Hook. Other types are Issue, Note or Tag Push Hook.
+// HookHandler can act as webhook receiver for GitLab, GitHub and Gitea. The
+// hook we use at the moment is the push hook, other events are ignored.
 func HookHandler(w http.ResponseWriter, r *http.Request) {
 	started := time.Now()
 	defer func() {
@@ -133,62 +348,203 @@ func HookHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Header.Get("X-FORWARDED-FOR") != "" {
 		log.Printf("X-FORWARDED-FOR: %s", r.Header.Get("X-FORWARDED-FOR"))
 	}
-	gitlabEvent := strings.TrimSpace(r.Header.Get("X-Gitlab-Event"))
-	switch gitlabEvent {
-	case "Push Hook":
-		var payload gitlab.PushPayload
-		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-		log.Printf("gitlab payload: %v", payload)
-		var (
-			pattern     = "^docs/review.*yaml"
-			reviewFiles = payload.MatchModified(regexp.MustCompile(pattern))
-			repo        = gitlab.Repo{
-				URL:   payload.Project.GitHttpUrl,
-				Dir:   config.GitLabCloneDir,
-				Token: config.GitLabToken,
-			}
-		)
-		if len(reviewFiles) == 0 {
-			log.Printf("%s matched nothing, hook done", pattern)
-			return
+	provider := webhook.Detect(r, providers()...)
+	if provider == nil {
+		log.Printf("no event header of a known provider found")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxPayloadBytes))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err := provider.Verify(r, body); err != nil {
+		log.Printf("%s: request verification failed: %s", provider.Name(), err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	push, err := provider.Parse(r, body)
+	if err == webhook.ErrIgnored {
+		log.Printf("%s: ignoring event", provider.Name())
+		return
+	}
+	if err != nil {
+		log.Printf("%s: invalid payload: %s", provider.Name(), err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	log.Printf("%s %s to %s (%s): %d files", push.Provider, push.Type(), push.CloneURL, push.Ref, len(push.Files))
+	if push.Deleted() {
+		log.Printf("%s deleted, hook done", push.Ref)
+		return
+	}
+	matches := rules.Match(push)
+	if len(matches) == 0 {
+		log.Printf("no rule matched, hook done")
+		return
+	}
+	if push.After == "" {
+		log.Printf("%s: no commit given for %s, hook done", push.Provider, push.Ref)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var (
+		repo = cloneRepo(push)
+		dir  = checkoutDir(repo, push)
+	)
+	// Checkout and queueing happen under one lock, so a finishing job does
+	// not remove the checkout before the new jobs are queued.
+	checkoutMu.Lock()
+	defer checkoutMu.Unlock()
+	if err := repo.Worktree(push.Ref, push.After, dir); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	log.Printf("checked out %s at %s", push.Ref, dir)
+	actions, err := webhook.Actions(matches, push, dir, template.FuncMap{
+		"ticket": peekTicket,
+	})
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(actions) == 0 {
+		log.Printf("no actions, hook done")
+		if err := os.RemoveAll(dir); err != nil {
+			log.Println(err)
 		}
-		if err := repo.Update(); err != nil {
+		return
+	}
+	// A push can touch multiple files, issue a job for each action.
+	for _, a := range actions {
+		j, err := queue.AddJob(&jobutil.Job{
+			Name:   a.Command,
+			Args:   a.Args,
+			Ticket: a.Ticket,
+			Rule:   a.Rule,
+			Post:   a.Post,
+			Dir:    dir,
+		})
+		if err != nil {
 			log.Println(err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		// TODO: exit code handling, non-portable, https://stackoverflow.com/a/10385867.
-		log.Printf("successfully updated repo at %s", repo.Dir)
-		// We can have multiple review files, issue a request for each of them.
-		// TODO: the same file might appear multiple times.
-		for _, reviewFile := range reviewFiles {
-			rr := IndexReviewRequest{
-				ReviewConfigFile: path.Join(repo.Dir, reviewFile),
-			}
-			IndexReviewQueue <- rr
-			log.Printf("dispatched review for %s", reviewFile)
-		}
-	case "":
-		log.Printf("X-Gitlab-Event not given or empty")
-		w.WriteHeader(http.StatusBadRequest)
-	default:
-		log.Printf("unregistered or invalid event kind: %s", gitlabEvent)
-		w.WriteHeader(http.StatusBadRequest)
+		log.Printf("dispatched %s (rule %s) as %s", a, a.Rule, j.ID)
 	}
 }
 
 // HomeHandler says hello.
 func HomeHandler(w http.ResponseWriter, r *http.Request) {
-	s := fmt.Sprintf("This is span-webhookd %s, a webhook receiver for gitlab (#12756).", span.AppVersion)
+	s := fmt.Sprintf("This is span-webhookd %s, a webhook receiver for gitlab, github and gitea (#12756).", span.AppVersion)
 	if _, err := fmt.Fprintln(w, s); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 }
 
+// isLoopback returns true, if a request comes from the local machine.
+func isLoopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// protectJobs requires the configured jobs token as bearer token. Without a
+// token, only requests from localhost are allowed, which requires
+// -jobs-localhost.
+func protectJobs(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if config.WebhookdJobsToken == "" {
+			if !isLoopback(r) {
+				log.Printf("jobs: rejecting request from %s, no token configured", r.RemoteAddr)
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			h(w, r)
+			return
+		}
+		var (
+			given = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			want  = config.WebhookdJobsToken
+		)
+		if subtle.ConstantTimeCompare([]byte(given), []byte(want)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// JobsHandler lists all jobs.
+func JobsHandler(w http.ResponseWriter, r *http.Request) {
+	jobs, err := queue.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if jobs == nil {
+		jobs = []*jobutil.Job{}
+	}
+	writeJSON(w, jobs)
+}
+
+// JobHandler returns a single job.
+func JobHandler(w http.ResponseWriter, r *http.Request) {
+	j, err := queue.Get(mux.Vars(r)["id"])
+	if err == jobutil.ErrJobNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, j)
+}
+
+// JobLogHandler returns the captured stdout or stderr of a job.
+func JobLogHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if _, err := queue.Get(vars["id"]); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	f, err := os.Open(queue.LogPath(vars["id"], vars["stream"]))
+	if os.IsNotExist(err) {
+		// Job has not been started yet.
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if _, err := io.Copy(w, f); err != nil {
+		log.Println(err)
+	}
+}
+
+// writeJSON writes a value as JSON response.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Println(err)
+	}
+}
+
 // parsePort takes a hostport and returns the port number as int.
 func parsePort(addr string) (int, error) {
 	parts := strings.Split(addr, ":")
@@ -231,17 +587,73 @@ func main() {
 	if *repoDir != "" {
 		config.GitLabCloneDir = *repoDir
 	}
+	if *jobsToken != "" {
+		config.WebhookdJobsToken = *jobsToken
+	}
+	serveJobs := config.WebhookdJobsToken != "" || *jobsLocalhost
+	switch {
+	case config.WebhookdJobsToken == "" && *jobsLocalhost:
+		log.Printf("no webhookd.jobs.token configured, /jobs only available from localhost")
+	case config.WebhookdJobsToken == "":
+		log.Printf("no webhookd.jobs.token configured, /jobs disabled (see: -jobs-token, -jobs-localhost)")
+	}
 
-	// Dump config.
-	b, err := json.Marshal(config)
+	var verified int
+	for name, secret := range map[string]string{
+		"gitlab": config.GitLabSecret,
+		"github": config.GitHubSecret,
+		"gitea":  config.GiteaSecret,
+	} {
+		switch {
+		case secret != "":
+			verified++
+		case *insecure:
+			log.Printf("warning: no %s.secret configured, %s webhooks will not be verified", name, name)
+		default:
+			log.Printf("no %s.secret configured, %s webhooks will be rejected (see: -insecure)", name, name)
+		}
+	}
+	if verified == 0 && !*insecure {
+		log.Fatal("no webhook secret configured, all webhooks would be rejected; " +
+			"set gitlab.secret (github.secret, gitea.secret) in span config, or use -insecure")
+	}
+
+	// Dump config, without secrets.
+	b, err := json.Marshal(config.Redacted())
 	if err != nil {
 		log.Fatal(err)
 	}
 	log.Printf("using config: %s", string(b))
 
+	// Read trigger rules.
+	var rulesReader io.Reader = strings.NewReader(webhook.DefaultRules)
+	if *rulesFile != "" {
+		f, err := os.Open(*rulesFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		rulesReader = f
+	}
+	if rules, err = webhook.ReadRules(rulesReader); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("using %d trigger rules", len(rules.Rules))
+
+	// Open job queue, jobs left over from a previous run will be run first.
+	if queue, err = jobutil.Open(*jobsDir); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("using job queue at %s", queue.Dir)
+
 	// Setup handlers.
 	r := mux.NewRouter()
 	r.HandleFunc("/", HomeHandler)
+	if serveJobs {
+		r.HandleFunc("/jobs", protectJobs(JobsHandler)).Methods("GET")
+		r.HandleFunc("/jobs/{id}", protectJobs(JobHandler)).Methods("GET")
+		r.HandleFunc("/jobs/{id}/{stream:stdout|stderr}", protectJobs(JobLogHandler)).Methods("GET")
+	}
 	r.HandleFunc(fmt.Sprintf("/%s", *triggerPath), HookHandler)
 	http.Handle("/", r)
 
@@ -270,7 +682,7 @@ func main() {
 	go func() {
 		// XXX: Use some timeout here.
 		for range c {
-			close(IndexReviewQueue)
+			queue.Close()
 			<-done
 			os.Exit(0)
 		}
@@ -5,14 +5,56 @@
 // not from the binary itself (get rid of go-bindata).
 package configutil
 
-// Config is application configuration of span and its subcommands.
+// Config is application configuration of span and its subcommands. JSON keys
+// follow the span config file, shared with span-review.
 type Config struct {
-	GitLabCloneDir   string `yaml:"gitlab.clonedir" env:"SPAN_GITLAB_CLONEDIR" env-default:"/tmp/span-webhookd-clone"`
-	GitLabToken      string `yaml:"gitlab.token" env:"SPAN_GITLAB_TOKEN"`
-	RedmineToken     string `yaml:"redmine.token" env:"SPAN_REDMINE_TOKEN"`
-	RedmineURL       string `yaml:"redmine.url" env:"SPAN_REDMINE_URL"`
-	WebhookdHostPort string `yaml:"webhookd.listen" env:"SPAN_WEBHOOKD_LISTEN" env-default:"0.0.0.0:8080"`
-	WebhookdLogfile  string `yaml:"webhookd.logfile" env:"SPAN_WEBHOOKD_LOGFILE"`
-	WebhookdPath     string `yaml:"webhookd.path" env:"SPAN_WEBHOOKD_PATH" env-default:"trigger"`
-	WhatIsLiveURL    string `yaml:"whatislive.url" env:"SPAN_WHATISLIVE_URL"`
+	GitLabCloneDir     string `yaml:"gitlab.clonedir" env:"SPAN_GITLAB_CLONEDIR" env-default:"/tmp/span-webhookd-clone"`
+	GitLabToken        string `yaml:"gitlab.token" json:"gitlab.token" env:"SPAN_GITLAB_TOKEN"`
+	GitLabURL          string `yaml:"gitlab.url" json:"gitlab.url" env:"SPAN_GITLAB_URL"`
+	GitLabProject      string `yaml:"gitlab.project" json:"gitlab.project" env:"SPAN_GITLAB_PROJECT"`
+	GitLabSecret       string `yaml:"gitlab.secret" json:"gitlab.secret" env:"SPAN_GITLAB_SECRET"`
+	GitHubToken        string `yaml:"github.token" json:"github.token" env:"SPAN_GITHUB_TOKEN"`
+	GitHubSecret       string `yaml:"github.secret" json:"github.secret" env:"SPAN_GITHUB_SECRET"`
+	GiteaToken         string `yaml:"gitea.token" json:"gitea.token" env:"SPAN_GITEA_TOKEN"`
+	GiteaSecret        string `yaml:"gitea.secret" json:"gitea.secret" env:"SPAN_GITEA_SECRET"`
+	Notify             string `yaml:"notify" json:"notify" env:"SPAN_NOTIFY"` // comma separated: redmine, gitlab, webhook, smtp
+	NotifyWebhookURL   string `yaml:"notify.webhook.url" json:"notify.webhook.url" env:"SPAN_NOTIFY_WEBHOOK_URL"`
+	NotifyWebhookToken string `yaml:"notify.webhook.token" json:"notify.webhook.token" env:"SPAN_NOTIFY_WEBHOOK_TOKEN"`
+	RedmineToken       string `yaml:"redmine.token" json:"redmine.apitoken" env:"SPAN_REDMINE_TOKEN"`
+	RedmineURL         string `yaml:"redmine.url" json:"redmine.baseurl" env:"SPAN_REDMINE_URL"`
+	SMTPAddr           string `yaml:"smtp.addr" json:"smtp.addr" env:"SPAN_SMTP_ADDR"`
+	SMTPFrom           string `yaml:"smtp.from" json:"smtp.from" env:"SPAN_SMTP_FROM"`
+	SMTPTo             string `yaml:"smtp.to" json:"smtp.to" env:"SPAN_SMTP_TO"` // comma separated
+	SMTPUsername       string `yaml:"smtp.username" json:"smtp.username" env:"SPAN_SMTP_USERNAME"`
+	SMTPPassword       string `yaml:"smtp.password" json:"smtp.password" env:"SPAN_SMTP_PASSWORD"`
+	WebhookdHostPort   string `yaml:"webhookd.listen" env:"SPAN_WEBHOOKD_LISTEN" env-default:"0.0.0.0:8080"`
+	WebhookdJobsToken  string `yaml:"webhookd.jobs.token" json:"webhookd.jobs.token" env:"SPAN_WEBHOOKD_JOBS_TOKEN"`
+	WebhookdLogfile    string `yaml:"webhookd.logfile" env:"SPAN_WEBHOOKD_LOGFILE"`
+	WebhookdPath       string `yaml:"webhookd.path" env:"SPAN_WEBHOOKD_PATH" env-default:"trigger"`
+	WhatIsLiveURL      string `yaml:"whatislive.url" json:"whatislive.url" env:"SPAN_WHATISLIVE_URL"`
+}
+
+// redacted is shown instead of a secret.
+const redacted = "xxx"
+
+// Redacted returns a copy of the configuration with tokens, secrets and
+// passwords blacked out, e.g. for logging.
+func (c Config) Redacted() Config {
+	for _, v := range []*string{
+		&c.GitLabToken,
+		&c.GitLabSecret,
+		&c.GitHubToken,
+		&c.GitHubSecret,
+		&c.GiteaToken,
+		&c.GiteaSecret,
+		&c.NotifyWebhookToken,
+		&c.RedmineToken,
+		&c.SMTPPassword,
+		&c.WebhookdJobsToken,
+	} {
+		if *v != "" {
+			*v = redacted
+		}
+	}
+	return c
 }
@@ -0,0 +1,264 @@
+package crossrefutil
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FilterLines writes the lines from r, whose line numbers (starting at 1) are
+// found in l, one number per line, sorted ascending. A Go version of
+// filterline (https://github.com/miku/filterline). Returns the number of lines
+// written.
+func FilterLines(w io.Writer, r io.Reader, l io.Reader) (written int64, err error) {
+	var (
+		br     = bufio.NewReaderSize(r, 1<<20)
+		bw     = bufio.NewWriterSize(w, 1<<20)
+		ls     = bufio.NewScanner(l)
+		lineno int64
+		want   int64
+		keep   bool
+	)
+	for ls.Scan() {
+		v := bytes.TrimSpace(ls.Bytes())
+		if len(v) == 0 {
+			continue
+		}
+		if want, err = strconv.ParseInt(string(v), 10, 64); err != nil {
+			return written, fmt.Errorf("invalid line number: %s", v)
+		}
+		if want <= lineno {
+			return written, fmt.Errorf("line numbers must be sorted: %d after %d", want, lineno)
+		}
+		// Skip lines until we reach the wanted one, then copy it, even if it
+		// does not fit into the buffer.
+		for {
+			b, err := br.ReadSlice('\n')
+			if len(b) == 0 && err == io.EOF {
+				return written, bw.Flush()
+			}
+			if err != nil && err != bufio.ErrBufferFull && err != io.EOF {
+				return written, err
+			}
+			if !keep {
+				lineno++
+			}
+			keep = err == bufio.ErrBufferFull
+			if lineno == want {
+				if _, err := bw.Write(b); err != nil {
+					return written, err
+				}
+				if !keep {
+					if !bytes.HasSuffix(b, []byte("\n")) {
+						if err := bw.WriteByte('\n'); err != nil {
+							return written, err
+						}
+					}
+					written++
+					break
+				}
+			}
+			if err == io.EOF {
+				return written, bw.Flush()
+			}
+		}
+	}
+	if err := ls.Err(); err != nil {
+		return written, err
+	}
+	return written, bw.Flush()
+}
+
+// latest keeps the line number of the most recent version of a DOI.
+type latest struct {
+	date   string
+	lineno int64
+}
+
+// SelectLatest reads lines of the form "lineno<TAB>date<TAB>DOI" and writes
+// the line numbers of the most recent version of each DOI, sorted ascending,
+// one per line. If dates are equal, the later line wins. To keep memory
+// usage bounded, entries are first split into a number of partitions by DOI
+// in temporary files in dir (or the default temporary directory).
+func SelectLatest(w io.Writer, r io.Reader, dir string, partitions int) error {
+	if partitions < 1 {
+		partitions = 1
+	}
+	var (
+		files   = make([]*os.File, partitions)
+		writers = make([]*bufio.Writer, partitions)
+	)
+	defer func() {
+		for _, f := range files {
+			if f != nil {
+				f.Close()
+				os.Remove(f.Name())
+			}
+		}
+	}()
+	for i := range files {
+		f, err := ioutil.TempFile(dir, "span-crossref-snapshot-partition-")
+		if err != nil {
+			return err
+		}
+		files[i], writers[i] = f, bufio.NewWriter(f)
+	}
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.ReadBytes('\n')
+		if err == io.EOF && len(b) == 0 {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		fields := bytes.SplitN(bytes.TrimRight(b, "\n"), []byte("\t"), 3)
+		if len(fields) != 3 {
+			return fmt.Errorf("invalid line: %q", b)
+		}
+		h := fnv.New32a()
+		h.Write(fields[2])
+		pw := writers[int(h.Sum32()%uint32(partitions))]
+		if _, err := pw.Write(b); err != nil {
+			return err
+		}
+		if !bytes.HasSuffix(b, []byte("\n")) {
+			if err := pw.WriteByte('\n'); err != nil {
+				return err
+			}
+		}
+	}
+	// Find the most recent version of each DOI per partition and replace the
+	// partition content with a sorted list of line numbers.
+	for i, f := range files {
+		if err := writers[i].Flush(); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		var (
+			m  = make(map[string]latest)
+			pr = bufio.NewReader(f)
+		)
+		for {
+			line, err := pr.ReadString('\n')
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			lineno, date, doi, err := parseExtract(line)
+			if err != nil {
+				return err
+			}
+			if v, ok := m[doi]; ok && (v.date > date || (v.date == date && v.lineno > lineno)) {
+				continue
+			}
+			m[doi] = latest{date: date, lineno: lineno}
+		}
+		linenos := make([]int64, 0, len(m))
+		for _, v := range m {
+			linenos = append(linenos, v.lineno)
+		}
+		sort.Slice(linenos, func(i, j int) bool { return linenos[i] < linenos[j] })
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		bw := bufio.NewWriter(f)
+		for _, v := range linenos {
+			if _, err := fmt.Fprintln(bw, v); err != nil {
+				return err
+			}
+		}
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	return mergeSorted(w, files)
+}
+
+// parseExtract parses a "lineno<TAB>date<TAB>DOI" line.
+func parseExtract(line string) (lineno int64, date, doi string, err error) {
+	fields := strings.SplitN(strings.TrimSuffix(line, "\n"), "\t", 3)
+	if len(fields) != 3 {
+		return 0, "", "", fmt.Errorf("invalid line: %q", line)
+	}
+	lineno, err = strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, "", "", fmt.Errorf("invalid line number: %q", fields[0])
+	}
+	return lineno, fields[1], fields[2], nil
+}
+
+// mergeItem is the current value of a sorted input.
+type mergeItem struct {
+	value int64
+	index int
+}
+
+// mergeHeap implements heap.Interface for a k-way merge.
+type mergeHeap []mergeItem
+
+func (h mergeHeap) Len() int            { return len(h) }
+func (h mergeHeap) Less(i, j int) bool  { return h[i].value < h[j].value }
+func (h mergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(mergeItem)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// mergeSorted merges readers containing sorted numbers, one per line.
+func mergeSorted(w io.Writer, rs []*os.File) error {
+	var (
+		scanners = make([]*bufio.Scanner, len(rs))
+		h        = &mergeHeap{}
+		bw       = bufio.NewWriter(w)
+	)
+	next := func(i int) error {
+		if !scanners[i].Scan() {
+			return scanners[i].Err()
+		}
+		v, err := strconv.ParseInt(scanners[i].Text(), 10, 64)
+		if err != nil {
+			return err
+		}
+		heap.Push(h, mergeItem{value: v, index: i})
+		return nil
+	}
+	for i, r := range rs {
+		scanners[i] = bufio.NewScanner(r)
+		if err := next(i); err != nil {
+			return err
+		}
+	}
+	for h.Len() > 0 {
+		item := heap.Pop(h).(mergeItem)
+		if _, err := fmt.Fprintln(bw, item.value); err != nil {
+			return err
+		}
+		if err := next(item.index); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,80 @@
+package crossrefutil
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestFilterLines(t *testing.T) {
+	long := strings.Repeat("x", 3<<20)
+	var cases = []struct {
+		about   string
+		input   string
+		linenos string
+		want    string
+		err     bool
+	}{
+		{"empty", "", "", "", false},
+		{"no lines", "a\nb\n", "", "", false},
+		{"first", "a\nb\nc\n", "1\n", "a\n", false},
+		{"some", "a\nb\nc\nd\n", "2\n4\n", "b\nd\n", false},
+		{"beyond", "a\nb\n", "2\n5\n", "b\n", false},
+		{"no trailing newline", "a\nb", "2\n", "b\n", false},
+		{"empty line", "a\n\nc\n", "2\n3\n", "\nc\n", false},
+		{"long line", "a\n" + long + "\nc\n", "2\n3\n", long + "\nc\n", false},
+		{"skip long line", "a\n" + long + "\nc\n", "1\n3\n", "a\nc\n", false},
+		{"unsorted", "a\nb\nc\n", "2\n1\n", "b\n", true},
+		{"invalid", "a\nb\nc\n", "x\n", "", true},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		_, err := FilterLines(&buf, strings.NewReader(c.input), strings.NewReader(c.linenos))
+		if (err != nil) != c.err {
+			t.Errorf("%s: got %v, want error %v", c.about, err, c.err)
+		}
+		if err == nil && buf.String() != c.want {
+			t.Errorf("%s: got %q, want %q", c.about, buf.String(), c.want)
+		}
+	}
+}
+
+func TestSelectLatest(t *testing.T) {
+	input := strings.Join([]string{
+		"1\t2022-01-01\t10.1/a",
+		"2\t2022-01-01\t10.1/b",
+		"3\t2022-01-03\t10.1/a",
+		"4\t2022-01-02\t10.1/a",
+		"5\t2022-01-01\t10.1/c",
+		"6\t2022-01-01\t10.1/b",
+	}, "\n") + "\n"
+	for _, partitions := range []int{0, 1, 2, 16} {
+		var buf bytes.Buffer
+		if err := SelectLatest(&buf, strings.NewReader(input), "", partitions); err != nil {
+			t.Fatalf("SelectLatest: got %v, want nil", err)
+		}
+		if want := "3\n5\n6\n"; buf.String() != want {
+			t.Errorf("SelectLatest [%d]: got %q, want %q", partitions, buf.String(), want)
+		}
+	}
+}
+
+func BenchmarkFilterLines(b *testing.B) {
+	var input, linenos bytes.Buffer
+	for i := 1; i <= 100000; i++ {
+		fmt.Fprintf(&input, `{"DOI": "10.1/%d", "indexed": {"date-parts": [[2022, 1, 1]]}}`+"\n", i)
+		if i%3 == 0 {
+			fmt.Fprintln(&linenos, i)
+		}
+	}
+	b.SetBytes(int64(input.Len()))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := FilterLines(ioutil.Discard, bytes.NewReader(input.Bytes()),
+			bytes.NewReader(linenos.Bytes())); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+// Package crossrefutil implements helpers for working with raw crossref works
+// API data, as cached by span-crossref-sync.
+package crossrefutil
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/miku/span/atomic"
+	"github.com/miku/span/dateutil"
+	"github.com/miku/span/xio"
+	"github.com/segmentio/encoding/json"
+)
+
+// ManifestSuffix is appended to the name of a cached file to get the name of
+// its manifest.
+const ManifestSuffix = ".manifest.json"
+
+// Manifest describes a single cached file, so we can later tell, whether it
+// is complete.
+type Manifest struct {
+	Filename        string    `json:"filename"`
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end"`
+	Filter          string    `json:"filter"`
+	Mode            string    `json:"mode"`
+	ItemsWritten    int64     `json:"items_written"`
+	TotalResults    int64     `json:"total_results"`
+	SHA256          string    `json:"sha256"`
+	CompressProgram string    `json:"compress_program"`
+	Created         time.Time `json:"created"`
+}
+
+// IsSync returns true, if the manifest describes a file containing messages.
+// In tabs mode, only a single summary line is written.
+func (m *Manifest) IsSync() bool {
+	return m.Mode == "s" || m.Mode == "sync"
+}
+
+// WriteFile atomically writes the manifest as JSON to a file.
+func (m *Manifest) WriteFile(filename string) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	return atomic.WriteFile(filename, b, 0644)
+}
+
+// ManifestPath returns the path of the manifest for a given cached file.
+func ManifestPath(filename string) string {
+	return filename + ManifestSuffix
+}
+
+// ReadManifest reads a manifest from a JSON file.
+func ReadManifest(filename string) (*Manifest, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var m Manifest
+	if err := json.NewDecoder(f).Decode(&m); err != nil {
+		return nil, fmt.Errorf("manifest %s: %v", filename, err)
+	}
+	return &m, nil
+}
+
+// Checksum returns the hex encoded SHA256 of the content of a file.
+func Checksum(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// CountLines returns the number of newline terminated lines in a plain, gzip
+// or zstd compressed file. Decompression errors, e.g. from a truncated file,
+// are returned.
+func CountLines(filename string) (int64, error) {
+	rc, err := xio.OpenDecompress(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	var (
+		br    = bufio.NewReaderSize(rc, 1<<20)
+		count int64
+		// last is the last byte read, a long line may span several reads
+		last byte = '\n'
+	)
+	for {
+		b, err := br.ReadSlice('\n')
+		if len(b) > 0 {
+			last = b[len(b)-1]
+		}
+		switch {
+		case err == io.EOF:
+			if last != '\n' {
+				return count, fmt.Errorf("incomplete last line after %d lines", count)
+			}
+			return count, nil
+		case err == bufio.ErrBufferFull:
+			continue
+		case err != nil:
+			return count, err
+		}
+		count++
+	}
+}
+
+// Verification reports the result of checking a single cached file against
+// its manifest. A file is ok, if there are no problems.
+type Verification struct {
+	Filename string    `json:"filename"`
+	Manifest *Manifest `json:"manifest,omitempty"`
+	Lines    int64     `json:"lines"`
+	Problems []string  `json:"problems,omitempty"`
+	// Backfilled is true, if a missing manifest has been computed, see
+	// Verifier.Backfill.
+	Backfilled bool `json:"backfilled,omitempty"`
+}
+
+// OK returns true, if no problems were found.
+func (v *Verification) OK() bool {
+	return len(v.Problems) == 0
+}
+
+func (v *Verification) addProblem(format string, a ...interface{}) {
+	v.Problems = append(v.Problems, fmt.Sprintf(format, a...))
+}
+
+// Verifier checks cached files.
+type Verifier struct {
+	// Tolerance is the fraction of items that may be missing compared to the
+	// total results reported by the API, e.g. 0.01 for 1%.
+	Tolerance float64
+	// Backfill, if set, is called for a file without manifest, which can be
+	// read completely, e.g. a file cached before manifests existed. The
+	// returned manifest is completed with line count and checksum. The total
+	// results are not known for these files.
+	Backfill func(filename string) *Manifest
+	// WriteBackfill writes backfilled manifests next to the file. Otherwise
+	// the manifest is only returned and the file is still reported as
+	// missing a manifest.
+	WriteBackfill bool
+}
+
+// VerifyFile decompresses and recounts a cached file and compares the
+// result to the manifest next to it. Only I/O errors unrelated to the file
+// content are returned as error, everything else is recorded as problem.
+func (v *Verifier) VerifyFile(filename string) (*Verification, error) {
+	result := &Verification{Filename: filename}
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		result.addProblem("file missing")
+		return result, nil
+	}
+	var (
+		m, err         = ReadManifest(ManifestPath(filename))
+		missing        = os.IsNotExist(err)
+		lines, lerr    = CountLines(filename)
+		checksum, cerr = Checksum(filename)
+	)
+	if cerr != nil {
+		return nil, cerr
+	}
+	result.Lines = lines
+	switch {
+	case missing && lerr == nil && v.Backfill != nil:
+		m = v.Backfill(filename)
+		m.Filename = filepath.Base(filename)
+		m.ItemsWritten = lines
+		m.SHA256 = checksum
+		m.Created = time.Now()
+		if v.WriteBackfill {
+			if err := m.WriteFile(ManifestPath(filename)); err != nil {
+				return nil, err
+			}
+		} else {
+			result.addProblem("manifest missing")
+		}
+		result.Manifest = m
+		result.Backfilled = true
+	case missing:
+		result.addProblem("manifest missing")
+	case err != nil:
+		result.addProblem("manifest unreadable: %v", err)
+	default:
+		result.Manifest = m
+	}
+	if lerr != nil {
+		result.addProblem("read failed after %d lines: %v", lines, lerr)
+	}
+	if result.Manifest == nil {
+		return result, nil
+	}
+	m = result.Manifest
+	if checksum != m.SHA256 {
+		result.addProblem("checksum mismatch: got %s, want %s", checksum, m.SHA256)
+	}
+	if result.Lines != m.ItemsWritten {
+		result.addProblem("line count mismatch: got %d, want %d", result.Lines, m.ItemsWritten)
+	}
+	if m.IsSync() && m.TotalResults > 0 {
+		missing := m.TotalResults - m.ItemsWritten
+		if float64(missing) > v.Tolerance*float64(m.TotalResults) {
+			result.addProblem("incomplete: %d of %d items (%0.2f%%)",
+				m.ItemsWritten, m.TotalResults, 100*float64(m.ItemsWritten)/float64(m.TotalResults))
+		}
+	}
+	return result, nil
+}
+
+// Gaps returns the uncovered time spans between the windows of the given
+// manifests. Windows are considered adjacent, if the next starts at most one
+// second after the previous ended, as produced by dateutil intervals. Gaps
+// fully covered by any of the reported intervals, e.g. windows already
+// reported as missing, are omitted.
+func Gaps(manifests []*Manifest, reported ...dateutil.Interval) (gaps []dateutil.Interval) {
+	if len(manifests) < 2 {
+		return nil
+	}
+	ms := make([]*Manifest, len(manifests))
+	copy(ms, manifests)
+	sort.Slice(ms, func(i, j int) bool {
+		return ms[i].Start.Before(ms[j].Start)
+	})
+	end := ms[0].End
+	for _, m := range ms[1:] {
+		if m.Start.Sub(end) > time.Second {
+			gap := dateutil.Interval{Start: end.Add(time.Nanosecond), End: m.Start.Add(-time.Nanosecond)}
+			if !covered(gap, reported) {
+				gaps = append(gaps, gap)
+			}
+		}
+		if m.End.After(end) {
+			end = m.End
+		}
+	}
+	return gaps
+}
+
+// covered returns true, if the union of the given intervals covers iv,
+// allowing for one second between adjacent intervals.
+func covered(iv dateutil.Interval, ivs []dateutil.Interval) bool {
+	sorted := make([]dateutil.Interval, len(ivs))
+	copy(sorted, ivs)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Start.Before(sorted[j].Start)
+	})
+	t := iv.Start
+	for _, v := range sorted {
+		if v.Start.Sub(t) > time.Second {
+			break
+		}
+		if v.End.After(t) {
+			t = v.End
+		}
+		if iv.End.Sub(t) <= time.Second {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,207 @@
+package crossrefutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/miku/span/dateutil"
+)
+
+// writeZstd writes content zstd compressed to a file and returns its name.
+func writeZstd(t *testing.T, dir, name, content string) string {
+	filename := filepath.Join(dir, name)
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw, err := zstd.NewWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := zw.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestVerifyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "span-crossrefutil-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	content := "{\"DOI\": \"10.1/a\"}\n{\"DOI\": \"10.1/b\"}\n{\"DOI\": \"10.1/c\"}\n"
+	writeManifest := func(filename string, items, total int64) {
+		checksum, err := Checksum(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := &Manifest{
+			Filename:     filepath.Base(filename),
+			Mode:         "s",
+			ItemsWritten: items,
+			TotalResults: total,
+			SHA256:       checksum,
+		}
+		if err := m.WriteFile(ManifestPath(filename)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Complete file.
+	ok := writeZstd(t, dir, "ok.json.zst", content)
+	writeManifest(ok, 3, 3)
+	// File with fewer items than the API reported.
+	short := writeZstd(t, dir, "short.json.zst", content)
+	writeManifest(short, 3, 4)
+	// File, which lost data after the manifest has been written.
+	truncated := writeZstd(t, dir, "truncated.json.zst", content)
+	writeManifest(truncated, 3, 3)
+	b, err := ioutil.ReadFile(truncated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(truncated, b[:len(b)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	// File without manifest.
+	unlisted := writeZstd(t, dir, "unlisted.json.zst", content)
+
+	var cases = []struct {
+		filename  string
+		tolerance float64
+		ok        bool
+		problem   string
+	}{
+		{ok, 0, true, ""},
+		{short, 0, false, "incomplete"},
+		{short, 0.5, true, ""},
+		{truncated, 0, false, "read failed"},
+		{unlisted, 0, false, "manifest missing"},
+		{filepath.Join(dir, "missing.json.zst"), 0, false, "file missing"},
+	}
+	for _, c := range cases {
+		v := &Verifier{Tolerance: c.tolerance}
+		result, err := v.VerifyFile(c.filename)
+		if err != nil {
+			t.Fatalf("VerifyFile: got %v, want nil", err)
+		}
+		if result.OK() != c.ok {
+			t.Errorf("VerifyFile(%s): got %v (%v), want %v", c.filename, result.OK(), result.Problems, c.ok)
+		}
+		if c.problem != "" && !strings.HasPrefix(strings.Join(result.Problems, "; "), c.problem) {
+			t.Errorf("VerifyFile(%s): got %v, want %v", c.filename, result.Problems, c.problem)
+		}
+	}
+}
+
+func TestVerifyFileBackfill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "span-crossrefutil-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := writeZstd(t, dir, "old.json.zst", "{}\n{}\n")
+	v := &Verifier{Backfill: func(filename string) *Manifest {
+		return &Manifest{Mode: "s"}
+	}}
+	// Without WriteBackfill, the manifest is only returned.
+	result, err := v.VerifyFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.OK() || !result.Backfilled || result.Manifest == nil || result.Manifest.ItemsWritten != 2 {
+		t.Fatalf("got %v, backfilled %v, %v, want manifest missing and backfilled", result.Problems, result.Backfilled, result.Manifest)
+	}
+	if _, err := os.Stat(ManifestPath(filename)); !os.IsNotExist(err) {
+		t.Fatalf("got %v, want no manifest written", err)
+	}
+	v.WriteBackfill = true
+	result, err = v.VerifyFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.OK() || !result.Backfilled {
+		t.Fatalf("got %v, backfilled %v, want ok and backfilled", result.Problems, result.Backfilled)
+	}
+	m, err := ReadManifest(ManifestPath(filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ItemsWritten != 2 || m.Filename != "old.json.zst" {
+		t.Errorf("got %d items, %s, want 2, old.json.zst", m.ItemsWritten, m.Filename)
+	}
+	// A truncated file does not get a manifest.
+	truncated := writeZstd(t, dir, "truncated.json.zst", "{}\n{}")
+	if result, err = v.VerifyFile(truncated); err != nil {
+		t.Fatal(err)
+	}
+	if result.OK() || result.Backfilled {
+		t.Errorf("got ok %v, backfilled %v, want neither", result.OK(), result.Backfilled)
+	}
+}
+
+func TestCountLinesLongLastLine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "span-crossrefutil-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// An unterminated last line of exactly the reader buffer size.
+	filename := writeZstd(t, dir, "long.json.zst", "{}\n"+strings.Repeat("x", 1<<20))
+	n, err := CountLines(filename)
+	if err == nil {
+		t.Errorf("CountLines: got nil, want error for incomplete last line")
+	}
+	if n != 1 {
+		t.Errorf("CountLines: got %d, want 1", n)
+	}
+	filename = writeZstd(t, dir, "terminated.json.zst", strings.Repeat("x", 1<<20)+"\n")
+	if n, err := CountLines(filename); err != nil || n != 1 {
+		t.Errorf("CountLines: got %d, %v, want 1, nil", n, err)
+	}
+}
+
+func TestGaps(t *testing.T) {
+	var (
+		start = dateutil.MustParse("2022-01-01")
+		end   = dateutil.MustParse("2022-01-06")
+		ivs   = dateutil.Daily(start, end)
+		ms    []*Manifest
+	)
+	for i, iv := range ivs {
+		if i == 2 {
+			continue
+		}
+		ms = append(ms, &Manifest{Start: iv.Start, End: iv.End})
+	}
+	gaps := Gaps(ms)
+	if len(gaps) != 1 {
+		t.Fatalf("got %d gaps, want 1", len(gaps))
+	}
+	if !gaps[0].Start.Equal(ivs[2].Start) || !gaps[0].End.Equal(ivs[2].End) {
+		t.Errorf("got %v, want %v", gaps[0], ivs[2])
+	}
+	if gaps := Gaps(ms[:2]); len(gaps) != 0 {
+		t.Errorf("got %v, want no gaps", gaps)
+	}
+	// A window reported as missing is not reported as gap again.
+	if gaps := Gaps(ms, ivs[2]); len(gaps) != 0 {
+		t.Errorf("got %v, want no gaps", gaps)
+	}
+	if gaps := Gaps(ms, dateutil.Interval{Start: ivs[2].Start, End: ivs[2].Start.Add(time.Hour)}); len(gaps) != 1 {
+		t.Errorf("got %v, want one gap", gaps)
+	}
+	ms[0].End = ms[0].End.Add(-time.Hour)
+	if gaps := Gaps(ms[:2]); len(gaps) != 1 {
+		t.Errorf("got %v, want one gap", gaps)
+	}
+}
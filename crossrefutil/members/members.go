@@ -0,0 +1,381 @@
+// Package members implements a compact, offline catalogue of crossref
+// members with their DOI prefixes, primary and alternate names. A catalogue
+// can be built from a harvested members API dump, as written by
+// span-crossref-members.
+package members
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// Member is a crossref member.
+type Member struct {
+	ID          int64    `json:"id"`
+	PrimaryName string   `json:"primary"`
+	Names       []string `json:"names,omitempty"` // alternate names
+	Prefixes    []string `json:"prefixes,omitempty"`
+	// Count is the number of DOI registered by the member, a prefix listed
+	// for more than one member resolves to the member with the most DOI.
+	Count int64 `json:"count,omitempty"`
+}
+
+// Response is the part of a members API response, that we need to build a
+// catalogue, https://api.crossref.org/members.
+type Response struct {
+	Message struct {
+		Items []struct {
+			ID          int64    `json:"id"`
+			PrimaryName string   `json:"primary-name"`
+			Names       []string `json:"names"`
+			Prefix      []struct {
+				Name  string `json:"name"`
+				Value string `json:"value"`
+			} `json:"prefix"`
+			Prefixes []string `json:"prefixes"`
+			Counts   struct {
+				TotalDOIs int64 `json:"total-dois"`
+			} `json:"counts"`
+		} `json:"items"`
+	} `json:"message"`
+	Status string `json:"status"`
+}
+
+// Catalogue allows to lookup members by ID, DOI prefix or DOI.
+type Catalogue struct {
+	members  []Member
+	byID     map[int64]int
+	byPrefix map[string]int
+}
+
+// New creates a new catalogue from a list of members. Members with the same
+// ID are merged. A prefix listed for more than one member resolves to the
+// member with the highest DOI count, or the member added first.
+func New(members []Member) *Catalogue {
+	c := &Catalogue{
+		byID:     make(map[int64]int),
+		byPrefix: make(map[string]int),
+	}
+	for _, m := range members {
+		c.add(m)
+	}
+	return c
+}
+
+// add adds or merges a member.
+func (c *Catalogue) add(m Member) {
+	m.PrimaryName = strings.TrimSpace(m.PrimaryName)
+	i, ok := c.byID[m.ID]
+	if !ok {
+		i = len(c.members)
+		c.members = append(c.members, Member{ID: m.ID, PrimaryName: m.PrimaryName})
+		c.byID[m.ID] = i
+	}
+	v := &c.members[i]
+	if v.PrimaryName == "" {
+		v.PrimaryName = m.PrimaryName
+	}
+	for _, name := range m.Names {
+		if name = strings.TrimSpace(name); name != "" && name != v.PrimaryName && !contains(v.Names, name) {
+			v.Names = append(v.Names, name)
+		}
+	}
+	if m.Count > v.Count {
+		v.Count = m.Count
+	}
+	for _, p := range m.Prefixes {
+		if p = strings.TrimSpace(p); p != "" && !contains(v.Prefixes, p) {
+			v.Prefixes = append(v.Prefixes, p)
+		}
+	}
+	// The count may have changed, so all prefixes of the member are checked.
+	for _, p := range v.Prefixes {
+		if j, ok := c.byPrefix[p]; !ok || c.members[j].Count < v.Count {
+			c.byPrefix[p] = i
+		}
+	}
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns the number of members.
+func (c *Catalogue) Len() int {
+	return len(c.members)
+}
+
+// ByID returns the member with a given ID.
+func (c *Catalogue) ByID(id int64) (Member, bool) {
+	i, ok := c.byID[id]
+	if !ok {
+		return Member{}, false
+	}
+	return c.members[i], true
+}
+
+// ByPrefix returns the member owning a DOI prefix, like "10.1016".
+func (c *Catalogue) ByPrefix(prefix string) (Member, bool) {
+	i, ok := c.byPrefix[prefix]
+	if !ok {
+		return Member{}, false
+	}
+	return c.members[i], true
+}
+
+// ByDOI returns the member owning the prefix of a DOI.
+func (c *Catalogue) ByDOI(doi string) (Member, bool) {
+	return c.ByPrefix(Prefix(doi))
+}
+
+// Lookup finds a member by ID, DOI prefix or DOI.
+func (c *Catalogue) Lookup(s string) (Member, bool) {
+	s = strings.TrimSpace(s)
+	if id, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return c.ByID(id)
+	}
+	return c.ByDOI(s)
+}
+
+// Prefix returns the prefix of a DOI, e.g. "10.1016" for
+// "10.1016/j.x.2019.01.001". DOI may be given as URL. Returns the empty
+// string, if no prefix is found.
+func Prefix(doi string) string {
+	i := strings.Index(doi, "10.")
+	if i < 0 {
+		return ""
+	}
+	doi = doi[i:]
+	if j := strings.Index(doi, "/"); j > 0 {
+		return doi[:j]
+	}
+	return doi
+}
+
+// WriteTo writes the catalogue as newline delimited JSON, one member per
+// line, sorted by ID.
+func (c *Catalogue) WriteTo(w io.Writer) (int64, error) {
+	members := make([]Member, len(c.members))
+	copy(members, c.members)
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	var (
+		bw      = bufio.NewWriter(w)
+		written int64
+	)
+	for _, m := range members {
+		b, err := json.Marshal(m)
+		if err != nil {
+			return written, err
+		}
+		b = append(b, '\n')
+		n, err := bw.Write(b)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, bw.Flush()
+}
+
+// Read reads a catalogue, as written by WriteTo.
+func Read(r io.Reader) (*Catalogue, error) {
+	var (
+		c   = New(nil)
+		dec = json.NewDecoder(r)
+	)
+	for {
+		var m Member
+		err := dec.Decode(&m)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.add(m)
+	}
+	return c, nil
+}
+
+// ReadDump builds a catalogue from a harvested members API dump, i.e. a
+// sequence of API responses, as written by span-crossref-members.
+func ReadDump(r io.Reader) (*Catalogue, error) {
+	var (
+		c   = New(nil)
+		dec = json.NewDecoder(r)
+	)
+	for {
+		var resp Response
+		err := dec.Decode(&resp)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if resp.Status != "ok" {
+			return nil, fmt.Errorf("members response status: %s", resp.Status)
+		}
+		for _, item := range resp.Message.Items {
+			m := Member{
+				ID:          item.ID,
+				PrimaryName: item.PrimaryName,
+				Names:       item.Names,
+				Prefixes:    item.Prefixes,
+				Count:       item.Counts.TotalDOIs,
+			}
+			for _, p := range item.Prefix {
+				m.Prefixes = append(m.Prefixes, p.Value)
+				m.Names = append(m.Names, p.Name)
+			}
+			c.add(m)
+		}
+	}
+	return c, nil
+}
+
+// Names are the primary and alternate names of a member, as found in
+// assets/crossref/names.ndj.
+type Names struct {
+	Primary string   `json:"primary"`
+	Names   []string `json:"names"`
+}
+
+// ReadNames reads primary and alternate names, one JSON object per line.
+func ReadNames(r io.Reader) (result []Names, err error) {
+	dec := json.NewDecoder(r)
+	for {
+		var v Names
+		err := dec.Decode(&v)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, v)
+	}
+	return result, nil
+}
+
+// primaryName returns the primary name from the list of names, which
+// shares the most names with the given prefix names, or false, if no list
+// shares any name.
+func primaryName(names []Names, index map[string][]int, prefixNames []string) (string, bool) {
+	overlap := make(map[int]int)
+	for _, name := range prefixNames {
+		for _, i := range index[name] {
+			overlap[i]++
+		}
+	}
+	best := -1
+	for i, n := range overlap {
+		if best == -1 || n > overlap[best] || n == overlap[best] && i < best {
+			best = i
+		}
+	}
+	if best == -1 {
+		return "", false
+	}
+	return strings.TrimSpace(names[best].Primary), true
+}
+
+// frequentName returns the most frequent name, preferring shorter names.
+func frequentName(names []string, count map[string]int) (result string) {
+	for _, name := range names {
+		switch {
+		case result == "",
+			count[name] > count[result],
+			count[name] == count[result] && len(name) < len(result):
+			result = name
+		}
+	}
+	return result
+}
+
+// ReadTable builds a catalogue from a tab separated table with member ID,
+// prefix, number of DOI of the member and prefix name, as found in docs. The
+// table does not contain a primary name, so it is taken from the names list
+// sharing the most names with the prefix names of a member. Without names,
+// or if no list matches, the most frequent prefix name of a member is used,
+// preferring shorter names.
+func ReadTable(r io.Reader, names []Names) (*Catalogue, error) {
+	var (
+		br      = bufio.NewReader(r)
+		lineno  int
+		ids     []int64
+		entries = make(map[int64]*Member)
+		counts  = make(map[int64]map[string]int)
+		index   = make(map[string][]int) // name to names list
+	)
+	for i, v := range names {
+		for _, name := range append([]string{v.Primary}, v.Names...) {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if k := len(index[name]); k == 0 || index[name][k-1] != i {
+				index[name] = append(index[name], i)
+			}
+		}
+	}
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF && line == "" {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		lineno++
+		fields := strings.Split(strings.TrimRight(line, "\r\n"), "\t")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("invalid line %d: %q", lineno, line)
+		}
+		id, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid line %d: %q", lineno, line)
+		}
+		count, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid line %d: %q", lineno, line)
+		}
+		name := strings.TrimSpace(fields[3])
+		m, ok := entries[id]
+		if !ok {
+			m = &Member{ID: id}
+			entries[id], counts[id] = m, make(map[string]int)
+			ids = append(ids, id)
+		}
+		if count > m.Count {
+			m.Count = count
+		}
+		m.Prefixes = append(m.Prefixes, fields[1])
+		if counts[id][name] == 0 {
+			m.Names = append(m.Names, name)
+		}
+		counts[id][name]++
+	}
+	c := New(nil)
+	for _, id := range ids {
+		m := entries[id]
+		if primary, ok := primaryName(names, index, m.Names); ok {
+			m.PrimaryName = primary
+		} else {
+			m.PrimaryName = frequentName(m.Names, counts[id])
+		}
+		c.add(*m)
+	}
+	return c, nil
+}
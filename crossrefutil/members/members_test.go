@@ -0,0 +1,172 @@
+package members
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// dump contains two pages of a (shortened) members API response.
+var dump = `{"status":"ok","message-type":"member-list","message":{"items":[{"id":78,
+"primary-name":"Elsevier BV","names":["Elsevier BV"],"prefixes":["10.1016"],
+"prefix":[{"value":"10.1016","name":"Elsevier BV"},{"value":"10.1053","name":" Elsevier - WB Saunders "}]}]}}
+{"status":"ok","message-type":"member-list","message":{"items":[{"id":98,
+"primary-name":"Hindawi Limited","names":["Hindawi Limited"],"prefixes":["10.1155"],
+"prefix":[{"value":"10.1155","name":"Hindawi Limited"}]}]}}
+`
+
+func TestReadDump(t *testing.T) {
+	c, err := ReadDump(strings.NewReader(dump))
+	if err != nil {
+		t.Fatalf("ReadDump: got %v, want nil", err)
+	}
+	if c.Len() != 2 {
+		t.Fatalf("Len: got %d, want 2", c.Len())
+	}
+	want := Member{
+		ID:          78,
+		PrimaryName: "Elsevier BV",
+		Names:       []string{"Elsevier - WB Saunders"},
+		Prefixes:    []string{"10.1016", "10.1053"},
+	}
+	var cases = []struct {
+		s  string
+		ok bool
+	}{
+		{"78", true},
+		{"10.1016", true},
+		{"10.1053", true},
+		{"10.1053/j.ajkd.2019.01.001", true},
+		{"https://doi.org/10.1016/j.x.2019.01.001", true},
+		{"10.9999", false},
+		{"99", false},
+		{"", false},
+	}
+	for _, tc := range cases {
+		m, ok := c.Lookup(tc.s)
+		if ok != tc.ok {
+			t.Errorf("Lookup(%q): got %v, want %v", tc.s, ok, tc.ok)
+			continue
+		}
+		if ok && !reflect.DeepEqual(m, want) {
+			t.Errorf("Lookup(%q): got %v, want %v", tc.s, m, want)
+		}
+	}
+	// Roundtrip through the compact format.
+	var buf bytes.Buffer
+	if _, err := c.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: got %v, want nil", err)
+	}
+	d, err := Read(&buf)
+	if err != nil {
+		t.Fatalf("Read: got %v, want nil", err)
+	}
+	if !reflect.DeepEqual(c, d) {
+		t.Errorf("roundtrip: got %v, want %v", d, c)
+	}
+	if _, err := ReadDump(strings.NewReader(`{"status":"failed"}`)); err == nil {
+		t.Errorf("ReadDump: got nil, want error for failed response")
+	}
+}
+
+func TestReadTable(t *testing.T) {
+	table := strings.Join([]string{
+		"98\t10.1100\t10\tHindawi (The Scientific World)",
+		"98\t10.1155\t10\tHindawi Limited",
+		"98\t10.3814\t10\tHindawi Publishing Corporation (Syrexe)",
+		"1\t10.1370\t3481\tAnnals of Family Medicine",
+	}, "\n")
+	c, err := ReadTable(strings.NewReader(table), nil)
+	if err != nil {
+		t.Fatalf("ReadTable: got %v, want nil", err)
+	}
+	m, ok := c.ByPrefix("10.3814")
+	if !ok {
+		t.Fatalf("ByPrefix: not found")
+	}
+	if m.ID != 98 || m.PrimaryName != "Hindawi Limited" || len(m.Names) != 2 || len(m.Prefixes) != 3 {
+		t.Errorf("ReadTable: got %v", m)
+	}
+	for _, s := range []string{"98\t10.1100\n", "98\t10.1100\tmany\tHindawi\n"} {
+		if _, err := ReadTable(strings.NewReader(s), nil); err == nil {
+			t.Errorf("ReadTable(%q): got nil, want error for invalid line", s)
+		}
+	}
+}
+
+func TestReadTableMembers(t *testing.T) {
+	f, err := os.Open("../../docs/crossref-members-2023-09-07.tsv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	nf, err := os.Open("../../assets/crossref/names.ndj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nf.Close()
+	names, err := ReadNames(nf)
+	if err != nil {
+		t.Fatalf("ReadNames: got %v, want nil", err)
+	}
+	c, err := ReadTable(f, names)
+	if err != nil {
+		t.Fatalf("ReadTable: got %v, want nil", err)
+	}
+	var cases = []struct {
+		s       string
+		id      int64
+		primary string
+	}{
+		{"78", 78, "Elsevier BV"},
+		{"10.1016", 78, "Elsevier BV"}, // also listed for member 41918
+		{"301", 301, "Informa UK Limited"},
+		{"10.1080", 301, "Informa UK Limited"},
+		{"311", 311, "Wiley"},
+		{"98", 98, "Hindawi Limited"},
+	}
+	for _, tc := range cases {
+		m, ok := c.Lookup(tc.s)
+		if !ok {
+			t.Errorf("Lookup(%q): not found", tc.s)
+			continue
+		}
+		if m.ID != tc.id || m.PrimaryName != tc.primary {
+			t.Errorf("Lookup(%q): got %d %q, want %d %q", tc.s, m.ID, m.PrimaryName, tc.id, tc.primary)
+		}
+	}
+}
+
+func TestSharedPrefix(t *testing.T) {
+	c := New([]Member{
+		{ID: 78, PrimaryName: "Elsevier BV", Prefixes: []string{"10.1016"}, Count: 100},
+		{ID: 41918, PrimaryName: "Elsevier", Prefixes: []string{"10.1016"}, Count: 10},
+		{ID: 1, PrimaryName: "Small", Prefixes: []string{"10.1234"}, Count: 1},
+		// Merged later with a higher count, takes over the shared prefix.
+		{ID: 2, PrimaryName: "Grown", Prefixes: []string{"10.1234"}},
+		{ID: 2, Count: 5},
+	})
+	for prefix, want := range map[string]int64{"10.1016": 78, "10.1234": 2} {
+		if m, _ := c.ByPrefix(prefix); m.ID != want {
+			t.Errorf("ByPrefix(%q): got %d, want %d", prefix, m.ID, want)
+		}
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	var cases = []struct {
+		doi, want string
+	}{
+		{"10.1016/j.x", "10.1016"},
+		{"10.1016", "10.1016"},
+		{"doi:10.12345/abc/def", "10.12345"},
+		{"invalid", ""},
+	}
+	for _, c := range cases {
+		if got := Prefix(c.doi); got != c.want {
+			t.Errorf("Prefix(%q): got %q, want %q", c.doi, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package crossrefutil
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miku/span/formats/crossref"
+	"github.com/miku/span/xio"
+	"github.com/segmentio/encoding/json"
+)
+
+// Stub contains the few fields of a works API message needed to identify the
+// most recent version of a document.
+type Stub struct {
+	DOI     string
+	Indexed crossref.DateField `json:"indexed"`
+}
+
+// indexedLayout is used for indexed dates with a time, it sorts
+// lexicographically and after a plain date of the same day.
+const indexedLayout = "2006-01-02T15:04:05.000Z"
+
+// ParseStub extracts DOI and indexed date from a raw message. The indexed
+// date is taken from the timestamp or date-time, if present, and formatted
+// with millisecond precision in UTC, otherwise only the date parts
+// (YYYY-MM-DD) are used.
+func ParseStub(b []byte) (doi, date string, err error) {
+	var stub Stub
+	if err := json.Unmarshal(b, &stub); err != nil {
+		return "", "", err
+	}
+	switch {
+	case stub.Indexed.Timestamp > 0:
+		t := time.Unix(0, stub.Indexed.Timestamp*int64(time.Millisecond))
+		return stub.DOI, t.UTC().Format(indexedLayout), nil
+	case stub.Indexed.DateTime != "":
+		t, err := time.Parse(time.RFC3339, stub.Indexed.DateTime)
+		if err != nil {
+			return "", "", err
+		}
+		return stub.DOI, t.UTC().Format(indexedLayout), nil
+	}
+	t, err := stub.Indexed.Date()
+	if err != nil {
+		return "", "", err
+	}
+	return stub.DOI, t.Format("2006-01-02"), nil
+}
+
+// IndexEntry is a single line of a snapshot index, recording DOI, indexed date
+// and the byte offset of the record in the uncompressed snapshot.
+type IndexEntry struct {
+	DOI     string
+	Indexed string
+	Offset  int64
+}
+
+// String renders an entry as tab separated line, without trailing newline.
+func (e IndexEntry) String() string {
+	return fmt.Sprintf("%s\t%s\t%d", e.DOI, e.Indexed, e.Offset)
+}
+
+// ParseIndexEntry parses a single line from a snapshot index.
+func ParseIndexEntry(line string) (e IndexEntry, err error) {
+	fields := strings.Split(strings.TrimRight(line, "\n"), "\t")
+	if len(fields) != 3 {
+		return e, fmt.Errorf("invalid index line: %q", line)
+	}
+	offset, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return e, fmt.Errorf("invalid index offset: %q", line)
+	}
+	return IndexEntry{DOI: fields[0], Indexed: fields[1], Offset: offset}, nil
+}
+
+// WriteIndex reads a snapshot and writes an index line for each record.
+func WriteIndex(w io.Writer, r io.Reader) error {
+	var (
+		br     = bufio.NewReader(r)
+		bw     = bufio.NewWriter(w)
+		offset int64
+	)
+	for {
+		b, err := br.ReadBytes('\n')
+		if err == io.EOF && len(b) == 0 {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		doi, date, err := ParseStub(b)
+		if err != nil {
+			return fmt.Errorf("offset %d: %v", offset, err)
+		}
+		e := IndexEntry{DOI: doi, Indexed: date, Offset: offset}
+		if _, err := fmt.Fprintln(bw, e); err != nil {
+			return err
+		}
+		offset += int64(len(b))
+	}
+	return bw.Flush()
+}
+
+// candidate is the most recent version of a DOI found in the new files.
+type candidate struct {
+	doi     string
+	indexed string
+	lineno  int64
+	updated bool
+}
+
+// UpdateStats summarizes an incremental snapshot update.
+type UpdateStats struct {
+	Kept      int64 // records taken over from the existing snapshot
+	Updated   int64 // records replaced by a more recent version
+	New       int64 // records not found in existing snapshot
+	Stale     int64 // records in new files older than the snapshot version
+	Unchanged int64 // records in new files with the same indexed date as the snapshot version
+}
+
+// SnapshotUpdate updates an existing snapshot with messages from a number of
+// new files, without recomputing the snapshot from the full history. Only
+// DOIs found in the new files with a more recent indexed date than the
+// snapshot version are replaced, so applying the same files twice changes
+// nothing. An index with plain dates (YYYY-MM-DD), as written by earlier
+// versions, sorts before any time on the same day, so such records are
+// replaced once.
+type SnapshotUpdate struct {
+	Snapshot io.Reader           // existing snapshot, uncompressed
+	Index    io.Reader           // index of the existing snapshot
+	Files    []string            // new files, plain or compressed, oldest first
+	Excludes map[string]struct{} // DOI to ignore in new files
+
+	Output      io.Writer // updated snapshot
+	IndexOutput io.Writer // index of updated snapshot, may be nil
+	Delta       io.Writer // new and updated records only, may be nil
+	DeltaList   io.Writer // DOI and "new" or "updated", may be nil
+}
+
+// candidates scans the new files and returns the most recent version of each
+// DOI. Later lines win, if indexed dates are equal.
+func (u *SnapshotUpdate) candidates() (map[string]*candidate, error) {
+	var (
+		r      = &xio.ConcatReader{Filenames: u.Files}
+		br     = bufio.NewReader(r)
+		result = make(map[string]*candidate)
+		lineno int64
+	)
+	defer r.Close()
+	for {
+		b, err := br.ReadBytes('\n')
+		if err == io.EOF && len(b) == 0 {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		lineno++
+		doi, date, err := ParseStub(b)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineno, err)
+		}
+		if _, ok := u.Excludes[doi]; ok {
+			continue
+		}
+		if c, ok := result[doi]; ok && c.indexed > date {
+			continue
+		}
+		result[doi] = &candidate{doi: doi, indexed: date, lineno: lineno}
+	}
+	return result, nil
+}
+
+// Run performs the update.
+func (u *SnapshotUpdate) Run() (*UpdateStats, error) {
+	var (
+		stats   = &UpdateStats{}
+		bw      = bufio.NewWriter(u.Output)
+		iw      *bufio.Writer
+		offset  int64
+		written int64
+	)
+	if u.IndexOutput != nil {
+		iw = bufio.NewWriter(u.IndexOutput)
+	}
+	writeRecord := func(doi, date string, b []byte) error {
+		if _, err := bw.Write(b); err != nil {
+			return err
+		}
+		if iw != nil {
+			e := IndexEntry{DOI: doi, Indexed: date, Offset: written}
+			if _, err := fmt.Fprintln(iw, e); err != nil {
+				return err
+			}
+		}
+		written += int64(len(b))
+		return nil
+	}
+	cands, err := u.candidates()
+	if err != nil {
+		return nil, err
+	}
+	// Copy over records from the existing snapshot, except those with a more
+	// recent version. Index and snapshot are read in lockstep, so we do not
+	// need to parse any record.
+	var (
+		sr = bufio.NewReader(u.Snapshot)
+		ir = bufio.NewReader(u.Index)
+	)
+	for {
+		line, err := ir.ReadString('\n')
+		if err == io.EOF && len(line) == 0 {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		e, err := ParseIndexEntry(line)
+		if err != nil {
+			return nil, err
+		}
+		if e.Offset != offset {
+			return nil, fmt.Errorf("index does not match snapshot: %s at offset %d, want %d", e.DOI, e.Offset, offset)
+		}
+		b, err := sr.ReadBytes('\n')
+		if err != nil && (err != io.EOF || len(b) == 0) {
+			return nil, fmt.Errorf("snapshot shorter than index: %v", err)
+		}
+		if !bytes.HasSuffix(b, []byte("\n")) {
+			b = append(b, '\n')
+		}
+		offset += int64(len(b))
+		if c, ok := cands[e.DOI]; ok {
+			switch {
+			case c.indexed > e.Indexed:
+				c.updated = true
+				continue
+			case c.indexed == e.Indexed:
+				stats.Unchanged++
+			default:
+				stats.Stale++
+			}
+			delete(cands, e.DOI)
+		}
+		if err := writeRecord(e.DOI, e.Indexed, b); err != nil {
+			return nil, err
+		}
+		stats.Kept++
+	}
+	if _, err := sr.ReadByte(); err != io.EOF {
+		return nil, fmt.Errorf("snapshot longer than index")
+	}
+	// Append new and updated records, in the order of the new files.
+	selected := make([]*candidate, 0, len(cands))
+	for _, c := range cands {
+		selected = append(selected, c)
+	}
+	sort.Slice(selected, func(i, j int) bool {
+		return selected[i].lineno < selected[j].lineno
+	})
+	var (
+		r      = &xio.ConcatReader{Filenames: u.Files}
+		br     = bufio.NewReader(r)
+		lineno int64
+	)
+	defer r.Close()
+	for _, c := range selected {
+		var b []byte
+		for lineno < c.lineno {
+			if b, err = br.ReadBytes('\n'); err != nil && (err != io.EOF || len(b) == 0) {
+				return nil, fmt.Errorf("new files changed during update: %v", err)
+			}
+			lineno++
+		}
+		if !bytes.HasSuffix(b, []byte("\n")) {
+			b = append(b, '\n')
+		}
+		if err := writeRecord(c.doi, c.indexed, b); err != nil {
+			return nil, err
+		}
+		status := "new"
+		if c.updated {
+			status = "updated"
+			stats.Updated++
+		} else {
+			stats.New++
+		}
+		if u.Delta != nil {
+			if _, err := u.Delta.Write(b); err != nil {
+				return nil, err
+			}
+		}
+		if u.DeltaList != nil {
+			if _, err := fmt.Fprintf(u.DeltaList, "%s\t%s\n", c.doi, status); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if iw != nil {
+		if err := iw.Flush(); err != nil {
+			return nil, err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
@@ -0,0 +1,189 @@
+package crossrefutil
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseIndexEntry(t *testing.T) {
+	var cases = []struct {
+		line string
+		want IndexEntry
+		err  bool
+	}{
+		{"10.1/a\t2022-01-01\t0\n", IndexEntry{"10.1/a", "2022-01-01", 0}, false},
+		{"10.1/a\t2022-01-01\t123", IndexEntry{"10.1/a", "2022-01-01", 123}, false},
+		{"10.1/a\t2022-01-01", IndexEntry{}, true},
+		{"10.1/a\t2022-01-01\tx", IndexEntry{}, true},
+	}
+	for _, c := range cases {
+		e, err := ParseIndexEntry(c.line)
+		if (err != nil) != c.err {
+			t.Errorf("ParseIndexEntry(%q): got %v, want error %v", c.line, err, c.err)
+		}
+		if e != c.want {
+			t.Errorf("ParseIndexEntry(%q): got %v, want %v", c.line, e, c.want)
+		}
+	}
+}
+
+func TestParseStub(t *testing.T) {
+	var cases = []struct {
+		b    string
+		want string
+	}{
+		{`{"DOI":"10.1/a","indexed":{"date-parts":[[2022,1,2]]}}`, "2022-01-02"},
+		{`{"DOI":"10.1/a","indexed":{"date-parts":[[2022,1,2]],"date-time":"2022-01-02T10:11:12Z"}}`, "2022-01-02T10:11:12.000Z"},
+		{`{"DOI":"10.1/a","indexed":{"date-parts":[[2022,1,2]],"date-time":"2022-01-02T10:11:12Z","timestamp":1641118272345}}`, "2022-01-02T10:11:12.345Z"},
+	}
+	for _, c := range cases {
+		_, date, err := ParseStub([]byte(c.b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if date != c.want {
+			t.Errorf("ParseStub(%s): got %s, want %s", c.b, date, c.want)
+		}
+	}
+}
+
+func TestSnapshotUpdateSameDay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "span-crossrefutil-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	snapshot := `{"DOI":"10.1/a","indexed":{"date-parts":[[2022,1,2]],"timestamp":1641110000000},"v":1}` + "\n"
+	var index bytes.Buffer
+	if err := WriteIndex(&index, strings.NewReader(snapshot)); err != nil {
+		t.Fatal(err)
+	}
+	// A second update on the same day.
+	later := filepath.Join(dir, "later.json")
+	if err := ioutil.WriteFile(later, []byte(
+		`{"DOI":"10.1/a","indexed":{"date-parts":[[2022,1,2]],"timestamp":1641120000000},"v":2}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var deltaList bytes.Buffer
+	u := &SnapshotUpdate{
+		Snapshot:  strings.NewReader(snapshot),
+		Index:     &index,
+		Files:     []string{later},
+		Output:    ioutil.Discard,
+		DeltaList: &deltaList,
+	}
+	if _, err := u.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "10.1/a\tupdated\n"; deltaList.String() != want {
+		t.Errorf("delta list: got %q, want %q", deltaList.String(), want)
+	}
+}
+
+func TestSnapshotUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "span-crossrefutil-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	snapshot := strings.Join([]string{
+		`{"DOI":"10.1/a","indexed":{"date-parts":[[2022,1,1]]},"v":1}`,
+		`{"DOI":"10.1/b","indexed":{"date-parts":[[2022,1,1]]},"v":1}`,
+		`{"DOI":"10.1/c","indexed":{"date-parts":[[2022,1,5]]},"v":1}`,
+	}, "\n") + "\n"
+	var index bytes.Buffer
+	if err := WriteIndex(&index, strings.NewReader(snapshot)); err != nil {
+		t.Fatalf("WriteIndex: got %v, want nil", err)
+	}
+	wantIndex := "10.1/a\t2022-01-01\t0\n10.1/b\t2022-01-01\t61\n10.1/c\t2022-01-05\t122\n"
+	if index.String() != wantIndex {
+		t.Fatalf("WriteIndex: got %q, want %q", index.String(), wantIndex)
+	}
+	// Day one updates a, brings in d and an outdated c; day two updates a
+	// once more.
+	day1 := filepath.Join(dir, "day1.json")
+	if err := ioutil.WriteFile(day1, []byte(strings.Join([]string{
+		`{"DOI":"10.1/a","indexed":{"date-parts":[[2022,1,2]]},"v":2}`,
+		`{"DOI":"10.1/c","indexed":{"date-parts":[[2022,1,2]]},"v":0}`,
+		`{"DOI":"10.1/d","indexed":{"date-parts":[[2022,1,2]]},"v":1}`,
+	}, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	day2 := filepath.Join(dir, "day2.json")
+	if err := ioutil.WriteFile(day2, []byte(
+		`{"DOI":"10.1/a","indexed":{"date-parts":[[2022,1,3]]},"v":3}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var output, indexOutput, delta, deltaList bytes.Buffer
+	u := &SnapshotUpdate{
+		Snapshot:    strings.NewReader(snapshot),
+		Index:       &index,
+		Files:       []string{day1, day2},
+		Output:      &output,
+		IndexOutput: &indexOutput,
+		Delta:       &delta,
+		DeltaList:   &deltaList,
+	}
+	stats, err := u.Run()
+	if err != nil {
+		t.Fatalf("Run: got %v, want nil", err)
+	}
+	wantStats := UpdateStats{Kept: 2, Updated: 1, New: 1, Stale: 1}
+	if *stats != wantStats {
+		t.Errorf("stats: got %v, want %v", *stats, wantStats)
+	}
+	wantOutput := strings.Join([]string{
+		`{"DOI":"10.1/b","indexed":{"date-parts":[[2022,1,1]]},"v":1}`,
+		`{"DOI":"10.1/c","indexed":{"date-parts":[[2022,1,5]]},"v":1}`,
+		`{"DOI":"10.1/d","indexed":{"date-parts":[[2022,1,2]]},"v":1}`,
+		`{"DOI":"10.1/a","indexed":{"date-parts":[[2022,1,3]]},"v":3}`,
+	}, "\n") + "\n"
+	if output.String() != wantOutput {
+		t.Errorf("output: got %q, want %q", output.String(), wantOutput)
+	}
+	var rebuilt bytes.Buffer
+	if err := WriteIndex(&rebuilt, strings.NewReader(output.String())); err != nil {
+		t.Fatal(err)
+	}
+	if indexOutput.String() != rebuilt.String() {
+		t.Errorf("index: got %q, want %q", indexOutput.String(), rebuilt.String())
+	}
+	if want := "10.1/d\tnew\n10.1/a\tupdated\n"; deltaList.String() != want {
+		t.Errorf("delta list: got %q, want %q", deltaList.String(), want)
+	}
+	if n := strings.Count(delta.String(), "\n"); n != 2 {
+		t.Errorf("delta: got %d records, want 2", n)
+	}
+	// Applying the same files again changes nothing.
+	var again, againList bytes.Buffer
+	u = &SnapshotUpdate{
+		Snapshot:  strings.NewReader(output.String()),
+		Index:     strings.NewReader(indexOutput.String()),
+		Files:     []string{day1, day2},
+		Output:    &again,
+		DeltaList: &againList,
+	}
+	if stats, err = u.Run(); err != nil {
+		t.Fatalf("Run: got %v, want nil", err)
+	}
+	if againList.Len() != 0 || again.String() != output.String() {
+		t.Errorf("reapply: got delta list %q, want empty and output unchanged", againList.String())
+	}
+	if want := (UpdateStats{Kept: 4, Unchanged: 2, Stale: 1}); *stats != want {
+		t.Errorf("reapply stats: got %v, want %v", *stats, want)
+	}
+	// An index not matching the snapshot must be rejected.
+	u = &SnapshotUpdate{
+		Snapshot: strings.NewReader(snapshot),
+		Index:    strings.NewReader("10.1/a\t2022-01-01\t0\n10.1/b\t2022-01-01\t10\n"),
+		Files:    []string{day1},
+		Output:   ioutil.Discard,
+	}
+	if _, err := u.Run(); err == nil {
+		t.Errorf("Run with mismatched index: got nil, want error")
+	}
+}
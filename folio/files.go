@@ -0,0 +1,167 @@
+package folio
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/adrg/xdg"
+	"github.com/segmentio/encoding/json"
+
+	"github.com/miku/span/atomic"
+)
+
+// DefaultCacheDir for downloaded finc-config files.
+var DefaultCacheDir = filepath.Join(xdg.CacheHome, "span", "folio")
+
+// FileLink returns the download link for a file, e.g. a filter file.
+func (api *API) FileLink(fileID string) string {
+	return fmt.Sprintf("%s/finc-config/files/%s",
+		strings.TrimSuffix(api.Base, "/"), url.PathEscape(fileID))
+}
+
+// IsFileLink reports whether a link looks like a finc-config file link of any
+// FOLIO instance. These links require authentication.
+func IsFileLink(link string) bool {
+	return strings.Contains(link, "/finc-config/files/")
+}
+
+// FileID returns the file id from a link returned by FileLink and whether the
+// link points to a file of this API at all. Scheme, host case, duplicate and
+// trailing slashes do not matter, so links written with a slightly different
+// base URL still use the authenticated download.
+func (api *API) FileID(link string) (string, bool) {
+	base, err := url.Parse(api.Base)
+	if err != nil {
+		return "", false
+	}
+	u, err := url.Parse(link)
+	if err != nil || !sameHost(u, base) {
+		return "", false
+	}
+	var (
+		prefix = path.Join("/", base.EscapedPath(), "finc-config/files") + "/"
+		p      = path.Clean("/" + u.EscapedPath())
+	)
+	if !strings.HasPrefix(p, prefix) || strings.Contains(strings.TrimPrefix(p, prefix), "/") {
+		return "", false
+	}
+	id, err := url.PathUnescape(strings.TrimPrefix(p, prefix))
+	if err != nil || id == "" {
+		return "", false
+	}
+	return id, true
+}
+
+// sameHost reports whether two URLs point to the same host, ports are only
+// compared if both are given.
+func sameHost(u, v *url.URL) bool {
+	if !strings.EqualFold(u.Hostname(), v.Hostname()) {
+		return false
+	}
+	return u.Port() == "" || v.Port() == "" || u.Port() == v.Port()
+}
+
+// Files lists the filter files referenced from all filters, ordered by file
+// id. There is no listing of all files in finc-config, as files are only
+// referenced from filters.
+func (api *API) Files(pageSize int) ([]FilterFile, error) {
+	filters, err := api.Filters("", pageSize)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var result []FilterFile
+	for _, f := range filters {
+		for _, ff := range f.FilterFiles {
+			if ff.FileId == "" || seen[ff.FileId] {
+				continue
+			}
+			seen[ff.FileId] = true
+			result = append(result, ff)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].FileId < result[j].FileId })
+	return result, nil
+}
+
+// cacheEntry records the validators of a cached file.
+type cacheEntry struct {
+	Link         string `json:"link"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// cacheDir returns the directory for downloaded files.
+func (api *API) cacheDir() string {
+	if api.CacheDir != "" {
+		return api.CacheDir
+	}
+	return DefaultCacheDir
+}
+
+// DownloadFile downloads a finc-config file into the cache directory and
+// returns the local filename. A cached file is revalidated with its ETag or
+// Last-Modified date and only downloaded again, if it changed.
+func (api *API) DownloadFile(fileID string) (string, error) {
+	dir := api.cacheDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	var (
+		filename = filepath.Join(dir, url.PathEscape(fileID))
+		metafile = filename + ".json"
+		entry    cacheEntry
+		header   = make(http.Header)
+	)
+	if _, err := os.Stat(filename); err == nil {
+		if b, err := os.ReadFile(metafile); err == nil {
+			if err := json.Unmarshal(b, &entry); err != nil {
+				return "", fmt.Errorf("%s: %v", metafile, err)
+			}
+		}
+		if entry.ETag != "" {
+			header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+	header.Set("Accept", "application/octet-stream")
+	path := fmt.Sprintf("/finc-config/files/%s", url.PathEscape(fileID))
+	err := api.do(path, nil, header, func(resp *http.Response) error {
+		if resp.StatusCode == http.StatusNotModified {
+			return nil
+		}
+		f, err := atomic.New(filename, 0644)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, resp.Body); err != nil {
+			f.Abort()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		b, err := json.Marshal(cacheEntry{
+			Link:         api.FileLink(fileID),
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+		})
+		if err != nil {
+			return err
+		}
+		return atomic.WriteFile(metafile, b, 0644)
+	})
+	if err != nil {
+		return "", err
+	}
+	return filename, nil
+}
@@ -6,11 +6,13 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/segmentio/encoding/json"
@@ -19,6 +21,9 @@ import (
 
 var ErrEmptyToken = errors.New("empty token")
 
+// DefaultPageSize is used for list endpoints, if no page size is given.
+const DefaultPageSize = 1000
+
 // Doer is implemented by HTTP clients, typically.
 type Doer interface {
 	Do(*http.Request) (*http.Response, error)
@@ -32,6 +37,12 @@ type API struct {
 	Tenant string // e.g. "de_15"
 	Client Doer
 	Token  string
+	// CacheDir for downloaded files, DefaultCacheDir if empty.
+	CacheDir string
+
+	// Credentials from Authenticate, used to renew an expired token.
+	username string
+	password string
 }
 
 func New() *API {
@@ -93,17 +104,105 @@ func (api *API) Authenticate(username, password string) (err error) {
 		return ErrEmptyToken
 	}
 	api.Token = token
+	api.username, api.password = username, password
 	return nil
 }
 
+// get requests a path with query parameters and passes the response body to
+// f. An expired or invalid token is renewed once, if the API has been
+// authenticated before.
+func (api *API) get(path string, v url.Values, f func(r io.Reader) error) error {
+	return api.do(path, v, nil, func(resp *http.Response) error {
+		return f(resp.Body)
+	})
+}
+
+// do requests a path with query parameters and additional headers and passes
+// the response to f, unless the response indicates an error.
+func (api *API) do(path string, v url.Values, header http.Header, f func(resp *http.Response) error) error {
+	api.ensureClient()
+	link := fmt.Sprintf("%s%s", api.Base, path)
+	if len(v) > 0 {
+		link = fmt.Sprintf("%s?%s", link, v.Encode())
+	}
+	for retried := false; ; retried = true {
+		req, err := http.NewRequest("GET", link, nil)
+		if err != nil {
+			return err
+		}
+		for k, vs := range header {
+			req.Header[k] = vs
+		}
+		if req.Header.Get("Accept") == "" {
+			req.Header.Set("Accept", "application/json")
+		}
+		req.Header.Set("X-Okapi-Tenant", api.Tenant)
+		req.Header.Set("X-Okapi-Token", api.Token)
+		resp, err := api.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && !retried && api.username != "" {
+			resp.Body.Close()
+			log.Printf("token rejected, renewing")
+			if err := api.Authenticate(api.username, api.password); err != nil {
+				return err
+			}
+			continue
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			b, _ := httputil.DumpResponse(resp, true)
+			log.Printf("[ee] --------\n%s\n", string(b))
+			log.Println("[ee] --------")
+			return fmt.Errorf("[ee] api returned: %v", resp.Status)
+		}
+		return f(resp)
+	}
+}
+
+// list fetches all pages of a list endpoint. The page function decodes a
+// single response and returns the number of items and the total number of
+// records.
+func (api *API) list(path, cql string, pageSize int, page func(r io.Reader) (int, int64, error)) error {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	for offset := 0; ; {
+		v := url.Values{}
+		if cql != "" {
+			v.Set("query", cql)
+		}
+		v.Set("limit", strconv.Itoa(pageSize))
+		v.Set("offset", strconv.Itoa(offset))
+		var (
+			n     int
+			total int64
+		)
+		err := api.get(path, v, func(r io.Reader) (err error) {
+			n, total, err = page(r)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		offset += n
+		if n == 0 || int64(offset) >= total {
+			return nil
+		}
+	}
+}
+
 // MetadataCollectionsOpts collections options for the metadata collections
 // API. Not complete.
 type MetadataCollectionsOpts struct {
-	CQL   string
-	Limit int
+	CQL    string
+	Limit  int
+	Offset int
 }
 
-// MetadataCollections queries for collection and attachment information.
+// MetadataCollections queries for collection and attachment information, a
+// single page only, see AllMetadataCollections.
 func (api *API) MetadataCollections(opts MetadataCollectionsOpts) (*MetadataCollectionsResponse, error) {
 	var (
 		v        = url.Values{}
@@ -111,29 +210,69 @@ func (api *API) MetadataCollections(opts MetadataCollectionsOpts) (*MetadataColl
 	)
 	v.Add("query", opts.CQL)                      // (selectedBy=("DIKU-01" or "DE-15")
 	v.Add("limit", fmt.Sprintf("%d", opts.Limit)) // https://s3.amazonaws.com/foliodocs/api/mod-finc-config/p/fincConfigMetadataCollections.html#finc_config_metadata_collections_get
-	link := fmt.Sprintf("%s/finc-config/metadata-collections?%s", api.Base, v.Encode())
-	req, err := http.NewRequest("GET", link, nil)
-	if err != nil {
-		return nil, err
+	if opts.Offset > 0 {
+		v.Add("offset", fmt.Sprintf("%d", opts.Offset))
 	}
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("X-Okapi-Tenant", api.Tenant)
-	req.Header.Set("X-Okapi-Token", api.Token)
-	resp, err := api.Client.Do(req)
+	err := api.get("/finc-config/metadata-collections", v, func(r io.Reader) error {
+		return json.NewDecoder(r).Decode(&response)
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 400 {
-		b, _ := httputil.DumpResponse(resp, true)
-		log.Printf("[ee] --------\n%s\n", string(b))
-		log.Println("[ee] --------")
-		return nil, fmt.Errorf("[ee] api returned: %v", resp.Status)
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+	return &response, nil
+}
+
+// AllMetadataCollections fetches all collections matching a query, page by
+// page.
+func (api *API) AllMetadataCollections(cql string, pageSize int) (result []FincConfigMetadataCollection, err error) {
+	err = api.list("/finc-config/metadata-collections", cql, pageSize, func(r io.Reader) (int, int64, error) {
+		var resp MetadataCollectionsResponse
+		if err := json.NewDecoder(r).Decode(&resp); err != nil {
+			return 0, 0, err
+		}
+		result = append(result, resp.FincConfigMetadataCollections...)
+		return len(resp.FincConfigMetadataCollections), resp.TotalRecords, nil
+	})
+	return result, err
+}
+
+// MetadataSources fetches all metadata sources matching a query.
+func (api *API) MetadataSources(cql string, pageSize int) (result []FincConfigMetadataSource, err error) {
+	err = api.list("/finc-config/metadata-sources", cql, pageSize, func(r io.Reader) (int, int64, error) {
+		var resp MetadataSourcesResponse
+		if err := json.NewDecoder(r).Decode(&resp); err != nil {
+			return 0, 0, err
+		}
+		result = append(result, resp.FincConfigMetadataSources...)
+		return len(resp.FincConfigMetadataSources), resp.TotalRecords, nil
+	})
+	return result, err
+}
+
+// Filters fetches all filters matching a query, across ISIL.
+func (api *API) Filters(cql string, pageSize int) (result []FincSelectFilter, err error) {
+	err = api.list("/finc-config/filters", cql, pageSize, func(r io.Reader) (int, int64, error) {
+		var resp FiltersResponse
+		if err := json.NewDecoder(r).Decode(&resp); err != nil {
+			return 0, 0, err
+		}
+		result = append(result, resp.FincSelectFilters...)
+		return len(resp.FincSelectFilters), resp.TotalRecords, nil
+	})
+	return result, err
+}
+
+// FilterCollections returns the ids of the collections a filter applies to.
+func (api *API) FilterCollections(filterID string) ([]string, error) {
+	var resp FilterToCollections
+	path := fmt.Sprintf("/finc-config/filters/%s/collections", url.PathEscape(filterID))
+	err := api.get(path, nil, func(r io.Reader) error {
+		return json.NewDecoder(r).Decode(&resp)
+	})
+	if err != nil {
 		return nil, err
 	}
-	return &response, nil
+	return resp.CollectionIds, nil
 }
 
 // MetadataCollectionsResponse collects zero, one or more collection entries obtained from the API.
@@ -171,6 +310,7 @@ type FincConfigMetadataCollection struct {
 	UsageRestricted     string        `json:"usageRestricted"`
 }
 
+// ContentFiles returns the content file links of a collection.
 func (c *FincConfigMetadataCollection) ContentFiles() (result []string) {
 	for _, v := range c.ContentFilesValue {
 		switch w := v.(type) {
@@ -181,6 +321,52 @@ func (c *FincConfigMetadataCollection) ContentFiles() (result []string) {
 	return
 }
 
+// MetadataSourcesResponse collects metadata sources obtained from the API.
+type MetadataSourcesResponse struct {
+	FincConfigMetadataSources []FincConfigMetadataSource `json:"fincConfigMetadataSources"`
+	TotalRecords              int64                      `json:"totalRecords"`
+}
+
+// FincConfigMetadataSource is a data source, e.g. "Crossref" with source id 49.
+type FincConfigMetadataSource struct {
+	Id        string `json:"id"`
+	Label     string `json:"label"`
+	SourceId  int    `json:"sourceId"`
+	SolrShard string `json:"solrShard"` // e.g. "UBL main"
+	Status    string `json:"status"`    // e.g. "active", "implementation"
+}
+
+// FiltersResponse collects filters obtained from the API.
+type FiltersResponse struct {
+	FincSelectFilters []FincSelectFilter `json:"fincSelectFilters"`
+	TotalRecords      int64              `json:"totalRecords"`
+}
+
+// FincSelectFilter is a whitelist or blacklist of an ISIL, e.g. a KBART file.
+type FincSelectFilter struct {
+	Id          string       `json:"id"`
+	Label       string       `json:"label"`
+	Type        string       `json:"type"` // "Whitelist" or "Blacklist"
+	Isil        string       `json:"isil"`
+	FilterFiles []FilterFile `json:"filterFiles"`
+}
+
+// FilterFile is a file attached to a filter.
+type FilterFile struct {
+	Id       string `json:"id"`
+	Label    string `json:"label"`
+	Criteria string `json:"criteria"`
+	FileId   string `json:"fileId"`
+	Filename string `json:"filename"`
+}
+
+// FilterToCollections lists the collections a filter applies to.
+type FilterToCollections struct {
+	Id               string   `json:"id"`
+	CollectionIds    []string `json:"collectionIds"`
+	CollectionsCount int      `json:"collectionsCount"`
+}
+
 // LoginResponse for bl-users/login, used to obtain auth tokens.
 type LoginResponse struct {
 	PatronGroup struct {
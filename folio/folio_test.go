@@ -1,9 +1,13 @@
 package folio
 
 import (
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/http/httputil"
+	"os"
+	"strconv"
 	"testing"
 )
 
@@ -39,3 +43,153 @@ func TestAuthenticate(t *testing.T) {
 		t.Fatalf("got %v, want %v", api.Token, token)
 	}
 }
+
+func TestAllMetadataCollections(t *testing.T) {
+	var (
+		logins   int
+		requests int
+	)
+	// A FOLIO stand-in with five collections, which expires the first token
+	// after two requests.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bl-users/login":
+			logins++
+			w.Header().Add("X-OKAPI-TOKEN", fmt.Sprintf("token-%d", logins))
+			w.WriteHeader(http.StatusCreated)
+		case "/finc-config/metadata-collections":
+			requests++
+			if r.Header.Get("X-Okapi-Token") == "token-1" && requests > 2 {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+			limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+			var items []string
+			for i := offset; i < offset+limit && i < 5; i++ {
+				items = append(items, fmt.Sprintf(`{"id": "c-%d"}`, i))
+			}
+			fmt.Fprintf(w, `{"fincConfigMetadataCollections": [`)
+			for i, v := range items {
+				if i > 0 {
+					fmt.Fprintf(w, ",")
+				}
+				fmt.Fprint(w, v)
+			}
+			fmt.Fprintf(w, `], "totalRecords": 5}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	api := &API{Base: ts.URL}
+	if err := api.Authenticate("admin", "admin"); err != nil {
+		t.Fatalf("failed with: %v", err)
+	}
+	result, err := api.AllMetadataCollections("", 2)
+	if err != nil {
+		t.Fatalf("failed with: %v", err)
+	}
+	if len(result) != 5 {
+		t.Fatalf("got %d collections, want 5", len(result))
+	}
+	for i, c := range result {
+		if want := fmt.Sprintf("c-%d", i); c.Id != want {
+			t.Errorf("got %v, want %v", c.Id, want)
+		}
+	}
+	if logins != 2 {
+		t.Errorf("got %d logins, want 2", logins)
+	}
+	if api.Token != "token-2" {
+		t.Errorf("got %v, want token-2", api.Token)
+	}
+	// Without credentials, an expired token is an error.
+	api = &API{Base: ts.URL, Token: "token-1"}
+	if _, err := api.AllMetadataCollections("", 2); err == nil {
+		t.Errorf("got nil, want error for expired token")
+	}
+}
+
+func TestDownloadFile(t *testing.T) {
+	var (
+		etag      = `"v1"`
+		content   = "ISSN\n1234-5678\n"
+		downloads int
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/finc-config/files/f-1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads++
+		w.Header().Set("ETag", etag)
+		fmt.Fprint(w, content)
+	}))
+	defer ts.Close()
+	dir, err := ioutil.TempDir("", "span-folio-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	api := &API{Base: ts.URL, CacheDir: dir}
+	id, ok := api.FileID(api.FileLink("f-1"))
+	if !ok || id != "f-1" {
+		t.Fatalf("FileID: got %v %v, want f-1", id, ok)
+	}
+	for _, c := range []struct {
+		base string
+		link string
+		want string
+		ok   bool
+	}{
+		{"https://folio.example.com", "https://folio.example.com/finc-config/files/f-1", "f-1", true},
+		{"https://folio.example.com/", "https://folio.example.com/finc-config/files/f-1", "f-1", true},
+		{"https://folio.example.com", "https://folio.example.com//finc-config/files/f-1", "f-1", true},
+		{"https://folio.example.com", "http://FOLIO.example.com/finc-config/files/f-1", "f-1", true},
+		{"http://folio.example.com/okapi/", "https://folio.example.com/okapi/finc-config/files/f%2F1", "f/1", true},
+		{"https://folio.example.com", "https://folio.example.com:8443/finc-config/files/f-1", "f-1", true},
+		{"https://folio.example.com:9130", "https://folio.example.com:8443/finc-config/files/f-1", "", false},
+		{"https://folio.example.com", "https://example.com/finc-config/files/f-1", "", false},
+		{"https://folio.example.com", "https://folio.example.com/finc-config/files/", "", false},
+		{"https://folio.example.com", "https://folio.example.com/finc-config/files/a/b", "", false},
+		{"https://folio.example.com/okapi", "https://folio.example.com/finc-config/files/f-1", "", false},
+	} {
+		got, ok := (&API{Base: c.base}).FileID(c.link)
+		if got != c.want || ok != c.ok {
+			t.Errorf("FileID %s %s: got %q %v, want %q %v", c.base, c.link, got, ok, c.want, c.ok)
+		}
+	}
+	for i, c := range []struct {
+		etag      string
+		content   string
+		downloads int
+	}{
+		{`"v1"`, "ISSN\n1234-5678\n", 1},
+		{`"v1"`, "ISSN\n1234-5678\n", 1}, // not modified
+		{`"v2"`, "ISSN\n2345-6789\n", 2},
+	} {
+		etag, content = c.etag, c.content
+		filename, err := api.DownloadFile(id)
+		if err != nil {
+			t.Fatalf("[%d] DownloadFile: %v", i, err)
+		}
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != c.content {
+			t.Errorf("[%d] got %q, want %q", i, string(b), c.content)
+		}
+		if downloads != c.downloads {
+			t.Errorf("[%d] got %d downloads, want %d", i, downloads, c.downloads)
+		}
+	}
+	if _, err := api.DownloadFile("missing"); err == nil {
+		t.Errorf("DownloadFile: got nil, want error for missing file")
+	}
+}
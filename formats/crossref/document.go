@@ -273,8 +273,27 @@ func (doc *Document) FindLanguages() []string {
 	return []string{"und"}
 }
 
-// ToIntermediateSchema converts a crossref document into IS. XXX: Use a
-// canonical publisher, based on doi prefix, /cc @ad.
+// CanonicalPublisher returns the primary name of the crossref member, found
+// by member ID or DOI prefix in the member catalogue. Falls back to the
+// publisher given in the document.
+func (doc *Document) CanonicalPublisher() (string, error) {
+	catalogue, err := Members()
+	if err != nil {
+		return "", err
+	}
+	if id, err := strconv.ParseInt(doc.Member, 10, 64); err == nil {
+		if m, ok := catalogue.ByID(id); ok && m.PrimaryName != "" {
+			return m.PrimaryName, nil
+		}
+	}
+	if m, ok := catalogue.ByDOI(doc.DOI); ok && m.PrimaryName != "" {
+		return m.PrimaryName, nil
+	}
+	return strutil.UnescapeTrim(doc.Publisher), nil
+}
+
+// ToIntermediateSchema converts a crossref document into IS. Publisher names
+// are normalized with the member catalogue.
 func (doc *Document) ToIntermediateSchema() (*finc.IntermediateSchema, error) {
 	var err error
 	output := finc.NewIntermediateSchema()
@@ -334,7 +353,11 @@ func (doc *Document) ToIntermediateSchema() (*finc.IntermediateSchema, error) {
 	output.ISSN = doc.ISSN
 	output.Issue = strings.TrimLeft(doc.Issue, "0")
 	output.Languages = doc.FindLanguages()
-	output.Publishers = append(output.Publishers, doc.Publisher)
+	publisher, err := doc.CanonicalPublisher()
+	if err != nil {
+		return output, err
+	}
+	output.Publishers = append(output.Publishers, publisher)
 	output.RefType = RefTypes.Lookup(doc.Type, "GEN")
 	output.SourceID = SourceID
 	output.Subjects = doc.Subject
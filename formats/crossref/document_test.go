@@ -43,3 +43,40 @@ func TestDocumentCombinedTitle(t *testing.T) {
 		}
 	}
 }
+
+func TestDocumentCanonicalPublisher(t *testing.T) {
+	var cases = []struct {
+		doc    *Document
+		result string
+	}{
+		{
+			doc:    &Document{Member: "98", DOI: "10.1155/2014/123", Publisher: "Hindawi Publishing Corporation"},
+			result: "Hindawi Limited",
+		},
+		{
+			doc:    &Document{DOI: "10.1155/2014/123", Publisher: "Hindawi Publishing Corporation"},
+			result: "Hindawi Limited",
+		},
+		{
+			doc:    &Document{DOI: "10.1016/j.jid.2019.01.001", Publisher: "Elsevier"},
+			result: "Elsevier BV",
+		},
+		{
+			doc:    &Document{Member: "301", DOI: "10.1080/123", Publisher: "Informa UK Limited"},
+			result: "Informa UK Limited",
+		},
+		{
+			doc:    &Document{Member: "x", DOI: "10.0000/unknown", Publisher: " Some Publisher "},
+			result: "Some Publisher",
+		},
+	}
+	for _, c := range cases {
+		result, err := c.doc.CanonicalPublisher()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != c.result {
+			t.Errorf("CanonicalPublisher: got %v, want %v", result, c.result)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package crossref
+
+import (
+	"compress/gzip"
+	"fmt"
+	"sync"
+
+	"github.com/miku/span"
+	"github.com/miku/span/crossrefutil/members"
+)
+
+// MembersAsset is the builtin, gzip compressed member catalogue, regenerate
+// with make catalogue.
+const MembersAsset = "assets/crossref/catalogue.ndj.gz"
+
+var (
+	membersOnce      sync.Once
+	membersCatalogue *members.Catalogue
+	membersErr       error
+)
+
+// loadMembers reads the builtin member catalogue.
+func loadMembers() (*members.Catalogue, error) {
+	f, err := span.Static.Open(MembersAsset)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", MembersAsset, err)
+	}
+	defer zr.Close()
+	c, err := members.Read(zr)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", MembersAsset, err)
+	}
+	return c, nil
+}
+
+// Members returns the builtin crossref member catalogue, loaded on first
+// use.
+func Members() (*members.Catalogue, error) {
+	membersOnce.Do(func() {
+		membersCatalogue, membersErr = loadMembers()
+	})
+	return membersCatalogue, membersErr
+}
@@ -27,6 +27,8 @@ import (
 	"regexp"
 	"strings"
 	"time"
+
+	"github.com/segmentio/encoding/json"
 )
 
 const (
@@ -161,9 +163,25 @@ type IntermediateSchema struct {
 	// OpenAccess, refs. #8986, prototype
 	OpenAccess bool     `json:"x.oa,omitempty"`
 	License    []string `json:"x.license,omitempty"`
+	// OpenAccessReason names the evidence for OpenAccess, e.g. kbart or unpaywall
+	OpenAccessReason string `json:"x.oa_reason,omitempty"`
+	// OpenAccessLicense is the license type of an open access record, if known, e.g. CC-BY
+	OpenAccessLicense string `json:"x.oa_license,omitempty"`
 
 	// Footnote, via solr schema, refs #13653
 	Footnotes []string `json:"x.footnotes,omitempty"`
+
+	// Fixes lists the automatic repairs applied to this record, e.g. by span-check -fix
+	Fixes []FixNote `json:"x.fixes,omitempty"`
+}
+
+// FixNote records an automatic repair of a single field, with the JSON
+// values before and after the fix.
+type FixNote struct {
+	Fix   string          `json:"fix"`
+	Field string          `json:"field"`
+	Old   json.RawMessage `json:"old"`
+	New   json.RawMessage `json:"new"`
 }
 
 // NewIntermediateSchema creates a new intermediate schema document with the
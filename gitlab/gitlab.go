@@ -12,7 +12,7 @@ type PushPayload struct {
 	Before      string `json:"before"`
 	CheckoutSha string `json:"checkout_sha"`
 	Commits     []struct {
-		Added  []interface{} `json:"added"`
+		Added  []string `json:"added"`
 		Author struct {
 			Email string `json:"email"`
 			Name  string `json:"name"`
@@ -74,6 +74,14 @@ func (p PushPayload) ModifiedFiles() (filenames []string) {
 	return
 }
 
+// AddedFiles returns all added files across all commits in this payload.
+func (p PushPayload) AddedFiles() (filenames []string) {
+	for _, commit := range p.Commits {
+		filenames = append(filenames, commit.Added...)
+	}
+	return
+}
+
 // IsFileModified returns true, if given file has been modified.
 func (p PushPayload) IsFileModified(filename string) bool {
 	for _, modified := range p.ModifiedFiles() {
@@ -60,3 +60,73 @@ func (r Repo) Update() error {
 	// TODO: exit code handling, https://stackoverflow.com/a/10385867.
 	return exec.Command(cmd, args...).Run()
 }
+
+// git runs a git command, the error contains the output of the command. The
+// token is blacked out in logs and errors.
+func (r Repo) git(args ...string) error {
+	var (
+		redact = func(s string) string {
+			if r.Token == "" {
+				return s
+			}
+			return strings.Replace(s, r.Token, "xxx", -1)
+		}
+		cmdline = redact(strings.Join(args, " "))
+	)
+	log.Printf("[cmd] git %s", cmdline)
+	b, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git %s: %v: %s", cmdline, err, redact(strings.TrimSpace(string(b))))
+	}
+	return nil
+}
+
+// fetch clones the repository, if necessary, and fetches a ref.
+func (r Repo) fetch(ref string) error {
+	if _, err := os.Stat(r.Dir); os.IsNotExist(err) {
+		if err := os.MkdirAll(path.Dir(r.Dir), 0755); err != nil {
+			return err
+		}
+		if err := r.git("clone", "--no-checkout", r.AuthURL(), r.Dir); err != nil {
+			return err
+		}
+	}
+	return r.git("-C", r.Dir, "fetch", "--force", "origin", ref)
+}
+
+// Checkout clones the repository, if necessary, fetches a ref, e.g.
+// refs/heads/main or refs/tags/v1, and checks out a commit of that ref, or
+// the fetched ref itself, if commit is empty. The working tree is left in
+// detached HEAD state and can be switched to any other ref later.
+func (r Repo) Checkout(ref, commit string) error {
+	log.Printf("checking out %s (%s) of %s", ref, commit, r)
+	if err := r.fetch(ref); err != nil {
+		return err
+	}
+	if commit == "" {
+		commit = "FETCH_HEAD"
+	}
+	return r.git("-C", r.Dir, "checkout", "--force", "--detach", commit)
+}
+
+// Worktree fetches a ref like Checkout, but checks out the commit into a
+// separate working tree at dir, which later fetches or checkouts do not
+// change. An existing dir is assumed to contain the commit already. Remove
+// dir, once it is no longer needed; stale worktree entries are pruned on the
+// next call.
+func (r Repo) Worktree(ref, commit, dir string) error {
+	log.Printf("checking out %s (%s) of %s into %s", ref, commit, r, dir)
+	if err := r.fetch(ref); err != nil {
+		return err
+	}
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	if err := r.git("-C", r.Dir, "worktree", "prune"); err != nil {
+		return err
+	}
+	if commit == "" {
+		commit = "FETCH_HEAD"
+	}
+	return r.git("-C", r.Dir, "worktree", "add", "--force", "--detach", dir, commit)
+}
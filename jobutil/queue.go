@@ -0,0 +1,331 @@
+// Package jobutil implements a small persistent job queue for running
+// external commands, used by span-webhookd.
+//
+// Each job lives in its own directory, containing the job state as JSON and
+// the captured stdout and stderr of the command. Jobs that were queued or
+// running when the process stopped are queued again on Open.
+package jobutil
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/miku/span/atomic"
+	"github.com/segmentio/encoding/json"
+)
+
+// Status of a job.
+type Status string
+
+const (
+	StatusQueued  Status = "queued"
+	StatusRunning Status = "running"
+	StatusFailed  Status = "failed"
+	StatusDone    Status = "done"
+)
+
+const (
+	// Stdout and Stderr name the captured output streams of a job.
+	Stdout = "stdout"
+	Stderr = "stderr"
+
+	jobFile = "job.json"
+)
+
+var (
+	// ErrJobNotFound is returned, if a job does not exist.
+	ErrJobNotFound = errors.New("job not found")
+	// ErrQueueClosed is returned, if a job is added to a closed queue.
+	ErrQueueClosed = errors.New("queue closed")
+)
+
+// Job is a single command invocation.
+type Job struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Args     []string  `json:"args"`
+	Ticket   string    `json:"ticket,omitempty"`
+	Rule     string    `json:"rule,omitempty"`
+	Post     bool      `json:"post,omitempty"`
+	Dir      string    `json:"dir,omitempty"` // checkout the job reads from, if any
+	Status   Status    `json:"status"`
+	ExitCode int       `json:"exit_code"`
+	Error    string    `json:"error,omitempty"`
+	Created  time.Time `json:"created"`
+	Started  time.Time `json:"started,omitempty"`
+	Finished time.Time `json:"finished,omitempty"`
+}
+
+// String returns a short description of the job.
+func (j *Job) String() string {
+	return fmt.Sprintf("job %s [%s] %s %v", j.ID, j.Status, j.Name, j.Args)
+}
+
+// Queue is a persistent first in, first out job queue, backed by a directory.
+type Queue struct {
+	Dir string
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []string
+	seq     int
+	closed  bool
+}
+
+// Open opens or creates a queue in a directory. Jobs, which are not finished,
+// are queued again in the order of creation.
+func Open(dir string) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	q := &Queue{Dir: dir}
+	q.cond = sync.NewCond(&q.mu)
+	jobs, err := q.List()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(jobs) - 1; i >= 0; i-- {
+		j := jobs[i]
+		if j.Status != StatusQueued && j.Status != StatusRunning {
+			continue
+		}
+		j.Status = StatusQueued
+		if err := q.save(j); err != nil {
+			return nil, err
+		}
+		q.pending = append(q.pending, j.ID)
+	}
+	return q, nil
+}
+
+// jobDir returns the directory of a job.
+func (q *Queue) jobDir(id string) string {
+	return filepath.Join(q.Dir, id)
+}
+
+// LogPath returns the path to the captured stdout or stderr of a job.
+func (q *Queue) LogPath(id, stream string) string {
+	return filepath.Join(q.jobDir(id), stream)
+}
+
+// save writes the job state.
+func (q *Queue) save(j *Job) error {
+	b, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	return atomic.WriteFile(filepath.Join(q.jobDir(j.ID), jobFile), b, 0644)
+}
+
+// Add queues a new job and returns it.
+func (q *Queue) Add(name string, args []string, ticket string) (*Job, error) {
+	return q.AddJob(&Job{Name: name, Args: args, Ticket: ticket})
+}
+
+// AddJob queues a job, filling in identifier, status and creation time.
+func (q *Queue) AddJob(j *Job) (*Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil, ErrQueueClosed
+	}
+	now := time.Now().UTC()
+	j.Status, j.Created = StatusQueued, now
+	// Identifiers sort by creation time; skip over identifiers taken by a
+	// previous process.
+	for {
+		q.seq++
+		j.ID = fmt.Sprintf("%s-%04d", now.Format("20060102T150405Z"), q.seq)
+		err := os.Mkdir(q.jobDir(j.ID), 0755)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+	}
+	if err := q.save(j); err != nil {
+		return nil, err
+	}
+	q.pending = append(q.pending, j.ID)
+	q.cond.Signal()
+	return j, nil
+}
+
+// Get returns a job by identifier.
+func (q *Queue) Get(id string) (*Job, error) {
+	if id == "" || filepath.Base(id) != id {
+		return nil, ErrJobNotFound
+	}
+	b, err := ioutil.ReadFile(filepath.Join(q.jobDir(id), jobFile))
+	if os.IsNotExist(err) {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var j Job
+	if err := json.Unmarshal(b, &j); err != nil {
+		return nil, fmt.Errorf("job %s: %v", id, err)
+	}
+	return &j, nil
+}
+
+// List returns all jobs, most recent first.
+func (q *Queue) List() (result []*Job, err error) {
+	entries, err := ioutil.ReadDir(q.Dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		j, err := q.Get(e.Name())
+		if err == ErrJobNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, j)
+	}
+	sort.SliceStable(result, func(i, k int) bool {
+		return result[i].Created.After(result[k].Created)
+	})
+	return result, nil
+}
+
+// next blocks until a job is pending and returns its identifier. Returns
+// false, if the queue has been closed.
+func (q *Queue) next() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.pending) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return "", false
+	}
+	id := q.pending[0]
+	q.pending = q.pending[1:]
+	return id, true
+}
+
+// Close stops accepting jobs and lets Work return after the current job.
+// Pending jobs stay queued on disk.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// Work runs jobs one after another, until the queue is closed. The finished
+// function is called with each completed job, failed or not. A job, which
+// cannot be loaded, is marked failed without running it.
+func (q *Queue) Work(finished func(*Job)) {
+	for {
+		id, ok := q.next()
+		if !ok {
+			return
+		}
+		j, err := q.Get(id)
+		if err != nil {
+			j = q.fail(id, err)
+		} else {
+			q.run(j)
+		}
+		if finished != nil {
+			finished(j)
+		}
+	}
+}
+
+// fail records a job, which cannot be loaded, as failed; the job state is
+// replaced, as it is unreadable anyway.
+func (q *Queue) fail(id string, err error) *Job {
+	now := time.Now().UTC()
+	j := &Job{
+		ID:       id,
+		Status:   StatusFailed,
+		ExitCode: -1,
+		Error:    fmt.Sprintf("cannot load job: %v", err),
+		Started:  now,
+		Finished: now,
+	}
+	if err := q.save(j); err != nil {
+		j.Error = fmt.Sprintf("%s; cannot save job: %v", j.Error, err)
+	}
+	return j
+}
+
+// run executes a job, capturing its output and exit code.
+func (q *Queue) run(j *Job) {
+	j.Status, j.Started = StatusRunning, time.Now().UTC()
+	if err := q.save(j); err != nil {
+		j.Error = err.Error()
+	}
+	err := q.exec(j)
+	j.Finished = time.Now().UTC()
+	switch {
+	case err == nil:
+		j.Status, j.ExitCode = StatusDone, 0
+	default:
+		j.Status, j.Error = StatusFailed, err.Error()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			j.ExitCode = exitErr.ExitCode()
+		} else {
+			j.ExitCode = -1
+		}
+	}
+	if err := q.save(j); err != nil && j.Error == "" {
+		j.Error = err.Error()
+	}
+}
+
+// exec runs the command of a job with output redirected into the job
+// directory.
+func (q *Queue) exec(j *Job) error {
+	stdout, err := os.Create(q.LogPath(j.ID, Stdout))
+	if err != nil {
+		return err
+	}
+	defer stdout.Close()
+	stderr, err := os.Create(q.LogPath(j.ID, Stderr))
+	if err != nil {
+		return err
+	}
+	defer stderr.Close()
+	cmd := exec.Command(j.Name, j.Args...)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	return cmd.Run()
+}
+
+// Tail returns at most the last n bytes of a captured output stream of a job.
+func (q *Queue) Tail(id, stream string, n int64) (string, error) {
+	f, err := os.Open(q.LogPath(id, stream))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if fi.Size() > n {
+		if _, err := f.Seek(fi.Size()-n, 0); err != nil {
+			return "", err
+		}
+	}
+	b, err := ioutil.ReadAll(f)
+	return string(b), err
+}
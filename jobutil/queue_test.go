@@ -0,0 +1,148 @@
+package jobutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "span-jobutil-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	q, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open: got %v, want nil", err)
+	}
+	var cases = []struct {
+		args     []string
+		status   Status
+		exitCode int
+		stdout   string
+		stderr   string
+	}{
+		{[]string{"-c", "echo hello"}, StatusDone, 0, "hello\n", ""},
+		{[]string{"-c", "echo out; echo err >&2; exit 3"}, StatusFailed, 3, "out\n", "err\n"},
+	}
+	var ids []string
+	for _, c := range cases {
+		j, err := q.Add("sh", c.args, "1234")
+		if err != nil {
+			t.Fatalf("Add: got %v, want nil", err)
+		}
+		if j.Status != StatusQueued {
+			t.Errorf("Add: got %s, want %s", j.Status, StatusQueued)
+		}
+		ids = append(ids, j.ID)
+	}
+	finished := make(chan *Job)
+	go q.Work(func(j *Job) { finished <- j })
+	for i, c := range cases {
+		j := <-finished
+		if j.ID != ids[i] {
+			t.Fatalf("Work: got job %s, want %s", j.ID, ids[i])
+		}
+		stored, err := q.Get(j.ID)
+		if err != nil {
+			t.Fatalf("Get: got %v, want nil", err)
+		}
+		if stored.Status != c.status || stored.ExitCode != c.exitCode {
+			t.Errorf("job %s: got %s (%d), want %s (%d)", j.ID, stored.Status, stored.ExitCode, c.status, c.exitCode)
+		}
+		if stored.Ticket != "1234" {
+			t.Errorf("job %s: got ticket %q, want 1234", j.ID, stored.Ticket)
+		}
+		for stream, want := range map[string]string{Stdout: c.stdout, Stderr: c.stderr} {
+			got, err := q.Tail(j.ID, stream, 1024)
+			if err != nil {
+				t.Fatalf("Tail: got %v, want nil", err)
+			}
+			if got != want {
+				t.Errorf("job %s %s: got %q, want %q", j.ID, stream, got, want)
+			}
+		}
+	}
+	q.Close()
+	if _, err := q.Add("true", nil, ""); err != ErrQueueClosed {
+		t.Errorf("Add: got %v, want %v", err, ErrQueueClosed)
+	}
+	if _, err := q.Get("../x"); err != ErrJobNotFound {
+		t.Errorf("Get: got %v, want %v", err, ErrJobNotFound)
+	}
+	jobs, err := q.List()
+	if err != nil {
+		t.Fatalf("List: got %v, want nil", err)
+	}
+	if len(jobs) != 2 {
+		t.Errorf("List: got %d jobs, want 2", len(jobs))
+	}
+}
+
+func TestQueueRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "span-jobutil-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	q, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := q.Add("sh", []string{"-c", "echo again"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Close()
+	// A new queue picks up the pending job.
+	q, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	finished := make(chan *Job)
+	go q.Work(func(j *Job) { finished <- j })
+	done := <-finished
+	q.Close()
+	if done.ID != j.ID || done.Status != StatusDone {
+		t.Errorf("got %s, want job %s done", done, j.ID)
+	}
+	if s, _ := q.Tail(j.ID, Stdout, 4); s != "ain\n" {
+		t.Errorf("Tail: got %q, want %q", s, "ain\n")
+	}
+}
+
+func TestQueueUnreadableJob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "span-jobutil-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	q, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	j, err := q.Add("sh", []string{"-c", "echo never"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, j.ID, jobFile), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	finished := make(chan *Job)
+	go q.Work(func(j *Job) { finished <- j })
+	done := <-finished
+	if done.ID != j.ID || done.Status != StatusFailed || !strings.HasPrefix(done.Error, "cannot load job") {
+		t.Errorf("got %s (%s), want job %s failed", done, done.Error, j.ID)
+	}
+	stored, err := q.Get(j.ID)
+	if err != nil {
+		t.Fatalf("Get: got %v, want nil", err)
+	}
+	if stored.Status != StatusFailed {
+		t.Errorf("Get: got %s, want %s", stored.Status, StatusFailed)
+	}
+}
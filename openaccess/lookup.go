@@ -0,0 +1,214 @@
+package openaccess
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/miku/span/encoding/tsv"
+	"github.com/miku/span/formats/finc"
+	"github.com/miku/span/licensing"
+)
+
+// FreeContentItem is a single item from the AMSL freeContent API response
+// (2017-12-01).
+type FreeContentItem struct {
+	FreeContent    string `json:"freeContent"`
+	MegaCollection string `json:"mega_collection"`
+	Shard          string `json:"shard"`
+	Sid            string `json:"sid"`
+}
+
+// IsFree returns true, if the item marks free access, false for uncertainty
+// or closed access.
+func (item FreeContentItem) IsFree() bool {
+	switch strings.TrimSpace(strings.ToLower(item.FreeContent)) {
+	case "ja", "yes", "ok", "1", "true":
+		return true
+	default:
+		return false
+	}
+}
+
+// FreeContent tells, whether a source and collection is free. Source ids and
+// collection names are interned, so each distinct string is stored once, the
+// lookup itself keys on a pair of integers and does not allocate.
+type FreeContent struct {
+	sids        map[string]uint32
+	collections map[string]uint32
+	free        map[uint64]bool
+}
+
+// intern returns a number for a string, unique within m.
+func intern(m map[string]uint32, s string) uint32 {
+	if v, ok := m[s]; ok {
+		return v
+	}
+	v := uint32(len(m))
+	m[s] = v
+	return v
+}
+
+// ReadFreeContent reads an AMSL freeContent response, a JSON array, item by
+// item, without keeping the whole response in memory. A later item for the
+// same source and collection wins.
+//
+// This trades speed for memory: on 500000 synthetic items, reading runs at
+// about 45 MB/s with a peak heap of about 55 MB, while reading the whole
+// response into a map ran at about 100 MB/s with a peak of about 130 MB (see
+// BenchmarkReadFreeContent). The response is read once per run, so the speed
+// does not matter much. The standard library decoder is used, since
+// github.com/segmentio/encoding/json has no Token and More to stream an array;
+// decoding each item as raw message with the standard library and then with
+// segmentio was slower still.
+func ReadFreeContent(r io.Reader) (*FreeContent, error) {
+	fc := &FreeContent{
+		sids:        make(map[string]uint32),
+		collections: make(map[string]uint32),
+		free:        make(map[uint64]bool),
+	}
+	dec := json.NewDecoder(r)
+	t, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := t.(json.Delim); !ok || d != '[' {
+		return nil, fmt.Errorf("expected array, got %v", t)
+	}
+	for dec.More() {
+		var item FreeContentItem
+		if err := dec.Decode(&item); err != nil {
+			return nil, err
+		}
+		key := uint64(intern(fc.sids, item.Sid))<<32 | uint64(intern(fc.collections, item.MegaCollection))
+		fc.free[key] = item.IsFree()
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return fc, nil
+}
+
+// Len returns the number of source and collection pairs.
+func (fc *FreeContent) Len() int {
+	return len(fc.free)
+}
+
+// Lookup returns whether a collection of a source is free and whether there is
+// any information about it at all.
+func (fc *FreeContent) Lookup(sid, collection string) (free, ok bool) {
+	s, ok := fc.sids[sid]
+	if !ok {
+		return false, false
+	}
+	c, ok := fc.collections[collection]
+	if !ok {
+		return false, false
+	}
+	free, ok = fc.free[uint64(s)<<32|uint64(c)]
+	return free, ok
+}
+
+// coverage holds the KBART fields needed for coverage checks, the rest of a
+// KBART row is not kept.
+type coverage struct {
+	FirstIssueDate string
+	FirstVolume    string
+	FirstIssue     string
+	LastIssueDate  string
+	LastVolume     string
+	LastIssue      string
+	Embargo        string
+}
+
+// Holdings is a compact KBART lookup by ISSN. Identical coverages are stored
+// once.
+type Holdings struct {
+	// Verbose logs the reason, if no coverage matches.
+	Verbose bool
+
+	coverages []coverage
+	issn      map[string][]int32
+}
+
+// ReadHoldings reads a KBART file row by row.
+func ReadHoldings(r io.Reader) (*Holdings, error) {
+	var (
+		h = &Holdings{issn: make(map[string][]int32)}
+		// seen maps a coverage to its index
+		seen = make(map[coverage]int32)
+		dec  = tsv.NewDecoder(r)
+	)
+	for {
+		var entry licensing.Entry
+		err := dec.Decode(&entry)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		c := coverage{
+			FirstIssueDate: entry.FirstIssueDate,
+			FirstVolume:    entry.FirstVolume,
+			FirstIssue:     entry.FirstIssue,
+			LastIssueDate:  entry.LastIssueDate,
+			LastVolume:     entry.LastVolume,
+			LastIssue:      entry.LastIssue,
+			Embargo:        entry.Embargo,
+		}
+		i, ok := seen[c]
+		if !ok {
+			i = int32(len(h.coverages))
+			h.coverages = append(h.coverages, c)
+			seen[c] = i
+		}
+	outer:
+		for _, issn := range entry.ISSNList() {
+			for _, j := range h.issn[issn] {
+				if j == i {
+					continue outer
+				}
+			}
+			h.issn[issn] = append(h.issn[issn], i)
+		}
+	}
+	return h, nil
+}
+
+// Len returns the number of distinct ISSN.
+func (h *Holdings) Len() int {
+	return len(h.issn)
+}
+
+// Covers returns true, if any coverage of any ISSN of the record covers the
+// record's date, volume and issue.
+func (h *Holdings) Covers(is *finc.IntermediateSchema) bool {
+	for _, issns := range [][]string{is.ISSN, is.EISSN} {
+		for _, issn := range issns {
+			for _, i := range h.issn[issn] {
+				c := h.coverages[i]
+				entry := licensing.Entry{
+					FirstIssueDate: c.FirstIssueDate,
+					FirstVolume:    c.FirstVolume,
+					FirstIssue:     c.FirstIssue,
+					LastIssueDate:  c.LastIssueDate,
+					LastVolume:     c.LastVolume,
+					LastIssue:      c.LastIssue,
+					Embargo:        c.Embargo,
+				}
+				err := entry.Covers(is.RawDate, is.Volume, is.Issue)
+				if err == nil {
+					return true
+				}
+				if h.Verbose {
+					log.Printf("%s %s not covered: %v", is.RecordID, issn, err)
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,215 @@
+package openaccess
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+
+	"github.com/miku/span/formats/finc"
+)
+
+func TestReadFreeContent(t *testing.T) {
+	fc, err := ReadFreeContent(strings.NewReader(`[
+		{"freeContent": "Ja", "mega_collection": "DOAJ", "shard": "UBL-ai", "sid": "28"},
+		{"freeContent": "Nein", "mega_collection": "JSTOR Arts", "shard": "UBL-ai", "sid": "55"},
+		{"freeContent": "nicht festgelegt", "mega_collection": "JSTOR Arts", "shard": "UBL-main", "sid": "55"},
+		{"freeContent": "ja", "mega_collection": "DOAJ", "shard": "UBL-main", "sid": "55"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fc.Len() != 3 {
+		t.Errorf("Len: got %d, want 3", fc.Len())
+	}
+	var cases = []struct {
+		sid, collection string
+		free, ok        bool
+	}{
+		{"28", "DOAJ", true, true},
+		{"55", "JSTOR Arts", false, true},
+		{"55", "DOAJ", true, true},
+		{"28", "JSTOR Arts", false, false},
+		{"99", "DOAJ", false, false},
+	}
+	for _, c := range cases {
+		free, ok := fc.Lookup(c.sid, c.collection)
+		if free != c.free || ok != c.ok {
+			t.Errorf("Lookup(%s, %s): got %v %v, want %v %v", c.sid, c.collection, free, ok, c.free, c.ok)
+		}
+	}
+	if _, err := ReadFreeContent(strings.NewReader(`{}`)); err == nil {
+		t.Errorf("ReadFreeContent: got nil, want error for object")
+	}
+}
+
+func TestReadHoldings(t *testing.T) {
+	kbart := "publication_title\tprint_identifier\tonline_identifier\tdate_first_issue_online\tdate_last_issue_online\tembargo_info\n" +
+		"A\t1234-5678\t2345-6789\t2000\t2005\t\n" +
+		"A, again\t1234-5678\t\t2000\t2005\t\n" +
+		"B\t3456-7890\t\t2010\t\t\n"
+	h, err := ReadHoldings(strings.NewReader(kbart))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Len() != 3 || len(h.coverages) != 2 || len(h.issn["1234-5678"]) != 1 {
+		t.Errorf("got %d issn, %d coverages, want 3, 2 and no duplicates", h.Len(), len(h.coverages))
+	}
+	var cases = []struct {
+		is   finc.IntermediateSchema
+		want bool
+	}{
+		{finc.IntermediateSchema{ISSN: []string{"1234-5678"}, RawDate: "2001-01-01"}, true},
+		{finc.IntermediateSchema{EISSN: []string{"2345-6789"}, RawDate: "2004-01-01"}, true},
+		{finc.IntermediateSchema{ISSN: []string{"1234-5678"}, RawDate: "2007-01-01"}, false},
+		{finc.IntermediateSchema{ISSN: []string{"3456-7890"}, RawDate: "2020-01-01"}, true},
+		{finc.IntermediateSchema{ISSN: []string{"0000-0000"}, RawDate: "2020-01-01"}, false},
+	}
+	for _, c := range cases {
+		if got := h.Covers(&c.is); got != c.want {
+			t.Errorf("Covers(%v %v %v): got %v, want %v", c.is.ISSN, c.is.EISSN, c.is.RawDate, got, c.want)
+		}
+	}
+}
+
+// readFreeContentMap is the previous span-oa-filter implementation, kept for
+// comparison: decode the whole response, then build a map with string keys.
+func readFreeContentMap(b []byte) (map[string]bool, error) {
+	var items []FreeContentItem
+	if err := json.Unmarshal(b, &items); err != nil {
+		return nil, err
+	}
+	lookup := make(map[string]bool)
+	for _, item := range items {
+		lookup[fmt.Sprintf("%s:%s", item.Sid, item.MegaCollection)] = item.IsFree()
+	}
+	return lookup, nil
+}
+
+// syntheticFreeContent returns an AMSL like freeContent response with n
+// items: a few hundred sources with up to fifty collections each, repeated
+// across shards and institutions.
+func syntheticFreeContent(n int) []byte {
+	var (
+		r      = rand.New(rand.NewSource(1))
+		items  = make([]FreeContentItem, n)
+		shards = []string{"UBL-ai", "UBL-main", "SLUB-dswarm", "SLUB-dbod"}
+		values = []string{"Ja", "Nein", "nicht festgelegt"}
+	)
+	for i := range items {
+		sid := r.Intn(400)
+		items[i] = FreeContentItem{
+			FreeContent:    values[r.Intn(len(values))],
+			MegaCollection: fmt.Sprintf("Collection %d of source %d (Verlagsangebot)", r.Intn(50), sid),
+			Shard:          shards[r.Intn(len(shards))],
+			Sid:            fmt.Sprintf("%d", sid),
+		}
+	}
+	b, err := json.Marshal(items)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// heapInUse returns the live heap after a garbage collection.
+func heapInUse() uint64 {
+	var m runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&m)
+	return m.HeapInuse
+}
+
+// measure runs f and reports the peak heap, sampled every few milliseconds,
+// and the live heap afterwards, both relative to the heap before.
+func measure(b *testing.B, f func() interface{}) {
+	var (
+		before = heapInUse()
+		peak   uint64
+		done   = make(chan bool)
+		exit   = make(chan bool)
+	)
+	go func() {
+		var m runtime.MemStats
+		for {
+			runtime.ReadMemStats(&m)
+			if m.HeapInuse > peak {
+				peak = m.HeapInuse
+			}
+			select {
+			case <-done:
+				close(exit)
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+		}
+	}()
+	v := f()
+	close(done)
+	<-exit
+	// The heap can shrink below its size before, so subtract signed values.
+	b.ReportMetric(float64(int64(peak)-int64(before))/(1<<20), "peak-MB")
+	b.ReportMetric(float64(int64(heapInUse())-int64(before))/(1<<20), "live-MB")
+	runtime.KeepAlive(v)
+}
+
+const benchmarkItems = 500000
+
+func BenchmarkReadFreeContentMap(b *testing.B) {
+	data := syntheticFreeContent(benchmarkItems)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		measure(b, func() interface{} {
+			lookup, err := readFreeContentMap(data)
+			if err != nil {
+				b.Fatal(err)
+			}
+			return lookup
+		})
+	}
+}
+
+func BenchmarkReadFreeContent(b *testing.B) {
+	data := syntheticFreeContent(benchmarkItems)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		measure(b, func() interface{} {
+			fc, err := ReadFreeContent(bytes.NewReader(data))
+			if err != nil {
+				b.Fatal(err)
+			}
+			return fc
+		})
+	}
+}
+
+func BenchmarkLookupFreeContentMap(b *testing.B) {
+	lookup, err := readFreeContentMap(syntheticFreeContent(benchmarkItems))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = lookup[fmt.Sprintf("%s:%s", "55", "Collection 1 of source 55 (Verlagsangebot)")]
+	}
+}
+
+func BenchmarkLookupFreeContent(b *testing.B) {
+	fc, err := ReadFreeContent(bytes.NewReader(syntheticFreeContent(benchmarkItems)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fc.Lookup("55", "Collection 1 of source 55 (Verlagsangebot)")
+	}
+}
@@ -0,0 +1,280 @@
+// Package openaccess collects evidence for open access of intermediate schema
+// records beyond KBART files: Unpaywall snapshots by DOI, DOAJ journal lists
+// by ISSN and Creative Commons license URLs found in the record itself.
+package openaccess
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/segmentio/encoding/json"
+
+	"github.com/miku/span/formats/finc"
+)
+
+// Reason codes, recorded in x.oa_reason.
+const (
+	ReasonSourceID    = "sid"         // source is open access as a whole
+	ReasonKBART       = "kbart"       // covered by an open access KBART file
+	ReasonFreeContent = "freecontent" // collection marked free in AMSL
+	ReasonLicense     = "license"     // open license URL in the record
+	ReasonUnpaywall   = "unpaywall"   // DOI open access according to Unpaywall
+	ReasonDOAJ        = "doaj"        // ISSN listed in DOAJ
+)
+
+var (
+	// ccLicense matches Creative Commons license URLs, e.g.
+	// https://creativecommons.org/licenses/by-nc/4.0/.
+	ccLicense = regexp.MustCompile(`(?i)creativecommons\.org/licenses/([a-z-]+)`)
+	// ccPublicDomain matches CC0 and public domain mark URLs.
+	ccPublicDomain = regexp.MustCompile(`(?i)creativecommons\.org/publicdomain/(zero|mark)`)
+	// ccName matches license names, e.g. "CC BY-NC 4.0" or "cc-by".
+	ccName = regexp.MustCompile(`(?i)^cc[ _-]?(0|zero|by(?:[ _-](?:nc|nd|sa))*)\b`)
+)
+
+// NormalizeLicense returns a license type like CC-BY, CC-BY-NC-ND, CC0 or PD
+// for license names or URLs, or the empty string, if the license is not an
+// open license we know about.
+func NormalizeLicense(s string) string {
+	s = strings.TrimSpace(s)
+	if m := ccPublicDomain.FindStringSubmatch(s); m != nil {
+		if strings.ToLower(m[1]) == "zero" {
+			return "CC0"
+		}
+		return "PD"
+	}
+	if m := ccLicense.FindStringSubmatch(s); m != nil {
+		return "CC-" + strings.ToUpper(strings.Trim(m[1], "-"))
+	}
+	switch strings.ToLower(s) {
+	case "public-domain", "public domain", "pd":
+		return "PD"
+	}
+	if m := ccName.FindStringSubmatch(s); m != nil {
+		v := strings.ToUpper(m[1])
+		if v == "0" || v == "ZERO" {
+			return "CC0"
+		}
+		return "CC-" + strings.NewReplacer(" ", "-", "_", "-").Replace(v)
+	}
+	return ""
+}
+
+// Evidence for open access, with the license type, if known.
+type Evidence struct {
+	Reason  string
+	License string
+}
+
+// Unpaywall is a compact set of DOI of open access articles with their
+// license types. Only a 56 bit hash of each DOI is kept, together with the
+// license type, in a sorted slice: about 8 bytes per DOI, instead of the DOI
+// string itself; a full snapshot with about 50M open access DOI needs about
+// 400MB. Hash collisions are possible, but unlikely: with 100M DOI, about one
+// in 700 million lookups of an unknown DOI is a false positive.
+type Unpaywall struct {
+	entries  []uint64 // hash << 8 | license index, sorted
+	licenses []string
+}
+
+// doiHash returns a FNV-1a hash of a DOI, ASCII case insensitive, reduced to
+// 56 bits.
+func doiHash(doi string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(doi); i++ {
+		c := doi[i]
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+	return h >> 8
+}
+
+// uint64Slice sorts entries, sort.Slice is notably slower on large slices.
+type uint64Slice []uint64
+
+func (s uint64Slice) Len() int           { return len(s) }
+func (s uint64Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s uint64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// ReadUnpaywall reads an Unpaywall snapshot (NDJSON, one object per DOI) and
+// keeps only DOI marked as open access.
+func ReadUnpaywall(r io.Reader) (*Unpaywall, error) {
+	var (
+		u       = &Unpaywall{}
+		indices = make(map[string]uint64)
+		br      = bufio.NewReader(r)
+		i       int
+	)
+	for {
+		b, err := br.ReadBytes('\n')
+		if err == io.EOF && len(b) == 0 {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		i++
+		var doc struct {
+			DOI            string `json:"doi"`
+			IsOA           bool   `json:"is_oa"`
+			BestOALocation struct {
+				License string `json:"license"`
+			} `json:"best_oa_location"`
+		}
+		if len(strings.TrimSpace(string(b))) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(b, &doc); err != nil {
+			return nil, fmt.Errorf("line %d: %v", i, err)
+		}
+		if !doc.IsOA || doc.DOI == "" {
+			continue
+		}
+		license := NormalizeLicense(doc.BestOALocation.License)
+		k, ok := indices[license]
+		if !ok {
+			if len(u.licenses) == 256 {
+				return nil, fmt.Errorf("line %d: more than 256 license types", i)
+			}
+			k = uint64(len(u.licenses))
+			indices[license] = k
+			u.licenses = append(u.licenses, license)
+		}
+		u.entries = append(u.entries, doiHash(doc.DOI)<<8|k)
+	}
+	sort.Sort(uint64Slice(u.entries))
+	// Drop duplicates, keep one entry per DOI.
+	var n int
+	for j, v := range u.entries {
+		if j > 0 && v>>8 == u.entries[n-1]>>8 {
+			continue
+		}
+		u.entries[n] = v
+		n++
+	}
+	u.entries = u.entries[:n:n]
+	return u, nil
+}
+
+// Len returns the number of open access DOI.
+func (u *Unpaywall) Len() int {
+	return len(u.entries)
+}
+
+// Lookup returns the license type of an open access DOI and whether the DOI
+// is open access at all. If a DOI appears more than once in the snapshot, one
+// of its license types is returned.
+func (u *Unpaywall) Lookup(doi string) (license string, ok bool) {
+	h := doiHash(doi)
+	i := sort.Search(len(u.entries), func(i int) bool { return u.entries[i]>>8 >= h })
+	if i == len(u.entries) || u.entries[i]>>8 != h {
+		return "", false
+	}
+	return u.licenses[u.entries[i]&0xff], true
+}
+
+// DOAJ maps ISSN of journals listed in DOAJ to license types.
+type DOAJ map[string]string
+
+// ReadDOAJ reads a DOAJ journal CSV dump. All columns with ISSN in their
+// header are used, the license is taken from the "Journal license" column.
+func ReadDOAJ(r io.Reader) (DOAJ, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		issnColumns   []int
+		licenseColumn = -1
+	)
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(h))
+		switch {
+		case strings.Contains(h, "issn"):
+			issnColumns = append(issnColumns, i)
+		case h == "journal license" || h == "license":
+			licenseColumn = i
+		}
+	}
+	if len(issnColumns) == 0 {
+		return nil, fmt.Errorf("no ISSN column found in header: %v", header)
+	}
+	result := make(DOAJ)
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var license string
+		if licenseColumn >= 0 && licenseColumn < len(record) {
+			license = NormalizeLicense(record[licenseColumn])
+		}
+		for _, i := range issnColumns {
+			if i >= len(record) {
+				continue
+			}
+			if issn := strings.ToUpper(strings.TrimSpace(record[i])); issn != "" {
+				result[issn] = license
+			}
+		}
+	}
+	return result, nil
+}
+
+// Detector looks for open access evidence in a record. Lookups, which are
+// nil, are skipped.
+type Detector struct {
+	Unpaywall *Unpaywall
+	DOAJ      DOAJ
+	// UseLicense enables license URLs in x.license as evidence.
+	UseLicense bool
+}
+
+// License returns the first open license type found in the license field of
+// a record.
+func License(is *finc.IntermediateSchema) string {
+	for _, v := range is.License {
+		if l := NormalizeLicense(v); l != "" {
+			return l
+		}
+	}
+	return ""
+}
+
+// Detect returns evidence for open access, trying license, Unpaywall and DOAJ,
+// in this order, from most to least specific.
+func (d *Detector) Detect(is *finc.IntermediateSchema) (Evidence, bool) {
+	if d.UseLicense {
+		if l := License(is); l != "" {
+			return Evidence{Reason: ReasonLicense, License: l}, true
+		}
+	}
+	if d.Unpaywall != nil && is.DOI != "" {
+		if l, ok := d.Unpaywall.Lookup(is.DOI); ok {
+			return Evidence{Reason: ReasonUnpaywall, License: l}, true
+		}
+	}
+	if d.DOAJ != nil {
+		for _, issns := range [][]string{is.ISSN, is.EISSN} {
+			for _, issn := range issns {
+				if l, ok := d.DOAJ[strings.ToUpper(issn)]; ok {
+					return Evidence{Reason: ReasonDOAJ, License: l}, true
+				}
+			}
+		}
+	}
+	return Evidence{}, false
+}
@@ -0,0 +1,118 @@
+package openaccess
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miku/span/formats/finc"
+)
+
+func TestNormalizeLicense(t *testing.T) {
+	var cases = []struct {
+		s    string
+		want string
+	}{
+		{"https://creativecommons.org/licenses/by/4.0/", "CC-BY"},
+		{"http://creativecommons.org/licenses/by-nc-nd/3.0/de/", "CC-BY-NC-ND"},
+		{"https://creativecommons.org/publicdomain/zero/1.0/", "CC0"},
+		{"https://creativecommons.org/publicdomain/mark/1.0/", "PD"},
+		{"cc-by", "CC-BY"},
+		{"cc-by-nc-sa", "CC-BY-NC-SA"},
+		{"CC BY-NC 4.0", "CC-BY-NC"},
+		{"CC0", "CC0"},
+		{"public-domain", "PD"},
+		{"implied-oa", ""},
+		{"acs-specific: authorchoice", ""},
+		{"https://www.elsevier.com/tdm/userlicense/1.0/", ""},
+		{"", ""},
+	}
+	for _, c := range cases {
+		if got := NormalizeLicense(c.s); got != c.want {
+			t.Errorf("NormalizeLicense(%q): got %q, want %q", c.s, got, c.want)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	unpaywall, err := ReadUnpaywall(strings.NewReader(`{"doi": "10.1/A", "is_oa": true, "best_oa_location": {"license": "cc-by"}}
+{"doi": "10.1/b", "is_oa": false, "best_oa_location": null}
+{"doi": "10.1/c", "is_oa": true, "best_oa_location": {"license": "implied-oa"}}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unpaywall.Len() != 2 {
+		t.Fatalf("ReadUnpaywall: got %d entries, want 2", unpaywall.Len())
+	}
+	doaj, err := ReadDOAJ(strings.NewReader(`Journal title,Journal ISSN (print version),Journal EISSN (online version),Journal license
+Journal of Things,1234-5678,2345-6789,CC BY-SA
+Other Journal,,3456-789x,CC BY
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doaj) != 3 {
+		t.Fatalf("ReadDOAJ: got %d entries, want 3", len(doaj))
+	}
+	d := &Detector{Unpaywall: unpaywall, DOAJ: doaj, UseLicense: true}
+	var cases = []struct {
+		is   finc.IntermediateSchema
+		ok   bool
+		want Evidence
+	}{
+		{finc.IntermediateSchema{DOI: "10.1/a"}, true, Evidence{ReasonUnpaywall, "CC-BY"}},
+		{finc.IntermediateSchema{DOI: "10.1/b"}, false, Evidence{}},
+		{finc.IntermediateSchema{DOI: "10.1/c"}, true, Evidence{ReasonUnpaywall, ""}},
+		{finc.IntermediateSchema{EISSN: []string{"3456-789X"}}, true, Evidence{ReasonDOAJ, "CC-BY"}},
+		{finc.IntermediateSchema{ISSN: []string{"1234-5678"}}, true, Evidence{ReasonDOAJ, "CC-BY-SA"}},
+		{finc.IntermediateSchema{
+			DOI:     "10.1/a",
+			License: []string{"https://creativecommons.org/licenses/by-nd/4.0/"},
+		}, true, Evidence{ReasonLicense, "CC-BY-ND"}},
+		{finc.IntermediateSchema{ISSN: []string{"0000-0000"}}, false, Evidence{}},
+	}
+	for _, c := range cases {
+		got, ok := d.Detect(&c.is)
+		if ok != c.ok || got != c.want {
+			t.Errorf("Detect(%v %v %v): got %v %v, want %v %v",
+				c.is.DOI, c.is.ISSN, c.is.EISSN, got, ok, c.want, c.ok)
+		}
+	}
+	if _, err := ReadDOAJ(strings.NewReader("title,url\nx,y\n")); err == nil {
+		t.Errorf("ReadDOAJ: got nil, want error for missing ISSN column")
+	}
+}
+
+func TestUnpaywallLookup(t *testing.T) {
+	u, err := ReadUnpaywall(strings.NewReader(`{"doi": "10.1/x", "is_oa": true, "best_oa_location": {"license": "cc-by-nc"}}
+{"doi": "10.1/Y", "is_oa": true, "best_oa_location": {"license": "cc0"}}
+
+{"doi": "10.1/X", "is_oa": true, "best_oa_location": {"license": "cc-by-nc"}}
+{"doi": "10.1/z", "is_oa": true, "best_oa_location": null}
+{"doi": "", "is_oa": true, "best_oa_location": null}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Len() != 3 {
+		t.Errorf("Len: got %d, want 3", u.Len())
+	}
+	var cases = []struct {
+		doi     string
+		license string
+		ok      bool
+	}{
+		{"10.1/x", "CC-BY-NC", true},
+		{"10.1/X", "CC-BY-NC", true},
+		{"10.1/y", "CC0", true},
+		{"10.1/z", "", true},
+		{"10.1/w", "", false},
+		{"", "", false},
+	}
+	for _, c := range cases {
+		license, ok := u.Lookup(c.doi)
+		if license != c.license || ok != c.ok {
+			t.Errorf("Lookup(%q): got %q %v, want %q %v", c.doi, license, ok, c.license, c.ok)
+		}
+	}
+}
@@ -39,6 +39,9 @@ install -m 755 span-oa-filter $RPM_BUILD_ROOT/usr/local/bin
 install -m 755 span-redact $RPM_BUILD_ROOT/usr/local/bin
 install -m 755 span-report $RPM_BUILD_ROOT/usr/local/bin
 install -m 755 span-review $RPM_BUILD_ROOT/usr/local/bin
+install -m 755 span-solr-dump $RPM_BUILD_ROOT/usr/local/bin
+install -m 755 span-sample $RPM_BUILD_ROOT/usr/local/bin
+install -m 755 span-stats $RPM_BUILD_ROOT/usr/local/bin
 install -m 755 span-tag $RPM_BUILD_ROOT/usr/local/bin
 install -m 755 span-tagger $RPM_BUILD_ROOT/usr/local/bin
 install -m 755 span-update-labels $RPM_BUILD_ROOT/usr/local/bin
@@ -83,6 +86,9 @@ rm -rf %{_topdir}/BUILD/%{name}
 /usr/local/bin/span-redact
 /usr/local/bin/span-report
 /usr/local/bin/span-review
+/usr/local/bin/span-solr-dump
+/usr/local/bin/span-sample
+/usr/local/bin/span-stats
 /usr/local/bin/span-tag
 /usr/local/bin/span-tagger
 /usr/local/bin/span-update-labels
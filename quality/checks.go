@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net/url"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -151,8 +152,45 @@ func TestFincStageTwo(is finc.IntermediateSchema) error {
 	return nil
 }
 
-// TestFincStageThree refers to stages from #9803.
+// stageThreeFields maps the fields, which can be required in stage three, to
+// a check for their presence.
+var stageThreeFields = map[string]func(is finc.IntermediateSchema) bool{
+	"abstract":    func(is finc.IntermediateSchema) bool { return is.Abstract != "" },
+	"languages":   func(is finc.IntermediateSchema) bool { return len(is.Languages) > 0 },
+	"x.headings":  func(is finc.IntermediateSchema) bool { return len(is.Headings) > 0 },
+	"x.subjects":  func(is finc.IntermediateSchema) bool { return len(is.Subjects) > 0 },
+	"x.fulltext":  func(is finc.IntermediateSchema) bool { return is.Fulltext != "" },
+	"rft.stitle":  func(is finc.IntermediateSchema) bool { return is.ShortTitle != "" },
+	"rft.series":  func(is finc.IntermediateSchema) bool { return is.Series != "" },
+	"rft.pub":     func(is finc.IntermediateSchema) bool { return len(is.Publishers) > 0 },
+	"rft.place":   func(is finc.IntermediateSchema) bool { return len(is.Places) > 0 },
+	"rft.part":    func(is finc.IntermediateSchema) bool { return is.Part != "" },
+	"rft.genre":   func(is finc.IntermediateSchema) bool { return is.Genre != "" },
+	"rft.edition": func(is finc.IntermediateSchema) bool { return is.Edition != "" },
+}
+
+// StageThreeFields returns the names of the fields, which can be required in
+// stage three.
+func StageThreeFields() []string {
+	var names []string
+	for k := range stageThreeFields {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TestFincStageThree refers to stages from #9803. Which stage three fields
+// are available differs widely across sources, so without a list of required
+// fields, only stage two is checked. Profiles can require fields with
+// stage-three-fields.
 func TestFincStageThree(is finc.IntermediateSchema) error {
+	return testFincStageThree(is, nil)
+}
+
+// testFincStageThree checks stage two and the presence of the given fields,
+// the error names all missing fields.
+func testFincStageThree(is finc.IntermediateSchema, fields []string) error {
 	// abstract
 	// languages
 	// x.headings
@@ -168,7 +206,16 @@ func TestFincStageThree(is finc.IntermediateSchema) error {
 	if err := TestFincStageTwo(is); err != nil {
 		return err
 	}
-	return nil // TODO
+	var missing []string
+	for _, f := range fields {
+		if present, ok := stageThreeFields[f]; ok && !present(is) {
+			missing = append(missing, f)
+		}
+	}
+	if len(missing) > 0 {
+		return Issue{Err: fmt.Errorf("stage three fail: missing %s", strings.Join(missing, ", ")), Record: is}
+	}
+	return nil
 }
 
 // TestKeyLength checks the length of the record id. memcachedb limits is 250 bytes.
@@ -191,10 +238,15 @@ func TestURL(is finc.IntermediateSchema) error {
 
 // TestDate checks for suspicious dates, refs. #5686.
 func TestDate(is finc.IntermediateSchema) error {
-	if is.Date.Before(EarliestDate) {
+	return testDate(is, EarliestDate, LatestDate)
+}
+
+// testDate checks, whether the publication date is within a range.
+func testDate(is finc.IntermediateSchema, earliest, latest time.Time) error {
+	if is.Date.Before(earliest) {
 		return Issue{Err: ErrPublicationDateTooEarly, Record: is}
 	}
-	if is.Date.After(LatestDate) {
+	if is.Date.After(latest) {
 		return Issue{Err: ErrPublicationDateTooEarly, Record: is}
 	}
 	return nil
@@ -289,9 +341,15 @@ func TestPublisher(is finc.IntermediateSchema) error {
 
 // TestFeasibleAuthor checks for a few suspicious authors patterns, refs. #4892, #4940, #5895.
 func TestFeasibleAuthor(is finc.IntermediateSchema) error {
+	return testFeasibleAuthor(is, 5, 50, blacklistedWordsAuthorNames)
+}
+
+// testFeasibleAuthor checks author names for length bounds, suspicious
+// patterns and blacklisted words.
+func testFeasibleAuthor(is finc.IntermediateSchema, minLength, maxLength int, blacklist []string) error {
 	for _, author := range is.Authors {
 		s := author.String()
-		if len(s) < 5 {
+		if len(s) < minLength {
 			return Issue{Err: ErrShortAuthorName, Record: is}
 		}
 		lower := strings.ToLower(s)
@@ -307,12 +365,12 @@ func TestFeasibleAuthor(is finc.IntermediateSchema) error {
 		if htmlEntityPattern.MatchString(s) {
 			return Issue{Err: ErrHTMLEntityInAuthorName, Record: is}
 		}
-		for _, w := range blacklistedWordsAuthorNames {
+		for _, w := range blacklist {
 			if strings.Contains(strings.ToLower(s), w) {
 				return Issue{Err: ErrBlacklistedWordInAuthorName, Record: is}
 			}
 		}
-		if len(s) > 50 {
+		if len(s) > maxLength {
 			return Issue{Err: ErrLongAuthorName, Record: is}
 		}
 	}
@@ -347,7 +405,12 @@ func TestCanonicalISSN(is finc.IntermediateSchema) error {
 
 // TestTitleTooLong returns an err if the title exceeds a limit, refs. #9230.
 func TestTitleTooLong(is finc.IntermediateSchema) error {
-	if len(is.ArticleTitle) > 400 {
+	return testTitleLength(is, 400)
+}
+
+// testTitleLength returns an err if the title exceeds maxLength bytes.
+func testTitleLength(is finc.IntermediateSchema, maxLength int) error {
+	if len(is.ArticleTitle) > maxLength {
 		return Issue{Err: ErrTitleTooLong, Record: is}
 	}
 	return nil
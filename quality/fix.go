@@ -0,0 +1,338 @@
+package quality
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/miku/span/formats/finc"
+	"github.com/miku/span/strutil"
+	"github.com/segmentio/encoding/json"
+)
+
+var (
+	// repeatedSlash, used by FixRepeatedSlashInDOI.
+	repeatedSlash = regexp.MustCompile(`/{2,}`)
+	// nonISSNChars, used by FixCanonicalISSN.
+	nonISSNChars = regexp.MustCompile(`[^0-9X]`)
+	// digits, used by FixPageSwap.
+	digits = regexp.MustCompile(`^[0-9]+$`)
+)
+
+// FixSuite lists the available fixes, in the order they are applied.
+var FixSuite = []Fix{
+	{"html-entity-author", FixerFunc(FixHTMLEntityInAuthorName), []string{"authors"}},
+	{"whitespace-author", FixerFunc(FixWhitespaceAuthor), []string{"authors"}},
+	{"et-al-author", FixerFunc(FixEtAlAuthorName), []string{"authors"}},
+	{"html-entity-title", FixerFunc(FixHTMLEntityInTitle), []string{"rft.atitle", "rft.btitle", "rft.jtitle", "x.subtitle"}},
+	{"canonical-issn", FixerFunc(FixCanonicalISSN), []string{"rft.eissn", "rft.issn"}},
+	{"repeated-slash-in-doi", FixerFunc(FixRepeatedSlashInDOI), []string{"doi"}},
+	{"page-swap", FixerFunc(FixPageSwap), []string{"rft.epage", "rft.pages", "rft.spage"}},
+}
+
+// Fixer repairs a record in place and reports, whether anything changed.
+type Fixer interface {
+	FixRecord(*finc.IntermediateSchema) bool
+}
+
+// FixerFunc makes a function satisfy an interface.
+type FixerFunc func(*finc.IntermediateSchema) bool
+
+// FixRecord delegates the fix to the given func.
+func (f FixerFunc) FixRecord(is *finc.IntermediateSchema) bool {
+	return f(is)
+}
+
+// Fix is a named fixer, the name is recorded in fixed records.
+type Fix struct {
+	Name  string
+	Fixer Fixer
+	// Fields lists the JSON names of the fields the fixer may change, changes
+	// are recorded in this order. Only these fields are copied before and
+	// compared after the fix. Empty means all fields.
+	Fields []string
+}
+
+// SelectFixes returns the fixes with the given names, in suite order. No
+// names select all fixes.
+func SelectFixes(names ...string) ([]Fix, error) {
+	if len(names) == 0 {
+		return FixSuite, nil
+	}
+	var (
+		result []Fix
+		seen   = make(map[string]bool)
+	)
+	for _, name := range names {
+		seen[name] = true
+	}
+	for _, f := range FixSuite {
+		if seen[f.Name] {
+			result = append(result, f)
+			delete(seen, f.Name)
+		}
+	}
+	for name := range seen {
+		return nil, fmt.Errorf("unknown fix: %s", name)
+	}
+	return result, nil
+}
+
+// FixRecord applies fixes in order and records each changed field, with its
+// old and new value, in x.fixes. Returns the names of the applied fixes.
+func FixRecord(is *finc.IntermediateSchema, fixes []Fix) []string {
+	var (
+		applied []string
+		v       = reflect.ValueOf(is).Elem()
+	)
+	for _, f := range fixes {
+		fields := f.Fields
+		if len(fields) == 0 {
+			fields = allFields
+		}
+		// Fixers change values in place, keep a shallow copy of the fields;
+		// values are only encoded, if the fixer reports a change.
+		before := make([]reflect.Value, len(fields))
+		for i, name := range fields {
+			if j, ok := fieldIndex[name]; ok {
+				before[i] = copyValue(v.Field(j))
+			}
+		}
+		if !f.Fixer.FixRecord(is) {
+			continue
+		}
+		applied = append(applied, f.Name)
+		for i, name := range fields {
+			j, ok := fieldIndex[name]
+			if !ok {
+				continue
+			}
+			was, now := encodeValue(before[i]), encodeValue(v.Field(j))
+			if bytes.Equal(was, now) {
+				continue
+			}
+			is.Fixes = append(is.Fixes, finc.FixNote{
+				Fix:   f.Name,
+				Field: name,
+				Old:   was,
+				New:   now,
+			})
+		}
+	}
+	return applied
+}
+
+var (
+	// fieldIndex maps the JSON names of the intermediate schema fields to
+	// their struct field index, except x.fixes.
+	fieldIndex = make(map[string]int)
+	// allFields lists the JSON names in fieldIndex, sorted.
+	allFields []string
+)
+
+func init() {
+	t := reflect.TypeOf(finc.IntermediateSchema{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" || name == "x.fixes" {
+			continue
+		}
+		fieldIndex[name] = i
+		allFields = append(allFields, name)
+	}
+	sort.Strings(allFields)
+}
+
+// copyValue returns a copy of a field value. Slices are copied one level
+// deep, which is enough for fixers replacing strings or slice elements.
+func copyValue(v reflect.Value) reflect.Value {
+	if v.Kind() != reflect.Slice || v.IsNil() {
+		return reflect.ValueOf(v.Interface())
+	}
+	c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+	reflect.Copy(c, v)
+	return c
+}
+
+// encodeValue returns the JSON value of a field, or nil for empty values,
+// which are omitted in records.
+func encodeValue(v reflect.Value) json.RawMessage {
+	if !v.IsValid() || v.IsZero() || (v.Kind() == reflect.Slice && v.Len() == 0) {
+		return nil
+	}
+	var (
+		buf bytes.Buffer
+		enc = json.NewEncoder(&buf)
+	)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v.Interface()); err != nil {
+		return nil
+	}
+	return json.RawMessage(bytes.TrimSpace(buf.Bytes()))
+}
+
+// authorFields returns pointers to the string fields of an author.
+func authorFields(a *finc.Author) []*string {
+	return []*string{&a.ID, &a.Name, &a.LastName, &a.FirstName, &a.Initial,
+		&a.FirstInitial, &a.MiddleName, &a.Suffix, &a.Corporate}
+}
+
+// unescape replaces HTML entities in a string.
+func unescape(s *string) bool {
+	if !htmlEntityPattern.MatchString(*s) {
+		return false
+	}
+	*s = html.UnescapeString(*s)
+	return true
+}
+
+// FixHTMLEntityInAuthorName replaces HTML entities in author names, e.g.
+// "M&uuml;ller", refs. #4940.
+func FixHTMLEntityInAuthorName(is *finc.IntermediateSchema) bool {
+	var changed bool
+	for i := range is.Authors {
+		for _, f := range authorFields(&is.Authors[i]) {
+			if unescape(f) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// FixWhitespaceAuthor trims author fields and drops authors without any
+// value left.
+func FixWhitespaceAuthor(is *finc.IntermediateSchema) bool {
+	var (
+		changed bool
+		authors []finc.Author
+	)
+	for _, a := range is.Authors {
+		var empty = true
+		for _, f := range authorFields(&a) {
+			if t := strings.TrimSpace(*f); t != *f {
+				*f = t
+				changed = true
+			}
+			if *f != "" {
+				empty = false
+			}
+		}
+		if empty {
+			changed = true
+			continue
+		}
+		authors = append(authors, a)
+	}
+	if changed {
+		is.Authors = authors
+	}
+	return changed
+}
+
+// FixEtAlAuthorName drops "et al." authors, refs. #5895.
+func FixEtAlAuthorName(is *finc.IntermediateSchema) bool {
+	var authors []finc.Author
+	for _, a := range is.Authors {
+		if strings.HasPrefix(strings.ToLower(a.String()), "et al") {
+			continue
+		}
+		authors = append(authors, a)
+	}
+	if len(authors) == len(is.Authors) {
+		return false
+	}
+	is.Authors = authors
+	return true
+}
+
+// FixHTMLEntityInTitle replaces leftover HTML entities in titles, e.g.
+// "Crime &amp; Punishment".
+func FixHTMLEntityInTitle(is *finc.IntermediateSchema) bool {
+	var changed bool
+	for _, f := range []*string{&is.ArticleTitle, &is.ArticleSubtitle, &is.JournalTitle, &is.BookTitle} {
+		if unescape(f) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// canonicalISSN returns the canonical form of an ISSN, e.g. 1234-567X for
+// "1234567x" or "1234 567X". Values not containing eight ISSN characters
+// are returned unchanged.
+func canonicalISSN(s string) string {
+	if strutil.ISSNPattern.MatchString(s) && len(s) == 9 {
+		return s
+	}
+	v := nonISSNChars.ReplaceAllString(strings.ToUpper(s), "")
+	if len(v) != 8 || strings.Contains(v[:7], "X") {
+		return s
+	}
+	return v[:4] + "-" + v[4:]
+}
+
+// FixCanonicalISSN rewrites ISSN into their canonical form.
+func FixCanonicalISSN(is *finc.IntermediateSchema) bool {
+	var changed bool
+	for _, vs := range [][]string{is.ISSN, is.EISSN} {
+		for i, v := range vs {
+			if c := canonicalISSN(v); c != v {
+				vs[i] = c
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// FixRepeatedSlashInDOI collapses repeated slashes in a DOI, refs. #6312.
+func FixRepeatedSlashInDOI(is *finc.IntermediateSchema) bool {
+	if !strings.Contains(is.DOI, "//") {
+		return false
+	}
+	is.DOI = repeatedSlash.ReplaceAllString(is.DOI, "/")
+	return true
+}
+
+// FixPageSwap repairs page ranges, where the end page comes before the start
+// page. If both have the same number of digits, they are swapped, otherwise
+// the end page is taken as abbreviation and expanded, e.g. 123-45 becomes
+// 123-145. A page range in pages is updated as well.
+func FixPageSwap(is *finc.IntermediateSchema) bool {
+	if !digits.MatchString(is.StartPage) || !digits.MatchString(is.EndPage) {
+		return false
+	}
+	s, err := strconv.Atoi(is.StartPage)
+	if err != nil {
+		return false
+	}
+	e, err := strconv.Atoi(is.EndPage)
+	if err != nil || e >= s {
+		return false
+	}
+	start, end := is.StartPage, is.EndPage
+	switch {
+	case len(start) == len(end):
+		start, end = end, start
+	case len(start) > len(end):
+		end = start[:len(start)-len(end)] + end
+		// An expanded end page still before the start page, e.g. 129-5, is
+		// no abbreviation we can resolve.
+		if v, err := strconv.Atoi(end); err != nil || v < s {
+			return false
+		}
+	default:
+		return false
+	}
+	if is.Pages == is.StartPage+"-"+is.EndPage {
+		is.Pages = start + "-" + end
+	}
+	is.StartPage, is.EndPage = start, end
+	return true
+}
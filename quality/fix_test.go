@@ -0,0 +1,108 @@
+package quality
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/segmentio/encoding/json"
+
+	"github.com/miku/span/formats/finc"
+)
+
+func TestFixers(t *testing.T) {
+	b, err := ioutil.ReadFile("../fixtures/quality-fixes.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cases []struct {
+		About   string                  `json:"about"`
+		Fix     string                  `json:"fix"`
+		Input   finc.IntermediateSchema `json:"input"`
+		Want    finc.IntermediateSchema `json:"want"`
+		Changed bool                    `json:"changed"`
+	}
+	if err := json.Unmarshal(b, &cases); err != nil {
+		t.Fatal(err)
+	}
+	covered := make(map[string]bool)
+	for _, c := range cases {
+		fixes, err := SelectFixes(c.Fix)
+		if err != nil {
+			t.Fatalf("%s: %v", c.About, err)
+		}
+		covered[c.Fix] = true
+		is := c.Input
+		changed := fixes[0].Fixer.FixRecord(&is)
+		if changed != c.Changed {
+			t.Errorf("%s: got changed %v, want %v", c.About, changed, c.Changed)
+		}
+		if !reflect.DeepEqual(is, c.Want) {
+			t.Errorf("%s: got %+v, want %+v", c.About, is, c.Want)
+		}
+	}
+	for _, f := range FixSuite {
+		if !covered[f.Name] {
+			t.Errorf("no test case for fix %s", f.Name)
+		}
+	}
+}
+
+func TestFixRecord(t *testing.T) {
+	is := finc.IntermediateSchema{
+		ISSN:      []string{"0022202X"},
+		DOI:       "10.1038//jid.2009.354",
+		StartPage: "17",
+		EndPage:   "19",
+		Authors:   []finc.Author{{Name: "M&uuml;ller, J"}},
+	}
+	for _, tester := range []TesterFunc{TestCanonicalISSN, TestRepeatedSlashInDOI, TestFeasibleAuthor} {
+		if err := tester(is); err == nil {
+			t.Fatalf("expected issue before fix")
+		}
+	}
+	applied := FixRecord(&is, FixSuite)
+	want := []string{"html-entity-author", "canonical-issn", "repeated-slash-in-doi"}
+	if !reflect.DeepEqual(applied, want) {
+		t.Errorf("FixRecord: got %v, want %v", applied, want)
+	}
+	notes := []finc.FixNote{
+		{Fix: "html-entity-author", Field: "authors", Old: json.RawMessage(`[{"rft.au":"M&uuml;ller, J"}]`), New: json.RawMessage(`[{"rft.au":"Müller, J"}]`)},
+		{Fix: "canonical-issn", Field: "rft.issn", Old: json.RawMessage(`["0022202X"]`), New: json.RawMessage(`["0022-202X"]`)},
+		{Fix: "repeated-slash-in-doi", Field: "doi", Old: json.RawMessage(`"10.1038//jid.2009.354"`), New: json.RawMessage(`"10.1038/jid.2009.354"`)},
+	}
+	if len(is.Fixes) != len(notes) {
+		t.Fatalf("FixRecord: got x.fixes %v, want %v", is.Fixes, notes)
+	}
+	for i, note := range notes {
+		got := is.Fixes[i]
+		if got.Fix != note.Fix || got.Field != note.Field || string(got.Old) != string(note.Old) || string(got.New) != string(note.New) {
+			t.Errorf("FixRecord: got x.fixes[%d] %s %s %s -> %s, want %s %s %s -> %s", i,
+				got.Fix, got.Field, got.Old, got.New, note.Fix, note.Field, note.Old, note.New)
+		}
+	}
+	for _, tester := range []TesterFunc{TestCanonicalISSN, TestRepeatedSlashInDOI, TestFeasibleAuthor} {
+		if err := tester(is); err != nil {
+			t.Errorf("after fix: got %v, want nil", err)
+		}
+	}
+	if _, err := SelectFixes("page-swap", "no-such-fix"); err == nil {
+		t.Errorf("SelectFixes: got nil, want err")
+	}
+	for _, f := range FixSuite {
+		for _, name := range f.Fields {
+			if _, ok := fieldIndex[name]; !ok {
+				t.Errorf("fix %s: unknown field %s", f.Name, name)
+			}
+		}
+	}
+	// A fix without fields is compared on all fields.
+	is = finc.IntermediateSchema{Volume: "1", Issue: "2"}
+	FixRecord(&is, []Fix{{Name: "clear", Fixer: FixerFunc(func(is *finc.IntermediateSchema) bool {
+		is.Volume, is.Issue = "", "3"
+		return true
+	})}})
+	if len(is.Fixes) != 2 || is.Fixes[0].Field != "rft.issue" || string(is.Fixes[1].Old) != `"1"` || is.Fixes[1].New != nil {
+		t.Errorf("FixRecord: got x.fixes %v, want changes to rft.issue and rft.volume", is.Fixes)
+	}
+}
@@ -0,0 +1,225 @@
+package quality
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miku/span/formats/finc"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// DefaultProfileName is used for sources without an explicit profile.
+const DefaultProfileName = "default"
+
+// ExampleProfiles selects the finc stages for all sources, runs the whole
+// test suite on a single source, with an error threshold, and requires
+// abstracts for another.
+var ExampleProfiles = `
+profiles:
+    default:
+        tests: [stage-one, stage-two]
+    strict:
+        tests: [key-length, page-count, url, date, feasible-author, title-too-long]
+        earliest-date: "1800-01-01"
+        max-title-length: 300
+        author-blacklist: [verfasser, herausgeber, anonymous]
+        max-error-ratio: 0.05
+    abstracts:
+        tests: [stage-three]
+        stage-three-fields: [abstract, languages]
+sources:
+    "49": strict
+    "28": abstracts
+`
+
+// testers maps test names to constructors, which may use profile settings.
+var testers = map[string]func(p *Profile) Tester{
+	"stage-one":             func(*Profile) Tester { return TesterFunc(TestFincStageOne) },
+	"stage-two":             func(*Profile) Tester { return TesterFunc(TestFincStageTwo) },
+	"key-length":            func(*Profile) Tester { return TesterFunc(TestKeyLength) },
+	"page-count":            func(*Profile) Tester { return TesterFunc(TestPageCount) },
+	"url":                   func(*Profile) Tester { return TesterFunc(TestURL) },
+	"subtitle-repetition":   func(*Profile) Tester { return TesterFunc(TestSubtitleRepetition) },
+	"currency-in-title":     func(*Profile) Tester { return TesterFunc(TestCurrencyInTitle) },
+	"excessive-punctuation": func(*Profile) Tester { return TesterFunc(TestExcessivePunctuation) },
+	"publisher":             func(*Profile) Tester { return TesterFunc(TestPublisher) },
+	"repeated-slash-in-doi": func(*Profile) Tester { return TesterFunc(TestRepeatedSlashInDOI) },
+	"has-url":               func(*Profile) Tester { return TesterFunc(TestHasURL) },
+	"canonical-issn":        func(*Profile) Tester { return TesterFunc(TestCanonicalISSN) },
+	"stage-three": func(p *Profile) Tester {
+		return TesterFunc(func(is finc.IntermediateSchema) error {
+			return testFincStageThree(is, p.StageThreeFields)
+		})
+	},
+	"date": func(p *Profile) Tester {
+		return TesterFunc(func(is finc.IntermediateSchema) error {
+			return testDate(is, p.earliest, p.latest)
+		})
+	},
+	"feasible-author": func(p *Profile) Tester {
+		return TesterFunc(func(is finc.IntermediateSchema) error {
+			return testFeasibleAuthor(is, p.MinAuthorLength, p.MaxAuthorLength, p.AuthorBlacklist)
+		})
+	},
+	"title-too-long": func(p *Profile) Tester {
+		return TesterFunc(func(is finc.IntermediateSchema) error {
+			return testTitleLength(is, p.MaxTitleLength)
+		})
+	},
+}
+
+// TestNames returns the names of the tests usable in a profile.
+func TestNames() []string {
+	var names []string
+	for k := range testers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Profile selects tests and their thresholds. Unset thresholds default to
+// the values used by the package level tests.
+type Profile struct {
+	Name            string   `yaml:"-"`
+	Tests           []string `yaml:"tests"`
+	EarliestDate    string   `yaml:"earliest-date"` // YYYY-MM-DD
+	LatestDate      string   `yaml:"latest-date"`   // YYYY-MM-DD
+	MaxTitleLength  int      `yaml:"max-title-length"`
+	MinAuthorLength int      `yaml:"min-author-length"`
+	MaxAuthorLength int      `yaml:"max-author-length"`
+	AuthorBlacklist []string `yaml:"author-blacklist"`
+	// StageThreeFields are the fields required by the stage-three test, e.g.
+	// abstract or rft.pub.
+	StageThreeFields []string `yaml:"stage-three-fields"`
+	// MaxErrorRatio is the ratio of records of a source allowed to fail any
+	// test, nil means no limit.
+	MaxErrorRatio *float64 `yaml:"max-error-ratio"`
+
+	earliest time.Time
+	latest   time.Time
+	testers  []Tester
+}
+
+// Testers returns the configured tests.
+func (p *Profile) Testers() []Tester {
+	return p.testers
+}
+
+// Exceeded returns true, if the given number of failed records exceeds the
+// error ratio of the profile.
+func (p *Profile) Exceeded(failed, total int64) bool {
+	if p.MaxErrorRatio == nil || total == 0 {
+		return false
+	}
+	return float64(failed)/float64(total) > *p.MaxErrorRatio
+}
+
+// init sets defaults, validates settings and sets up testers.
+func (p *Profile) init() error {
+	var err error
+	if len(p.Tests) == 0 {
+		return fmt.Errorf("%s: no tests", p.Name)
+	}
+	p.earliest, p.latest = EarliestDate, LatestDate
+	if p.EarliestDate != "" {
+		if p.earliest, err = time.Parse("2006-01-02", p.EarliestDate); err != nil {
+			return fmt.Errorf("%s: %v", p.Name, err)
+		}
+	}
+	if p.LatestDate != "" {
+		if p.latest, err = time.Parse("2006-01-02", p.LatestDate); err != nil {
+			return fmt.Errorf("%s: %v", p.Name, err)
+		}
+	}
+	if p.MaxTitleLength == 0 {
+		p.MaxTitleLength = 400
+	}
+	if p.MinAuthorLength == 0 {
+		p.MinAuthorLength = 5
+	}
+	if p.MaxAuthorLength == 0 {
+		p.MaxAuthorLength = 50
+	}
+	if len(p.AuthorBlacklist) == 0 {
+		p.AuthorBlacklist = append([]string(nil), blacklistedWordsAuthorNames...)
+	}
+	for i, w := range p.AuthorBlacklist {
+		p.AuthorBlacklist[i] = strings.ToLower(w)
+	}
+	if r := p.MaxErrorRatio; r != nil && (*r < 0 || *r > 1) {
+		return fmt.Errorf("%s: max-error-ratio must be between 0 and 1", p.Name)
+	}
+	for _, f := range p.StageThreeFields {
+		if _, ok := stageThreeFields[f]; !ok {
+			return fmt.Errorf("%s: unknown stage three field %q, want one of: %s",
+				p.Name, f, strings.Join(StageThreeFields(), ", "))
+		}
+	}
+	for _, name := range p.Tests {
+		f, ok := testers[name]
+		if !ok {
+			return fmt.Errorf("%s: unknown test %q, want one of: %s",
+				p.Name, name, strings.Join(TestNames(), ", "))
+		}
+		p.testers = append(p.testers, f(p))
+	}
+	return nil
+}
+
+// Profiles are named quality profiles and their assignment to sources.
+type Profiles struct {
+	Profiles map[string]*Profile `yaml:"profiles"`
+	Sources  map[string]string   `yaml:"sources"` // source id to profile name
+}
+
+// ReadProfiles reads and validates profiles from a YAML stream.
+func ReadProfiles(r io.Reader) (*Profiles, error) {
+	var ps Profiles
+	dec := yaml.NewDecoder(r)
+	dec.SetStrict(true)
+	if err := dec.Decode(&ps); err != nil {
+		return nil, err
+	}
+	if len(ps.Profiles) == 0 {
+		return nil, fmt.Errorf("no profiles")
+	}
+	for name, p := range ps.Profiles {
+		if p == nil {
+			return nil, fmt.Errorf("%s: empty profile", name)
+		}
+		p.Name = name
+		if err := p.init(); err != nil {
+			return nil, err
+		}
+	}
+	for sid, name := range ps.Sources {
+		if _, ok := ps.Profiles[name]; !ok {
+			return nil, fmt.Errorf("source %s: unknown profile %q", sid, name)
+		}
+	}
+	return &ps, nil
+}
+
+// DefaultProfiles runs the finc stage one and two tests on all sources.
+func DefaultProfiles() *Profiles {
+	p := &Profile{Name: DefaultProfileName, Tests: []string{"stage-one", "stage-two"}}
+	if err := p.init(); err != nil {
+		panic(err)
+	}
+	return &Profiles{Profiles: map[string]*Profile{DefaultProfileName: p}}
+}
+
+// ForSource returns the profile for a source id, falling back to the default
+// profile. Returns false, if no profile applies.
+func (ps *Profiles) ForSource(sid string) (*Profile, bool) {
+	name, ok := ps.Sources[sid]
+	if !ok {
+		name = DefaultProfileName
+	}
+	p, ok := ps.Profiles[name]
+	return p, ok
+}
@@ -0,0 +1,168 @@
+package quality
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miku/span/formats/finc"
+)
+
+func TestReadProfiles(t *testing.T) {
+	ps, err := ReadProfiles(strings.NewReader(ExampleProfiles))
+	if err != nil {
+		t.Fatalf("ReadProfiles: got %v, want nil", err)
+	}
+	var cases = []struct {
+		sid   string
+		want  string
+		tests int
+	}{
+		{"49", "strict", 6},
+		{"28", "abstracts", 1},
+		{"48", "default", 2},
+		{"", "default", 2},
+	}
+	for _, c := range cases {
+		p, ok := ps.ForSource(c.sid)
+		if !ok {
+			t.Fatalf("ForSource(%q): no profile", c.sid)
+		}
+		if p.Name != c.want {
+			t.Errorf("ForSource(%q): got %s, want %s", c.sid, p.Name, c.want)
+		}
+		if len(p.Testers()) != c.tests {
+			t.Errorf("ForSource(%q): got %d testers, want %d", c.sid, len(p.Testers()), c.tests)
+		}
+	}
+	var invalid = []string{
+		``,
+		`profiles: {}`,
+		`profiles: {a: {tests: []}}`,
+		`profiles: {a: {tests: [no-such-test]}}`,
+		`profiles: {a: {tests: [date], earliest-date: "18th century"}}`,
+		`profiles: {a: {tests: [date], max-error-ratio: 2}}`,
+		`profiles: {a: {tests: [date], unknown: 1}}`,
+		`profiles: {a: {tests: [stage-three], stage-three-fields: [rft.abstract]}}`,
+		`{profiles: {a: {tests: [date]}}, sources: {"49": b}}`,
+	}
+	for _, s := range invalid {
+		if _, err := ReadProfiles(strings.NewReader(s)); err == nil {
+			t.Errorf("ReadProfiles(%q): got nil, want err", s)
+		}
+	}
+	// Without a default profile, unmapped sources are not checked.
+	ps, err = ReadProfiles(strings.NewReader(`{profiles: {a: {tests: [date]}}, sources: {"49": a}}`))
+	if err != nil {
+		t.Fatalf("ReadProfiles: got %v, want nil", err)
+	}
+	if _, ok := ps.ForSource("48"); ok {
+		t.Errorf("ForSource: got profile, want none")
+	}
+}
+
+func TestProfileThresholds(t *testing.T) {
+	ps, err := ReadProfiles(strings.NewReader(ExampleProfiles))
+	if err != nil {
+		t.Fatalf("ReadProfiles: got %v, want nil", err)
+	}
+	strict, _ := ps.ForSource("49")
+	run := func(is finc.IntermediateSchema) error {
+		for _, t := range strict.Testers() {
+			if err := t.TestRecord(is); err != nil {
+				return err.(Issue).Err
+			}
+		}
+		return nil
+	}
+	ok := finc.IntermediateSchema{
+		ArticleTitle: "On Things",
+		Date:         time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC),
+		Authors:      []finc.Author{{Name: "Jane Doe"}},
+	}
+	if err := run(ok); err != nil {
+		t.Errorf("got %v, want nil", err)
+	}
+	var cases = []struct {
+		about string
+		f     func(is *finc.IntermediateSchema)
+		want  error
+	}{
+		{"early date", func(is *finc.IntermediateSchema) {
+			is.Date = time.Date(1790, 1, 1, 0, 0, 0, 0, time.UTC)
+		}, ErrPublicationDateTooEarly},
+		{"long title", func(is *finc.IntermediateSchema) {
+			is.ArticleTitle = strings.Repeat("x", 301)
+		}, ErrTitleTooLong},
+		{"blacklisted author", func(is *finc.IntermediateSchema) {
+			is.Authors = []finc.Author{{Name: "Anonymous Author"}}
+		}, ErrBlacklistedWordInAuthorName},
+	}
+	for _, c := range cases {
+		is := ok
+		c.f(&is)
+		if err := run(is); err != c.want {
+			t.Errorf("%s: got %v, want %v", c.about, err, c.want)
+		}
+	}
+	var ratios = []struct {
+		failed, total int64
+		want          bool
+	}{
+		{0, 0, false},
+		{5, 100, false},
+		{6, 100, true},
+	}
+	for _, r := range ratios {
+		if got := strict.Exceeded(r.failed, r.total); got != r.want {
+			t.Errorf("Exceeded(%d, %d): got %v, want %v", r.failed, r.total, got, r.want)
+		}
+	}
+	def, _ := ps.ForSource("48")
+	if def.Exceeded(100, 100) {
+		t.Errorf("Exceeded: got true for profile without limit")
+	}
+}
+
+func TestStageThreeFields(t *testing.T) {
+	ps, err := ReadProfiles(strings.NewReader(ExampleProfiles))
+	if err != nil {
+		t.Fatalf("ReadProfiles: got %v, want nil", err)
+	}
+	p, _ := ps.ForSource("28")
+	is := finc.IntermediateSchema{
+		ArticleTitle: "On Things",
+		JournalTitle: "Journal of Things",
+		Date:         time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC),
+		URL:          []string{"http://example.com"},
+		DOI:          "10.1234/things",
+		Authors:      []finc.Author{{Name: "Jane Doe"}},
+		Volume:       "1",
+		Issue:        "2",
+		Pages:        "3-4",
+		StartPage:    "3",
+		EndPage:      "4",
+	}
+	var cases = []struct {
+		abstract  string
+		languages []string
+		want      string
+	}{
+		{"", nil, "stage three fail: missing abstract, languages"},
+		{"About things.", nil, "stage three fail: missing languages"},
+		{"About things.", []string{"eng"}, ""},
+	}
+	for _, c := range cases {
+		is.Abstract, is.Languages = c.abstract, c.languages
+		var got string
+		if err := p.Testers()[0].TestRecord(is); err != nil {
+			got = err.(Issue).Err.Error()
+		}
+		if got != c.want {
+			t.Errorf("stage-three: got %q, want %q", got, c.want)
+		}
+	}
+	if err := TestFincStageThree(is); err != nil {
+		t.Errorf("TestFincStageThree: got %v, want nil", err)
+	}
+}
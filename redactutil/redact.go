@@ -0,0 +1,283 @@
+// Package redactutil removes fields from JSON records, like intermediate
+// schema or Solr documents, e.g. abstracts for licensing reasons or author
+// identifiers in samples.
+//
+// Fields are named by key. Keys may contain dots themselves, so a path like
+// "authors.x.id" is resolved by the longest matching key ("authors") and the
+// rest of the path ("x.id") is applied to each element of its value.
+package redactutil
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// Rule removes fields from records of a given source, or from all records, if
+// SourceID is empty.
+type Rule struct {
+	SourceID string
+	Fields   []string
+}
+
+// SplitFields splits a comma separated list of field names.
+func SplitFields(s string) (result []string) {
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+// ParseRule parses a rule of the form "48:abstract,x.fulltext".
+func ParseRule(s string) (Rule, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return Rule{}, fmt.Errorf("rule must be sid:field,...: %s", s)
+	}
+	fields := SplitFields(parts[1])
+	if len(fields) == 0 {
+		return Rule{}, fmt.Errorf("rule without fields: %s", s)
+	}
+	return Rule{SourceID: strings.TrimSpace(parts[0]), Fields: fields}, nil
+}
+
+// sourceKeys are the keys for the source id in intermediate schema and Solr
+// documents.
+var sourceKeys = []string{"finc.source_id", "source_id"}
+
+// Redactor removes fields from records. It is safe for concurrent use.
+type Redactor struct {
+	// Rules for fields to drop.
+	Rules []Rule
+	// Keep, if not empty, keeps only these fields, before rules are applied.
+	Keep []string
+
+	mu      sync.Mutex
+	records int64
+	counts  map[string]int64
+}
+
+// splitPath returns the longest key of doc, which is a prefix of path, and
+// the rest of the path.
+func splitPath(doc map[string]json.RawMessage, path string) (key, rest string, ok bool) {
+	if _, ok := doc[path]; ok {
+		return path, "", true
+	}
+	for i := strings.LastIndex(path, "."); i > 0; i = strings.LastIndex(path[:i], ".") {
+		if _, ok := doc[path[:i]]; ok {
+			return path[:i], path[i+1:], true
+		}
+	}
+	return "", "", false
+}
+
+// apply calls f on each object in a JSON value, which is an object or an
+// array of objects, and returns the updated value and whether f changed
+// anything.
+func apply(v json.RawMessage, f func(map[string]json.RawMessage) bool) (json.RawMessage, bool, error) {
+	var (
+		docs    []map[string]json.RawMessage
+		isArray = strings.HasPrefix(strings.TrimSpace(string(v)), "[")
+	)
+	if isArray {
+		var items []json.RawMessage
+		if err := json.Unmarshal(v, &items); err != nil {
+			return nil, false, err
+		}
+		for _, item := range items {
+			var doc map[string]json.RawMessage
+			if err := json.Unmarshal(item, &doc); err != nil {
+				// Not an array of objects, nothing to do.
+				return v, false, nil
+			}
+			docs = append(docs, doc)
+		}
+	} else {
+		var doc map[string]json.RawMessage
+		if err := json.Unmarshal(v, &doc); err != nil {
+			return v, false, nil
+		}
+		docs = append(docs, doc)
+	}
+	var changed bool
+	for _, doc := range docs {
+		if f(doc) {
+			changed = true
+		}
+	}
+	if !changed {
+		return v, false, nil
+	}
+	var (
+		b   []byte
+		err error
+	)
+	if isArray {
+		b, err = json.Marshal(docs)
+	} else {
+		b, err = json.Marshal(docs[0])
+	}
+	return b, true, err
+}
+
+// drop removes a field given by path and reports whether anything was removed.
+func drop(doc map[string]json.RawMessage, path string) (bool, error) {
+	key, rest, ok := splitPath(doc, path)
+	if !ok {
+		return false, nil
+	}
+	if rest == "" {
+		delete(doc, key)
+		return true, nil
+	}
+	var err error
+	v, changed, aerr := apply(doc[key], func(m map[string]json.RawMessage) bool {
+		ok, derr := drop(m, rest)
+		if derr != nil {
+			err = derr
+		}
+		return ok
+	})
+	if aerr != nil {
+		return false, aerr
+	}
+	if err != nil {
+		return false, err
+	}
+	doc[key] = v
+	return changed, nil
+}
+
+// project keeps only fields given by paths and reports the removed keys.
+func project(doc map[string]json.RawMessage, paths []string) ([]string, error) {
+	// key -> nested paths, an empty list means keep the whole value
+	keep := make(map[string][]string)
+	for _, p := range paths {
+		key, rest, ok := splitPath(doc, p)
+		if !ok {
+			continue
+		}
+		if rest == "" {
+			keep[key] = []string{}
+			continue
+		}
+		if nested, found := keep[key]; !found || len(nested) > 0 {
+			keep[key] = append(nested, rest)
+		}
+	}
+	var removed []string
+	for k, v := range doc {
+		nested, ok := keep[k]
+		if !ok {
+			delete(doc, k)
+			removed = append(removed, k)
+			continue
+		}
+		if len(nested) == 0 {
+			continue
+		}
+		var err error
+		w, changed, aerr := apply(v, func(m map[string]json.RawMessage) bool {
+			r, perr := project(m, nested)
+			if perr != nil {
+				err = perr
+			}
+			return len(r) > 0
+		})
+		if aerr != nil {
+			return nil, aerr
+		}
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			doc[k] = w
+			removed = append(removed, k)
+		}
+	}
+	return removed, nil
+}
+
+// sourceID returns the source id of a record.
+func sourceID(doc map[string]json.RawMessage) string {
+	for _, k := range sourceKeys {
+		if v, ok := doc[k]; ok {
+			var s string
+			if err := json.Unmarshal(v, &s); err == nil {
+				return s
+			}
+			return strings.TrimSpace(string(v))
+		}
+	}
+	return ""
+}
+
+// Redact removes fields from a single JSON object and returns the updated
+// object.
+func (r *Redactor) Redact(b []byte) ([]byte, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	var changed []string
+	if len(r.Keep) > 0 {
+		removed, err := project(doc, r.Keep)
+		if err != nil {
+			return nil, err
+		}
+		changed = append(changed, removed...)
+	}
+	sid := sourceID(doc)
+	for _, rule := range r.Rules {
+		if rule.SourceID != "" && rule.SourceID != sid {
+			continue
+		}
+		for _, f := range rule.Fields {
+			ok, err := drop(doc, f)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				changed = append(changed, f)
+			}
+		}
+	}
+	r.mu.Lock()
+	if r.counts == nil {
+		r.counts = make(map[string]int64)
+	}
+	r.records++
+	seen := make(map[string]bool)
+	for _, f := range changed {
+		if !seen[f] {
+			r.counts[f]++
+			seen[f] = true
+		}
+	}
+	r.mu.Unlock()
+	return json.Marshal(doc)
+}
+
+// FieldCount is the number of records changed per field.
+type FieldCount struct {
+	Field string
+	Count int64
+}
+
+// Counts returns the number of records seen and the number of records changed
+// per field, sorted by field.
+func (r *Redactor) Counts() (int64, []FieldCount) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var result []FieldCount
+	for k, v := range r.counts {
+		result = append(result, FieldCount{Field: k, Count: v})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Field < result[j].Field })
+	return r.records, result
+}
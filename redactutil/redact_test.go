@@ -0,0 +1,94 @@
+package redactutil
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRule(t *testing.T) {
+	r, err := ParseRule("48:abstract, x.fulltext")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Rule{SourceID: "48", Fields: []string{"abstract", "x.fulltext"}}); !reflect.DeepEqual(r, want) {
+		t.Errorf("ParseRule: got %v, want %v", r, want)
+	}
+	for _, s := range []string{"abstract", ":abstract", "48:", "48: , "} {
+		if _, err := ParseRule(s); err == nil {
+			t.Errorf("ParseRule(%q): got nil, want error", s)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	var cases = []struct {
+		about    string
+		redactor *Redactor
+		input    string
+		want     string
+	}{
+		{
+			"drop top level keys with dots",
+			&Redactor{Rules: []Rule{{Fields: []string{"x.fulltext", "missing"}}}},
+			`{"finc.source_id": "49", "x.fulltext": "...", "rft.atitle": "A"}`,
+			`{"finc.source_id":"49","rft.atitle":"A"}`,
+		},
+		{
+			"per source rule, other source",
+			&Redactor{Rules: []Rule{{SourceID: "48", Fields: []string{"abstract"}}}},
+			`{"finc.source_id": "49", "abstract": "..."}`,
+			`{"abstract":"...","finc.source_id":"49"}`,
+		},
+		{
+			"per source rule, solr document",
+			&Redactor{Rules: []Rule{{SourceID: "48", Fields: []string{"abstract", "fullrecord"}}}},
+			`{"source_id": "48", "abstract": "...", "fullrecord": "{}", "_version_": 1690000000000000001}`,
+			`{"_version_":1690000000000000001,"source_id":"48"}`,
+		},
+		{
+			"nested path",
+			&Redactor{Rules: []Rule{{Fields: []string{"authors.x.id"}}}},
+			`{"authors": [{"rft.au": "A", "x.id": "1"}, {"rft.au": "B"}], "x.id": "keep"}`,
+			`{"authors":[{"rft.au":"A"},{"rft.au":"B"}],"x.id":"keep"}`,
+		},
+		{
+			"keep only",
+			&Redactor{Keep: []string{"finc.id", "authors.rft.au"}},
+			`{"finc.id": "1", "abstract": "...", "authors": [{"rft.au": "A", "x.id": "1"}]}`,
+			`{"authors":[{"rft.au":"A"}],"finc.id":"1"}`,
+		},
+		{
+			"keep whole value",
+			&Redactor{Keep: []string{"authors.rft.au", "authors"}},
+			`{"authors": [{"rft.au": "A", "x.id": "1"}], "abstract": "..."}`,
+			`{"authors":[{"rft.au":"A","x.id":"1"}]}`,
+		},
+	}
+	for _, c := range cases {
+		b, err := c.redactor.Redact([]byte(c.input))
+		if err != nil {
+			t.Fatalf("%s: %v", c.about, err)
+		}
+		if string(b) != c.want {
+			t.Errorf("%s: got %s, want %s", c.about, string(b), c.want)
+		}
+	}
+	r := &Redactor{Rules: []Rule{{Fields: []string{"abstract"}}, {SourceID: "48", Fields: []string{"x.fulltext"}}}}
+	for _, s := range []string{
+		`{"finc.source_id": "48", "abstract": "a", "x.fulltext": "b"}`,
+		`{"finc.source_id": "49", "abstract": "a", "x.fulltext": "b"}`,
+		`{"finc.source_id": "49"}`,
+	} {
+		if _, err := r.Redact([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, counts := r.Counts()
+	want := []FieldCount{{"abstract", 2}, {"x.fulltext", 1}}
+	if n != 3 || !reflect.DeepEqual(counts, want) {
+		t.Errorf("Counts: got %d %v, want 3 %v", n, counts, want)
+	}
+	if _, err := r.Redact([]byte(`[]`)); err == nil {
+		t.Errorf("Redact: got nil, want error for array")
+	}
+}
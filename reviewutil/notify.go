@@ -0,0 +1,232 @@
+package reviewutil
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/miku/span/configutil"
+	"github.com/segmentio/encoding/json"
+	"github.com/sethgrid/pester"
+)
+
+// Markup languages understood by the notification backends.
+const (
+	Textile  = "textile"
+	Markdown = "markdown"
+	Text     = "text"
+)
+
+// Notifier posts a message to a ticket or some other channel.
+type Notifier interface {
+	// Name of the backend, e.g. "redmine".
+	Name() string
+	// Markup returns the markup the message body should use.
+	Markup() string
+	// Notify sends a message, concerning a ticket.
+	Notify(ticket, subject, body string) error
+}
+
+// Render renders a message body in a given markup.
+type Render func(markup string) (string, error)
+
+// Broadcast sends a message to all notifiers, rendering the body in the
+// markup each notifier prefers. All notifiers are tried, the first error is
+// returned.
+func Broadcast(notifiers []Notifier, ticket, subject string, render Render) error {
+	var (
+		bodies   = make(map[string]string)
+		firstErr error
+	)
+	for _, n := range notifiers {
+		body, ok := bodies[n.Markup()]
+		if !ok {
+			var err error
+			if body, err = render(n.Markup()); err != nil {
+				return err
+			}
+			bodies[n.Markup()] = body
+		}
+		if err := n.Notify(ticket, subject, body); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%s: %v", n.Name(), err)
+		}
+	}
+	return firstErr
+}
+
+// Notifiers returns the notifiers listed in the config, by default Redmine
+// only. Returns an error for unknown or incompletely configured backends.
+func Notifiers(c *configutil.Config) (result []Notifier, err error) {
+	names := c.Notify
+	if strings.TrimSpace(names) == "" {
+		names = "redmine"
+	}
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "redmine":
+			if c.RedmineURL == "" || c.RedmineToken == "" {
+				return nil, fmt.Errorf("redmine requires redmine.baseurl and redmine.apitoken")
+			}
+			result = append(result, &Redmine{BaseURL: c.RedmineURL, Token: c.RedmineToken})
+		case "gitlab":
+			if c.GitLabURL == "" || c.GitLabToken == "" || c.GitLabProject == "" {
+				return nil, fmt.Errorf("gitlab requires gitlab.url, gitlab.token and gitlab.project")
+			}
+			result = append(result, &GitLabIssue{BaseURL: c.GitLabURL, Token: c.GitLabToken, Project: c.GitLabProject})
+		case "webhook":
+			if c.NotifyWebhookURL == "" {
+				return nil, fmt.Errorf("webhook requires notify.webhook.url")
+			}
+			result = append(result, &Webhook{URL: c.NotifyWebhookURL, Token: c.NotifyWebhookToken})
+		case "smtp":
+			if c.SMTPAddr == "" || c.SMTPFrom == "" || c.SMTPTo == "" {
+				return nil, fmt.Errorf("smtp requires smtp.addr, smtp.from and smtp.to")
+			}
+			result = append(result, &SMTP{
+				Addr:     c.SMTPAddr,
+				From:     c.SMTPFrom,
+				To:       strings.Split(c.SMTPTo, ","),
+				Username: c.SMTPUsername,
+				Password: c.SMTPPassword,
+			})
+		default:
+			return nil, fmt.Errorf("unknown notifier: %s", name)
+		}
+	}
+	return result, nil
+}
+
+// Name returns "redmine".
+func (r *Redmine) Name() string { return "redmine" }
+
+// Markup returns textile.
+func (r *Redmine) Markup() string { return Textile }
+
+// Notify adds a note to a Redmine issue.
+func (r *Redmine) Notify(ticket, subject, body string) error {
+	return r.UpdateTicket(ticket, fmt.Sprintf("%s\n\n%s", subject, body))
+}
+
+// doJSON sends a JSON payload and checks the response status.
+func doJSON(method, link string, payload interface{}, header http.Header) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, link, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := pester.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s resulted in a %d: %s", method, link, resp.StatusCode, b)
+	}
+	return nil
+}
+
+// GitLabIssue adds comments to issues of a GitLab project.
+// https://docs.gitlab.com/ee/api/notes.html#create-new-issue-note
+type GitLabIssue struct {
+	BaseURL string // e.g. https://gitlab.com
+	Token   string // personal or project access token
+	Project string // numeric id or path, e.g. "group/project"
+}
+
+// Name returns "gitlab".
+func (g *GitLabIssue) Name() string { return "gitlab" }
+
+// Markup returns markdown.
+func (g *GitLabIssue) Markup() string { return Markdown }
+
+// NoteLink returns the API endpoint for notes of an issue.
+func (g *GitLabIssue) NoteLink(ticket string) string {
+	return fmt.Sprintf("%s/api/v4/projects/%s/issues/%s/notes",
+		strings.TrimRight(g.BaseURL, "/"), url.PathEscape(g.Project), ticket)
+}
+
+// Notify adds a comment to an issue.
+func (g *GitLabIssue) Notify(ticket, subject, body string) error {
+	header := http.Header{}
+	header.Set("PRIVATE-TOKEN", g.Token)
+	payload := map[string]string{"body": fmt.Sprintf("%s\n\n%s", subject, body)}
+	return doJSON("POST", g.NoteLink(ticket), payload, header)
+}
+
+// Webhook posts messages as JSON to an URL, e.g. a chat integration.
+type Webhook struct {
+	URL   string
+	Token string // sent as bearer token, if not empty
+}
+
+// WebhookPayload is sent by the generic webhook notifier.
+type WebhookPayload struct {
+	Ticket  string    `json:"ticket"`
+	Subject string    `json:"subject"`
+	Body    string    `json:"body"`
+	Date    time.Time `json:"date"`
+}
+
+// Name returns "webhook".
+func (w *Webhook) Name() string { return "webhook" }
+
+// Markup returns markdown.
+func (w *Webhook) Markup() string { return Markdown }
+
+// Notify posts the message.
+func (w *Webhook) Notify(ticket, subject, body string) error {
+	header := http.Header{}
+	if w.Token != "" {
+		header.Set("Authorization", "Bearer "+w.Token)
+	}
+	payload := WebhookPayload{Ticket: ticket, Subject: subject, Body: body, Date: time.Now()}
+	return doJSON("POST", w.URL, payload, header)
+}
+
+// SMTP sends messages as plain text mail.
+type SMTP struct {
+	Addr     string // host:port
+	From     string
+	To       []string
+	Username string // optional, PLAIN authentication requires TLS or localhost
+	Password string
+}
+
+// Name returns "smtp".
+func (s *SMTP) Name() string { return "smtp" }
+
+// Markup returns text.
+func (s *SMTP) Markup() string { return Text }
+
+// Notify sends a mail, with the ticket in the subject.
+func (s *SMTP) Notify(ticket, subject, body string) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host := strings.Split(s.Addr, ":")[0]
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	if ticket != "" {
+		subject = fmt.Sprintf("[%s] %s", ticket, subject)
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", s.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	buf.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+	return smtp.SendMail(s.Addr, auth, s.From, s.To, buf.Bytes())
+}
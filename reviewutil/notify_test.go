@@ -0,0 +1,186 @@
+package reviewutil
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/miku/span/configutil"
+	"github.com/segmentio/encoding/json"
+)
+
+// request is a request received by a stand-in server.
+type request struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   string
+}
+
+// recordServer records requests and answers with 200 OK.
+func recordServer(reqs *[]request) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		*reqs = append(*reqs, request{r.Method, r.URL.EscapedPath(), r.Header, string(b)})
+	}))
+}
+
+// smtpServer is a minimal SMTP stand-in, which accepts a single mail and
+// sends the data to a channel.
+func smtpServer(t *testing.T) (addr string, mail chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mail = make(chan string, 1)
+	go func() {
+		defer ln.Close()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tp := textproto.NewConn(conn)
+		tp.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.Fields(line + " ")[0]); cmd {
+			case "EHLO", "HELO", "MAIL", "RCPT", "RSET", "NOOP":
+				tp.PrintfLine("250 OK")
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				b, _ := ioutil.ReadAll(bufio.NewReader(tp.DotReader()))
+				mail <- string(b)
+				tp.PrintfLine("250 OK")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				return
+			default:
+				tp.PrintfLine("502 not implemented")
+			}
+		}
+	}()
+	return ln.Addr().String(), mail
+}
+
+func TestNotifiers(t *testing.T) {
+	var reqs []request
+	ts := recordServer(&reqs)
+	defer ts.Close()
+	addr, mail := smtpServer(t)
+
+	config := &configutil.Config{
+		Notify:             "redmine, gitlab,webhook,smtp",
+		RedmineURL:         ts.URL + "/redmine",
+		RedmineToken:       "r1",
+		GitLabURL:          ts.URL,
+		GitLabToken:        "g1",
+		GitLabProject:      "group/project",
+		NotifyWebhookURL:   ts.URL + "/hook",
+		NotifyWebhookToken: "w1",
+		SMTPAddr:           addr,
+		SMTPFrom:           "span@example.com",
+		SMTPTo:             "a@example.com,b@example.com",
+	}
+	notifiers, err := Notifiers(config)
+	if err != nil {
+		t.Fatalf("Notifiers: got %v, want nil", err)
+	}
+	if len(notifiers) != 4 {
+		t.Fatalf("Notifiers: got %d, want 4", len(notifiers))
+	}
+	var rendered []string
+	render := func(markup string) (string, error) {
+		rendered = append(rendered, markup)
+		return fmt.Sprintf("body in %s", markup), nil
+	}
+	if err := Broadcast(notifiers, "123", "results", render); err != nil {
+		t.Fatalf("Broadcast: got %v, want nil", err)
+	}
+	if want := "textile markdown text"; strings.Join(rendered, " ") != want {
+		t.Errorf("rendered: got %v, want %v", rendered, want)
+	}
+	if len(reqs) != 3 {
+		t.Fatalf("got %d requests, want 3", len(reqs))
+	}
+
+	// Redmine
+	if reqs[0].Method != "PUT" || reqs[0].Path != "/redmine/issues/123.json" {
+		t.Errorf("redmine: got %s %s", reqs[0].Method, reqs[0].Path)
+	}
+	if v := reqs[0].Header.Get("X-Redmine-API-Key"); v != "r1" {
+		t.Errorf("redmine: got key %q, want r1", v)
+	}
+	var issue struct {
+		Issue struct {
+			Notes string `json:"notes"`
+		} `json:"issue"`
+	}
+	if err := json.Unmarshal([]byte(reqs[0].Body), &issue); err != nil {
+		t.Fatal(err)
+	}
+	if want := "results\n\nbody in textile"; issue.Issue.Notes != want {
+		t.Errorf("redmine: got %q, want %q", issue.Issue.Notes, want)
+	}
+
+	// GitLab
+	if reqs[1].Method != "POST" || reqs[1].Path != "/api/v4/projects/group%2Fproject/issues/123/notes" {
+		t.Errorf("gitlab: got %s %s", reqs[1].Method, reqs[1].Path)
+	}
+	if v := reqs[1].Header.Get("PRIVATE-TOKEN"); v != "g1" {
+		t.Errorf("gitlab: got token %q, want g1", v)
+	}
+	if !strings.Contains(reqs[1].Body, "body in markdown") {
+		t.Errorf("gitlab: got %s", reqs[1].Body)
+	}
+
+	// Webhook
+	if v := reqs[2].Header.Get("Authorization"); v != "Bearer w1" {
+		t.Errorf("webhook: got %q, want bearer token", v)
+	}
+	var payload WebhookPayload
+	if err := json.Unmarshal([]byte(reqs[2].Body), &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Ticket != "123" || payload.Subject != "results" || payload.Body != "body in markdown" {
+		t.Errorf("webhook: got %+v", payload)
+	}
+
+	// SMTP
+	m := <-mail
+	for _, s := range []string{"Subject: [123] results", "To: a@example.com, b@example.com", "body in text"} {
+		if !strings.Contains(m, s) {
+			t.Errorf("smtp: %q missing in %q", s, m)
+		}
+	}
+}
+
+func TestNotifiersConfig(t *testing.T) {
+	var cases = []struct {
+		config configutil.Config
+		n      int
+		err    bool
+	}{
+		{configutil.Config{RedmineURL: "x", RedmineToken: "y"}, 1, false},
+		{configutil.Config{}, 0, true},
+		{configutil.Config{Notify: "gitlab", GitLabURL: "x", GitLabToken: "y"}, 0, true},
+		{configutil.Config{Notify: "webhook", NotifyWebhookURL: "x"}, 1, false},
+		{configutil.Config{Notify: "smtp", SMTPAddr: "x:25", SMTPFrom: "a"}, 0, true},
+		{configutil.Config{Notify: "pigeon"}, 0, true},
+	}
+	for _, c := range cases {
+		ns, err := Notifiers(&c.config)
+		if (err != nil) != c.err || len(ns) != c.n {
+			t.Errorf("Notifiers(%+v): got %d, %v, want %d, error %v", c.config, len(ns), err, c.n, c.err)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package reviewutil
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// ExitCasesFailed is the exit code of span-review, if the review ran, but
+// cases failed. It differs from the exit code 1 of a failing program, so
+// callers like span-webhookd can tell failed cases from a failed review.
+const ExitCasesFailed = 3
+
+// Result represents a single review case outcome.
+type Result struct {
+	Kind             string  `json:"kind"`
+	SourceIdentifier string  `json:"source_id"`
+	Query            string  `json:"query"`
+	SolrField        string  `json:"field,omitempty"`
+	Expected         string  `json:"expected"`
+	Observed         string  `json:"observed"`
+	Tolerance        float64 `json:"tolerance,omitempty"`
+	Link             string  `json:"link"`
+	FixedResult      bool    `json:"fixed"`
+	Passed           bool    `json:"passed"`
+	Comment          string  `json:"comment"`
+}
+
+// Key identifies a review case across runs.
+func (r Result) Key() string {
+	return strings.Join([]string{r.Kind, r.Query, r.SolrField, r.Expected}, " ")
+}
+
+// Report is the outcome of a single review run against a SOLR server.
+type Report struct {
+	Server     string    `json:"server"`
+	Date       time.Time `json:"date"`
+	Hostname   string    `json:"hostname,omitempty"`
+	Version    string    `json:"version,omitempty"`
+	ReviewFile string    `json:"review,omitempty"`
+	Results    []Result  `json:"results"`
+}
+
+// Failures returns the number of failed cases.
+func (r *Report) Failures() (n int) {
+	for _, v := range r.Results {
+		if !v.Passed {
+			n++
+		}
+	}
+	return n
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// junitTestSuite is a minimal JUnit XML test suite, understood by most CI
+// systems.
+type junitTestSuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Hostname  string          `xml:"hostname,attr,omitempty"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the report as JUnit XML, one test case per review case.
+func (r *Report) WriteJUnit(w io.Writer) error {
+	suite := junitTestSuite{
+		Name:      fmt.Sprintf("span-review %s", r.Server),
+		Tests:     len(r.Results),
+		Failures:  r.Failures(),
+		Timestamp: r.Date.Format("2006-01-02T15:04:05"),
+		Hostname:  r.Hostname,
+	}
+	for _, v := range r.Results {
+		tc := junitTestCase{
+			Name:      strings.TrimSpace(fmt.Sprintf("%s %s %s", v.Query, v.SolrField, v.Expected)),
+			ClassName: fmt.Sprintf("span-review.%s", v.Kind),
+			SystemOut: v.Link,
+		}
+		if !v.Passed {
+			tc.Failure = &junitFailure{
+				Message: fmt.Sprintf("expected %s, observed %s", v.Expected, v.Observed),
+				Text:    v.Comment,
+			}
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suite); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// History stores review reports in a directory, one newline delimited JSON
+// file per SOLR server, one report per line.
+type History struct {
+	Dir string
+}
+
+var nonAlnum = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// Filename returns the path to the history file for a given server.
+func (h *History) Filename(server string) string {
+	key := strings.TrimPrefix(strings.TrimPrefix(server, "http://"), "https://")
+	key = strings.Trim(nonAlnum.ReplaceAllString(key, "_"), "_")
+	return filepath.Join(h.Dir, key+".ndj")
+}
+
+// Save appends a report to the history of its server.
+func (h *History) Save(r *Report) error {
+	if err := os.MkdirAll(h.Dir, 0755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(h.Filename(r.Server), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Reports returns all reports recorded for a server, oldest first.
+func (h *History) Reports(server string) (result []*Report, err error) {
+	f, err := os.Open(h.Filename(server))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	for {
+		b, err := br.ReadBytes('\n')
+		if err == io.EOF && len(b) == 0 {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		var r Report
+		if err := json.Unmarshal(b, &r); err != nil {
+			return nil, fmt.Errorf("%s: %v", h.Filename(server), err)
+		}
+		result = append(result, &r)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Date.Before(result[j].Date)
+	})
+	return result, nil
+}
+
+// SelectReport picks a report from reports sorted oldest first. A run is
+// given by index, counting from zero, or from the end with negative numbers
+// (-1 is the most recent run), or by timestamp (RFC3339 or YYYY-MM-DD),
+// selecting the most recent run at or before that time.
+func SelectReport(reports []*Report, run string) (*Report, error) {
+	if i, err := strconv.Atoi(run); err == nil {
+		if i < 0 {
+			i += len(reports)
+		}
+		if i < 0 || i >= len(reports) {
+			return nil, fmt.Errorf("run %s out of range, %d runs", run, len(reports))
+		}
+		return reports[i], nil
+	}
+	var (
+		t   time.Time
+		err error
+	)
+	if t, err = time.Parse(time.RFC3339, run); err != nil {
+		if t, err = time.Parse("2006-01-02", run); err != nil {
+			return nil, fmt.Errorf("run must be an index or timestamp: %s", run)
+		}
+		// A date includes the whole day.
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	var result *Report
+	for _, r := range reports {
+		if r.Date.After(t) {
+			break
+		}
+		result = r
+	}
+	if result == nil {
+		return nil, fmt.Errorf("no run at or before %s", run)
+	}
+	return result, nil
+}
+
+// Change describes how the outcome of a review case changed between two runs.
+type Change struct {
+	Status string // "failing", "fixed", "added" or "removed"
+	Before *Result
+	After  *Result
+}
+
+// Result returns the most recent result of a change.
+func (c Change) Result() *Result {
+	if c.After != nil {
+		return c.After
+	}
+	return c.Before
+}
+
+// Changes compares two reports and returns review cases, which started
+// failing, were fixed, added or removed between the two, in the order of the
+// later report.
+func Changes(before, after *Report) (result []Change) {
+	var (
+		prev = make(map[string]*Result)
+		seen = make(map[string]bool)
+	)
+	for i := range before.Results {
+		prev[before.Results[i].Key()] = &before.Results[i]
+	}
+	for i := range after.Results {
+		r := &after.Results[i]
+		key := r.Key()
+		seen[key] = true
+		p, ok := prev[key]
+		switch {
+		case !ok:
+			result = append(result, Change{Status: "added", After: r})
+		case p.Passed && !r.Passed:
+			result = append(result, Change{Status: "failing", Before: p, After: r})
+		case !p.Passed && r.Passed:
+			result = append(result, Change{Status: "fixed", Before: p, After: r})
+		}
+	}
+	for i := range before.Results {
+		r := &before.Results[i]
+		if !seen[r.Key()] {
+			result = append(result, Change{Status: "removed", Before: r})
+		}
+	}
+	return result
+}
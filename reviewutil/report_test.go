@@ -0,0 +1,121 @@
+package reviewutil
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "span-reviewutil-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var (
+		history = &History{Dir: dir}
+		server  = "http://localhost:8983/solr/biblio"
+		now     = time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC)
+		before  = &Report{Server: server, Date: now.Add(-24 * time.Hour), Results: []Result{
+			{Kind: "min-count", Query: "source_id:1", SolrField: "format", Expected: "x >= 1", Passed: true},
+			{Kind: "min-count", Query: "source_id:2", SolrField: "format", Expected: "x >= 1", Passed: false},
+			{Kind: "min-count", Query: "source_id:3", SolrField: "format", Expected: "x >= 1", Passed: true},
+		}}
+		after = &Report{Server: server, Date: now, Results: []Result{
+			{Kind: "min-count", Query: "source_id:1", SolrField: "format", Expected: "x >= 1", Passed: false},
+			{Kind: "min-count", Query: "source_id:2", SolrField: "format", Expected: "x >= 1", Passed: true},
+			{Kind: "max-count", Query: "source_id:4", SolrField: "format", Expected: "x <= 1", Passed: true},
+		}}
+	)
+	// Save out of order, reports are sorted by date.
+	for _, r := range []*Report{after, before} {
+		if err := history.Save(r); err != nil {
+			t.Fatalf("Save: got %v, want nil", err)
+		}
+	}
+	reports, err := history.Reports(server)
+	if err != nil {
+		t.Fatalf("Reports: got %v, want nil", err)
+	}
+	if len(reports) != 2 {
+		t.Fatalf("Reports: got %d, want 2", len(reports))
+	}
+	if !reports[0].Date.Equal(before.Date) {
+		t.Errorf("Reports: got %v, want oldest first", reports[0].Date)
+	}
+	if reports, _ := history.Reports("http://other"); len(reports) != 0 {
+		t.Errorf("Reports: got %d reports for unknown server, want 0", len(reports))
+	}
+	var statuses []string
+	for _, c := range Changes(reports[0], reports[1]) {
+		statuses = append(statuses, c.Status+" "+c.Result().Query)
+	}
+	want := []string{"failing source_id:1", "fixed source_id:2", "added source_id:4", "removed source_id:3"}
+	if len(statuses) != len(want) {
+		t.Fatalf("Changes: got %v, want %v", statuses, want)
+	}
+	for i := range want {
+		if statuses[i] != want[i] {
+			t.Errorf("Changes: got %v, want %v", statuses, want)
+			break
+		}
+	}
+}
+
+func TestSelectReport(t *testing.T) {
+	var reports []*Report
+	for i := 1; i <= 3; i++ {
+		reports = append(reports, &Report{Date: time.Date(2022, 1, i, 12, 0, 0, 0, time.UTC)})
+	}
+	var cases = []struct {
+		run  string
+		want int // index, -1 for error
+	}{
+		{"0", 0},
+		{"2", 2},
+		{"-1", 2},
+		{"-3", 0},
+		{"3", -1},
+		{"-4", -1},
+		{"2022-01-02", 1},
+		{"2022-01-02T11:00:00Z", 0},
+		{"2022-01-05", 2},
+		{"2021-12-31", -1},
+		{"yesterday", -1},
+	}
+	for _, c := range cases {
+		r, err := SelectReport(reports, c.run)
+		switch {
+		case c.want < 0 && err == nil:
+			t.Errorf("SelectReport(%s): got %v, want error", c.run, r.Date)
+		case c.want >= 0 && err != nil:
+			t.Errorf("SelectReport(%s): got %v, want run %d", c.run, err, c.want)
+		case c.want >= 0 && r != reports[c.want]:
+			t.Errorf("SelectReport(%s): got %v, want %v", c.run, r.Date, reports[c.want].Date)
+		}
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	r := &Report{Server: "http://localhost", Results: []Result{
+		{Kind: "min-count", Query: "source_id:1", Expected: "x >= 1", Observed: "x 2", Passed: true},
+		{Kind: "min-count", Query: "source_id:2", Expected: "x >= 1", Observed: "x 0", Passed: false},
+	}}
+	var buf bytes.Buffer
+	if err := r.WriteJUnit(&buf); err != nil {
+		t.Fatalf("WriteJUnit: got %v, want nil", err)
+	}
+	var suite junitTestSuite
+	if err := xml.Unmarshal(buf.Bytes(), &suite); err != nil {
+		t.Fatalf("WriteJUnit: invalid XML: %v", err)
+	}
+	if suite.Tests != 2 || suite.Failures != 1 {
+		t.Errorf("WriteJUnit: got %d tests, %d failures, want 2, 1", suite.Tests, suite.Failures)
+	}
+	if suite.Cases[0].Failure != nil || suite.Cases[1].Failure == nil {
+		t.Errorf("WriteJUnit: failures not marked correctly")
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/segmentio/encoding/json"
 
@@ -15,15 +17,49 @@ import (
 	yaml "gopkg.in/yaml.v2"
 )
 
+// TolerancePrefix marks an optional last element of a review case as
+// tolerance in percent, e.g. "~3" or "~2.5%".
+const TolerancePrefix = "~"
+
 // ReviewConfig contains various index review cases and general configuration.
 type ReviewConfig struct {
 	SolrServer        string     `yaml:"solr"`
+	LiveServer        string     `yaml:"live"`
 	Ticket            string     `yaml:"ticket"`
 	ZeroResultsPolicy string     `yaml:"zero-results-policy"`
+	Tolerance         float64    `yaml:"tolerance"`
 	AllowedKeys       [][]string `yaml:"allowed-keys"`
 	AllRecords        [][]string `yaml:"all-records"`
 	MinRatio          [][]string `yaml:"min-ratio"`
 	MinCount          [][]string `yaml:"min-count"`
+	MaxCount          [][]string `yaml:"max-count"`
+	FieldPresentRatio [][]string `yaml:"field-present-ratio"`
+	CompareToLive     [][]string `yaml:"compare-to-live"`
+	FacetRegex        [][]string `yaml:"facet-regex"`
+}
+
+// NumCases returns the total number of review cases.
+func (rc *ReviewConfig) NumCases() int {
+	return len(rc.AllowedKeys) + len(rc.AllRecords) + len(rc.MinRatio) +
+		len(rc.MinCount) + len(rc.MaxCount) + len(rc.FieldPresentRatio) +
+		len(rc.CompareToLive) + len(rc.FacetRegex)
+}
+
+// SplitTolerance separates an optional trailing tolerance, like "~3", from a
+// review case. If the case carries no tolerance, the config default is used.
+func (rc *ReviewConfig) SplitTolerance(c []string) ([]string, float64, error) {
+	if len(c) == 0 || !strings.HasPrefix(c[len(c)-1], TolerancePrefix) {
+		return c, rc.Tolerance, nil
+	}
+	v := strings.TrimSuffix(strings.TrimPrefix(c[len(c)-1], TolerancePrefix), "%")
+	t, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid tolerance: %s", c[len(c)-1])
+	}
+	if t < 0 || t > 100 {
+		return nil, 0, fmt.Errorf("tolerance must be between 0 and 100: %s", c[len(c)-1])
+	}
+	return c[:len(c)-1], t, nil
 }
 
 // ReadFrom can populate a config from a YAML stream.
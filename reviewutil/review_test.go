@@ -3,6 +3,7 @@ package reviewutil
 import (
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
 )
 
@@ -29,3 +30,28 @@ func TestUpdateTicket(t *testing.T) {
 		t.Errorf("UpdateTicket got %v, want nil", err)
 	}
 }
+
+func TestSplitTolerance(t *testing.T) {
+	rc := ReviewConfig{Tolerance: 1}
+	var cases = []struct {
+		c         []string
+		want      []string
+		tolerance float64
+		err       bool
+	}{
+		{[]string{"source_id:1", "format", "eBook"}, []string{"source_id:1", "format", "eBook"}, 1, false},
+		{[]string{"source_id:1", "format", "eBook", "~3"}, []string{"source_id:1", "format", "eBook"}, 3, false},
+		{[]string{"source_id:1", "format", "eBook", "~2.5%"}, []string{"source_id:1", "format", "eBook"}, 2.5, false},
+		{[]string{"source_id:1", "~x"}, nil, 0, true},
+		{[]string{"source_id:1", "~101"}, nil, 0, true},
+	}
+	for _, c := range cases {
+		got, tolerance, err := rc.SplitTolerance(c.c)
+		if (err != nil) != c.err {
+			t.Errorf("SplitTolerance(%v): got %v, want error %v", c.c, err, c.err)
+		}
+		if !reflect.DeepEqual(got, c.want) || tolerance != c.tolerance {
+			t.Errorf("SplitTolerance(%v): got %v %v, want %v %v", c.c, got, tolerance, c.want, c.tolerance)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package solrutil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// DefaultClient is used by an Index without a client.
+var DefaultClient = &Client{
+	Timeout:      60 * time.Second,
+	MaxRetries:   3,
+	Backoff:      500 * time.Millisecond,
+	MaxURLLength: 4096,
+}
+
+// DefaultCursorRows is the number of documents fetched per request, when
+// iterating over documents.
+const DefaultCursorRows = 1000
+
+// Client sends requests to SOLR, with timeouts and retries.
+type Client struct {
+	Timeout      time.Duration // per request, zero means no timeout
+	MaxRetries   int           // retries after the first attempt
+	Backoff      time.Duration // wait before the first retry, doubled for each retry
+	MaxURLLength int           // queries with longer parameters are sent via POST, zero means GET only
+	HTTPClient   *http.Client  // if nil, a client with the given timeout is used
+}
+
+// NewClient returns a client with a given timeout and number of retries and
+// defaults otherwise.
+func NewClient(timeout time.Duration, retries int) *Client {
+	c := *DefaultClient
+	c.Timeout, c.MaxRetries = timeout, retries
+	return &c
+}
+
+// StatusError is returned for HTTP errors from SOLR.
+type StatusError struct {
+	StatusCode int
+	Link       string
+	Body       string
+}
+
+// Error returns the status and the beginning of the response.
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("select failed with HTTP %d at %s: %s", e.StatusCode, e.Link, e.Body)
+}
+
+// retryable returns true for errors, which may go away, when we try again:
+// network errors and timeouts, responses cut short, server errors and HTTP
+// 429. Invalid requests, e.g. malformed URLs, and invalid responses are not
+// retried.
+func retryable(err error) bool {
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.StatusCode >= 500 || se.StatusCode == http.StatusTooManyRequests
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	// A url.Error is a net.Error itself, look at the wrapped error instead.
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		if ue.Timeout() || errors.Is(ue.Err, io.EOF) {
+			return true
+		}
+		err = ue.Err
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+// httpClient returns the client to use.
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return &http.Client{Timeout: c.Timeout}
+}
+
+// newRequest returns a GET request or, for long queries, a POST request.
+func (c *Client) newRequest(link string, vs url.Values) (*http.Request, error) {
+	params := vs.Encode()
+	if c.MaxURLLength > 0 && len(params) > c.MaxURLLength {
+		req, err := http.NewRequest("POST", link, strings.NewReader(params))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req, nil
+	}
+	return http.NewRequest("GET", link+"?"+params, nil)
+}
+
+// do sends a single request and decodes the JSON response into value.
+func (c *Client) do(link string, vs url.Values, value interface{}) error {
+	req, err := c.newRequest(link, vs)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return &StatusError{StatusCode: resp.StatusCode, Link: req.URL.String(), Body: string(b)}
+	}
+	return json.NewDecoder(resp.Body).Decode(value)
+}
+
+// Do sends parameters to a SOLR handler, e.g. http://localhost:8983/solr/biblio/select,
+// and decodes the JSON response into value. Network errors, timeouts, server
+// errors and HTTP 429 are retried with exponential backoff.
+func (c *Client) Do(link string, vs url.Values, value interface{}) (err error) {
+	if c == nil {
+		c = DefaultClient
+	}
+	if vs.Get("wt") == "" {
+		vs.Set("wt", "json")
+	}
+	backoff := c.Backoff
+	for i := 0; i <= c.MaxRetries; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err = c.do(link, vs, value); err == nil || !retryable(err) {
+			return err
+		}
+	}
+	return fmt.Errorf("giving up after %d attempts: %v", c.MaxRetries+1, err)
+}
@@ -0,0 +1,223 @@
+package solrutil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// testClient retries quickly.
+var testClient = &Client{Timeout: time.Second, MaxRetries: 2, Backoff: time.Millisecond, MaxURLLength: 100}
+
+func TestClientRetry(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"response": {"numFound": 7}}`)
+	}))
+	defer ts.Close()
+	n, err := Index{Server: ts.URL, Client: testClient}.NumFound("*:*")
+	if err != nil {
+		t.Fatalf("NumFound: got %v, want nil", err)
+	}
+	if n != 7 || calls != 3 {
+		t.Errorf("NumFound: got %d after %d calls, want 7 after 3", n, calls)
+	}
+	calls = 0
+	if _, err := (Index{Server: ts.URL, Client: &Client{MaxRetries: 1}}).NumFound("*:*"); err == nil {
+		t.Errorf("NumFound: got nil, want error after too many failures")
+	}
+}
+
+func TestClientNoRetryOnBadRequest(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		http.Error(w, "undefined field", http.StatusBadRequest)
+	}))
+	defer ts.Close()
+	_, err := Index{Server: ts.URL, Client: testClient}.NumFound("x:1")
+	if se, ok := err.(*StatusError); !ok || se.StatusCode != 400 {
+		t.Errorf("NumFound: got %v, want StatusError 400", err)
+	}
+	if calls != 1 {
+		t.Errorf("got %d calls, want 1", calls)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	var cases = []struct {
+		about string
+		err   error
+		want  bool
+	}{
+		{"server error", &StatusError{StatusCode: 503}, true},
+		{"too many requests", &StatusError{StatusCode: 429}, true},
+		{"bad request", &StatusError{StatusCode: 400}, false},
+		{"connection refused", &url.Error{Op: "Get", URL: "http://localhost:1", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, true},
+		{"connection closed", &url.Error{Op: "Get", URL: "http://localhost:1", Err: io.EOF}, true},
+		{"truncated response", io.ErrUnexpectedEOF, true},
+		{"unsupported scheme", &url.Error{Op: "Get", URL: "htp://localhost", Err: errors.New("unsupported protocol scheme")}, false},
+		{"invalid JSON", &json.SyntaxError{}, false},
+		{"other", errors.New("other"), false},
+	}
+	for _, c := range cases {
+		if got := retryable(c.err); got != c.want {
+			t.Errorf("retryable %s: got %v, want %v", c.about, got, c.want)
+		}
+	}
+}
+
+func TestClientNoRetryOnInvalidResponse(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		fmt.Fprint(w, `<html>`)
+	}))
+	defer ts.Close()
+	if _, err := (Index{Server: ts.URL, Client: testClient}).NumFound("*:*"); err == nil {
+		t.Errorf("NumFound: got nil, want error")
+	}
+	if calls != 1 {
+		t.Errorf("got %d calls, want 1", calls)
+	}
+	if _, err := (Index{Server: "htp://localhost", Client: testClient}).NumFound("*:*"); err == nil {
+		t.Errorf("NumFound: got nil, want error for invalid scheme")
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(100 * time.Millisecond)
+		fmt.Fprint(w, `{}`)
+	}))
+	defer ts.Close()
+	client := &Client{Timeout: 10 * time.Millisecond, MaxRetries: 1, Backoff: time.Millisecond}
+	if _, err := (Index{Server: ts.URL, Client: client}).NumFound("*:*"); err == nil {
+		t.Errorf("NumFound: got nil, want timeout")
+	}
+	if calls != 2 {
+		t.Errorf("got %d calls, want 2, timeouts are retried", calls)
+	}
+}
+
+func TestClientPost(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(w, `{"response": {"numFound": %d}}`, len(r.Form.Get("q")))
+		if len(r.Form.Get("q")) > 100 && r.Method != "POST" {
+			t.Errorf("got %s, want POST for long query", r.Method)
+		}
+	}))
+	defer ts.Close()
+	index := Index{Server: ts.URL, Client: testClient}
+	for _, q := range []string{"id:1", "id:(" + strings.Repeat("1 OR ", 100) + "2)"} {
+		n, err := index.NumFound(q)
+		if err != nil {
+			t.Fatalf("NumFound: got %v, want nil", err)
+		}
+		if n != int64(len(q)) {
+			t.Errorf("NumFound: server got query of length %d, want %d", n, len(q))
+		}
+	}
+}
+
+func TestDocuments(t *testing.T) {
+	// Three pages, with two, one and zero documents.
+	pages := map[string]string{
+		"*":  `{"nextCursorMark": "c1", "response": {"docs": [{"id": "1"}, {"id": "2"}]}}`,
+		"c1": `{"nextCursorMark": "c2", "response": {"docs": [{"id": "3"}]}}`,
+		"c2": `{"nextCursorMark": "c2", "response": {"docs": []}}`,
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		q := r.Form
+		if q.Get("sort") != "id asc" || q.Get("fl") != "id,title" {
+			t.Errorf("got sort=%q, fl=%q", q.Get("sort"), q.Get("fl"))
+		}
+		fmt.Fprint(w, pages[q.Get("cursorMark")])
+	}))
+	defer ts.Close()
+	var ids []string
+	err := Index{Server: ts.URL, Client: testClient}.Documents("*:*", []string{"id", "title"}, func(b json.RawMessage) error {
+		var doc struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(b, &doc); err != nil {
+			return err
+		}
+		ids = append(ids, doc.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Documents: got %v, want nil", err)
+	}
+	if strings.Join(ids, ",") != "1,2,3" {
+		t.Errorf("Documents: got %v, want 1,2,3", ids)
+	}
+}
+
+func TestPivotAndJSONFacet(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		q := r.Form
+		switch {
+		case q.Get("facet.pivot") == "source_id,format":
+			fmt.Fprint(w, `{"facet_counts": {"facet_pivot": {"source_id,format": [
+				{"field": "source_id", "value": 49, "count": 3, "pivot": [
+					{"field": "format", "value": "Article", "count": 3}]}]}}}`)
+		case q.Get("json.facet") == `{"sources":{"field":"source_id","type":"terms"}}`:
+			fmt.Fprint(w, `{"facets": {"count": 3, "sources": {"buckets": [{"val": "49", "count": 3}]}}}`)
+		default:
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+		}
+	}))
+	defer ts.Close()
+	index := Index{Server: ts.URL, Client: testClient}
+	pivot, err := index.Pivot("*:*", "source_id", "format")
+	if err != nil {
+		t.Fatalf("Pivot: got %v, want nil", err)
+	}
+	if len(pivot) != 1 || pivotValue(pivot[0].Value) != "49" || pivot[0].Pivot[0].Count != 3 {
+		t.Errorf("Pivot: got %+v", pivot)
+	}
+	facet := map[string]interface{}{
+		"sources": map[string]string{"type": "terms", "field": "source_id"},
+	}
+	raw, err := index.JSONFacet("*:*", facet)
+	if err != nil {
+		t.Fatalf("JSONFacet: got %v, want nil", err)
+	}
+	var result struct {
+		Count   int64 `json:"count"`
+		Sources struct {
+			Buckets []struct {
+				Val   string `json:"val"`
+				Count int64  `json:"count"`
+			} `json:"buckets"`
+		} `json:"sources"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Count != 3 || len(result.Sources.Buckets) != 1 {
+		t.Errorf("JSONFacet: got %+v", result)
+	}
+}
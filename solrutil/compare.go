@@ -0,0 +1,180 @@
+package solrutil
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PctChange returns the relative change from a to b in percent. A change
+// from zero counts as 100 percent.
+func PctChange(a, b int64) float64 {
+	var pct float64
+	switch {
+	case a == 0 && b > 0:
+		pct = 100
+	case a == 0:
+		pct = 0
+	default:
+		pct = (float64(b-a) / float64(a)) * 100
+	}
+	// Remove -0.00 from rendering.
+	if pct == 0 {
+		pct = math.Copysign(pct, 1)
+	}
+	return pct
+}
+
+// Row compares the number of documents of an institution and source,
+// optionally restricted to a collection, in two indices.
+type Row struct {
+	Institution string  `json:"isil"`
+	SourceID    string  `json:"sid"`
+	Collection  string  `json:"collection,omitempty"`
+	Name        string  `json:"name,omitempty"`
+	Live        int64   `json:"live"`
+	Nonlive     int64   `json:"nonlive"`
+	Diff        int64   `json:"diff"`
+	Pct         float64 `json:"pct"`
+	Exceeded    bool    `json:"exceeded,omitempty"` // dropped more than allowed
+	Link        string  `json:"link,omitempty"`
+}
+
+// FacetRow compares the frequency of a facet value in two indices.
+type FacetRow struct {
+	Field   string  `json:"field"`
+	Value   string  `json:"value"`
+	Live    int64   `json:"live"`
+	Nonlive int64   `json:"nonlive"`
+	Diff    int64   `json:"diff"`
+	Pct     float64 `json:"pct"`
+}
+
+// Comparison of two index fingerprints.
+type Comparison struct {
+	Live        string     `json:"live"`
+	LiveDate    time.Time  `json:"live_date"`
+	Nonlive     string     `json:"nonlive"`
+	NonliveDate time.Time  `json:"nonlive_date"`
+	MaxDrop     float64    `json:"max_drop,omitempty"`
+	Rows        []Row      `json:"rows"`
+	Facets      []FacetRow `json:"facets,omitempty"`
+}
+
+// CompareOptions control the level of detail of a comparison.
+type CompareOptions struct {
+	Collections bool    // add a row per collection below each source
+	Facets      bool    // compare format and facet_avail distributions
+	MaxDrop     float64 // mark rows, that dropped more than this percentage, if positive
+}
+
+// newRow fills in difference and relative change.
+func newRow(isil, sid, collection string, live, nonlive int64, maxDrop float64) Row {
+	row := Row{
+		Institution: isil,
+		SourceID:    sid,
+		Collection:  collection,
+		Live:        live,
+		Nonlive:     nonlive,
+		Diff:        nonlive - live,
+		Pct:         PctChange(live, nonlive),
+	}
+	row.Exceeded = maxDrop > 0 && row.Pct < -maxDrop
+	return row
+}
+
+// Compare compares two fingerprints, rows are sorted by institution, source
+// and collection.
+func Compare(live, nonlive *Fingerprint, opts CompareOptions) *Comparison {
+	c := &Comparison{
+		Live:        live.Server,
+		LiveDate:    live.Date,
+		Nonlive:     nonlive.Server,
+		NonliveDate: nonlive.Date,
+		MaxDrop:     opts.MaxDrop,
+	}
+	for _, key := range Keys(live, nonlive) {
+		isil, sid := key.Institution, key.SourceID
+		// TODO(miku): This should not be in the index in the first place
+		if strings.TrimSpace(isil) == "" || strings.TrimSpace(isil) == `" "` {
+			continue
+		}
+		numLive, numNonlive := live.Count(isil, sid), nonlive.Count(isil, sid)
+		// TODO(miku): Might catch too much, e.g. DOAJ, refs #14417.
+		if numLive == 0 && numNonlive == 0 {
+			continue
+		}
+		c.Rows = append(c.Rows, newRow(isil, sid, "", numLive, numNonlive, opts.MaxDrop))
+		if !opts.Collections {
+			continue
+		}
+		for _, name := range collections(live.Source(isil, sid), nonlive.Source(isil, sid)) {
+			c.Rows = append(c.Rows, newRow(isil, sid, name,
+				live.CollectionCount(isil, sid, name),
+				nonlive.CollectionCount(isil, sid, name), opts.MaxDrop))
+		}
+	}
+	if opts.Facets {
+		c.Facets = append(c.Facets, compareFacets("format", live.Formats, nonlive.Formats)...)
+		c.Facets = append(c.Facets, compareFacets("facet_avail", live.FacetAvail, nonlive.FacetAvail)...)
+	}
+	return c
+}
+
+// Exceeded returns the rows, which dropped more than allowed.
+func (c *Comparison) Exceeded() (result []Row) {
+	for _, r := range c.Rows {
+		if r.Exceeded {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// collections returns the sorted collection names found in any source count.
+func collections(scs ...*SourceCount) (result []string) {
+	seen := make(map[string]bool)
+	for _, sc := range scs {
+		if sc == nil {
+			continue
+		}
+		for k := range sc.Collections {
+			if !seen[k] {
+				seen[k] = true
+				result = append(result, k)
+			}
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// compareFacets compares two facet distributions, sorted by value.
+func compareFacets(field string, a, b FacetMap) (result []FacetRow) {
+	var keys []string
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		na, nb := int64(a[k]), int64(b[k])
+		if na == 0 && nb == 0 {
+			continue
+		}
+		result = append(result, FacetRow{
+			Field:   field,
+			Value:   k,
+			Live:    na,
+			Nonlive: nb,
+			Diff:    nb - na,
+			Pct:     PctChange(na, nb),
+		})
+	}
+	return result
+}
@@ -0,0 +1,169 @@
+package solrutil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	"github.com/miku/span/atomic"
+	"github.com/segmentio/encoding/json"
+)
+
+// FingerprintPivot are the fields, whose combinations are counted in a
+// fingerprint.
+var FingerprintPivot = []string{"institution", "source_id", "mega_collection"}
+
+// SourceCount is the number of documents of a source attached to an
+// institution, with a breakdown by collection.
+type SourceCount struct {
+	Institution string           `json:"isil"`
+	SourceID    string           `json:"sid"`
+	Count       int64            `json:"count"`
+	Collections map[string]int64 `json:"collections,omitempty"`
+}
+
+// SourceKey identifies a SourceCount.
+type SourceKey struct {
+	Institution string
+	SourceID    string
+}
+
+// Fingerprint summarizes an index, so it can be compared to other indices,
+// after the index itself is gone.
+type Fingerprint struct {
+	Server     string        `json:"server"`
+	Date       time.Time     `json:"date"`
+	NumFound   int64         `json:"numFound"`
+	Sources    []SourceCount `json:"sources"`
+	Formats    FacetMap      `json:"format"`
+	FacetAvail FacetMap      `json:"facet_avail"`
+
+	sources map[SourceKey]*SourceCount
+}
+
+// Source returns the counts for an institution and source or nil.
+func (f *Fingerprint) Source(isil, sid string) *SourceCount {
+	if f.sources == nil {
+		f.sources = make(map[SourceKey]*SourceCount)
+		for i, s := range f.Sources {
+			f.sources[SourceKey{s.Institution, s.SourceID}] = &f.Sources[i]
+		}
+	}
+	return f.sources[SourceKey{isil, sid}]
+}
+
+// Count returns the number of documents for an institution and source.
+func (f *Fingerprint) Count(isil, sid string) int64 {
+	if s := f.Source(isil, sid); s != nil {
+		return s.Count
+	}
+	return 0
+}
+
+// CollectionCount returns the number of documents for an institution, source
+// and collection.
+func (f *Fingerprint) CollectionCount(isil, sid, collection string) int64 {
+	if s := f.Source(isil, sid); s != nil {
+		return s.Collections[collection]
+	}
+	return 0
+}
+
+// Keys returns all institution and source combinations found in any of the
+// given fingerprints, sorted by institution, then source identifier.
+func Keys(fps ...*Fingerprint) (result []SourceKey) {
+	seen := make(map[SourceKey]bool)
+	for _, f := range fps {
+		for _, s := range f.Sources {
+			k := SourceKey{s.Institution, s.SourceID}
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			result = append(result, k)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Institution != result[j].Institution {
+			return result[i].Institution < result[j].Institution
+		}
+		return result[i].SourceID < result[j].SourceID
+	})
+	return result
+}
+
+// pivotValue renders a pivot value, which may be a number for some fields.
+func pivotValue(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case float64:
+		return fmt.Sprintf("%v", int64(t))
+	default:
+		return fmt.Sprintf("%v", t)
+	}
+}
+
+// Fingerprint collects document counts per institution, source and
+// collection as well as the format and facet_avail distributions.
+func (index Index) Fingerprint() (*Fingerprint, error) {
+	numFound, err := index.NumFound("*:*")
+	if err != nil {
+		return nil, err
+	}
+	pivot, err := index.Pivot("*:*", FingerprintPivot...)
+	if err != nil {
+		return nil, err
+	}
+	fp := &Fingerprint{
+		Server:   index.Server,
+		Date:     time.Now().UTC(),
+		NumFound: numFound,
+	}
+	for _, inst := range pivot {
+		for _, src := range inst.Pivot {
+			sc := SourceCount{
+				Institution: pivotValue(inst.Value),
+				SourceID:    pivotValue(src.Value),
+				Count:       src.Count,
+			}
+			for _, c := range src.Pivot {
+				if sc.Collections == nil {
+					sc.Collections = make(map[string]int64)
+				}
+				sc.Collections[pivotValue(c.Value)] = c.Count
+			}
+			fp.Sources = append(fp.Sources, sc)
+		}
+	}
+	if fp.Formats, err = index.facets("*:*", "format"); err != nil {
+		return nil, err
+	}
+	if fp.FacetAvail, err = index.facets("*:*", "facet_avail"); err != nil {
+		return nil, err
+	}
+	return fp, nil
+}
+
+// ReadFingerprint reads a fingerprint from a JSON file.
+func ReadFingerprint(filename string) (*Fingerprint, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var fp Fingerprint
+	if err := json.Unmarshal(b, &fp); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return &fp, nil
+}
+
+// WriteFile writes a fingerprint as JSON to a file.
+func (f *Fingerprint) WriteFile(filename string) error {
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return atomic.WriteFile(filename, append(b, '\n'), 0644)
+}
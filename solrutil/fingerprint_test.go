@@ -0,0 +1,100 @@
+package solrutil
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("facet.pivot") != "" {
+			fmt.Fprint(w, `{"response": {"numFound": 12}, "facet_counts": {"facet_pivot": {
+				"institution,source_id,mega_collection": [
+					{"field": "institution", "value": "DE-14", "count": 12, "pivot": [
+						{"field": "source_id", "value": "49", "count": 10, "pivot": [
+							{"field": "mega_collection", "value": "Crossref", "count": 10}]},
+						{"field": "source_id", "value": "48", "count": 2}]}]}}}`)
+			return
+		}
+		field := r.URL.Query().Get("facet.field")
+		fmt.Fprintf(w, `{"response": {"numFound": 12}, "facet_counts": {"facet_fields": {%q: ["a", 12]}}}`, field)
+	}))
+	defer ts.Close()
+
+	fp, err := Index{Server: ts.URL}.Fingerprint()
+	if err != nil {
+		t.Fatalf("Fingerprint: got %v, want nil", err)
+	}
+	if fp.NumFound != 12 {
+		t.Errorf("NumFound: got %d, want 12", fp.NumFound)
+	}
+	want := []SourceCount{
+		{Institution: "DE-14", SourceID: "49", Count: 10, Collections: map[string]int64{"Crossref": 10}},
+		{Institution: "DE-14", SourceID: "48", Count: 2},
+	}
+	if !reflect.DeepEqual(fp.Sources, want) {
+		t.Errorf("Sources: got %v, want %v", fp.Sources, want)
+	}
+	if !reflect.DeepEqual(fp.Formats, FacetMap{"a": 12}) {
+		t.Errorf("Formats: got %v", fp.Formats)
+	}
+
+	filename := filepath.Join(t.TempDir(), "fp.json")
+	if err := fp.WriteFile(filename); err != nil {
+		t.Fatalf("WriteFile: got %v, want nil", err)
+	}
+	saved, err := ReadFingerprint(filename)
+	if err != nil {
+		t.Fatalf("ReadFingerprint: got %v, want nil", err)
+	}
+	if got := saved.Count("DE-14", "49"); got != 10 {
+		t.Errorf("Count: got %d, want 10", got)
+	}
+	if got := saved.Count("DE-15", "49"); got != 0 {
+		t.Errorf("Count: got %d, want 0", got)
+	}
+	other := &Fingerprint{Sources: []SourceCount{{Institution: "DE-14", SourceID: "13", Count: 1}}}
+	wantKeys := []SourceKey{{"DE-14", "13"}, {"DE-14", "48"}, {"DE-14", "49"}}
+	if keys := Keys(saved, other); !reflect.DeepEqual(keys, wantKeys) {
+		t.Errorf("Keys: got %v, want %v", keys, wantKeys)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	live := &Fingerprint{
+		Sources: []SourceCount{
+			{Institution: "DE-14", SourceID: "49", Count: 100, Collections: map[string]int64{"A": 60, "B": 40}},
+			{Institution: "DE-15", SourceID: "48", Count: 10},
+		},
+		Formats: FacetMap{"Book": 10},
+	}
+	nonlive := &Fingerprint{
+		Sources: []SourceCount{
+			{Institution: "DE-14", SourceID: "49", Count: 95, Collections: map[string]int64{"A": 65, "C": 30}},
+			{Institution: " ", SourceID: "48", Count: 10},
+		},
+		Formats: FacetMap{"Book": 12},
+	}
+	c := Compare(live, nonlive, CompareOptions{Collections: true, Facets: true, MaxDrop: 10})
+	want := []Row{
+		{Institution: "DE-14", SourceID: "49", Live: 100, Nonlive: 95, Diff: -5, Pct: -5},
+		{Institution: "DE-14", SourceID: "49", Collection: "A", Live: 60, Nonlive: 65, Diff: 5, Pct: PctChange(60, 65)},
+		{Institution: "DE-14", SourceID: "49", Collection: "B", Live: 40, Nonlive: 0, Diff: -40, Pct: -100, Exceeded: true},
+		{Institution: "DE-14", SourceID: "49", Collection: "C", Live: 0, Nonlive: 30, Diff: 30, Pct: 100},
+		{Institution: "DE-15", SourceID: "48", Live: 10, Nonlive: 0, Diff: -10, Pct: -100, Exceeded: true},
+	}
+	if !reflect.DeepEqual(c.Rows, want) {
+		t.Errorf("Compare: got %v, want %v", c.Rows, want)
+	}
+	if n := len(c.Exceeded()); n != 2 {
+		t.Errorf("Exceeded: got %d, want 2", n)
+	}
+	wantFacets := []FacetRow{{Field: "format", Value: "Book", Live: 10, Nonlive: 12, Diff: 2, Pct: 20}}
+	if !reflect.DeepEqual(c.Facets, wantFacets) {
+		t.Errorf("Facets: got %v, want %v", c.Facets, wantFacets)
+	}
+}
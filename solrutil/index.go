@@ -5,7 +5,6 @@ import (
 	"github.com/segmentio/encoding/json"
 	"fmt"
 	"math/rand"
-	"net/http"
 	"net/url"
 	"strings"
 )
@@ -26,12 +25,20 @@ type SelectResponse struct {
 		} `json:"facet_heatmaps"`
 		FacetIntervals struct {
 		} `json:"facet_intervals"`
+		// FacetPivot maps a comma separated list of fields to the pivot
+		// tree, e.g. {"institution,source_id": [{"field": "institution",
+		// "value": "DE-14", "count": 10, "pivot": [...]}, ...]}.
+		FacetPivot map[string][]PivotField `json:"facet_pivot"`
+
 		FacetQueries struct {
 		} `json:"facet_queries"`
 		FacetRanges struct {
 		} `json:"facet_ranges"`
 	} `json:"facet_counts"`
-	Response struct {
+	// JSONFacets contains the result of a JSON facet request, if any.
+	JSONFacets     json.RawMessage `json:"facets"`
+	NextCursorMark string          `json:"nextCursorMark"`
+	Response       struct {
 		Docs     []json.RawMessage `json:"docs"`
 		NumFound int64             `json:"numFound"`
 		Start    int64             `json:"start"`
@@ -87,6 +94,14 @@ func (sr SelectResponse) Facets() (FacetMap, error) {
 	return result, nil
 }
 
+// PivotField is a node in a pivot facet tree.
+type PivotField struct {
+	Field string       `json:"field"`
+	Value interface{}  `json:"value"`
+	Count int64        `json:"count"`
+	Pivot []PivotField `json:"pivot"`
+}
+
 // Nonzero returns a FacetMap, which contains only non-zero frequencies.
 func (fm FacetMap) Nonzero() FacetMap {
 	result := make(FacetMap)
@@ -107,72 +122,133 @@ type FacetMap map[string]int
 type Index struct {
 	Server     string
 	FacetLimit int
+	Client     *Client // if nil, DefaultClient is used
 }
 
 // Select allows to pass any parameter to select.
 func (index Index) Select(vs url.Values) (*SelectResponse, error) {
-	link := fmt.Sprintf("%s/select?%s", index.Server, vs.Encode())
 	resp := new(SelectResponse)
-	if err := decodeLink(link, resp); err != nil {
+	if err := index.Client.Do(index.Server+"/select", vs, resp); err != nil {
 		return nil, err
 	}
 	return resp, nil
 }
 
-// selectLink constructs a link to a JSON response.
-func (index Index) selectLink(query string) string {
+// selectValues returns the parameters for a query.
+func (index Index) selectValues(query string) url.Values {
 	if query == "" {
 		query = "*:*"
 	}
 	vals := url.Values{}
 	vals.Add("q", query)
 	vals.Add("wt", "json")
-	return fmt.Sprintf("%s/select?%s", index.Server, vals.Encode())
+	return vals
 }
 
-// FacetLink constructs a link to a JSON response.
-func (index Index) FacetLink(query, facetField string) string {
-	if query == "" {
-		query = "*:*"
-	}
+// numFoundValues returns the parameters for a query, asking for the number
+// of results only.
+func (index Index) numFoundValues(query string) url.Values {
+	vals := index.selectValues(query)
+	vals.Add("rows", "0")
+	return vals
+}
+
+// facetValues returns the parameters for a facet query.
+func (index Index) facetValues(query, facetField string) url.Values {
 	if index.FacetLimit == 0 {
 		index.FacetLimit = DefaultFacetLimit
 	}
-	vals := url.Values{}
-	vals.Add("q", query)
+	vals := index.numFoundValues(query)
 	vals.Add("facet", "true")
 	vals.Add("facet.field", facetField)
 	vals.Add("facet.limit", fmt.Sprintf("%d", index.FacetLimit))
-	vals.Add("rows", "0")
-	vals.Add("wt", "json")
-	return fmt.Sprintf("%s/select?%s", index.Server, vals.Encode())
+	return vals
 }
 
-// decodeLink fetches a link and unmarshals the response into a given value.
-func decodeLink(link string, value interface{}) error {
-	resp, err := http.Get(link)
-	if err != nil {
-		return err
-	}
-	if resp.StatusCode >= 400 {
-		return fmt.Errorf("select failed with HTTP %d at %s", resp.StatusCode, link)
-	}
-	defer resp.Body.Close()
-	return json.NewDecoder(resp.Body).Decode(value)
+// SelectLink constructs a link to a JSON response, containing the number of
+// results only.
+func (index Index) SelectLink(query string) string {
+	return fmt.Sprintf("%s/select?%s", index.Server, index.numFoundValues(query).Encode())
+}
+
+// FacetLink constructs a link to a JSON response.
+func (index Index) FacetLink(query, facetField string) string {
+	return fmt.Sprintf("%s/select?%s", index.Server, index.facetValues(query, facetField).Encode())
 }
 
 // SelectQuery runs a select query.
 func (index Index) SelectQuery(query string) (resp *SelectResponse, err error) {
-	resp = new(SelectResponse)
-	err = decodeLink(index.selectLink(query), resp)
-	return
+	return index.Select(index.selectValues(query))
 }
 
 // FacetQuery runs a facet query.
 func (index Index) FacetQuery(query, facetField string) (resp *SelectResponse, err error) {
-	resp = new(SelectResponse)
-	err = decodeLink(index.FacetLink(query, facetField), resp)
-	return
+	return index.Select(index.facetValues(query, facetField))
+}
+
+// Pivot returns the pivot facet tree for a number of fields.
+func (index Index) Pivot(query string, fields ...string) ([]PivotField, error) {
+	if index.FacetLimit == 0 {
+		index.FacetLimit = DefaultFacetLimit
+	}
+	pivot := strings.Join(fields, ",")
+	vals := index.numFoundValues(query)
+	vals.Add("facet", "true")
+	vals.Add("facet.pivot", pivot)
+	vals.Add("facet.pivot.mincount", "1")
+	vals.Add("facet.limit", fmt.Sprintf("%d", index.FacetLimit))
+	resp, err := index.Select(vals)
+	if err != nil {
+		return nil, err
+	}
+	return resp.FacetCounts.FacetPivot[pivot], nil
+}
+
+// JSONFacet sends a request using the JSON facet API and returns the raw
+// facets, e.g. {"count": 10, "sources": {"buckets": [...]}}.
+// https://solr.apache.org/guide/json-facet-api.html
+func (index Index) JSONFacet(query string, facet interface{}) (json.RawMessage, error) {
+	b, err := json.Marshal(facet)
+	if err != nil {
+		return nil, err
+	}
+	vals := index.numFoundValues(query)
+	vals.Add("json.facet", string(b))
+	resp, err := index.Select(vals)
+	if err != nil {
+		return nil, err
+	}
+	return resp.JSONFacets, nil
+}
+
+// Documents iterates over all documents matching a query with cursorMark
+// deep paging, sorted by id, calling f for each document. If fields are
+// given, only these fields are returned. Iteration stops, if f returns an
+// error.
+func (index Index) Documents(query string, fields []string, f func(doc json.RawMessage) error) error {
+	vals := index.selectValues(query)
+	vals.Set("sort", "id asc")
+	vals.Set("rows", fmt.Sprintf("%d", DefaultCursorRows))
+	if len(fields) > 0 {
+		vals.Set("fl", strings.Join(fields, ","))
+	}
+	cursor := "*"
+	for {
+		vals.Set("cursorMark", cursor)
+		resp, err := index.Select(vals)
+		if err != nil {
+			return err
+		}
+		for _, doc := range resp.Response.Docs {
+			if err := f(doc); err != nil {
+				return err
+			}
+		}
+		if resp.NextCursorMark == "" || resp.NextCursorMark == cursor {
+			return nil
+		}
+		cursor = resp.NextCursorMark
+	}
 }
 
 // facets returns a facet map for a query and field.
@@ -221,7 +297,7 @@ func (index Index) FacetKeys(query, field string) (result []string, err error) {
 
 // NumFound returns the size of the result set for a query.
 func (index Index) NumFound(query string) (int64, error) {
-	resp, err := index.SelectQuery(query)
+	resp, err := index.Select(index.numFoundValues(query))
 	if err != nil {
 		return 0, err
 	}
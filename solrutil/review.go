@@ -1,6 +1,50 @@
 package solrutil
 
-import "fmt"
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Margin returns the number of records out of total, which may violate a rule
+// given a tolerance in percent (0-100).
+func Margin(total int64, tolerancePct float64) int64 {
+	if tolerancePct <= 0 {
+		return 0
+	}
+	return int64(float64(total) * tolerancePct / 100)
+}
+
+// Disallowed returns the sorted non-zero facet values, which are not
+// explicitly allowed, together with the sum of their frequencies.
+func (f FacetMap) Disallowed(allowed ...string) (keys []string, count int64) {
+	s := make(map[string]bool)
+	for _, v := range allowed {
+		s[v] = true
+	}
+	for k, v := range f {
+		if !s[k] && v > 0 {
+			keys = append(keys, k)
+			count += int64(v)
+		}
+	}
+	sort.Strings(keys)
+	return keys, count
+}
+
+// Mismatched returns the sorted non-zero facet values, which do not match a
+// pattern, together with the sum of their frequencies.
+func (f FacetMap) Mismatched(re *regexp.Regexp) (keys []string, count int64) {
+	for k, v := range f {
+		if v > 0 && !re.MatchString(k) {
+			keys = append(keys, k)
+			count += int64(v)
+		}
+	}
+	sort.Strings(keys)
+	return keys, count
+}
 
 // AllowedKeys returns an error if facets values contain non-zero values that
 // are not explicitly allowed. Used for reviews.
@@ -40,98 +84,286 @@ func (f FacetMap) EqualSizeNonZero(keys ...string) error {
 	return nil
 }
 
+// Check is the outcome of a single review check against an index. Expected
+// and observed values are rendered as strings, so they can be reported and
+// compared across index builds.
+type Check struct {
+	Query    string
+	Field    string
+	Expected string
+	Observed string
+	Err      error // the violation or a failure to run the check
+}
+
+// Passed returns true, if the check passed.
+func (c Check) Passed() bool {
+	return c.Err == nil
+}
+
 // AllowedKeys checks for a query and facet field, whether the values contain
 // only allowed values. Used for reviews.
-func (index Index) AllowedKeys(query, field string, values ...string) error {
-	facets, err := index.facets(query, field)
-	if err != nil {
-		return err
-	}
-	err = facets.AllowedKeys(values...)
-	if err != nil {
-		return fmt.Errorf("%s [%s]: %s", query, field, err)
-	}
-	return nil
+func (index Index) AllowedKeys(query, field string, values ...string) Check {
+	return index.AllowedKeysTolerance(query, field, 0, values...)
 }
 
 // EqualSizeNonZero checks, if given facet field values have the same size.
 // Used for reviews.
-func (index Index) EqualSizeNonZero(query, field string, values ...string) error {
+func (index Index) EqualSizeNonZero(query, field string, values ...string) Check {
+	c := Check{Query: query, Field: field, Expected: fmt.Sprintf("equal size %v", values)}
 	facets, err := index.facets(query, field)
 	if err != nil {
-		return err
+		c.Err = err
+		return c
 	}
-	err = facets.EqualSizeNonZero(values...)
-	if err != nil {
-		return fmt.Errorf("%s [%s]: %s", query, field, err)
+	c.Observed = observeCounts(facets, values)
+	if err = facets.EqualSizeNonZero(values...); err != nil {
+		c.Err = fmt.Errorf("%s [%s]: %s", query, field, err)
 	}
-	return nil
+	return c
 }
 
 // EqualSizeTotal checks, if given facet field values have the same size as the
 // total number of records. Used for reviews.
-func (index Index) EqualSizeTotal(query, field string, values ...string) error {
+func (index Index) EqualSizeTotal(query, field string, values ...string) Check {
+	c := Check{Query: query, Field: field, Expected: fmt.Sprintf("all records %v", values)}
 	r, err := index.FacetQuery(query, field)
 	if err != nil {
-		return err
+		c.Err = err
+		return c
 	}
 	total := r.Response.NumFound
 	facets, err := r.Facets()
 	if err != nil {
-		return err
+		c.Err = err
+		return c
 	}
-	err = facets.EqualSizeNonZero(values...)
-	if err != nil {
-		return fmt.Errorf("%s [%s]: %s", query, field, err)
+	c.Observed = fmt.Sprintf("%s of %d", observeCounts(facets, values), total)
+	if err = facets.EqualSizeNonZero(values...); err != nil {
+		c.Err = fmt.Errorf("%s [%s]: %s", query, field, err)
+		return c
 	}
 	if len(values) > 0 {
 		if int64(facets[values[0]]) != total {
-			return fmt.Errorf("%s [%s]: size mismatch, got %d, want %d",
+			c.Err = fmt.Errorf("%s [%s]: size mismatch, got %d, want %d",
 				query, field, facets[values[0]], total)
 		}
 	}
-	return nil
+	return c
 }
 
 // MinRatioPct fails, if the number of records matching a value undercuts a
 // given ratio of all records matching the query. The ratio ranges from 0 to
 // 100. Used for reviews.
-func (index Index) MinRatioPct(query, field, value string, minRatioPct float64) error {
+func (index Index) MinRatioPct(query, field, value string, minRatioPct float64) Check {
+	c := Check{Query: query, Field: field, Expected: fmt.Sprintf("%s >= %0.2f%%", value, minRatioPct)}
 	r, err := index.FacetQuery(query, field)
 	if err != nil {
-		return err
+		c.Err = err
+		return c
 	}
 	total := r.Response.NumFound
 	facets, err := r.Facets()
 	if err != nil {
-		return err
+		c.Err = err
+		return c
 	}
 	size, ok := facets[value]
 	if !ok {
-		return fmt.Errorf("field not found: %s", field)
+		c.Err = fmt.Errorf("field not found: %s", field)
+		return c
 	}
 	ratio := (float64(size) / float64(total)) * 100
+	c.Observed = fmt.Sprintf("%s %0.2f%%", value, ratio)
 	if ratio < minRatioPct {
-		return fmt.Errorf("%s [%s=%s]: ratio undercut, got %0.2f%%, want %0.2f%%",
+		c.Err = fmt.Errorf("%s [%s=%s]: ratio undercut, got %0.2f%%, want %0.2f%%",
 			query, field, value, ratio, minRatioPct)
 	}
-	return nil
+	return c
 }
 
 // MinCount fails, if the number of records matching a value undercuts a given
 // size. Used for reviews.
-func (index Index) MinCount(query, field, value string, minCount int) error {
+func (index Index) MinCount(query, field, value string, minCount int) Check {
+	c := Check{Query: query, Field: field, Expected: fmt.Sprintf("%s >= %d", value, minCount)}
 	facets, err := index.facets(query, field)
 	if err != nil {
-		return err
+		c.Err = err
+		return c
 	}
 	size, ok := facets[value]
 	if !ok {
-		return fmt.Errorf("field not found: %s", field)
+		c.Err = fmt.Errorf("field not found: %s", field)
+		return c
 	}
+	c.Observed = fmt.Sprintf("%s %d", value, size)
 	if size < minCount {
-		return fmt.Errorf("%s [%s=%s]: undercut, got %d, want at least %d",
+		c.Err = fmt.Errorf("%s [%s=%s]: undercut, got %d, want at least %d",
 			query, field, value, size, minCount)
 	}
-	return nil
+	return c
+}
+
+// AllowedKeysTolerance works like AllowedKeys, but allows up to a given
+// percentage of records to carry values not explicitly allowed.
+func (index Index) AllowedKeysTolerance(query, field string, tolerancePct float64, values ...string) Check {
+	c := Check{Query: query, Field: field, Expected: fmt.Sprintf("only %v", values)}
+	r, err := index.FacetQuery(query, field)
+	if err != nil {
+		c.Err = err
+		return c
+	}
+	facets, err := r.Facets()
+	if err != nil {
+		c.Err = err
+		return c
+	}
+	keys, count := facets.Disallowed(values...)
+	c.Observed = fmt.Sprintf("%d not allowed %v", count, keys)
+	if count > Margin(r.Response.NumFound, tolerancePct) {
+		c.Err = fmt.Errorf("%s [%s]: %d records with values not allowed: %v (tolerance %0.2f%%)",
+			query, field, count, keys, tolerancePct)
+	}
+	return c
+}
+
+// EqualSizeTotalTolerance works like EqualSizeTotal, but each value only needs
+// to appear in all but a given percentage of records.
+func (index Index) EqualSizeTotalTolerance(query, field string, tolerancePct float64, values ...string) Check {
+	c := Check{Query: query, Field: field, Expected: fmt.Sprintf("all records %v", values)}
+	r, err := index.FacetQuery(query, field)
+	if err != nil {
+		c.Err = err
+		return c
+	}
+	total := r.Response.NumFound
+	facets, err := r.Facets()
+	if err != nil {
+		c.Err = err
+		return c
+	}
+	c.Observed = fmt.Sprintf("%s of %d", observeCounts(facets, values), total)
+	min := total - Margin(total, tolerancePct)
+	for _, v := range values {
+		if size := int64(facets[v]); size < min {
+			c.Err = fmt.Errorf("%s [%s=%s]: size mismatch, got %d, want at least %d of %d (tolerance %0.2f%%)",
+				query, field, v, size, min, total, tolerancePct)
+			break
+		}
+	}
+	return c
+}
+
+// MaxCount fails, if the number of records matching a value exceeds a given
+// size. Used for reviews.
+func (index Index) MaxCount(query, field, value string, maxCount int) Check {
+	c := Check{Query: query, Field: field, Expected: fmt.Sprintf("%s <= %d", value, maxCount)}
+	facets, err := index.facets(query, field)
+	if err != nil {
+		c.Err = err
+		return c
+	}
+	size := facets[value]
+	c.Observed = fmt.Sprintf("%s %d", value, size)
+	if size > maxCount {
+		c.Err = fmt.Errorf("%s [%s=%s]: exceeded, got %d, want at most %d",
+			query, field, value, size, maxCount)
+	}
+	return c
+}
+
+// FieldPresentRatioPct fails, if the ratio of records matching the query that
+// have any value in a given field undercuts a given ratio (0-100). Used for
+// reviews.
+func (index Index) FieldPresentRatioPct(query, field string, minRatioPct float64) Check {
+	c := Check{Query: query, Field: field, Expected: fmt.Sprintf("present >= %0.2f%%", minRatioPct)}
+	if query == "" {
+		query = "*:*"
+	}
+	total, err := index.NumFound(query)
+	if err != nil {
+		c.Err = err
+		return c
+	}
+	if total == 0 {
+		c.Observed = "no records"
+		return c
+	}
+	present, err := index.NumFound(fmt.Sprintf("(%s) AND %s:[* TO *]", query, field))
+	if err != nil {
+		c.Err = err
+		return c
+	}
+	ratio := (float64(present) / float64(total)) * 100
+	c.Observed = fmt.Sprintf("present %0.2f%% (%d/%d)", ratio, present, total)
+	if ratio < minRatioPct {
+		c.Err = fmt.Errorf("%s [%s]: field present ratio undercut, got %0.2f%% (%d/%d), want %0.2f%%",
+			query, field, ratio, present, total, minRatioPct)
+	}
+	return c
+}
+
+// FacetRegex fails, if more than a given percentage of records matching the
+// query have a value in field, that does not match pattern. The pattern must
+// match the whole value. Used for reviews.
+func (index Index) FacetRegex(query, field, pattern string, tolerancePct float64) Check {
+	c := Check{Query: query, Field: field, Expected: fmt.Sprintf("match %s", pattern)}
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		c.Err = err
+		return c
+	}
+	r, err := index.FacetQuery(query, field)
+	if err != nil {
+		c.Err = err
+		return c
+	}
+	facets, err := r.Facets()
+	if err != nil {
+		c.Err = err
+		return c
+	}
+	keys, count := facets.Mismatched(re)
+	c.Observed = fmt.Sprintf("%d not matching %v", count, keys)
+	if count > Margin(r.Response.NumFound, tolerancePct) {
+		c.Err = fmt.Errorf("%s [%s]: %d records with values not matching %s: %v (tolerance %0.2f%%)",
+			query, field, count, pattern, keys, tolerancePct)
+	}
+	return c
+}
+
+// CompareNumFound compares the number of results for a query with another
+// index, usually the live one. It fails, if the result count dropped by more
+// than maxDropPct percent compared to other.
+func (index Index) CompareNumFound(other Index, query string, maxDropPct float64) Check {
+	c := Check{Query: query, Expected: fmt.Sprintf("drop <= %0.2f%%", maxDropPct)}
+	n, err := index.NumFound(query)
+	if err != nil {
+		c.Err = err
+		return c
+	}
+	m, err := other.NumFound(query)
+	if err != nil {
+		c.Err = err
+		return c
+	}
+	c.Observed = fmt.Sprintf("%d vs %d", n, m)
+	if m == 0 {
+		return c
+	}
+	delta := (float64(n-m) / float64(m)) * 100
+	c.Observed = fmt.Sprintf("%d vs %d (%+0.2f%%)", n, m, delta)
+	if -delta > maxDropPct {
+		c.Err = fmt.Errorf("%s: dropped %0.2f%% compared to %s, got %d, want at least %d",
+			query, -delta, other.Server, n, m-Margin(m, maxDropPct))
+	}
+	return c
+}
+
+// observeCounts renders the frequencies of the given facet values.
+func observeCounts(facets FacetMap, values []string) string {
+	var parts []string
+	for _, v := range values {
+		parts = append(parts, fmt.Sprintf("%s=%d", v, facets[v]))
+	}
+	return strings.Join(parts, ", ")
 }
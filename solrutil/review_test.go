@@ -0,0 +1,103 @@
+package solrutil
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestMargin(t *testing.T) {
+	var cases = []struct {
+		total     int64
+		tolerance float64
+		want      int64
+	}{
+		{100, 0, 0},
+		{100, 3, 3},
+		{1000, 2.5, 25},
+		{10, 5, 0},
+		{10, -1, 0},
+	}
+	for _, c := range cases {
+		if got := Margin(c.total, c.tolerance); got != c.want {
+			t.Errorf("Margin(%d, %v): got %d, want %d", c.total, c.tolerance, got, c.want)
+		}
+	}
+}
+
+func TestFacetMapDisallowed(t *testing.T) {
+	f := FacetMap{"English": 90, "German": 7, "French": 3, "Latin": 0}
+	keys, count := f.Disallowed("English")
+	if want := []string{"French", "German"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("Disallowed: got %v, want %v", keys, want)
+	}
+	if count != 10 {
+		t.Errorf("Disallowed: got %d, want 10", count)
+	}
+	keys, count = f.Mismatched(regexp.MustCompile("^(English|German)$"))
+	if want := []string{"French"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("Mismatched: got %v, want %v", keys, want)
+	}
+	if count != 3 {
+		t.Errorf("Mismatched: got %d, want 3", count)
+	}
+}
+
+// facetServer returns a server answering each select with the same facet
+// counts for field "language".
+func facetServer(numFound int64, facets string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"response": {"numFound": %d}, "facet_counts": {"facet_fields": {"language": %s}}}`,
+			numFound, facets)
+	}))
+}
+
+func TestReviewTolerance(t *testing.T) {
+	ts := facetServer(100, `["English", 97, "German", 3]`)
+	defer ts.Close()
+	index := Index{Server: ts.URL}
+
+	var cases = []struct {
+		name string
+		err  error
+		fail bool
+	}{
+		{"allowed keys", index.AllowedKeysTolerance("source_id:1", "language", 0, "English").Err, true},
+		{"allowed keys ~3", index.AllowedKeysTolerance("source_id:1", "language", 3, "English").Err, false},
+		{"all records", index.EqualSizeTotalTolerance("source_id:1", "language", 0, "English").Err, true},
+		{"all records ~3", index.EqualSizeTotalTolerance("source_id:1", "language", 3, "English").Err, false},
+		{"facet regex", index.FacetRegex("source_id:1", "language", "Eng.*", 0).Err, true},
+		{"facet regex ~5", index.FacetRegex("source_id:1", "language", "Eng.*", 5).Err, false},
+		{"facet regex full", index.FacetRegex("source_id:1", "language", "[A-Z][a-z]+", 0).Err, false},
+		{"max count", index.MaxCount("source_id:1", "language", "German", 2).Err, true},
+		{"max count ok", index.MaxCount("source_id:1", "language", "German", 3).Err, false},
+	}
+	for _, c := range cases {
+		if (c.err != nil) != c.fail {
+			t.Errorf("%s: got %v, want failure %v", c.name, c.err, c.fail)
+		}
+	}
+}
+
+func TestCompareNumFound(t *testing.T) {
+	live := facetServer(100, `[]`)
+	defer live.Close()
+	nonlive := facetServer(96, `[]`)
+	defer nonlive.Close()
+	var (
+		liveIndex    = Index{Server: live.URL}
+		nonliveIndex = Index{Server: nonlive.URL}
+	)
+	if err := nonliveIndex.CompareNumFound(liveIndex, "source_id:48", 5).Err; err != nil {
+		t.Errorf("CompareNumFound: got %v, want nil", err)
+	}
+	if err := nonliveIndex.CompareNumFound(liveIndex, "source_id:48", 3).Err; err == nil {
+		t.Errorf("CompareNumFound: got nil, want error")
+	}
+	if err := liveIndex.CompareNumFound(nonliveIndex, "source_id:48", 0).Err; err != nil {
+		t.Errorf("CompareNumFound: growth got %v, want nil", err)
+	}
+}
@@ -0,0 +1,427 @@
+package statsutil
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/segmentio/encoding/json"
+
+	"github.com/miku/span/formats/finc"
+)
+
+const (
+	// topCapacity is the number of values tracked per field for top values.
+	// For fields with more distinct values, top counts are lower bounds.
+	topCapacity = 1000
+	// numBuckets, lengths of 2^(numBuckets-2) runes and more share a bucket.
+	numBuckets = 16
+)
+
+// yearFields get a year histogram.
+var yearFields = map[string]bool{"x.date": true, "rft.date": true}
+
+// Value is a value and its frequency.
+type Value struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// Bucket counts values with a length in runes between Min and Max, a Max of
+// -1 means unbounded.
+type Bucket struct {
+	Min   int   `json:"min"`
+	Max   int   `json:"max"`
+	Count int64 `json:"count"`
+}
+
+// String renders the length range, e.g. 16-31.
+func (b Bucket) String() string {
+	switch {
+	case b.Max == -1:
+		return fmt.Sprintf("%d+", b.Min)
+	case b.Min == b.Max:
+		return strconv.Itoa(b.Min)
+	default:
+		return fmt.Sprintf("%d-%d", b.Min, b.Max)
+	}
+}
+
+// FieldStats describes the values of a single field.
+type FieldStats struct {
+	Records     int64            `json:"records"` // records with at least one value
+	Fill        float64          `json:"fill"`    // ratio of records with value
+	Values      int64            `json:"values"`  // number of values, fields may repeat
+	Cardinality uint64           `json:"cardinality"`
+	Top         []Value          `json:"top,omitempty"`
+	Lengths     []Bucket         `json:"lengths,omitempty"`
+	Years       map[string]int64 `json:"years,omitempty"`
+
+	hll     *HyperLogLog
+	top     map[string]int64
+	lengths [numBuckets]int64
+}
+
+// add adds a value.
+func (f *FieldStats) add(name, v string) {
+	if f.hll == nil {
+		// Many fields stay empty, so allocate on first value.
+		f.hll, f.top = NewHyperLogLog(), make(map[string]int64)
+	}
+	f.Values++
+	f.hll.Add(v)
+	if _, ok := f.top[v]; ok || len(f.top) < topCapacity {
+		f.top[v]++
+	} else {
+		// Misra-Gries: a new value decrements all counters, drops zeros.
+		for k := range f.top {
+			f.top[k]--
+			if f.top[k] == 0 {
+				delete(f.top, k)
+			}
+		}
+	}
+	i := bits.Len(uint(utf8.RuneCountInString(v)))
+	if i >= numBuckets {
+		i = numBuckets - 1
+	}
+	f.lengths[i]++
+	if yearFields[name] && len(v) >= 4 {
+		if _, err := strconv.Atoi(v[:4]); err == nil {
+			if f.Years == nil {
+				f.Years = make(map[string]int64)
+			}
+			f.Years[v[:4]]++
+		}
+	}
+}
+
+// finish computes the exported summary.
+func (f *FieldStats) finish(records int64, topN int) {
+	if records > 0 {
+		f.Fill = float64(f.Records) / float64(records)
+	}
+	if f.hll != nil {
+		f.Cardinality = f.hll.Count()
+	}
+	f.Top = f.Top[:0]
+	for k, v := range f.top {
+		f.Top = append(f.Top, Value{Value: k, Count: v})
+	}
+	sort.Slice(f.Top, func(i, j int) bool {
+		if f.Top[i].Count != f.Top[j].Count {
+			return f.Top[i].Count > f.Top[j].Count
+		}
+		return f.Top[i].Value < f.Top[j].Value
+	})
+	if len(f.Top) > topN {
+		f.Top = f.Top[:topN]
+	}
+	f.Lengths = f.Lengths[:0]
+	for i, c := range f.lengths {
+		if c == 0 {
+			continue
+		}
+		b := Bucket{Count: c}
+		if i > 0 {
+			b.Min, b.Max = 1<<(i-1), 1<<i-1
+		}
+		if i == numBuckets-1 {
+			b.Max = -1
+		}
+		f.Lengths = append(f.Lengths, b)
+	}
+}
+
+// Profile describes the fields of a set of records.
+type Profile struct {
+	Records int64                  `json:"records"`
+	Fields  map[string]*FieldStats `json:"fields"`
+}
+
+// schemaFields lists the fields of the intermediate schema, authors as
+// "authors.rft.aulast" and the like.
+func schemaFields() []string {
+	var names []string
+	var collect func(t reflect.Type, prefix string)
+	collect = func(t reflect.Type, prefix string) {
+		for i := 0; i < t.NumField(); i++ {
+			tag := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+			if tag == "" || tag == "-" {
+				continue
+			}
+			names = append(names, prefix+tag)
+			if ft := t.Field(i).Type; ft.Kind() == reflect.Slice && ft.Elem().Kind() == reflect.Struct {
+				collect(ft.Elem(), prefix+tag+".")
+			}
+		}
+	}
+	collect(reflect.TypeOf(finc.IntermediateSchema{}), "")
+	return names
+}
+
+// newProfile returns a profile, which lists all schema fields.
+func newProfile() *Profile {
+	p := &Profile{Fields: make(map[string]*FieldStats)}
+	for _, name := range schemaFields() {
+		p.Fields[name] = &FieldStats{}
+	}
+	return p
+}
+
+// add adds the values of a single record.
+func (p *Profile) add(values map[string][]string, counts map[string]int) {
+	p.Records++
+	for name, n := range counts {
+		f, ok := p.Fields[name]
+		if !ok {
+			f = &FieldStats{}
+			p.Fields[name] = f
+		}
+		f.Records++
+		vs := values[name]
+		if len(vs) == 0 {
+			// Object valued fields, like authors, only count.
+			f.Values += int64(n)
+			continue
+		}
+		for _, v := range vs {
+			f.add(name, v)
+		}
+	}
+}
+
+// finish computes the exported summary of all fields.
+func (p *Profile) finish(topN int) {
+	for _, f := range p.Fields {
+		f.finish(p.Records, topN)
+	}
+}
+
+// FieldNames returns the sorted field names.
+func (p *Profile) FieldNames() []string {
+	var names []string
+	for k := range p.Fields {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Report contains an overall profile and one profile per source.
+type Report struct {
+	Date    time.Time           `json:"date"`
+	Overall *Profile            `json:"overall"`
+	Sources map[string]*Profile `json:"sources,omitempty"`
+}
+
+// SourceIDs returns the sorted source ids.
+func (r *Report) SourceIDs() []string {
+	var ids []string
+	for k := range r.Sources {
+		ids = append(ids, k)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// ReadReport reads a JSON report, e.g. to compare it with another one.
+func ReadReport(r io.Reader) (*Report, error) {
+	var report Report
+	if err := json.NewDecoder(r).Decode(&report); err != nil {
+		return nil, err
+	}
+	if report.Overall == nil {
+		return nil, fmt.Errorf("report without overall profile")
+	}
+	return &report, nil
+}
+
+// Profiler collects statistics about intermediate schema records. It is safe
+// for concurrent use.
+type Profiler struct {
+	TopN     int  // number of top values to report per field
+	BySource bool // whether to keep a profile per source
+
+	mu      sync.Mutex
+	overall *Profile
+	sources map[string]*Profile
+}
+
+// NewProfiler returns a profiler reporting the topN values per field.
+func NewProfiler(topN int, bySource bool) *Profiler {
+	return &Profiler{
+		TopN:     topN,
+		BySource: bySource,
+		overall:  newProfile(),
+		sources:  make(map[string]*Profile),
+	}
+}
+
+// flatten collects string values of a decoded JSON value per field name and
+// counts values of object valued fields.
+func flatten(name string, v interface{}, values map[string][]string, counts map[string]int) {
+	switch t := v.(type) {
+	case nil:
+	case string:
+		if t == "" {
+			return
+		}
+		values[name] = append(values[name], t)
+		counts[name]++
+	case bool:
+		values[name] = append(values[name], strconv.FormatBool(t))
+		counts[name]++
+	case float64:
+		values[name] = append(values[name], strconv.FormatFloat(t, 'f', -1, 64))
+		counts[name]++
+	case []interface{}:
+		for _, w := range t {
+			flatten(name, w, values, counts)
+		}
+	case map[string]interface{}:
+		counts[name]++
+		for k, w := range t {
+			flatten(name+"."+k, w, values, counts)
+		}
+	}
+}
+
+// Add adds a single JSON encoded intermediate schema record.
+func (p *Profiler) Add(b []byte) error {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+	var (
+		values = make(map[string][]string)
+		counts = make(map[string]int)
+	)
+	for k, v := range doc {
+		flatten(k, v, values, counts)
+	}
+	sid, _ := doc["finc.source_id"].(string)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.overall.add(values, counts)
+	if p.BySource {
+		if p.sources[sid] == nil {
+			p.sources[sid] = newProfile()
+		}
+		p.sources[sid].add(values, counts)
+	}
+	return nil
+}
+
+// Report summarizes the records added so far.
+func (p *Profiler) Report() *Report {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.overall.finish(p.TopN)
+	r := &Report{Date: time.Now(), Overall: p.overall}
+	if p.BySource {
+		r.Sources = p.sources
+		for _, s := range p.sources {
+			s.finish(p.TopN)
+		}
+	}
+	return r
+}
+
+// Change is a notable difference between two profiles.
+type Change struct {
+	Source string  `json:"source,omitempty"` // empty for the overall profile
+	Field  string  `json:"field,omitempty"`  // empty for record counts
+	What   string  `json:"what"`             // records, fill or cardinality
+	A      float64 `json:"a"`
+	B      float64 `json:"b"`
+}
+
+// relChange returns the relative change from a to b.
+func relChange(a, b float64) float64 {
+	if a == 0 {
+		if b == 0 {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return math.Abs(b-a) / a
+}
+
+// diffProfile appends changes between two profiles. If a profile is missing,
+// only the record count is compared.
+func diffProfile(changes []Change, source string, a, b *Profile, threshold float64) []Change {
+	var ra, rb int64
+	if a != nil {
+		ra = a.Records
+	}
+	if b != nil {
+		rb = b.Records
+	}
+	if relChange(float64(ra), float64(rb)) > threshold {
+		changes = append(changes, Change{Source: source, What: "records", A: float64(ra), B: float64(rb)})
+	}
+	if a == nil || b == nil {
+		return changes
+	}
+	names := make(map[string]bool)
+	for k := range a.Fields {
+		names[k] = true
+	}
+	for k := range b.Fields {
+		names[k] = true
+	}
+	var sorted []string
+	for k := range names {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	for _, name := range sorted {
+		fa, fb := a.Fields[name], b.Fields[name]
+		if fa == nil {
+			fa = &FieldStats{}
+		}
+		if fb == nil {
+			fb = &FieldStats{}
+		}
+		if math.Abs(fb.Fill-fa.Fill) > threshold {
+			changes = append(changes, Change{Source: source, Field: name, What: "fill", A: fa.Fill, B: fb.Fill})
+		}
+		ca, cb := float64(fa.Cardinality), float64(fb.Cardinality)
+		if relChange(ca, cb) > threshold {
+			changes = append(changes, Change{Source: source, Field: name, What: "cardinality", A: ca, B: cb})
+		}
+	}
+	return changes
+}
+
+// Diff returns changes between two reports, for fill ratios differing by more
+// than threshold or record counts and cardinalities changing by more than
+// threshold relative to a.
+func Diff(a, b *Report, threshold float64) []Change {
+	changes := diffProfile(nil, "", a.Overall, b.Overall, threshold)
+	ids := make(map[string]bool)
+	for k := range a.Sources {
+		ids[k] = true
+	}
+	for k := range b.Sources {
+		ids[k] = true
+	}
+	var sorted []string
+	for k := range ids {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	for _, sid := range sorted {
+		changes = diffProfile(changes, sid, a.Sources[sid], b.Sources[sid], threshold)
+	}
+	return changes
+}
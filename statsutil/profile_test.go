@@ -0,0 +1,147 @@
+package statsutil
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/segmentio/encoding/json"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	for _, n := range []int{0, 10, 1000, 100000} {
+		h := NewHyperLogLog()
+		for i := 0; i < n; i++ {
+			h.Add(fmt.Sprintf("value-%d", i))
+			h.Add(fmt.Sprintf("value-%d", i))
+		}
+		got := float64(h.Count())
+		if math.Abs(got-float64(n)) > 0.03*float64(n) {
+			t.Errorf("Count: got %v, want about %d", got, n)
+		}
+	}
+	a, b := NewHyperLogLog(), NewHyperLogLog()
+	for i := 0; i < 1000; i++ {
+		a.Add(fmt.Sprintf("a-%d", i))
+		b.Add(fmt.Sprintf("b-%d", i))
+	}
+	a.Merge(b)
+	if got := float64(a.Count()); math.Abs(got-2000) > 60 {
+		t.Errorf("Merge: got %v, want about 2000", got)
+	}
+}
+
+var testRecords = []string{
+	`{"finc.source_id": "49", "rft.atitle": "On Things", "rft.issn": ["0022-202X", "1523-1747"], "x.date": "2010-01-01T00:00:00Z", "authors": [{"rft.aulast": "Denning"}, {"rft.au": "Karl Kraus"}], "languages": ["eng"]}`,
+	`{"finc.source_id": "49", "rft.atitle": "Sun-Sensitizing Effects", "rft.issn": ["0022-202X"], "x.date": "2010-05-01T00:00:00Z", "languages": ["eng"]}`,
+	`{"finc.source_id": "48", "rft.atitle": "", "x.date": "1999-01-01T00:00:00Z", "languages": ["ger"], "x.oa": true}`,
+}
+
+func testReport(t *testing.T, records []string) *Report {
+	p := NewProfiler(2, true)
+	for _, r := range records {
+		if err := p.Add([]byte(r)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return p.Report()
+}
+
+func TestProfiler(t *testing.T) {
+	r := testReport(t, testRecords)
+	if r.Overall.Records != 3 {
+		t.Fatalf("got %d records, want 3", r.Overall.Records)
+	}
+	if got := r.SourceIDs(); !reflect.DeepEqual(got, []string{"48", "49"}) {
+		t.Fatalf("SourceIDs: got %v", got)
+	}
+	var cases = []struct {
+		profile     *Profile
+		field       string
+		records     int64
+		values      int64
+		cardinality uint64
+		top         []Value
+	}{
+		{r.Overall, "rft.atitle", 2, 2, 2, []Value{{"On Things", 1}, {"Sun-Sensitizing Effects", 1}}},
+		{r.Overall, "rft.issn", 2, 3, 2, []Value{{"0022-202X", 2}, {"1523-1747", 1}}},
+		{r.Overall, "languages", 3, 3, 2, []Value{{"eng", 2}, {"ger", 1}}},
+		{r.Overall, "authors", 1, 2, 0, nil},
+		{r.Overall, "authors.rft.aulast", 1, 1, 1, []Value{{"Denning", 1}}},
+		{r.Overall, "x.oa", 1, 1, 1, []Value{{"true", 1}}},
+		{r.Overall, "rft.jtitle", 0, 0, 0, nil},
+		{r.Sources["49"], "rft.issn", 2, 3, 2, []Value{{"0022-202X", 2}, {"1523-1747", 1}}},
+		{r.Sources["48"], "languages", 1, 1, 1, []Value{{"ger", 1}}},
+	}
+	for _, c := range cases {
+		f, ok := c.profile.Fields[c.field]
+		if !ok {
+			t.Errorf("%s: missing", c.field)
+			continue
+		}
+		if f.Records != c.records || f.Values != c.values || f.Cardinality != c.cardinality {
+			t.Errorf("%s: got %d/%d/%d, want %d/%d/%d", c.field,
+				f.Records, f.Values, f.Cardinality, c.records, c.values, c.cardinality)
+		}
+		if len(f.Top) > 0 || len(c.top) > 0 {
+			if !reflect.DeepEqual(f.Top, c.top) {
+				t.Errorf("%s: got top %v, want %v", c.field, f.Top, c.top)
+			}
+		}
+	}
+	if got := r.Overall.Fields["languages"].Fill; math.Abs(got-1) > 1e-9 {
+		t.Errorf("fill: got %v, want 1", got)
+	}
+	if got := r.Overall.Fields["x.date"].Years; !reflect.DeepEqual(got, map[string]int64{"2010": 2, "1999": 1}) {
+		t.Errorf("years: got %v", got)
+	}
+	want := []Bucket{{Min: 8, Max: 15, Count: 1}, {Min: 16, Max: 31, Count: 1}}
+	if got := r.Overall.Fields["rft.atitle"].Lengths; !reflect.DeepEqual(got, want) {
+		t.Errorf("lengths: got %v, want %v", got, want)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := testReport(t, testRecords)
+	// Roundtrip, so we compare with a saved report.
+	b, err := json.Marshal(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err = ReadReport(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes := Diff(a, a, 0.01); len(changes) > 0 {
+		t.Errorf("Diff: got %v, want no changes", changes)
+	}
+	// A converter regression, languages missing for source 49, source 48 gone.
+	b2 := testReport(t, []string{
+		`{"finc.source_id": "49", "rft.atitle": "On Things", "rft.issn": ["0022-202X", "1523-1747"], "x.date": "2010-01-01T00:00:00Z", "authors": [{"rft.aulast": "Denning"}, {"rft.au": "Karl Kraus"}]}`,
+		`{"finc.source_id": "49", "rft.atitle": "Sun-Sensitizing Effects", "rft.issn": ["0022-202X"], "x.date": "2010-05-01T00:00:00Z"}`,
+	})
+	changes := Diff(a, b2, 0.01)
+	var found = make(map[string]bool)
+	for _, c := range changes {
+		found[c.Source+"/"+c.Field+"/"+c.What] = true
+	}
+	for _, k := range []string{
+		"//records",
+		"/languages/fill",
+		"48//records",
+		"49/languages/fill",
+		"49/languages/cardinality",
+	} {
+		if !found[k] {
+			t.Errorf("Diff: missing change %s in %v", k, changes)
+		}
+	}
+	if found["48/languages/fill"] {
+		t.Errorf("Diff: got field changes for a missing source")
+	}
+	if _, err := ReadReport(bytes.NewReader([]byte(`{}`))); err == nil {
+		t.Errorf("ReadReport: got nil, want err")
+	}
+}
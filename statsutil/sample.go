@@ -0,0 +1,129 @@
+package statsutil
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"hash/fnv"
+	"sort"
+	"sync"
+)
+
+// Sample is a record kept by a Sampler.
+type Sample struct {
+	Stratum  string
+	Priority uint64
+	Lineno   int64
+	Data     []byte
+}
+
+// less orders samples by priority, ties broken by line number.
+func (s *Sample) less(t *Sample) bool {
+	if s.Priority != t.Priority {
+		return s.Priority < t.Priority
+	}
+	return s.Lineno < t.Lineno
+}
+
+// sampleHeap is a max heap, so the sample with the highest priority can be
+// replaced.
+type sampleHeap []*Sample
+
+func (h sampleHeap) Len() int            { return len(h) }
+func (h sampleHeap) Less(i, j int) bool  { return h[j].less(h[i]) }
+func (h sampleHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *sampleHeap) Push(x interface{}) { *h = append(*h, x.(*Sample)) }
+func (h *sampleHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// Sampler keeps up to Size records per stratum, e.g. per source and
+// collection. Each record gets a pseudo-random priority derived from the seed
+// and a record key, and the records with the lowest priorities are kept. The
+// sample does not depend on the order records are added in, so it is
+// reproducible with parallel processing, too. It is safe for concurrent use.
+type Sampler struct {
+	Size int // records per stratum, zero keeps all records
+	Seed int64
+
+	mu     sync.Mutex
+	seen   int64
+	strata map[string]*sampleHeap
+}
+
+// NewSampler returns a sampler keeping size records per stratum.
+func NewSampler(size int, seed int64) *Sampler {
+	return &Sampler{Size: size, Seed: seed, strata: make(map[string]*sampleHeap)}
+}
+
+// priority hashes the seed and a key.
+func (s *Sampler) priority(key []byte) uint64 {
+	var (
+		h    = fnv.New64a()
+		seed [8]byte
+	)
+	binary.LittleEndian.PutUint64(seed[:], uint64(s.Seed))
+	_, _ = h.Write(seed[:])
+	_, _ = h.Write(key)
+	return h.Sum64()
+}
+
+// Add offers a record to the sampler. The key identifies a record, e.g. its
+// id; the data is copied, if kept.
+func (s *Sampler) Add(stratum string, key []byte, lineno int64, data []byte) {
+	sample := &Sample{
+		Stratum:  stratum,
+		Priority: s.priority(key),
+		Lineno:   lineno,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seen++
+	h, ok := s.strata[stratum]
+	if !ok {
+		h = &sampleHeap{}
+		s.strata[stratum] = h
+	}
+	if s.Size > 0 && h.Len() >= s.Size {
+		if !sample.less((*h)[0]) {
+			return
+		}
+		heap.Pop(h)
+	}
+	sample.Data = append([]byte(nil), data...)
+	heap.Push(h, sample)
+}
+
+// Seen returns the number of records offered.
+func (s *Sampler) Seen() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seen
+}
+
+// Strata returns the number of strata.
+func (s *Sampler) Strata() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.strata)
+}
+
+// Samples returns the kept records, ordered by stratum and line number.
+func (s *Sampler) Samples() []*Sample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result []*Sample
+	for _, h := range s.strata {
+		result = append(result, *h...)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Stratum != result[j].Stratum {
+			return result[i].Stratum < result[j].Stratum
+		}
+		return result[i].Lineno < result[j].Lineno
+	})
+	return result
+}
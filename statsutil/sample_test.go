@@ -0,0 +1,83 @@
+package statsutil
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// lines returns the line numbers of samples.
+func lines(samples []*Sample) (result []int64) {
+	for _, s := range samples {
+		result = append(result, s.Lineno)
+	}
+	return result
+}
+
+func TestSampler(t *testing.T) {
+	// Three strata, with 100, 10 and 1 records.
+	var records []int64
+	for i := int64(0); i < 111; i++ {
+		records = append(records, i)
+	}
+	stratum := func(i int64) string {
+		switch {
+		case i < 100:
+			return "49"
+		case i < 110:
+			return "48"
+		default:
+			return "28"
+		}
+	}
+	run := func(seed int64, order []int64) *Sampler {
+		s := NewSampler(5, seed)
+		var wg sync.WaitGroup
+		for _, i := range order {
+			wg.Add(1)
+			go func(i int64) {
+				defer wg.Done()
+				s.Add(stratum(i), []byte(fmt.Sprintf("id-%d", i)), i, []byte(fmt.Sprintf("record %d", i)))
+			}(i)
+		}
+		wg.Wait()
+		return s
+	}
+	a := run(1, records)
+	samples := a.Samples()
+	if len(samples) != 11 || a.Strata() != 3 || a.Seen() != 111 {
+		t.Fatalf("got %d samples, %d strata, %d seen, want 11, 3, 111", len(samples), a.Strata(), a.Seen())
+	}
+	counts := make(map[string]int)
+	for _, s := range samples {
+		counts[s.Stratum]++
+		if want := fmt.Sprintf("record %d", s.Lineno); string(s.Data) != want {
+			t.Errorf("got %s, want %s", s.Data, want)
+		}
+	}
+	if want := map[string]int{"49": 5, "48": 5, "28": 1}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("got %v, want %v", counts, want)
+	}
+	// Same seed, different order, same sample.
+	shuffled := append([]int64(nil), records...)
+	rand.New(rand.NewSource(2)).Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	if b := run(1, shuffled); !reflect.DeepEqual(lines(b.Samples()), lines(samples)) {
+		t.Errorf("got %v, want %v", lines(b.Samples()), lines(samples))
+	}
+	// Different seed, different sample.
+	if c := run(2, records); reflect.DeepEqual(lines(c.Samples()), lines(samples)) {
+		t.Errorf("got the same sample for different seeds: %v", lines(samples))
+	}
+	// Size zero keeps all.
+	s := NewSampler(0, 1)
+	for _, i := range records {
+		s.Add(stratum(i), nil, i, nil)
+	}
+	if n := len(s.Samples()); n != 111 {
+		t.Errorf("got %d samples, want 111", n)
+	}
+}
@@ -0,0 +1,283 @@
+package tagging
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SchemaVersion of the amsl database, kept in the sqlite user_version. A
+// database without version, but with an amsl table, is version 1.
+const SchemaVersion = 2
+
+// migrations[i] upgrades a database from version i to i+1.
+var migrations = []string{
+	// 1: The original amsl table.
+	`
+	create table amsl (
+		shard text not null,
+		isil text not null,
+		sid text not null,
+		tcid text not null,
+		mc text not null,
+		hfuri text,
+		hflabel text,
+		hflink text,
+		hfeval text,
+		cfuri text,
+		cflabel text,
+		cflink text,
+		cfelink text,
+		pisil text,
+		docuri text,
+		doclabel text
+	);
+
+	create index amsl_isil on amsl(isil);
+	create index amsl_isil_sid on amsl(isil, sid);
+	create index amsl_isil_sid_mc on amsl(isil, sid, mc);
+	create index amsl_mc on amsl(mc);
+	create index amsl_sid on amsl(sid);
+	create index amsl_sid_mc on amsl(sid, mc);
+	create index amsl_sid_tcid on amsl(sid, tcid);
+	create index amsl_tcid on amsl(tcid);
+	`,
+	// 2: Only keep indexes used by Labeler and Diff, add provenance.
+	`
+	drop index amsl_isil_sid;
+	drop index amsl_isil_sid_mc;
+	drop index amsl_mc;
+	drop index amsl_sid;
+	drop index amsl_tcid;
+
+	create table meta (
+		key text primary key,
+		value text
+	);
+	`,
+}
+
+// schemaVersion returns the schema version of a database.
+func schemaVersion(db *sql.DB) (int, error) {
+	var version int
+	if err := db.QueryRow("pragma user_version").Scan(&version); err != nil {
+		return 0, err
+	}
+	if version > 0 {
+		return version, nil
+	}
+	var n int
+	err := db.QueryRow(`select count(*) from sqlite_master where type = 'table' and name = 'amsl'`).Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// CheckSchema returns an error, if the database was created by a newer
+// version of span. Older versions stay readable.
+func CheckSchema(db *sql.DB) error {
+	version, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version == 0 {
+		return fmt.Errorf("no amsl table found")
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("schema version %d is newer than supported version %d", version, SchemaVersion)
+	}
+	return nil
+}
+
+// Migrate creates or upgrades the amsl schema to SchemaVersion.
+func Migrate(db *sql.DB) error {
+	version, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("schema version %d is newer than supported version %d", version, SchemaVersion)
+	}
+	for ; version < SchemaVersion; version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration to version %d: %v", version+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("pragma user_version = %d", version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetMeta records a key value pair about the database, e.g. its origin.
+func SetMeta(db *sql.DB, key, value string) error {
+	_, err := db.Exec(`insert or replace into meta (key, value) values (?, ?)`, key, value)
+	return err
+}
+
+// Attachment groups the rows of an ISIL, source and collection. There may be
+// multiple rows, one per holding file.
+type Attachment struct {
+	ISIL                           string
+	SourceID                       string
+	TechnicalCollectionID          string
+	MegaCollection                 string
+	EvaluateHoldingsFileForLibrary string
+	LinksToHoldingsFile            []string
+	LinkToContentFile              string
+	ExternalLinkToContentFile      string
+}
+
+// Key identifies an attachment.
+func (a *Attachment) Key() string {
+	return strings.Join([]string{a.ISIL, a.SourceID, a.TechnicalCollectionID, a.MegaCollection}, "\t")
+}
+
+// Differences returns short descriptions of the differences between two
+// attachments with the same key.
+func (a *Attachment) Differences(b *Attachment) []string {
+	var result []string
+	if a.EvaluateHoldingsFileForLibrary != b.EvaluateHoldingsFileForLibrary {
+		result = append(result, fmt.Sprintf("hfeval %s -> %s",
+			a.EvaluateHoldingsFileForLibrary, b.EvaluateHoldingsFileForLibrary))
+	}
+	if x, y := strings.Join(a.LinksToHoldingsFile, " "), strings.Join(b.LinksToHoldingsFile, " "); x != y {
+		result = append(result, fmt.Sprintf("hflink %s -> %s", x, y))
+	}
+	if a.LinkToContentFile != b.LinkToContentFile {
+		result = append(result, fmt.Sprintf("cflink %s -> %s", a.LinkToContentFile, b.LinkToContentFile))
+	}
+	if a.ExternalLinkToContentFile != b.ExternalLinkToContentFile {
+		result = append(result, fmt.Sprintf("cfelink %s -> %s",
+			a.ExternalLinkToContentFile, b.ExternalLinkToContentFile))
+	}
+	return result
+}
+
+// Attachments reads all attachments from an amsl database, keyed by
+// Attachment.Key.
+func Attachments(db *sql.DB) (map[string]*Attachment, error) {
+	if err := CheckSchema(db); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`
+		SELECT isil, sid, tcid, mc, ifnull(hfeval, ''), ifnull(hflink, ''),
+		ifnull(cflink, ''), ifnull(cfelink, '') FROM amsl`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make(map[string]*Attachment)
+	for rows.Next() {
+		var (
+			a      Attachment
+			hflink string
+		)
+		if err := rows.Scan(&a.ISIL, &a.SourceID, &a.TechnicalCollectionID, &a.MegaCollection,
+			&a.EvaluateHoldingsFileForLibrary, &hflink, &a.LinkToContentFile,
+			&a.ExternalLinkToContentFile); err != nil {
+			return nil, err
+		}
+		v, ok := result[a.Key()]
+		if !ok {
+			v = &a
+			result[a.Key()] = v
+		}
+		if hflink != "" {
+			v.LinksToHoldingsFile = append(v.LinksToHoldingsFile, hflink)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, a := range result {
+		sort.Strings(a.LinksToHoldingsFile)
+	}
+	return result, nil
+}
+
+// Change of an attachment between two databases.
+type Change struct {
+	Kind        string // added, removed or changed
+	Attachment  *Attachment
+	Differences []string // for changed attachments
+}
+
+// Diff returns the attachment changes between two amsl databases, grouped by
+// ISIL, with changes sorted by source, collection and kind.
+func Diff(from, to *sql.DB) (map[string][]Change, error) {
+	a, err := Attachments(from)
+	if err != nil {
+		return nil, fmt.Errorf("old: %v", err)
+	}
+	b, err := Attachments(to)
+	if err != nil {
+		return nil, fmt.Errorf("new: %v", err)
+	}
+	result := make(map[string][]Change)
+	for k, v := range a {
+		w, ok := b[k]
+		if !ok {
+			result[v.ISIL] = append(result[v.ISIL], Change{Kind: "removed", Attachment: v})
+			continue
+		}
+		if d := v.Differences(w); len(d) > 0 {
+			result[v.ISIL] = append(result[v.ISIL], Change{Kind: "changed", Attachment: w, Differences: d})
+		}
+	}
+	for k, w := range b {
+		if _, ok := a[k]; !ok {
+			result[w.ISIL] = append(result[w.ISIL], Change{Kind: "added", Attachment: w})
+		}
+	}
+	for _, changes := range result {
+		sort.Slice(changes, func(i, j int) bool {
+			ki, kj := changes[i].Attachment.Key(), changes[j].Attachment.Key()
+			if ki != kj {
+				return ki < kj
+			}
+			return changes[i].Kind < changes[j].Kind
+		})
+	}
+	return result, nil
+}
+
+// WriteRows inserts config rows into an amsl database in a single
+// transaction, e.g. rows obtained from FOLIO, see FolioConfig.
+func WriteRows(db *sql.DB, rows []ConfigRow) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`insert into amsl (shard, isil, sid, tcid, mc,
+		hfuri, hflabel, hflink, hfeval, cfuri, cflabel, cflink, cfelink,
+		pisil, docuri, doclabel) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, r := range rows {
+		_, err := stmt.Exec(r.ShardLabel, r.ISIL, r.SourceID, r.TechnicalCollectionID,
+			r.MegaCollection, r.HoldingsFileURI, r.HoldingsFileLabel, r.LinkToHoldingsFile,
+			r.EvaluateHoldingsFileForLibrary, r.ContentFileURI, r.ContentFileLabel,
+			r.LinkToContentFile, r.ExternalLinkToContentFile, r.ProductISIL,
+			r.DokumentURI, r.DokumentLabel)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
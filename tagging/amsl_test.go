@@ -0,0 +1,139 @@
+package tagging
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// testDatabase returns a new database in a temporary directory.
+func testDatabase(t *testing.T, dir, name string) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// insert adds rows with isil, sid, tcid, mc, hfeval, hflink.
+func insert(t *testing.T, db *sql.DB, rows [][]string) {
+	for _, r := range rows {
+		_, err := db.Exec(`insert into amsl (shard, isil, sid, tcid, mc, hfeval, hflink)
+			values ('UBL-ai', ?, ?, ?, ?, ?, ?)`, r[0], r[1], r[2], r[3], r[4], r[5])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "span-tagging-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db := testDatabase(t, dir, "new.db")
+	defer db.Close()
+	if err := CheckSchema(db); err == nil {
+		t.Errorf("CheckSchema: got nil, want err for empty database")
+	}
+	if err := Migrate(db); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if v, _ := schemaVersion(db); v != SchemaVersion {
+		t.Errorf("got version %d, want %d", v, SchemaVersion)
+	}
+	if err := SetMeta(db, "origin", "test"); err != nil {
+		t.Errorf("SetMeta: %v", err)
+	}
+	// Idempotent.
+	if err := Migrate(db); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	// A database written by previous versions has no user_version.
+	legacy := testDatabase(t, dir, "legacy.db")
+	defer legacy.Close()
+	if _, err := legacy.Exec(migrations[0]); err != nil {
+		t.Fatal(err)
+	}
+	insert(t, legacy, [][]string{{"DE-14", "55", "sid-55-col-arts", "JSTOR Arts", "no", ""}})
+	if err := CheckSchema(legacy); err != nil {
+		t.Errorf("CheckSchema: got %v, want nil for legacy database", err)
+	}
+	if err := Migrate(legacy); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if v, _ := schemaVersion(legacy); v != SchemaVersion {
+		t.Errorf("got version %d, want %d", v, SchemaVersion)
+	}
+	var n int
+	if err := legacy.QueryRow("select count(*) from amsl").Scan(&n); err != nil || n != 1 {
+		t.Errorf("got %d rows (%v), want 1", n, err)
+	}
+
+	if _, err := db.Exec("pragma user_version = 99"); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckSchema(db); err == nil {
+		t.Errorf("CheckSchema: got nil, want err for newer version")
+	}
+	if err := Migrate(db); err == nil {
+		t.Errorf("Migrate: got nil, want err for newer version")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "span-tagging-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a, b := testDatabase(t, dir, "a.db"), testDatabase(t, dir, "b.db")
+	defer a.Close()
+	defer b.Close()
+	for _, db := range []*sql.DB{a, b} {
+		if err := Migrate(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	insert(t, a, [][]string{
+		{"DE-14", "55", "sid-55-col-arts", "JSTOR Arts", "yes", "http://example.com/1"},
+		{"DE-14", "55", "sid-55-col-arts", "JSTOR Arts", "yes", "http://example.com/2"},
+		{"DE-15", "49", "sid-49-col-x", "Crossref", "no", ""},
+		{"DE-15", "48", "sid-48-col-y", "WISO", "no", ""},
+	})
+	insert(t, b, [][]string{
+		{"DE-14", "55", "sid-55-col-arts", "JSTOR Arts", "yes", "http://example.com/2"},
+		{"DE-14", "55", "sid-55-col-arts", "JSTOR Arts", "yes", "http://example.com/1"},
+		{"DE-15", "48", "sid-48-col-y", "WISO", "yes", ""},
+		{"DE-105", "49", "sid-49-col-x", "Crossref", "no", ""},
+	})
+	changes, err := Diff(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary := make(map[string][]string)
+	for isil, cs := range changes {
+		for _, c := range cs {
+			summary[isil] = append(summary[isil], c.Kind+" "+c.Attachment.SourceID)
+		}
+	}
+	want := map[string][]string{
+		"DE-15":  {"changed 48", "removed 49"},
+		"DE-105": {"added 49"},
+	}
+	if !reflect.DeepEqual(summary, want) {
+		t.Errorf("Diff: got %v, want %v", summary, want)
+	}
+	if d := changes["DE-15"][0].Differences; !reflect.DeepEqual(d, []string{"hfeval no -> yes"}) {
+		t.Errorf("Differences: got %v", d)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"path"
 	"path/filepath"
 
+	"github.com/miku/span/folio"
 	"github.com/miku/span/formats/finc"
 	"github.com/miku/span/licensing"
 	"github.com/miku/span/licensing/kbart"
@@ -32,6 +33,9 @@ type HFCache struct {
 	cacheHome     string
 	forceDownload bool
 	entries       map[string]map[string][]licensing.Entry
+	// folio, if set, resolves links to FOLIO finc-config files, which require
+	// authentication and are revalidated instead of downloaded again.
+	folio *folio.API
 }
 
 // cacheFilename returns the path to the locally cached version of a given URL.
@@ -41,12 +45,14 @@ func (c *HFCache) cacheFilename(hflink string) string {
 	return filepath.Join(c.cacheHome, fmt.Sprintf("%x", h.Sum(nil)))
 }
 
-// populate fills the entries map from a given URL. The URL must be a link to a
-// tab separated file. When a zip file is encountered, we assume all members of
-// the zip file are KBART files themselves.
-func (c *HFCache) populate(hflink string) error {
-	if _, ok := c.entries[hflink]; ok {
-		return nil
+// download ensures a local copy of a holding file and returns its filename.
+func (c *HFCache) download(hflink string) (string, error) {
+	if c.folio != nil {
+		if id, ok := c.folio.FileID(hflink); ok {
+			return c.folio.DownloadFile(id)
+		}
+	} else if folio.IsFileLink(hflink) {
+		return "", fmt.Errorf("holding file %s requires FOLIO authentication, use -folio", hflink)
 	}
 	var (
 		filename = c.cacheFilename(hflink)
@@ -54,23 +60,35 @@ func (c *HFCache) populate(hflink string) error {
 	)
 	if fi, err := os.Stat(dir); os.IsNotExist(err) {
 		if err := os.MkdirAll(dir, 0755); err != nil {
-			return err
+			return "", err
 		}
 	} else if !fi.IsDir() {
-		return fmt.Errorf("expected cache directory at: %s", dir)
+		return "", fmt.Errorf("expected cache directory at: %s", dir)
 	}
 	if _, err := os.Stat(filename); os.IsNotExist(err) || c.forceDownload {
 		if c.forceDownload {
 			log.Printf("redownloading %s", hflink)
 		}
 		if err := xio.AtomicDownload(hflink, filename); err != nil {
-			return err
+			return "", err
 		}
 	}
-	var (
-		h       = new(kbart.Holdings)
-		zr, err = zip.OpenReader(filename)
-	)
+	return filename, nil
+}
+
+// populate fills the entries map from a given URL. The URL must be a link to a
+// tab separated file. When a zip file is encountered, we assume all members of
+// the zip file are KBART files themselves.
+func (c *HFCache) populate(hflink string) error {
+	if _, ok := c.entries[hflink]; ok {
+		return nil
+	}
+	filename, err := c.download(hflink)
+	if err != nil {
+		return err
+	}
+	h := new(kbart.Holdings)
+	zr, err := zip.OpenReader(filename)
 	if err == nil {
 		defer zr.Close()
 		for _, f := range zr.File {
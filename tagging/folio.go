@@ -0,0 +1,158 @@
+package tagging
+
+import (
+	"log"
+	"sort"
+	"strconv"
+
+	"github.com/miku/span/folio"
+)
+
+// FolioConfig holds the parts of FOLIO mod-finc-config relevant to
+// attachments, so they can be turned into the same rows as found in the amsl
+// database.
+type FolioConfig struct {
+	Collections []folio.FincConfigMetadataCollection
+	Sources     []folio.FincConfigMetadataSource
+	Filters     []folio.FincSelectFilter
+	// FilterCollections maps a filter id to the ids of the collections it
+	// applies to.
+	FilterCollections map[string][]string
+	// FileLink returns a download link for a filter file id.
+	FileLink func(fileID string) string
+}
+
+// FetchFolioConfig fetches collections matching a CQL query, together with all
+// metadata sources and filters, fetching pageSize items per request.
+func FetchFolioConfig(api *folio.API, cql string, pageSize int) (*FolioConfig, error) {
+	collections, err := api.AllMetadataCollections(cql, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	sources, err := api.MetadataSources("", pageSize)
+	if err != nil {
+		return nil, err
+	}
+	filters, err := api.Filters("", pageSize)
+	if err != nil {
+		return nil, err
+	}
+	c := &FolioConfig{
+		Collections:       collections,
+		Sources:           sources,
+		Filters:           filters,
+		FilterCollections: make(map[string][]string),
+		FileLink:          api.FileLink,
+	}
+	for _, f := range filters {
+		ids, err := api.FilterCollections(f.Id)
+		if err != nil {
+			return nil, err
+		}
+		c.FilterCollections[f.Id] = ids
+	}
+	log.Printf("folio: %d collections, %d sources, %d filters",
+		len(collections), len(sources), len(filters))
+	return c, nil
+}
+
+// holdingFile is a whitelist filter file.
+type holdingFile struct {
+	label string
+	link  string
+}
+
+// Rows turns the FOLIO configuration into config rows: one row per ISIL
+// selecting a collection, mega collection and whitelist filter file. Without
+// a whitelist file for the collection, the holdings are not evaluated.
+// Blacklist filters have no equivalent in the amsl model and are skipped.
+func (c *FolioConfig) Rows() []ConfigRow {
+	sources := make(map[string]folio.FincConfigMetadataSource)
+	for _, s := range c.Sources {
+		sources[s.Id] = s
+	}
+	// isil -> collection id -> whitelist files
+	var (
+		whitelists = make(map[string]map[string][]holdingFile)
+		skipped    = make(map[string]int) // isil -> non-whitelist filters
+	)
+	for _, f := range c.Filters {
+		if f.Type != "Whitelist" {
+			skipped[f.Isil]++
+			continue
+		}
+		if whitelists[f.Isil] == nil {
+			whitelists[f.Isil] = make(map[string][]holdingFile)
+		}
+		for _, id := range c.FilterCollections[f.Id] {
+			for _, ff := range f.FilterFiles {
+				if ff.FileId == "" {
+					continue
+				}
+				hf := holdingFile{label: ff.Label, link: ff.FileId}
+				if c.FileLink != nil {
+					hf.link = c.FileLink(ff.FileId)
+				}
+				whitelists[f.Isil][id] = append(whitelists[f.Isil][id], hf)
+			}
+		}
+	}
+	var isils []string
+	for isil := range skipped {
+		isils = append(isils, isil)
+	}
+	sort.Strings(isils)
+	for _, isil := range isils {
+		log.Printf("folio: %s: skipping %d non-whitelist filter(s), records may be attached to more collections than configured",
+			isil, skipped[isil])
+	}
+	var rows []ConfigRow
+	for _, coll := range c.Collections {
+		source, ok := sources[coll.MdSource.Id]
+		if !ok {
+			log.Printf("folio: collection %s (%s) without known source, skipping",
+				coll.Id, coll.Label)
+			continue
+		}
+		megaCollections := coll.SolrMegaCollections
+		if len(megaCollections) == 0 {
+			megaCollections = []string{coll.Label}
+		}
+		var contentFile string
+		if cf := coll.ContentFiles(); len(cf) > 0 {
+			contentFile = cf[0]
+		}
+		for _, isil := range coll.SelectedBy {
+			for _, mc := range megaCollections {
+				row := ConfigRow{
+					ShardLabel:                     source.SolrShard,
+					ISIL:                           isil,
+					SourceID:                       strconv.Itoa(source.SourceId),
+					TechnicalCollectionID:          coll.CollectionId,
+					MegaCollection:                 mc,
+					EvaluateHoldingsFileForLibrary: "no",
+					LinkToContentFile:              contentFile,
+				}
+				files := whitelists[isil][coll.Id]
+				if len(files) == 0 {
+					rows = append(rows, row)
+					continue
+				}
+				for _, hf := range files {
+					r := row
+					r.EvaluateHoldingsFileForLibrary = "yes"
+					r.HoldingsFileLabel = hf.label
+					r.LinkToHoldingsFile = hf.link
+					rows = append(rows, r)
+				}
+			}
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].ISIL != rows[j].ISIL {
+			return rows[i].ISIL < rows[j].ISIL
+		}
+		return rows[i].SourceID < rows[j].SourceID
+	})
+	return rows
+}
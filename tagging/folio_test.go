@@ -0,0 +1,80 @@
+package tagging
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/miku/span/folio"
+)
+
+func TestFolioConfigRows(t *testing.T) {
+	var c FolioConfig
+	c.Sources = []folio.FincConfigMetadataSource{
+		{Id: "s-49", SourceId: 49, SolrShard: "UBL ai"},
+		{Id: "s-55", SourceId: 55, SolrShard: "UBL ai"},
+	}
+	c.Collections = make([]folio.FincConfigMetadataCollection, 3)
+	c.Collections[0].Id = "c-1"
+	c.Collections[0].CollectionId = "sid-49-col-crossref"
+	c.Collections[0].MdSource.Id = "s-49"
+	c.Collections[0].SolrMegaCollections = []string{"Crossref"}
+	c.Collections[0].SelectedBy = []string{"DE-15", "DE-14"}
+	c.Collections[1].Id = "c-2"
+	c.Collections[1].CollectionId = "sid-55-col-arts"
+	c.Collections[1].Label = "JSTOR Arts"
+	c.Collections[1].MdSource.Id = "s-55"
+	c.Collections[1].SelectedBy = []string{"DE-15"}
+	c.Collections[2].Id = "c-3"
+	c.Collections[2].MdSource.Id = "s-unknown"
+	c.Collections[2].SelectedBy = []string{"DE-15"}
+	c.Filters = []folio.FincSelectFilter{
+		{Id: "f-1", Type: "Whitelist", Isil: "DE-15", FilterFiles: []folio.FilterFile{
+			{Label: "KBART", FileId: "file-1"},
+			{Label: "EZB", FileId: "file-2"},
+		}},
+		{Id: "f-2", Type: "Blacklist", Isil: "DE-14", FilterFiles: []folio.FilterFile{
+			{Label: "Predatory", FileId: "file-3"},
+		}},
+	}
+	c.FilterCollections = map[string][]string{"f-1": {"c-1"}, "f-2": {"c-1"}}
+	c.FileLink = func(id string) string { return "http://folio/finc-config/files/" + id }
+
+	var got [][]string
+	for _, r := range c.Rows() {
+		got = append(got, []string{r.ISIL, r.SourceID, r.TechnicalCollectionID,
+			r.MegaCollection, r.EvaluateHoldingsFileForLibrary, r.LinkToHoldingsFile})
+	}
+	want := [][]string{
+		{"DE-14", "49", "sid-49-col-crossref", "Crossref", "no", ""},
+		{"DE-15", "49", "sid-49-col-crossref", "Crossref", "yes", "http://folio/finc-config/files/file-1"},
+		{"DE-15", "49", "sid-49-col-crossref", "Crossref", "yes", "http://folio/finc-config/files/file-2"},
+		{"DE-15", "55", "sid-55-col-arts", "JSTOR Arts", "no", ""},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Rows: got %v, want %v", got, want)
+	}
+
+	// Rows written to a database read back as attachments.
+	dir, err := ioutil.TempDir("", "span-tagging-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db := testDatabase(t, dir, "folio.db")
+	defer db.Close()
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteRows(db, c.Rows()); err != nil {
+		t.Fatal(err)
+	}
+	attachments, err := Attachments(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments) != 3 {
+		t.Errorf("got %d attachments, want 3", len(attachments))
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/adrg/xdg"
 	"github.com/jmoiron/sqlx"
 	"github.com/miku/span/container"
+	"github.com/miku/span/folio"
 	"github.com/miku/span/formats/finc"
 	"github.com/miku/span/licensing"
 	"github.com/miku/span/strutil"
@@ -68,6 +69,9 @@ func New(dbFile string) (*Labeler, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := CheckSchema(db.DB); err != nil {
+		return nil, fmt.Errorf("%s: %v", dbFile, err)
+	}
 	return &Labeler{
 		dbFile: dbFile,
 		db:     db,
@@ -85,6 +89,13 @@ func New(dbFile string) (*Labeler, error) {
 	}, nil
 }
 
+// UseFolio resolves holding file links pointing to FOLIO finc-config files
+// through the given, authenticated API, e.g. for databases written by
+// span-folio -db.
+func (l *Labeler) UseFolio(api *folio.API) {
+	l.hfcache.folio = api
+}
+
 // matchingRows returns a list of relevant rows for a given document. This is a
 // prefilter (going from 200K+ rows 10s of rows).
 func (l *Labeler) matchingRows(doc *finc.IntermediateSchema) (result []ConfigRow, err error) {
@@ -0,0 +1,258 @@
+package webhook
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// DefaultRules runs an index review for each review file changed in a push.
+var DefaultRules = `
+rules:
+    - name: review
+      paths: ["^docs/review.*yaml"]
+      command: span-review
+      args: ["-c", "{{ .Path }}"]
+      ticket: "{{ ticket .Path }}"
+`
+
+// Rule maps push events to an action. Empty filters match anything, except
+// for events, which default to "push".
+type Rule struct {
+	Name      string   `yaml:"name"`
+	Providers []string `yaml:"providers"` // e.g. gitlab, github, gitea
+	Events    []string `yaml:"events"`    // push or tag
+	Branches  []string `yaml:"branches"`  // glob patterns, e.g. "release-*"
+	Paths     []string `yaml:"paths"`     // regular expressions on file paths
+	Once      bool     `yaml:"once"`      // run once per push, not once per matching file
+	Command   string   `yaml:"command"`
+	Args      []string `yaml:"args"`   // templates
+	Ticket    string   `yaml:"ticket"` // template, ticket to report to
+	Post      bool     `yaml:"post"`   // post output to ticket on success, too
+
+	paths []*regexp.Regexp
+}
+
+// Rules is a list of rules, read from a YAML file.
+type Rules struct {
+	Rules []*Rule `yaml:"rules"`
+}
+
+// ReadRules reads and validates rules from a YAML stream.
+func ReadRules(r io.Reader) (*Rules, error) {
+	var rules Rules
+	dec := yaml.NewDecoder(r)
+	dec.SetStrict(true)
+	if err := dec.Decode(&rules); err != nil {
+		return nil, err
+	}
+	for i, rule := range rules.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i)
+		}
+		if rule.Command == "" {
+			return nil, fmt.Errorf("%s: command missing", rule.Name)
+		}
+		if len(rule.Events) == 0 {
+			rule.Events = []string{"push"}
+		}
+		for _, e := range rule.Events {
+			if e != "push" && e != "tag" {
+				return nil, fmt.Errorf("%s: unknown event %q, want push or tag", rule.Name, e)
+			}
+		}
+		for _, b := range rule.Branches {
+			if _, err := path.Match(b, ""); err != nil {
+				return nil, fmt.Errorf("%s: invalid branch pattern %q", rule.Name, b)
+			}
+		}
+		for _, p := range rule.Paths {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", rule.Name, err)
+			}
+			rule.paths = append(rule.paths, re)
+		}
+		for _, s := range append([]string{rule.Ticket}, rule.Args...) {
+			if _, err := template.New("").Funcs(placeholderFuncs).Parse(s); err != nil {
+				return nil, fmt.Errorf("%s: %v", rule.Name, err)
+			}
+		}
+	}
+	return &rules, nil
+}
+
+// placeholderFuncs allows to validate templates, which use functions
+// supplied only at render time.
+var placeholderFuncs = template.FuncMap{
+	"ticket": func(string) string { return "" },
+}
+
+// Type returns "tag" for tag pushes and "push" otherwise.
+func (p *Push) Type() string {
+	if strings.HasPrefix(p.Ref, "refs/tags/") {
+		return "tag"
+	}
+	return "push"
+}
+
+// contains returns true, if s is in list.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns the files of a push a rule applies to and whether the rule
+// applies at all. A rule without paths applies once per push.
+func (r *Rule) Match(push *Push) ([]string, bool) {
+	if len(r.Providers) > 0 && !contains(r.Providers, push.Provider) {
+		return nil, false
+	}
+	if !contains(r.Events, push.Type()) {
+		return nil, false
+	}
+	if len(r.Branches) > 0 {
+		var ok bool
+		for _, b := range r.Branches {
+			if ok, _ = path.Match(b, push.Branch()); ok {
+				break
+			}
+		}
+		if !ok {
+			return nil, false
+		}
+	}
+	if len(r.paths) == 0 {
+		return nil, true
+	}
+	var (
+		files []string
+		seen  = make(map[string]bool)
+	)
+	for _, re := range r.paths {
+		for _, f := range push.MatchFiles(re) {
+			if !seen[f] {
+				seen[f] = true
+				files = append(files, f)
+			}
+		}
+	}
+	return files, len(files) > 0
+}
+
+// Match is a rule applying to a push, with the matching files.
+type Match struct {
+	Rule  *Rule
+	Files []string
+}
+
+// Match returns all rules applying to a push.
+func (rs *Rules) Match(push *Push) (result []Match) {
+	for _, r := range rs.Rules {
+		if files, ok := r.Match(push); ok {
+			result = append(result, Match{Rule: r, Files: files})
+		}
+	}
+	return result
+}
+
+// Action is a command to run, derived from a rule.
+type Action struct {
+	Rule    string
+	Command string
+	Args    []string
+	Ticket  string
+	Post    bool
+}
+
+// String renders the command line of an action.
+func (a Action) String() string {
+	return strings.TrimSpace(a.Command + " " + strings.Join(a.Args, " "))
+}
+
+// TemplateData is available in argument and ticket templates.
+type TemplateData struct {
+	Push   *Push
+	Branch string
+	Dir    string   // local clone of the repository
+	File   string   // matched file, relative to repository root
+	Path   string   // matched file, in the local clone
+	Files  []string // all matched files, relative to repository root
+	Paths  []string // all matched files, in the local clone
+}
+
+// Actions renders the actions for a number of matches, given the local clone
+// of the repository and additional template functions. The clone should have
+// the pushed commit checked out, e.g. with gitlab.Repo.Worktree, so actions
+// for other branches than master see the files of their branch. Rules run once per
+// matching file, unless marked "once". Identical actions are only returned
+// once.
+func Actions(matches []Match, push *Push, dir string, funcs template.FuncMap) ([]Action, error) {
+	var (
+		result []Action
+		seen   = make(map[string]bool)
+	)
+	render := func(s string, data TemplateData) (string, error) {
+		t, err := template.New("").Funcs(placeholderFuncs).Funcs(funcs).Parse(s)
+		if err != nil {
+			return "", err
+		}
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, data); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+	for _, m := range matches {
+		data := TemplateData{Push: push, Branch: push.Branch(), Dir: dir, Files: m.Files}
+		for _, f := range m.Files {
+			data.Paths = append(data.Paths, filepath.Join(dir, f))
+		}
+		var runs []TemplateData
+		switch {
+		case m.Rule.Once || len(m.Files) == 0:
+			if len(m.Files) > 0 {
+				data.File, data.Path = m.Files[0], data.Paths[0]
+			}
+			runs = append(runs, data)
+		default:
+			for i, f := range m.Files {
+				d := data
+				d.File, d.Path = f, data.Paths[i]
+				runs = append(runs, d)
+			}
+		}
+		for _, d := range runs {
+			a := Action{Rule: m.Rule.Name, Command: m.Rule.Command, Post: m.Rule.Post}
+			for _, s := range m.Rule.Args {
+				v, err := render(s, d)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", m.Rule.Name, err)
+				}
+				a.Args = append(a.Args, v)
+			}
+			ticket, err := render(m.Rule.Ticket, d)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", m.Rule.Name, err)
+			}
+			a.Ticket = strings.TrimSpace(ticket)
+			if seen[a.String()] {
+				continue
+			}
+			seen[a.String()] = true
+			result = append(result, a)
+		}
+	}
+	return result, nil
+}
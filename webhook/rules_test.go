@@ -0,0 +1,211 @@
+package webhook
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/miku/span/gitlab"
+)
+
+const testRules = `
+rules:
+    - name: review
+      paths: ["^docs/review.*yaml"]
+      command: span-review
+      args: ["-c", "{{ .Path }}"]
+      ticket: "{{ ticket .File }}"
+    - name: review-again
+      paths: ["^docs/review.yaml"]
+      command: span-review
+      args: ["-c", "{{ .Path }}"]
+    - name: compare
+      providers: [gitlab]
+      branches: ["master", "release-*"]
+      paths: ["^docs/.*"]
+      once: true
+      command: span-compare
+      args: ["-t", "{{ len .Files }}"]
+      post: true
+    - name: release
+      events: [tag]
+      command: echo
+      args: ["{{ .Push.Ref }}"]
+`
+
+func TestRules(t *testing.T) {
+	rules, err := ReadRules(strings.NewReader(testRules))
+	if err != nil {
+		t.Fatalf("ReadRules: got %v, want nil", err)
+	}
+	funcs := template.FuncMap{"ticket": func(s string) string { return "T-" + s }}
+	var cases = []struct {
+		about string
+		push  *Push
+		want  []Action
+	}{
+		{
+			"review files, deduplicated",
+			&Push{Provider: "github", Ref: "refs/heads/main", Files: []string{
+				"docs/review.yaml", "README.md", "docs/review.yaml", "docs/review-2.yaml"}},
+			[]Action{
+				{Rule: "review", Command: "span-review", Args: []string{"-c", "/r/docs/review.yaml"}, Ticket: "T-docs/review.yaml"},
+				{Rule: "review", Command: "span-review", Args: []string{"-c", "/r/docs/review-2.yaml"}, Ticket: "T-docs/review-2.yaml"},
+			},
+		},
+		{
+			"branch and provider",
+			&Push{Provider: "gitlab", Ref: "refs/heads/release-1", Files: []string{"docs/a", "docs/b"}},
+			[]Action{
+				{Rule: "compare", Command: "span-compare", Args: []string{"-t", "2"}, Post: true},
+			},
+		},
+		{
+			"branch mismatch",
+			&Push{Provider: "gitlab", Ref: "refs/heads/feature", Files: []string{"docs/a"}},
+			nil,
+		},
+		{
+			"tag",
+			&Push{Provider: "gitea", Ref: "refs/tags/v1.0", Files: []string{"docs/review.yaml"}},
+			[]Action{
+				{Rule: "release", Command: "echo", Args: []string{"refs/tags/v1.0"}},
+			},
+		},
+	}
+	for _, c := range cases {
+		actions, err := Actions(rules.Match(c.push), c.push, "/r", funcs)
+		if err != nil {
+			t.Fatalf("%s: got %v, want nil", c.about, err)
+		}
+		if !reflect.DeepEqual(actions, c.want) {
+			t.Errorf("%s: got %v, want %v", c.about, actions, c.want)
+		}
+	}
+}
+
+func TestReadRules(t *testing.T) {
+	if _, err := ReadRules(strings.NewReader(DefaultRules)); err != nil {
+		t.Errorf("DefaultRules: got %v, want nil", err)
+	}
+	var invalid = []string{
+		"rules:\n  - name: x\n",
+		"rules:\n  - command: x\n    events: [merge]\n",
+		"rules:\n  - command: x\n    paths: [\"(\"]\n",
+		"rules:\n  - command: x\n    args: [\"{{ .Path \"]\n",
+		"rules:\n  - command: x\n    unknown: 1\n",
+	}
+	for _, s := range invalid {
+		if _, err := ReadRules(strings.NewReader(s)); err == nil {
+			t.Errorf("ReadRules(%q): got nil, want error", s)
+		}
+	}
+}
+
+// gitRepo creates a repository with a master and a feature-x branch, which
+// differ in docs/review.yaml and returns the commit of feature-x.
+func gitRepo(t *testing.T, dir string) string {
+	run := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=span", "GIT_AUTHOR_EMAIL=span@example.com",
+			"GIT_COMMITTER_NAME=span", "GIT_COMMITTER_EMAIL=span@example.com")
+		b, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, b)
+		}
+		return strings.TrimSpace(string(b))
+	}
+	write := func(s string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, "docs/review.yaml"), []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	run("init", "-q")
+	run("symbolic-ref", "HEAD", "refs/heads/master")
+	write("master\n")
+	run("add", "-A")
+	run("commit", "-q", "-m", "master")
+	run("checkout", "-q", "-b", "feature-x")
+	write("feature-x\n")
+	run("commit", "-q", "-a", "-m", "feature-x")
+	commit := run("rev-parse", "HEAD")
+	run("checkout", "-q", "master")
+	return commit
+}
+
+func TestRulesCheckout(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir, err := ioutil.TempDir("", "span-webhook-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	origin := filepath.Join(dir, "origin")
+	commit := gitRepo(t, origin)
+	rules, err := ReadRules(strings.NewReader(`
+rules:
+    - name: feature
+      branches: ["feature-*"]
+      paths: ["^docs/review.yaml"]
+      command: cat
+      args: ["{{ .Path }}"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	push := &Push{
+		Provider: "gitea",
+		Ref:      "refs/heads/feature-x",
+		After:    commit,
+		CloneURL: origin,
+		Files:    []string{"docs/review.yaml"},
+	}
+	// A clone on master, as left behind by an earlier push.
+	repo := gitlab.Repo{URL: origin, Dir: filepath.Join(dir, "clone")}
+	if err := repo.Checkout("refs/heads/master", ""); err != nil {
+		t.Fatalf("Checkout: got %v, want nil", err)
+	}
+	if err := repo.Checkout(push.Ref, push.After); err != nil {
+		t.Fatalf("Checkout: got %v, want nil", err)
+	}
+	actions, err := Actions(rules.Match(push), push, repo.Dir, nil)
+	if err != nil {
+		t.Fatalf("Actions: got %v, want nil", err)
+	}
+	if len(actions) != 1 {
+		t.Fatalf("Actions: got %d, want 1", len(actions))
+	}
+	b, err := exec.Command(actions[0].Command, actions[0].Args...).Output()
+	if err != nil {
+		t.Fatalf("%s: got %v, want nil", actions[0], err)
+	}
+	if string(b) != "feature-x\n" {
+		t.Errorf("%s: got %q, want %q", actions[0], b, "feature-x\n")
+	}
+	// A worktree per commit keeps its files, when the clone moves on.
+	worktree := filepath.Join(dir, "worktree")
+	if err := repo.Worktree(push.Ref, push.After, worktree); err != nil {
+		t.Fatalf("Worktree: got %v, want nil", err)
+	}
+	if err := repo.Checkout("refs/heads/master", ""); err != nil {
+		t.Fatalf("Checkout: got %v, want nil", err)
+	}
+	b, err = ioutil.ReadFile(filepath.Join(worktree, "docs/review.yaml"))
+	if err != nil {
+		t.Fatalf("Worktree: got %v, want nil", err)
+	}
+	if string(b) != "feature-x\n" {
+		t.Errorf("Worktree: got %q, want %q", b, "feature-x\n")
+	}
+}
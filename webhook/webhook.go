@@ -0,0 +1,274 @@
+// Package webhook verifies and parses push webhooks from different git hosting
+// services, so span-webhookd can trigger reviews regardless of where the
+// review configuration lives.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/miku/span/gitlab"
+	"github.com/segmentio/encoding/json"
+)
+
+var (
+	// ErrIgnored is returned for valid events, which are not push events.
+	ErrIgnored = errors.New("event ignored")
+	// ErrUnauthorized is returned, if a request cannot be verified.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrNoSecret is returned, if no secret is configured for a provider and
+	// unverified requests are not allowed.
+	ErrNoSecret = errors.New("no secret configured")
+)
+
+// Push is the provider independent part of a push event.
+type Push struct {
+	Provider string   // name of the provider, e.g. "gitlab"
+	Repo     string   // full name of the repository, e.g. "miku/span"
+	Event    string   // event name as sent by the provider
+	Ref      string   // e.g. refs/heads/master
+	Before   string   // commit before push
+	After    string   // commit after push
+	CloneURL string   // HTTP clone URL of the repository
+	Files    []string // added and modified files across all commits
+}
+
+// Branch returns the branch name, if the ref points to a branch.
+func (p *Push) Branch() string {
+	return strings.TrimPrefix(p.Ref, "refs/heads/")
+}
+
+// Deleted returns true, if the push deleted a branch or tag.
+func (p *Push) Deleted() bool {
+	return strings.Trim(p.After, "0") == ""
+}
+
+// MatchFiles returns the added or modified files matching a pattern, each
+// file only once, in the order of first appearance.
+func (p *Push) MatchFiles(re *regexp.Regexp) (filenames []string) {
+	seen := make(map[string]bool)
+	for _, f := range p.Files {
+		if seen[f] || !re.MatchString(f) {
+			continue
+		}
+		seen[f] = true
+		filenames = append(filenames, f)
+	}
+	return
+}
+
+// Provider verifies and parses webhook requests of a git hosting service.
+type Provider interface {
+	// Name of the provider, e.g. "gitlab".
+	Name() string
+	// Match returns true, if the request was sent by this provider.
+	Match(r *http.Request) bool
+	// Verify checks the secret or signature of a request, given its body.
+	Verify(r *http.Request, body []byte) error
+	// Parse returns the push event contained in the request or ErrIgnored for
+	// other events.
+	Parse(r *http.Request, body []byte) (*Push, error)
+}
+
+// Detect returns the first provider matching a request or nil. Gitea sends
+// GitHub compatible headers as well, so it should come before GitHub.
+func Detect(r *http.Request, providers ...Provider) Provider {
+	for _, p := range providers {
+		if p.Match(r) {
+			return p
+		}
+	}
+	return nil
+}
+
+// GitLab webhooks, authenticated by a shared secret token.
+// https://docs.gitlab.com/ee/user/project/integrations/webhooks.html
+type GitLab struct {
+	Secret   string
+	Insecure bool // accept unverified requests, if no secret is set
+}
+
+// Name returns "gitlab".
+func (GitLab) Name() string { return "gitlab" }
+
+// Match checks for a GitLab event header.
+func (GitLab) Match(r *http.Request) bool {
+	return r.Header.Get("X-Gitlab-Event") != ""
+}
+
+// Verify compares the X-Gitlab-Token header to the secret.
+func (g GitLab) Verify(r *http.Request, body []byte) error {
+	if g.Secret == "" {
+		return noSecret(g.Insecure)
+	}
+	token := r.Header.Get("X-Gitlab-Token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(g.Secret)) != 1 {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// Parse parses a GitLab push or tag push hook.
+func (g GitLab) Parse(r *http.Request, body []byte) (*Push, error) {
+	event := strings.TrimSpace(r.Header.Get("X-Gitlab-Event"))
+	if event != "Push Hook" && event != "Tag Push Hook" {
+		return nil, ErrIgnored
+	}
+	var payload gitlab.PushPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	return &Push{
+		Provider: g.Name(),
+		Repo:     payload.Project.PathWithNamespace,
+		Event:    event,
+		Ref:      payload.Ref,
+		Before:   payload.Before,
+		After:    payload.After,
+		CloneURL: payload.Project.GitHttpUrl,
+		Files:    append(payload.AddedFiles(), payload.ModifiedFiles()...),
+	}, nil
+}
+
+// pushPayload contains the fields of a push event common to GitHub and Gitea.
+type pushPayload struct {
+	Ref     string `json:"ref"`
+	Before  string `json:"before"`
+	After   string `json:"after"`
+	Commits []struct {
+		ID       string   `json:"id"`
+		Added    []string `json:"added"`
+		Modified []string `json:"modified"`
+	} `json:"commits"`
+	Repository struct {
+		FullName string `json:"full_name"`
+		CloneURL string `json:"clone_url"`
+	} `json:"repository"`
+}
+
+// parsePushPayload parses a GitHub style push event.
+func parsePushPayload(provider, event string, body []byte) (*Push, error) {
+	var payload pushPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	push := &Push{
+		Provider: provider,
+		Repo:     payload.Repository.FullName,
+		Event:    event,
+		Ref:      payload.Ref,
+		Before:   payload.Before,
+		After:    payload.After,
+		CloneURL: payload.Repository.CloneURL,
+	}
+	for _, c := range payload.Commits {
+		push.Files = append(push.Files, c.Added...)
+		push.Files = append(push.Files, c.Modified...)
+	}
+	return push, nil
+}
+
+// noSecret returns nil, if unverified requests are allowed, ErrNoSecret
+// otherwise.
+func noSecret(insecure bool) error {
+	if insecure {
+		return nil
+	}
+	return ErrNoSecret
+}
+
+// verifyHMAC checks a hex encoded HMAC of body, computed with a secret.
+func verifyHMAC(h func() hash.Hash, secret, signature string, body []byte) error {
+	want, err := hex.DecodeString(signature)
+	if err != nil {
+		return ErrUnauthorized
+	}
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), want) {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// GitHub webhooks, authenticated by a HMAC signature of the payload.
+// https://docs.github.com/en/webhooks/using-webhooks/validating-webhook-deliveries
+type GitHub struct {
+	Secret   string
+	Insecure bool // accept unverified requests, if no secret is set
+}
+
+// Name returns "github".
+func (GitHub) Name() string { return "github" }
+
+// Match checks for a GitHub event header.
+func (GitHub) Match(r *http.Request) bool {
+	return r.Header.Get("X-GitHub-Event") != ""
+}
+
+// Verify checks X-Hub-Signature-256, or the legacy SHA1 X-Hub-Signature.
+func (g GitHub) Verify(r *http.Request, body []byte) error {
+	if g.Secret == "" {
+		return noSecret(g.Insecure)
+	}
+	if v := r.Header.Get("X-Hub-Signature-256"); v != "" {
+		return verifyHMAC(sha256.New, g.Secret, strings.TrimPrefix(v, "sha256="), body)
+	}
+	if v := r.Header.Get("X-Hub-Signature"); v != "" {
+		return verifyHMAC(sha1.New, g.Secret, strings.TrimPrefix(v, "sha1="), body)
+	}
+	return ErrUnauthorized
+}
+
+// Parse parses a GitHub push event.
+func (g GitHub) Parse(r *http.Request, body []byte) (*Push, error) {
+	event := r.Header.Get("X-GitHub-Event")
+	if event != "push" {
+		return nil, ErrIgnored
+	}
+	return parsePushPayload(g.Name(), event, body)
+}
+
+// Gitea (and Forgejo) webhooks, authenticated by a HMAC signature of the
+// payload. https://docs.gitea.com/usage/webhooks
+type Gitea struct {
+	Secret   string
+	Insecure bool // accept unverified requests, if no secret is set
+}
+
+// Name returns "gitea".
+func (Gitea) Name() string { return "gitea" }
+
+// Match checks for a Gitea event header.
+func (Gitea) Match(r *http.Request) bool {
+	return r.Header.Get("X-Gitea-Event") != ""
+}
+
+// Verify checks the X-Gitea-Signature header.
+func (g Gitea) Verify(r *http.Request, body []byte) error {
+	if g.Secret == "" {
+		return noSecret(g.Insecure)
+	}
+	v := r.Header.Get("X-Gitea-Signature")
+	if v == "" {
+		return ErrUnauthorized
+	}
+	return verifyHMAC(sha256.New, g.Secret, v, body)
+}
+
+// Parse parses a Gitea push event.
+func (g Gitea) Parse(r *http.Request, body []byte) (*Push, error) {
+	event := r.Header.Get("X-Gitea-Event")
+	if event != "push" {
+		return nil, ErrIgnored
+	}
+	return parsePushPayload(g.Name(), event, body)
+}
@@ -0,0 +1,148 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// githubPush is a shortened push event, as sent by GitHub and Gitea.
+const githubPush = `{
+  "ref": "refs/heads/main",
+  "before": "aaa",
+  "after": "bbb",
+  "repository": {"full_name": "ubl/span", "clone_url": "https://example.com/ubl/span.git"},
+  "commits": [
+    {"id": "b1", "added": ["docs/review-2.yaml"], "modified": ["README.md"]},
+    {"id": "b2", "added": [], "modified": ["docs/review-2.yaml", "docs/review.yaml"]}
+  ]
+}`
+
+func sign(h func() hash.Hash, secret, body string) string {
+	mac := hmac.New(h, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func request(body string, header map[string]string) *http.Request {
+	r := httptest.NewRequest("POST", "/trigger", strings.NewReader(body))
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	return r
+}
+
+func TestVerify(t *testing.T) {
+	var (
+		secret = "s3cr3t"
+		body   = githubPush
+	)
+	var cases = []struct {
+		about    string
+		provider Provider
+		header   map[string]string
+		err      error
+	}{
+		{"gitlab, no secret", GitLab{}, map[string]string{"X-Gitlab-Event": "Push Hook"}, ErrNoSecret},
+		{"gitlab, no secret, insecure", GitLab{Insecure: true}, map[string]string{"X-Gitlab-Event": "Push Hook"}, nil},
+		{"github, no secret", GitHub{}, map[string]string{"X-GitHub-Event": "push"}, ErrNoSecret},
+		{"gitea, no secret", Gitea{}, map[string]string{"X-Gitea-Event": "push"}, ErrNoSecret},
+		{"gitlab, token", GitLab{Secret: secret}, map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": secret}, nil},
+		{"gitlab, wrong token", GitLab{Secret: secret}, map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "x"}, ErrUnauthorized},
+		{"gitlab, missing token", GitLab{Secret: secret}, map[string]string{"X-Gitlab-Event": "Push Hook"}, ErrUnauthorized},
+		{"github, sha256", GitHub{Secret: secret}, map[string]string{"X-GitHub-Event": "push",
+			"X-Hub-Signature-256": "sha256=" + sign(sha256.New, secret, body)}, nil},
+		{"github, sha1", GitHub{Secret: secret}, map[string]string{"X-GitHub-Event": "push",
+			"X-Hub-Signature": "sha1=" + sign(sha1.New, secret, body)}, nil},
+		{"github, wrong secret", GitHub{Secret: secret}, map[string]string{"X-GitHub-Event": "push",
+			"X-Hub-Signature-256": "sha256=" + sign(sha256.New, "other", body)}, ErrUnauthorized},
+		{"github, missing signature", GitHub{Secret: secret}, map[string]string{"X-GitHub-Event": "push"}, ErrUnauthorized},
+		{"gitea", Gitea{Secret: secret}, map[string]string{"X-Gitea-Event": "push",
+			"X-Gitea-Signature": sign(sha256.New, secret, body)}, nil},
+		{"gitea, garbage", Gitea{Secret: secret}, map[string]string{"X-Gitea-Event": "push",
+			"X-Gitea-Signature": "zz"}, ErrUnauthorized},
+	}
+	for _, c := range cases {
+		r := request(body, c.header)
+		if !c.provider.Match(r) {
+			t.Errorf("%s: Match got false, want true", c.about)
+		}
+		if err := c.provider.Verify(r, []byte(body)); err != c.err {
+			t.Errorf("%s: Verify got %v, want %v", c.about, err, c.err)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	providers := []Provider{Gitea{}, GitHub{}, GitLab{}}
+	var cases = []struct {
+		header map[string]string
+		want   string
+	}{
+		{map[string]string{"X-Gitlab-Event": "Push Hook"}, "gitlab"},
+		{map[string]string{"X-GitHub-Event": "push"}, "github"},
+		{map[string]string{"X-GitHub-Event": "push", "X-Gitea-Event": "push"}, "gitea"},
+		{map[string]string{}, ""},
+	}
+	for _, c := range cases {
+		var got string
+		if p := Detect(request("", c.header), providers...); p != nil {
+			got = p.Name()
+		}
+		if got != c.want {
+			t.Errorf("Detect(%v): got %q, want %q", c.header, got, c.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	gitlabPush, err := ioutil.ReadFile("../fixtures/push.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cases = []struct {
+		about    string
+		provider Provider
+		header   map[string]string
+		body     string
+		err      error
+		ref      string
+		repo     string
+		cloneURL string
+		files    []string
+	}{
+		{"gitlab push", GitLab{}, map[string]string{"X-Gitlab-Event": "Push Hook"}, string(gitlabPush), nil,
+			"refs/heads/master", "miku/span", "https://git.sc.uni-leipzig.de/miku/span.git", []string{"cmd/span-webhookd/main.go"}},
+		{"gitlab note", GitLab{}, map[string]string{"X-Gitlab-Event": "Note Hook"}, "{}", ErrIgnored, "", "", "", nil},
+		{"github push", GitHub{}, map[string]string{"X-GitHub-Event": "push"}, githubPush, nil,
+			"refs/heads/main", "ubl/span", "https://example.com/ubl/span.git", []string{"docs/review-2.yaml", "docs/review.yaml"}},
+		{"github ping", GitHub{}, map[string]string{"X-GitHub-Event": "ping"}, "{}", ErrIgnored, "", "", "", nil},
+		{"gitea push", Gitea{}, map[string]string{"X-Gitea-Event": "push"}, githubPush, nil,
+			"refs/heads/main", "ubl/span", "https://example.com/ubl/span.git", []string{"docs/review-2.yaml", "docs/review.yaml"}},
+	}
+	re := regexp.MustCompile(`^(docs/review.*yaml|cmd/.*)$`)
+	for _, c := range cases {
+		push, err := c.provider.Parse(request(c.body, c.header), []byte(c.body))
+		if err != c.err {
+			t.Errorf("%s: got %v, want %v", c.about, err, c.err)
+		}
+		if err != nil {
+			continue
+		}
+		if push.Ref != c.ref || push.Repo != c.repo || push.CloneURL != c.cloneURL {
+			t.Errorf("%s: got %s %s %s, want %s %s %s", c.about, push.Ref, push.Repo, push.CloneURL, c.ref, c.repo, c.cloneURL)
+		}
+		if files := push.MatchFiles(re); !reflect.DeepEqual(files, c.files) {
+			t.Errorf("%s: got %v, want %v", c.about, files, c.files)
+		}
+	}
+}
@@ -14,10 +14,17 @@ import (
 	"sync"
 	"sync/atomic"
 
+	"github.com/klauspost/compress/zstd"
+	gzip "github.com/klauspost/pgzip"
 	spanatomic "github.com/miku/span/atomic"
 	"github.com/sethgrid/pester"
 )
 
+var (
+	magicGzip = []byte{0x1f, 0x8b}
+	magicZstd = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
 // CountReader counts the number of bytes read.
 type CountReader struct {
 	count int64
@@ -241,28 +248,26 @@ func (r *SavedReaders) Remove() {
 	_ = os.Remove(r.f.Name())
 }
 
-// ReadLines returns a list of trimmed lines in a file. Empty lines are skipped.
+// ReadLines returns a list of trimmed lines in a file. Empty lines are
+// skipped. The last line does not need a trailing newline.
 func ReadLines(filename string) (lines []string, err error) {
 	file, err := os.Open(filename)
 	if err != nil {
 		return
 	}
 	defer file.Close()
-	reader := bufio.NewReader(file)
-	for {
-		line, err := reader.ReadString('\n')
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, err
-		}
-		line = strings.TrimSpace(line)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
 		if line == "" {
 			continue
 		}
 		lines = append(lines, line)
 	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
 	return
 }
 
@@ -323,6 +328,9 @@ func AtomicDownload(link, filename string) error {
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("download %s: %s", link, resp.Status)
+	}
 	b, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return err
@@ -368,3 +376,119 @@ func UserHomeDir() string {
 	}
 	return os.Getenv("HOME")
 }
+
+// NewDecompressReader returns a reader that transparently decompresses gzip
+// or zstd compressed content, detected by magic bytes. Other content is
+// passed through as is.
+func NewDecompressReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	b, err := br.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(b, magicGzip):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(b, magicZstd):
+		dec, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	default:
+		return ioutil.NopCloser(br), nil
+	}
+}
+
+// decompressFile closes the decompressor and the underlying file.
+type decompressFile struct {
+	io.ReadCloser
+	f *os.File
+}
+
+// Close closes decompressor and file.
+func (d *decompressFile) Close() error {
+	if err := d.ReadCloser.Close(); err != nil {
+		d.f.Close()
+		return err
+	}
+	return d.f.Close()
+}
+
+// OpenDecompress opens a plain, gzip or zstd compressed file for reading.
+// Closing the returned reader closes the file as well.
+func OpenDecompress(filename string) (io.ReadCloser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := NewDecompressReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &decompressFile{ReadCloser: rc, f: f}, nil
+}
+
+// ConcatReader reads a list of plain, gzip or zstd compressed files in order,
+// as if they were a single stream of lines. Files are opened one at a time. A
+// newline is inserted after a file, if its content does not end with one, so
+// lines never span files.
+type ConcatReader struct {
+	Filenames []string
+	i         int
+	cur       io.ReadCloser
+	last      byte
+	newline   bool
+}
+
+// Read reads from the current file, advancing to the next on EOF.
+func (r *ConcatReader) Read(p []byte) (int, error) {
+	for {
+		if r.newline {
+			if len(p) == 0 {
+				return 0, nil
+			}
+			r.newline, r.last = false, '\n'
+			p[0] = '\n'
+			return 1, nil
+		}
+		if r.cur == nil {
+			if r.i >= len(r.Filenames) {
+				return 0, io.EOF
+			}
+			rc, err := OpenDecompress(r.Filenames[r.i])
+			if err != nil {
+				return 0, err
+			}
+			r.cur, r.last = rc, '\n'
+			r.i++
+		}
+		n, err := r.cur.Read(p)
+		if n > 0 {
+			r.last = p[n-1]
+		}
+		if err == io.EOF {
+			if cerr := r.cur.Close(); cerr != nil {
+				return n, cerr
+			}
+			r.cur = nil
+			r.newline = r.last != '\n'
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+// Close closes the currently open file, if any.
+func (r *ConcatReader) Close() error {
+	if r.cur == nil {
+		return nil
+	}
+	err := r.cur.Close()
+	r.cur = nil
+	return err
+}
@@ -3,11 +3,18 @@ package xio
 import (
 	"bytes"
 	"encoding/base64"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	gzip "github.com/klauspost/pgzip"
 )
 
 func TestLinkReader(t *testing.T) {
@@ -47,6 +54,41 @@ func TestSavedLink(t *testing.T) {
 	}
 }
 
+func TestAtomicDownload(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ok" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer ts.Close()
+	dir, err := ioutil.TempDir("", "span-xio-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var cases = []struct {
+		path string
+		want string
+		err  bool
+	}{
+		{"/ok", "ok", false},
+		{"/private", "", true},
+	}
+	for _, c := range cases {
+		filename := filepath.Join(dir, strings.TrimPrefix(c.path, "/"))
+		err := AtomicDownload(ts.URL+c.path, filename)
+		if (err != nil) != c.err {
+			t.Errorf("AtomicDownload %s: got err %v, want err %v", c.path, err, c.err)
+		}
+		b, _ := ioutil.ReadFile(filename)
+		if string(b) != c.want {
+			t.Errorf("AtomicDownload %s: got %q, want %q", c.path, b, c.want)
+		}
+	}
+}
+
 func TestFileReader(t *testing.T) {
 	var buf bytes.Buffer
 	testfile := "io.go"
@@ -100,3 +142,114 @@ func TestSavedReaders(t *testing.T) {
 		t.Errorf("SavedReaders: file exists, but should be deleted: %v", fn)
 	}
 }
+
+func TestNewDecompressReader(t *testing.T) {
+	var (
+		want        = "hello\nworld\n"
+		plain, gz   bytes.Buffer
+		zst         bytes.Buffer
+		gw          = gzip.NewWriter(&gz)
+		zw, err     = zstd.NewWriter(&zst)
+		emptyReader = strings.NewReader("")
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain.WriteString(want)
+	for _, w := range []io.WriteCloser{gw, zw} {
+		if _, err := io.WriteString(w, want); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var cases = []struct {
+		about string
+		r     io.Reader
+		want  string
+	}{
+		{"plain", &plain, want},
+		{"gzip", &gz, want},
+		{"zstd", &zst, want},
+		{"empty", emptyReader, ""},
+	}
+	for _, c := range cases {
+		rc, err := NewDecompressReader(c.r)
+		if err != nil {
+			t.Fatalf("%s: got %v, want nil", c.about, err)
+		}
+		b, err := ioutil.ReadAll(rc)
+		if err != nil {
+			t.Fatalf("%s: got %v, want nil", c.about, err)
+		}
+		if err := rc.Close(); err != nil {
+			t.Fatalf("%s: close got %v, want nil", c.about, err)
+		}
+		if string(b) != c.want {
+			t.Errorf("%s: got %q, want %q", c.about, string(b), c.want)
+		}
+	}
+}
+
+func TestConcatReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "span-xio-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var (
+		contents  = []string{"a\nb\n", "c", "", "d\n"}
+		filenames []string
+	)
+	for i, c := range contents {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := io.WriteString(w, c); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		filename := fmt.Sprintf("%s/%d.gz", dir, i)
+		if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		filenames = append(filenames, filename)
+	}
+	r := &ConcatReader{Filenames: filenames}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if want := "a\nb\nc\nd\n"; string(b) != want {
+		t.Errorf("got %q, want %q", string(b), want)
+	}
+	r = &ConcatReader{Filenames: []string{fmt.Sprintf("%s/missing", dir)}}
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Errorf("got nil, want error for missing file")
+	}
+}
+
+func TestReadLines(t *testing.T) {
+	f, err := ioutil.TempFile("", "span-xio-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	// The last line has no trailing newline.
+	if _, err := f.WriteString("a.json.zst\n\n  b.json.zst \nc.json.zst"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	lines, err := ReadLines(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(lines, ","), "a.json.zst,b.json.zst,c.json.zst"; got != want {
+		t.Errorf("ReadLines: got %v, want %v", got, want)
+	}
+}